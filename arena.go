@@ -0,0 +1,284 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+const (
+	// arenaChunkSize is the size of the chunks the arena requests from the
+	// operating system. It's also the upper bound for a single slot.
+	arenaChunkSize = 4 << 20
+	// arenaHeaderSize is the number of bytes in front of every slot holding
+	// the key, length and state of the value stored in it.
+	arenaHeaderSize = 16
+	// arenaMinShift is log2 of the smallest slot size.
+	arenaMinShift = 6
+)
+
+const (
+	// slotUsed is set while a slot holds a live value.
+	slotUsed int32 = 1 << iota
+	// slotDead is set when a pinned slot was freed. It gets recycled when the
+	// last pin is released.
+	slotDead
+	// slotPin is the increment for the pin count stored in the upper bits.
+	slotPin
+)
+
+var (
+	// ErrArenaClosed is returned when allocating from a closed arena.
+	ErrArenaClosed = errors.New("arena is closed")
+	// ErrValueTooLarge is returned when a value doesn't fit into a single
+	// arena slot.
+	ErrValueTooLarge = errors.New("value is too large for the arena")
+)
+
+// arenaRef locates a slot inside of an arena. The upper 32 bits hold the chunk
+// index and the lower 32 bits hold the offset of the slot within the chunk.
+type arenaRef uint64
+
+func newArenaRef(chunk, offset int) arenaRef {
+	return arenaRef(uint64(chunk)<<32 | uint64(offset))
+}
+
+func (r arenaRef) chunk() int  { return int(r >> 32) }
+func (r arenaRef) offset() int { return int(uint32(r)) }
+
+// arenaClass keeps track of free slots for a single slot size.
+type arenaClass struct {
+	free []arenaRef
+	// chunk is the index of the chunk currently being carved into slots, or
+	// -1 if there is none
+	chunk int
+	next  int
+}
+
+// arena is a slab allocator for byte slices. Memory is requested in large
+// chunks (mmap'd outside of the Go heap where possible) which are carved into
+// power of two sized slots, so storing millions of values doesn't produce
+// millions of heap objects for the garbage collector to track.
+//
+// Every slot starts with a header containing the hash of the key that owns it,
+// which lets readers verify that a slot wasn't recycled for another key in the
+// meantime.
+type arena struct {
+	sync.RWMutex
+	chunks  [][]byte
+	classes []arenaClass
+	closed  bool
+	// used is the number of bytes handed out in slots
+	used int64
+}
+
+func newArena() *arena {
+	n := bits.Len(uint(arenaChunkSize)) - arenaMinShift
+	a := &arena{classes: make([]arenaClass, n)}
+	for i := range a.classes {
+		a.classes[i].chunk = -1
+	}
+	return a
+}
+
+// slotClass returns the class index for a value of size n, which includes the
+// slot header.
+func slotClass(n int) int {
+	if n <= 1<<arenaMinShift {
+		return 0
+	}
+	return bits.Len(uint(n-1)) - arenaMinShift
+}
+
+// slotSize returns the number of bytes a value of length n occupies.
+func slotSize(n int) int {
+	return 1 << uint(slotClass(n+arenaHeaderSize)+arenaMinShift)
+}
+
+func (a *arena) state(ref arenaRef) *int32 {
+	return (*int32)(unsafe.Pointer(&a.chunks[ref.chunk()][ref.offset()+12]))
+}
+
+func (a *arena) header(ref arenaRef) (key uint64, length int) {
+	b := a.chunks[ref.chunk()][ref.offset():]
+	return binary.LittleEndian.Uint64(b), int(binary.LittleEndian.Uint32(b[8:]))
+}
+
+func (a *arena) data(ref arenaRef, length int) []byte {
+	off := ref.offset() + arenaHeaderSize
+	return a.chunks[ref.chunk()][off : off+length : off+length]
+}
+
+// put copies val into a free slot owned by key.
+func (a *arena) put(key uint64, val []byte) (arenaRef, error) {
+	n := len(val) + arenaHeaderSize
+	if n > arenaChunkSize {
+		return 0, ErrValueTooLarge
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return 0, ErrArenaClosed
+	}
+	class := slotClass(n)
+	ref, err := a.alloc(class)
+	if err != nil {
+		return 0, err
+	}
+	b := a.chunks[ref.chunk()][ref.offset():]
+	binary.LittleEndian.PutUint64(b, key)
+	binary.LittleEndian.PutUint32(b[8:], uint32(len(val)))
+	*a.state(ref) = slotUsed
+	copy(b[arenaHeaderSize:], val)
+	a.used += 1 << uint(class+arenaMinShift)
+	return ref, nil
+}
+
+// alloc returns a free slot from the class, carving a new chunk if needed.
+// The arena must be locked.
+func (a *arena) alloc(class int) (arenaRef, error) {
+	c := &a.classes[class]
+	if n := len(c.free); n > 0 {
+		ref := c.free[n-1]
+		c.free = c.free[:n-1]
+		return ref, nil
+	}
+	size := 1 << uint(class+arenaMinShift)
+	if c.chunk < 0 || c.next+size > arenaChunkSize {
+		chunk, err := z.MmapAnon(arenaChunkSize)
+		if err != nil {
+			return 0, err
+		}
+		a.chunks = append(a.chunks, chunk)
+		c.chunk, c.next = len(a.chunks)-1, 0
+	}
+	ref := newArenaRef(c.chunk, c.next)
+	c.next += size
+	return ref, nil
+}
+
+// valid reports whether ref holds a live value owned by key and returns the
+// length of the value. The arena must be at least read locked.
+func (a *arena) valid(ref arenaRef, key uint64) (int, bool) {
+	if a.closed || ref.chunk() >= len(a.chunks) {
+		return 0, false
+	}
+	if atomic.LoadInt32(a.state(ref))&(slotUsed|slotDead) != slotUsed {
+		return 0, false
+	}
+	owner, length := a.header(ref)
+	return length, owner == key
+}
+
+// get appends the value stored at ref to dst.
+func (a *arena) get(ref arenaRef, key uint64, dst []byte) ([]byte, bool) {
+	a.RLock()
+	defer a.RUnlock()
+	length, ok := a.valid(ref, key)
+	if !ok {
+		return dst, false
+	}
+	return append(dst, a.data(ref, length)...), true
+}
+
+// pin returns the value stored at ref without copying it. The slot won't be
+// recycled until unpin is called, even if it's freed in the meantime.
+func (a *arena) pin(ref arenaRef, key uint64) ([]byte, bool) {
+	a.RLock()
+	defer a.RUnlock()
+	length, ok := a.valid(ref, key)
+	if !ok {
+		return nil, false
+	}
+	atomic.AddInt32(a.state(ref), slotPin)
+	return a.data(ref, length), true
+}
+
+// unpin releases a pin obtained from pin.
+func (a *arena) unpin(ref arenaRef) {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return
+	}
+	state := a.state(ref)
+	*state -= slotPin
+	if *state == slotUsed|slotDead {
+		a.release(ref)
+	}
+}
+
+// free returns the slot at ref to its class. Pinned slots are only marked as
+// dead and recycled once the last pin is released.
+func (a *arena) free(ref arenaRef) {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return
+	}
+	state := a.state(ref)
+	if *state&slotUsed == 0 || *state&slotDead != 0 {
+		return
+	}
+	if *state >= slotPin {
+		*state |= slotDead
+		return
+	}
+	a.release(ref)
+}
+
+// release recycles the slot at ref. The arena must be locked.
+func (a *arena) release(ref arenaRef) {
+	_, length := a.header(ref)
+	class := slotClass(length + arenaHeaderSize)
+	*a.state(ref) = 0
+	a.classes[class].free = append(a.classes[class].free, ref)
+	a.used -= 1 << uint(class+arenaMinShift)
+}
+
+// size returns the number of bytes currently handed out in slots.
+func (a *arena) size() int64 {
+	a.RLock()
+	defer a.RUnlock()
+	return a.used
+}
+
+// close returns all chunks to the operating system. Any outstanding pinned
+// slices must not be used afterwards.
+func (a *arena) close() error {
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	var err error
+	for _, chunk := range a.chunks {
+		if e := z.Munmap(chunk); e != nil && err == nil {
+			err = e
+		}
+	}
+	a.chunks = nil
+	return err
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestArenaPutGet(t *testing.T) {
+	a := newArena()
+	defer a.close()
+	ref, err := a.put(1, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, ok := a.get(ref, 1, nil); !ok || !bytes.Equal(val, []byte("hello")) {
+		t.Fatal("put/get error")
+	}
+	if _, ok := a.get(ref, 2, nil); ok {
+		t.Fatal("slot should only be readable by its owner")
+	}
+	if _, err := a.put(1, make([]byte, arenaChunkSize)); err != ErrValueTooLarge {
+		t.Fatal("oversized value should be rejected")
+	}
+}
+
+func TestArenaFree(t *testing.T) {
+	a := newArena()
+	defer a.close()
+	ref, _ := a.put(1, []byte("hello"))
+	a.free(ref)
+	if _, ok := a.get(ref, 1, nil); ok {
+		t.Fatal("freed slot shouldn't be readable")
+	}
+	if a.size() != 0 {
+		t.Fatal("freed slot should be returned")
+	}
+	// a slot of the same class should be recycled
+	if again, _ := a.put(2, []byte("world")); again != ref {
+		t.Fatal("slot wasn't recycled")
+	}
+}
+
+func TestArenaPin(t *testing.T) {
+	a := newArena()
+	defer a.close()
+	ref, _ := a.put(1, []byte("hello"))
+	data, ok := a.pin(ref, 1)
+	if !ok {
+		t.Fatal("pin error")
+	}
+	a.free(ref)
+	// the slot must not be recycled while pinned
+	if again, _ := a.put(2, []byte("world")); again == ref {
+		t.Fatal("pinned slot was recycled")
+	}
+	if !bytes.Equal(data, []byte("hello")) {
+		t.Fatal("pinned data was modified")
+	}
+	a.unpin(ref)
+	if again, _ := a.put(3, []byte("again")); again != ref {
+		t.Fatal("unpinned slot wasn't recycled")
+	}
+}
+
+func TestSlotSize(t *testing.T) {
+	if slotSize(1) != 64 || slotSize(48) != 64 || slotSize(49) != 128 {
+		t.Fatal("slot size error")
+	}
+}
+
+func BenchmarkArenaPut(b *testing.B) {
+	a := newArena()
+	defer a.close()
+	val := make([]byte, 512)
+	b.SetBytes(int64(len(val)))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		ref, _ := a.put(uint64(n), val)
+		a.free(ref)
+	}
+}
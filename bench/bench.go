@@ -0,0 +1,201 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bench compares the throughput and hit ratios of ristretto with
+// other Go caches: sync.Map, hashicorp/golang-lru, bigcache and freecache.
+// It's a separate module, so ristretto doesn't depend on the other caches.
+// The benchmarks are run with go test:
+//
+//	go test -run xxx -bench . -args -capacity 100000 -keys 1000000
+//
+// Every cache is sized to hold capacity values, and keys are drawn from a
+// Zipfian distribution over keys keys with a fixed seed, so runs are
+// comparable. Note that bigcache and freecache can't be smaller than 1MB and
+// 512KB, so they hold more values than that when values are small, and that
+// sync.Map never evicts anything. Hit ratio benchmarks report the hit ratio as the hit-ratio
+// metric.
+package bench
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/allegro/bigcache"
+	"github.com/coocood/freecache"
+	"github.com/dgraph-io/ristretto"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// Cache is the common interface of the caches compared.
+type Cache interface {
+	// Get returns whether the key was found.
+	Get(key uint64) bool
+	Set(key uint64, value []byte)
+}
+
+// Constructor creates a Cache holding capacity values of valueSize bytes.
+type Constructor func(capacity, valueSize int) (Cache, error)
+
+// Caches are the constructors of every Cache compared, by name.
+var Caches = map[string]Constructor{
+	"ristretto":  NewRistretto,
+	"sync.Map":   NewSyncMap,
+	"golang-lru": NewLRU,
+	"bigcache":   NewBigCache,
+	"freecache":  NewFreeCache,
+}
+
+type ristrettoCache struct {
+	cache *ristretto.Cache
+}
+
+// NewRistretto creates a ristretto Cache where every value costs 1.
+func NewRistretto(capacity, valueSize int) (Cache, error) {
+	return newRistretto(capacity, false)
+}
+
+// NewRistrettoSync is like NewRistretto, but the Cache applies every Get and
+// Set before returning, so hit ratios are reproducible and don't depend on
+// how busy the machine is. It's much slower under contention.
+func NewRistrettoSync(capacity, valueSize int) (Cache, error) {
+	return newRistretto(capacity, true)
+}
+
+func newRistretto(capacity int, deterministic bool) (Cache, error) {
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:       int64(capacity) * 10,
+		MaxCost:           int64(capacity),
+		BufferItems:       64,
+		DeterministicMode: deterministic,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ristrettoCache{cache: cache}, nil
+}
+
+func (c *ristrettoCache) Get(key uint64) bool {
+	_, ok := c.cache.Get(key)
+	return ok
+}
+
+func (c *ristrettoCache) Set(key uint64, value []byte) {
+	c.cache.Set(key, value, 1)
+}
+
+// syncMap never evicts anything, so it's only a baseline for throughput.
+type syncMap struct {
+	m sync.Map
+}
+
+// NewSyncMap creates a Cache backed by a sync.Map, which never evicts values.
+func NewSyncMap(capacity, valueSize int) (Cache, error) {
+	return &syncMap{}, nil
+}
+
+func (c *syncMap) Get(key uint64) bool {
+	_, ok := c.m.Load(key)
+	return ok
+}
+
+func (c *syncMap) Set(key uint64, value []byte) {
+	c.m.Store(key, value)
+}
+
+type lruCache struct {
+	cache *lru.Cache
+}
+
+// NewLRU creates a hashicorp/golang-lru Cache.
+func NewLRU(capacity, valueSize int) (Cache, error) {
+	cache, err := lru.New(capacity)
+	if err != nil {
+		return nil, err
+	}
+	return &lruCache{cache: cache}, nil
+}
+
+func (c *lruCache) Get(key uint64) bool {
+	_, ok := c.cache.Get(key)
+	return ok
+}
+
+func (c *lruCache) Set(key uint64, value []byte) {
+	c.cache.Add(key, value)
+}
+
+type bigCache struct {
+	cache *bigcache.BigCache
+}
+
+// NewBigCache creates a bigcache Cache limited to the memory capacity values
+// take.
+func NewBigCache(capacity, valueSize int) (Cache, error) {
+	config := bigcache.DefaultConfig(time.Hour)
+	config.MaxEntriesInWindow = capacity
+	config.MaxEntrySize = valueSize
+	config.HardMaxCacheSize = megabytes(capacity * valueSize)
+	config.Verbose = false
+	cache, err := bigcache.NewBigCache(config)
+	if err != nil {
+		return nil, err
+	}
+	return &bigCache{cache: cache}, nil
+}
+
+func (c *bigCache) Get(key uint64) bool {
+	_, err := c.cache.Get(keyString(key))
+	return err == nil
+}
+
+func (c *bigCache) Set(key uint64, value []byte) {
+	c.cache.Set(keyString(key), value)
+}
+
+type freeCache struct {
+	cache *freecache.Cache
+}
+
+// NewFreeCache creates a freecache Cache limited to the memory capacity
+// values take.
+func NewFreeCache(capacity, valueSize int) (Cache, error) {
+	return &freeCache{cache: freecache.NewCache(capacity * valueSize)}, nil
+}
+
+func (c *freeCache) Get(key uint64) bool {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], key)
+	_, err := c.cache.Get(b[:])
+	return err == nil
+}
+
+func (c *freeCache) Set(key uint64, value []byte) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], key)
+	c.cache.Set(b[:], value, 0)
+}
+
+func keyString(key uint64) string {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], key)
+	return string(b[:])
+}
+
+// megabytes rounds bytes up to megabytes.
+func megabytes(bytes int) int {
+	return (bytes + 1<<20 - 1) >> 20
+}
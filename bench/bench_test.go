@@ -0,0 +1,181 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bench
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	capacity  = flag.Int("capacity", 100000, "number of values every cache holds")
+	keys      = flag.Uint64("keys", 1000000, "number of distinct keys")
+	zipfS     = flag.Float64("zipf-s", 1.01, "skew of the key distribution")
+	valueSize = flag.Int("value-size", 64, "size of values in bytes")
+	seed      = flag.Int64("seed", 1, "seed of the key distribution")
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if err := checkFlags(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	os.Exit(m.Run())
+}
+
+// checkFlags returns an error if the flags can't make a benchmark, rather
+// than letting the caches divide by zero or the key distribution panic.
+func checkFlags() error {
+	switch {
+	case *capacity <= 0:
+		return errors.New("-capacity must be positive")
+	case *keys < 2:
+		return errors.New("-keys must be at least 2")
+	case *zipfS <= 1:
+		return errors.New("-zipf-s must be greater than 1")
+	case *valueSize <= 0:
+		return errors.New("-value-size must be positive")
+	}
+	return nil
+}
+
+// names returns the names of the caches in a stable order.
+func names() []string {
+	var names []string
+	for name := range Caches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newCache(b *testing.B, name string) Cache {
+	return create(b, Caches[name])
+}
+
+func create(b *testing.B, constructor Constructor) Cache {
+	cache, err := constructor(*capacity, *valueSize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return cache
+}
+
+// zipf returns a Zipfian key distribution seeded with s.
+func zipf(s int64) *rand.Zipf {
+	return rand.NewZipf(rand.New(rand.NewSource(s)), *zipfS, 1, *keys-1)
+}
+
+// fill adds every key up to the capacity, so Gets find them.
+func fill(cache Cache, value []byte) {
+	for key := uint64(0); key < uint64(*capacity); key++ {
+		cache.Set(key, value)
+	}
+}
+
+// benchmarkMix runs Gets and Sets from every goroutine, with one Set per sets
+// operations, or none if sets is zero.
+func benchmarkMix(b *testing.B, sets int) {
+	for _, name := range names() {
+		b.Run(name, func(b *testing.B) {
+			cache := newCache(b, name)
+			value := make([]byte, *valueSize)
+			fill(cache, value)
+			var goroutine int64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				z := zipf(*seed + atomic.AddInt64(&goroutine, 1))
+				for i := 0; pb.Next(); i++ {
+					key := z.Uint64()
+					if sets > 0 && i%sets == 0 {
+						cache.Set(key, value)
+					} else {
+						cache.Get(key)
+					}
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkMix(b, 0)
+}
+
+// BenchmarkMixed does 25% Sets.
+func BenchmarkMixed(b *testing.B) {
+	benchmarkMix(b, 4)
+}
+
+func BenchmarkWrite(b *testing.B) {
+	benchmarkMix(b, 1)
+}
+
+// BenchmarkHitRatio sets every key that's missed, like a cache in front of a
+// database, and reports the hit ratio. Throughput isn't comparable to the
+// other benchmarks, since it's single threaded, and ristretto applies Sets
+// synchronously, so the ratio is reproducible.
+func BenchmarkHitRatio(b *testing.B) {
+	for _, name := range names() {
+		b.Run(name, func(b *testing.B) {
+			constructor := Caches[name]
+			if name == "ristretto" {
+				constructor = NewRistrettoSync
+			}
+			cache := create(b, constructor)
+			value := make([]byte, *valueSize)
+			z := zipf(*seed)
+			hits := 0
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := z.Uint64()
+				if cache.Get(key) {
+					hits++
+				} else {
+					cache.Set(key, value)
+				}
+			}
+			b.ReportMetric(float64(hits)/float64(b.N), "hit-ratio")
+		})
+	}
+}
+
+func TestCaches(t *testing.T) {
+	for _, name := range names() {
+		cache, err := Caches[name](100, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Set(1, []byte("value"))
+		// ristretto applies Sets asynchronously
+		for i := 0; i < 1000 && !cache.Get(1); i++ {
+			time.Sleep(time.Millisecond)
+		}
+		if !cache.Get(1) {
+			t.Fatalf("%s: value wasn't set\n", name)
+		}
+	}
+}
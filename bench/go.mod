@@ -0,0 +1,17 @@
+module github.com/dgraph-io/ristretto/bench
+
+go 1.23
+
+require (
+	github.com/allegro/bigcache v1.2.1
+	github.com/coocood/freecache v1.2.4
+	github.com/dgraph-io/ristretto v0.0.0-00010101000000-000000000000
+	github.com/hashicorp/golang-lru v1.0.2
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/golang/snappy v0.0.4 // indirect
+)
+
+replace github.com/dgraph-io/ristretto => ../
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "time"
+
+// Builder builds a Cache with a fluent API, as an alternative to filling in a
+// Config:
+//
+//	cache, err := ristretto.NewBuilder().
+//		MaxCost(1 << 30).
+//		TTL(time.Minute).
+//		OnEvict(onEvict).
+//		Build()
+//
+// Only MaxCost is required. NumCounters defaults to 10 times ExpectedKeys, or
+// is derived from MaxCost and AverageCost like it is by NewCache. Settings
+// without a method of their own can be changed with Config.
+type Builder struct {
+	config       Config
+	expectedKeys int64
+}
+
+// NewBuilder returns a Builder with every setting at its default.
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// MaxCost sets Config.MaxCost.
+func (b *Builder) MaxCost(maxCost int64) *Builder {
+	b.config.MaxCost = maxCost
+	return b
+}
+
+// NumCounters sets Config.NumCounters, overriding the default derived from
+// ExpectedKeys or AverageCost.
+func (b *Builder) NumCounters(numCounters int64) *Builder {
+	b.config.NumCounters = numCounters
+	return b
+}
+
+// ExpectedKeys is the number of keys the cache is expected to hold when
+// full, which NumCounters defaults to 10 times of. Unlike counters derived
+// from AverageCost, they aren't resized as the number of keys changes.
+func (b *Builder) ExpectedKeys(keys int64) *Builder {
+	b.expectedKeys = keys
+	return b
+}
+
+// AverageCost sets Config.AverageCost.
+func (b *Builder) AverageCost(cost int64) *Builder {
+	b.config.AverageCost = cost
+	return b
+}
+
+// TTL sets Config.DefaultTTL, the TTL of keys Set without WithTTL.
+func (b *Builder) TTL(ttl time.Duration) *Builder {
+	b.config.DefaultTTL = ttl
+	return b
+}
+
+// OnEvict sets Config.OnEvict.
+func (b *Builder) OnEvict(f func(key uint64, value interface{}, cost int64)) *Builder {
+	b.config.OnEvict = f
+	return b
+}
+
+// OnExpire sets Config.OnExpire.
+func (b *Builder) OnExpire(f func(key uint64, value interface{}, cost int64)) *Builder {
+	b.config.OnExpire = f
+	return b
+}
+
+// OnExit sets Config.OnExit.
+func (b *Builder) OnExit(f func(value interface{})) *Builder {
+	b.config.OnExit = f
+	return b
+}
+
+// EvictionPolicy sets Config.EvictionPolicy.
+func (b *Builder) EvictionPolicy(policy EvictionPolicy) *Builder {
+	b.config.EvictionPolicy = policy
+	return b
+}
+
+// Metrics turns on Config.Metrics.
+func (b *Builder) Metrics() *Builder {
+	b.config.Metrics = true
+	return b
+}
+
+// Clock sets Config.Clock.
+func (b *Builder) Clock(clock Clock) *Builder {
+	b.config.Clock = clock
+	return b
+}
+
+// Config calls f with the Config being built, to change settings without a
+// method of their own.
+func (b *Builder) Config(f func(*Config)) *Builder {
+	f(&b.config)
+	return b
+}
+
+// Build returns a new Cache with the settings of the Builder, or the error
+// NewCache returns if they're invalid. The Builder can be reused afterwards.
+func (b *Builder) Build() (*Cache, error) {
+	config := b.config
+	if config.NumCounters == 0 && b.expectedKeys > 0 {
+		config.NumCounters = countersFor(b.expectedKeys)
+	}
+	return NewCache(&config)
+}
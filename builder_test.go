@@ -0,0 +1,78 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuilder(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var evicted []uint64
+	cache, err := NewBuilder().
+		MaxCost(10).
+		TTL(time.Second).
+		Clock(clock).
+		OnEvict(func(key uint64, _ interface{}, _ int64) {
+			evicted = append(evicted, key)
+		}).
+		Config(func(config *Config) {
+			config.DeterministicMode = true
+		}).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := cache.ConfigSnapshot().NumCounters; n != 100 {
+		t.Fatalf("expected 100 counters by default but got %d\n", n)
+	}
+	for key := 0; key < 11; key++ {
+		cache.Set(key, key, 1)
+	}
+	if len(evicted) != 1 {
+		t.Fatalf("expected a single eviction but got %v\n", evicted)
+	}
+	clock.Advance(time.Second)
+	if _, ok := cache.Get(10); ok {
+		t.Fatal("keys should expire after the TTL")
+	}
+}
+
+func TestBuilderNumCounters(t *testing.T) {
+	for _, test := range []struct {
+		builder *Builder
+		want    int64
+	}{
+		{NewBuilder().MaxCost(1000), 10000},
+		{NewBuilder().MaxCost(1 << 30), DefaultMaxCounters},
+		{NewBuilder().MaxCost(1 << 30).AverageCost(1 << 20), 10240},
+		{NewBuilder().MaxCost(1 << 30).ExpectedKeys(1000), 10000},
+		{NewBuilder().MaxCost(1 << 30).NumCounters(42), 42},
+	} {
+		cache, err := test.builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := cache.ConfigSnapshot().NumCounters; n != test.want {
+			t.Fatalf("expected %d counters but got %d\n", test.want, n)
+		}
+	}
+	if _, err := NewBuilder().Build(); err == nil {
+		t.Fatal("Build should validate the settings")
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// ByteCache is a Cache specialized for []byte values. Values are copied into
+// an arena of large chunks allocated outside of the Go heap, and the cache
+// itself only keeps a fixed-size reference to them. This keeps GC scan times
+// flat no matter how many values are cached.
+//
+// The cost of every value is the number of arena bytes it occupies, so
+// Config.MaxCost effectively becomes the arena memory limit.
+type ByteCache struct {
+	cache *Cache
+	arena *arena
+}
+
+// ByteView is a pinned, zero-copy view of a value stored in a ByteCache. The
+// underlying memory stays valid until Release is called, even if the value is
+// evicted in the meantime.
+type ByteView struct {
+	arena *arena
+	ref   arenaRef
+	data  []byte
+}
+
+// Bytes returns the pinned value. The slice must not be modified or used
+// after Release.
+func (v *ByteView) Bytes() []byte {
+	return v.data
+}
+
+// Release unpins the value. It must be called exactly once.
+func (v *ByteView) Release() {
+	v.arena.unpin(v.ref)
+	v.data = nil
+}
+
+// NewByteCache returns a new ByteCache instance and any configuration errors,
+// if any. Config.OnEvict and OnEvictWithFlags receive a copy of the evicted
+// value. Config.OnExit isn't called, as values are copied into memory the
+// ByteCache manages itself.
+func NewByteCache(config *Config) (*ByteCache, error) {
+	b := &ByteCache{arena: newArena()}
+	conf := *config
+	if onEvict := config.OnEvict; onEvict != nil {
+		conf.OnEvict = func(key uint64, val interface{}, cost int64) {
+			data, _ := b.arena.get(val.(arenaRef), key, nil)
+			onEvict(key, data, cost)
+		}
+	}
+	if onEvict := config.OnEvictWithFlags; onEvict != nil {
+		conf.OnEvictWithFlags = func(key uint64, val interface{}, cost int64,
+			flags uint32) {
+			data, _ := b.arena.get(val.(arenaRef), key, nil)
+			onEvict(key, data, cost, flags)
+		}
+	}
+	// the arena owns the values, and frees them once they leave the cache
+	conf.OnExit = nil
+	cache, err := NewCache(&conf)
+	if err != nil {
+		return nil, err
+	}
+	cache.onExit = func(val interface{}) {
+		b.arena.free(val.(arenaRef))
+	}
+	b.cache = cache
+	return b, nil
+}
+
+// Get returns a copy of the value (if any) and a boolean representing whether
+// the value was found or not.
+func (b *ByteCache) Get(key interface{}) ([]byte, bool) {
+	val, ok := b.GetAppend(key, nil)
+	if !ok {
+		return nil, false
+	}
+	return val, true
+}
+
+// GetAppend appends the value (if any) to dst and returns the extended
+// buffer, along with whether the value was found. dst is returned as is if it
+// wasn't. Gets reusing a buffer with enough room for the value don't
+// allocate.
+func (b *ByteCache) GetAppend(key interface{}, dst []byte) ([]byte, bool) {
+	if b == nil {
+		return dst, false
+	}
+	hash := b.cache.keyToHash(key)
+	val, ok := b.cache.get(hash)
+	if !ok {
+		return dst, false
+	}
+	return b.arena.get(val.(arenaRef), hash, dst)
+}
+
+// View returns a pinned view of the value (if any) without copying it. The
+// view must be released once the caller is done with it.
+func (b *ByteCache) View(key interface{}) (*ByteView, bool) {
+	if b == nil {
+		return nil, false
+	}
+	hash := b.cache.keyToHash(key)
+	val, ok := b.cache.get(hash)
+	if !ok {
+		return nil, false
+	}
+	ref := val.(arenaRef)
+	data, ok := b.arena.pin(ref, hash)
+	if !ok {
+		return nil, false
+	}
+	return &ByteView{arena: b.arena, ref: ref, data: data}, true
+}
+
+// Set copies val into the arena and attempts to add it to the cache. See
+// Cache.Set for the meaning of the returned boolean.
+func (b *ByteCache) Set(key interface{}, val []byte) bool {
+	if b == nil {
+		return false
+	}
+	hash := b.cache.keyToHash(key)
+	cost := int64(slotSize(len(val)))
+	if b.cache.oversized(hash, val, cost) {
+		b.cache.getAndDel(hash)
+		return false
+	}
+	ref, err := b.arena.put(hash, val)
+	if err != nil {
+		return false
+	}
+	if !b.cache.set(hash, ref, cost, setOptions{}, nil) {
+		b.arena.free(ref)
+		return false
+	}
+	return true
+}
+
+// Del deletes the key-value item from the cache if it exists.
+func (b *ByteCache) Del(key interface{}) {
+	if b == nil {
+		return
+	}
+	hash := b.cache.keyToHash(key)
+	b.cache.del(hash, b.cache.nextVersion())
+	b.cache.publish(hash)
+}
+
+// Metrics returns statistics about cache performance.
+func (b *ByteCache) Metrics() *metrics {
+	if b == nil {
+		return nil
+	}
+	return b.cache.Metrics()
+}
+
+// Close stops the goroutines of the cache, once they've applied the buffered
+// Sets, and then releases the arena. Values returned by View must not be used
+// afterwards.
+func (b *ByteCache) Close() error {
+	if b == nil {
+		return nil
+	}
+	b.cache.Close()
+	return b.arena.close()
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func newByteCache(onEvict func(uint64, interface{}, int64)) *ByteCache {
+	cache, err := NewByteCache(&Config{
+		NumCounters: 1000,
+		MaxCost:     64 * 100,
+		BufferItems: 64,
+		OnEvict:     onEvict,
+
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestByteCacheSetGet(t *testing.T) {
+	cache := newByteCache(nil)
+	defer cache.Close()
+	val := []byte("value")
+	cache.Set(1, val)
+	// the arena must hold its own copy
+	val[0] = 'V'
+	if got, ok := cache.Get(1); !ok || !bytes.Equal(got, []byte("value")) {
+		t.Fatal("set/get error")
+	}
+	view, ok := cache.View(1)
+	if !ok || !bytes.Equal(view.Bytes(), []byte("value")) {
+		t.Fatal("view error")
+	}
+	view.Release()
+	cache.Del(1)
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("value shouldn't exist")
+	}
+	if cache.arena.size() != 0 {
+		t.Fatal("deleted value wasn't freed")
+	}
+}
+
+func TestByteCacheOverwrite(t *testing.T) {
+	cache := newByteCache(nil)
+	defer cache.Close()
+	cache.Set(1, []byte("a"))
+	cache.Set(1, []byte("b"))
+	if got, _ := cache.Get(1); !bytes.Equal(got, []byte("b")) {
+		t.Fatal("overwrite error")
+	}
+	if cache.arena.size() != 64 {
+		t.Fatal("overwritten value wasn't freed")
+	}
+}
+
+func TestByteCacheOnEvict(t *testing.T) {
+	mu := &sync.Mutex{}
+	evicted := 0
+	cache := newByteCache(func(key uint64, val interface{}, cost int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !bytes.Equal(val.([]byte), []byte{byte(key)}) {
+			t.Errorf("onEvict key-val mismatch")
+		}
+		evicted++
+	})
+	defer cache.Close()
+	for i := 0; i < 200; i++ {
+		cache.Set(uint64(i), []byte{byte(i)})
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if evicted == 0 {
+		t.Fatal("onEvict not being called")
+	}
+	if cache.arena.size() > 64*100 {
+		t.Fatal("evicted values weren't freed")
+	}
+}
+
+func TestByteCacheGetAppend(t *testing.T) {
+	cache := newByteCache(nil)
+	defer cache.Close()
+	cache.Set(1, []byte("value"))
+	buf, ok := cache.GetAppend(1, []byte("key="))
+	if !ok || string(buf) != "key=value" {
+		t.Fatalf("expected the value appended but got %q\n", buf)
+	}
+	if buf, ok = cache.GetAppend(2, buf[:0]); ok || len(buf) != 0 {
+		t.Fatal("missing keys should leave the buffer as is")
+	}
+	if raceEnabled {
+		return
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = cache.GetAppend(1, buf[:0])
+	})
+	if allocs != 0 {
+		t.Fatalf("GetAppend allocated %v times\n", allocs)
+	}
+}
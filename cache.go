@@ -21,9 +21,17 @@ package ristretto
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 
 	"github.com/dgraph-io/ristretto/z"
 )
@@ -32,6 +40,9 @@ import (
 // policy and a Sampled LFU eviction policy. You can use the same Cache instance
 // from as many goroutines as you want.
 type Cache struct {
+	// version is the last version handed out to a write. It's accessed
+	// atomically and kept first for 64-bit alignment.
+	version uint64
 	// store is the central concurrent hashmap where key-value items are stored
 	store store
 	// policy determines what gets let in to the cache and what gets kicked out
@@ -41,19 +52,140 @@ type Cache struct {
 	getBuf *ringBuffer
 	// setBuf is a buffer allowing us to batch/drop Sets during times of high
 	// contention
-	setBuf chan *item
+	setBuf *setBuffer
 	// stats contains a running log of important statistics like hits, misses,
 	// and dropped items
 	stats *metrics
 	// onEvict is called for item evictions
 	onEvict func(uint64, interface{}, int64)
+	// onEvictFlags is onEvict, also passed the flags of the item
+	onEvictFlags func(uint64, interface{}, int64, uint32)
+	// evictQueue feeds evictions to the goroutines calling onEvict, if
+	// they're called asynchronously, until the cache is closed
+	evictQueue chan eviction
+	// evictWorkers tracks the goroutines draining evictQueue
+	evictWorkers sync.WaitGroup
+	// stop is closed by Close to stop the goroutines started by NewCache,
+	// which background tracks
+	stop       chan struct{}
+	stopOnce   sync.Once
+	background *sync.WaitGroup
+	// onExpire is called for items removed because they expired
+	onExpire func(uint64, interface{}, int64)
+	// onUpdate is called for values overwritten by a write
+	onUpdate func(uint64, interface{}, interface{})
+	// invalidator broadcasts Dels to other processes
+	invalidator Invalidator
+	// peers owns the keys GetOrLoad doesn't load itself
+	peers PeerPicker
+	// lowerTier takes evicted values, and gives them back on misses
+	lowerTier *Cache
+	// demoted tracks the keys handed down to lowerTier, if it's set
+	demoted *demotions
+	// tags indexes the keys set with tags, for InvalidateTag
+	tags *tagIndex
+	// onExit is called with every value that is no longer referenced by the
+	// cache, whether it was evicted, deleted, overwritten or rejected. It is
+	// used by wrappers managing memory outside of the Go heap.
+	onExit func(interface{})
 	// KeyToHash function is used to customize the key hashing algorithm.
 	// Each key will be hashed using the provided function. If keyToHash value
 	// is not set, the default keyToHash function is used.
 	keyToHash func(interface{}) uint64
+	// codec encodes and decodes []byte values, if set
+	codec Codec
+	// setBlocking makes Set wait for room in setBuf instead of dropping
+	setBlocking bool
+	// setTimeout bounds how long a blocking Set waits, if non-zero
+	setTimeout time.Duration
+	// dropPolicy decides what's dropped when setBuf is full
+	dropPolicy SetDropPolicy
+	// pending holds the items in setBuf by key when coalescing duplicates
+	pending   map[uint64]*item
+	pendingMu sync.Mutex
+	// locks serializes GetOrCompute calls for the same key
+	locks *KeyedMutex
+	// loads tracks the keys being computed by GetOrCompute
+	loads *loadTracker
+	// refs tracks the values referenced by handles returned by Acquire
+	refs *refTracker
+	// loadErrorTTL is how long the errors of failed loads are served, if set
+	loadErrorTTL time.Duration
+	// clock is the source of time for timeouts
+	clock Clock
+	// deterministic makes Sets and Dels skip setBuf and apply right away
+	deterministic bool
+	// debugInvariants checks the cache's invariants whenever setBuf is
+	// drained
+	debugInvariants bool
+	// processMu serializes applying items, with a lock per worker guarding
+	// the keys of its policy shard
+	processMu []sync.Mutex
+	// config is the Config the cache was created with, for Dump
+	config Config
+	// name is set by NewNamedCache
+	name string
+	// evictions remembers the most recent evictions, for debugging
+	evictions evictionLog
+	// births are the times keys were added and last updated, for the age of
+	// victims and OnEvictWithTimes. It's guarded by birthsMu, and nil
+	// without either.
+	births   map[uint64]keyTimes
+	birthsMu sync.Mutex
+	// expirations tracks keys set with a TTL until they expire
+	expirations *expirationMap
+	// ttlJitter is the fraction by which TTLs are randomized
+	ttlJitter float64
+	// pressureThreshold is the fraction of the memory limit above which
+	// pressureEvict of the cache's cost is evicted, if set
+	pressureThreshold float64
+	pressureEvict     float64
+	// ready is closed once the cache stayed saturated for Config.ReadyAfter,
+	// counting from saturatedSince
+	ready          chan struct{}
+	saturatedSince time.Time
+	isReady        bool
+	// reconciledAt is when the cost accounting was last reconciled, or when
+	// the cache was created
+	reconciledAt time.Time
+	// counters is the number of access counters, which are resized by
+	// retuneCounters if tuneCounters is set
+	counters int64
+	// droppedSets counts the Sets dropped since logDrops last logged them
+	droppedSets uint64
+	// health is what the goroutines of the cache record for Health
+	health       *healthState
+	tuneCounters bool
+	// shadows holds the []*Shadow fed the cache's accesses, which is
+	// replaced instead of modified under shadowsMu
+	shadows   atomic.Value
+	shadowsMu sync.Mutex
+	// closed makes buffered Sets release their values instead of adding
+	// them, once Close released the cached ones. It's guarded by processMu.
+	closed bool
 }
 
-// Config is passed to NewCache for creating new Cache instances.
+// The defaults of the buffer and eviction sample sizes in Config.
+const (
+	// DefaultGetBufferStripeSize is the number of keys a Get buffer stripe
+	// holds before it's drained to the policy.
+	DefaultGetBufferStripeSize = 64
+	// DefaultSetBufferSize is the number of Sets the Set buffer holds.
+	DefaultSetBufferSize = 32 * 1024
+	// DefaultEvictionSampleSize is the number of keys sampled when looking
+	// for a key to evict. 5 seems to be the most optimal number [citation
+	// needed].
+	DefaultEvictionSampleSize = 5
+	// DefaultGetDrainInterval is how often DrainOnInterval drains the Get
+	// buffer.
+	DefaultGetDrainInterval = 10 * time.Millisecond
+	// DefaultMaxCounters caps the NumCounters derived when it's zero, which
+	// takes about 5MB of counters.
+	DefaultMaxCounters = 1e7
+)
+
+// Config is passed to NewCache for creating new Cache instances. It can be
+// encoded as JSON, leaving out callbacks and interfaces.
 type Config struct {
 	// NumCounters determines the number of counters (keys) to keep that hold
 	// access frequency information. It's generally a good idea to have more
@@ -63,82 +195,908 @@ type Config struct {
 	// For example, if you expect your cache to hold 1,000,000 items when full,
 	// NumCounters should be 10,000,000 (10x). Each counter takes up 4 bits, so
 	// keeping 10,000,000 counters would require 5MB of memory.
-	NumCounters int64
+	//
+	// If it's zero, it's derived from MaxCost and AverageCost, up to
+	// DefaultMaxCounters, and the counters are resized whenever the number
+	// of cached keys strays from the estimate by more than a factor of 2.
+	NumCounters int64 `json:"numCounters"`
+	// AverageCost is the expected average cost of a key, 1 by default, used
+	// to estimate how many keys the cache holds when full if NumCounters is
+	// zero. Caches whose costs are bytes should set it to the typical size
+	// of a value.
+	AverageCost int64 `json:"averageCost"`
 	// MaxCost can be considered as the cache capacity, in whatever units you
-	// choose to use.
+	// choose to use. It can be zero if MaxCostPercent is set.
 	//
 	// For example, if you want the cache to have a max capacity of 100MB, you
 	// would set MaxCost to 100,000,000 and pass an item's number of bytes as
 	// the `cost` parameter for calls to Set. If new items are accepted, the
 	// eviction process will take care of making room for the new item and not
 	// overflowing the MaxCost value.
-	MaxCost int64
-	// BufferItems determines the size of Get buffers.
+	MaxCost int64 `json:"maxCost"`
+	// MaxCostPercent, if set, makes MaxCost that percentage of the memory
+	// limit (GOMEMLIMIT), or of the total system memory if there's no limit,
+	// so the same Config fits machines of any size. Costs have to be bytes
+	// for it to make sense. MaxCost is recomputed every second in case the
+	// limit changes, and only used if neither is known.
+	MaxCostPercent float64 `json:"maxCostPercent"`
+	// MaxKeyCost, if set, is the highest cost of a single key. Sets of
+	// costlier values fail, delete the key's previous value, and hand the
+	// value to OnOversize instead, so it doesn't take up most of the cache.
+	MaxKeyCost int64 `json:"maxKeyCost"`
+	// OnOversize is called for every value costing more than MaxKeyCost,
+	// before it's encoded by Codec, so the caller can split it into chunks
+	// or store it somewhere else.
+	OnOversize func(key uint64, value interface{}, cost int64) `json:"-"`
+	// GetBufferStripeSize is the number of keys each Get buffer stripe holds
+	// before it's drained to the policy, DefaultGetBufferStripeSize by
+	// default. Bigger stripes mean less contention on the policy but a longer
+	// delay before accesses influence admission and eviction. It's unrelated
+	// to the size of the Set buffer, which is SetBufferSize.
+	GetBufferStripeSize int64 `json:"getBufferStripeSize"`
+	// BufferItems is the Get buffer stripe size used if GetBufferStripeSize
+	// and GetBufferSize aren't set.
 	//
-	// Unless you have a rare use case, using `64` as the BufferItems value
-	// results in good performance.
-	BufferItems int64
+	// Deprecated: Use GetBufferStripeSize.
+	BufferItems int64 `json:"bufferItems"`
+	// GetBufferSize is the Get buffer stripe size used if
+	// GetBufferStripeSize isn't set.
+	//
+	// Deprecated: Use GetBufferStripeSize.
+	GetBufferSize int64 `json:"getBufferSize"`
+	// GetBufferStripes, if set, replaces the default pool of Get buffer
+	// stripes with a fixed number of stripes, which must be a power of two.
+	// Every Get uses the stripe of the CPU it runs on, so it should be at
+	// least GOMAXPROCS. A Get finding its stripe busy draining is dropped
+	// rather than waiting, and counted in the gets-dropped metric.
+	GetBufferStripes int64 `json:"getBufferStripes"`
+	// GetDrainPolicy chooses when the Get buffer hands accesses to the
+	// policy, DrainWhenFull by default. Latency sensitive workloads may want
+	// accesses to count sooner, batch workloads bigger stripes.
+	GetDrainPolicy GetDrainPolicy `json:"getDrainPolicy"`
+	// GetDrainInterval is how often DrainOnInterval drains the Get buffer,
+	// DefaultGetDrainInterval by default.
+	GetDrainInterval time.Duration `json:"getDrainInterval"`
 	// Metrics determines whether cache statistics are kept during the cache's
 	// lifetime. There *is* some overhead to keeping statistics, so you should
 	// only set this flag to true when testing or throughput performance isn't a
 	// major factor.
-	Metrics bool
+	Metrics bool `json:"metrics"`
 	// OnEvict is called for every eviction and passes the hashed key, value,
-	// and cost to the function.
-	OnEvict func(key uint64, value interface{}, cost int64)
+	// and cost to the function. Like the other callbacks, it must not call
+	// GetOrCompute, GetOrLoad or SetIfAbsent, which wait for the Sets it's
+	// called from to be applied, and deadlock.
+	OnEvict func(key uint64, value interface{}, cost int64) `json:"-"`
+	// OnEvictWithFlags is like OnEvict, but also passes the flags the value
+	// was set with (see SetWithFlags). It's called along with OnEvict, if
+	// both are set.
+	OnEvictWithFlags func(key uint64, value interface{}, cost int64,
+		flags uint32) `json:"-"`
+	// OnExpire is called for every key removed because its TTL ran out, with
+	// the same arguments as OnEvict. Expired keys aren't passed to OnEvict,
+	// so evictions only count keys that didn't fit in the cache.
+	OnExpire func(key uint64, value interface{}, cost int64) `json:"-"`
+	// OnEvictWithTimes is like OnEvict, but also gets when the key was added
+	// to the cache, and when its value was last updated, so caches without
+	// TTLs can still tell how long keys stay cached. Keys that were never
+	// updated were last updated when they were added.
+	OnEvictWithTimes func(key uint64, value interface{}, cost int64,
+		added, updated time.Time) `json:"-"`
+	// OnUpdate is called when a Set, or a write like Replace or
+	// GetAndSet, overwrites the value of a cached key, with the value it
+	// replaced and the new one, so values referencing resources like file
+	// handles or off-heap buffers can release the previous one right away.
+	// It's called by the write itself, or by the goroutine applying buffered
+	// Sets if another Set added the key first.
+	OnUpdate func(key uint64, prev, next interface{}) `json:"-"`
+	// OnExit is called exactly once with every value the cache took, once
+	// it no longer references it: when it's evicted, expires, is deleted or
+	// overwritten, is rejected by the policy or dropped from the Set buffer
+	// after its Set returned true, or is still cached on Close. Values of
+	// Sets returning false are never taken. This makes it safe to cache
+	// values owning off-heap memory or file descriptors. It's called after
+	// the other callbacks are done with the value, and must not call
+	// GetOrCompute, GetOrLoad or SetIfAbsent either.
+	OnExit func(value interface{}) `json:"-"`
+	// OnEvictWorkers, if set, is the number of goroutines calling OnEvict and
+	// OnEvictWithFlags, so callbacks doing I/O don't hold up Sets. Evictions
+	// wait for them in a queue of OnEvictQueueSize, 1024 by default. If the
+	// queue is full, the callbacks are called right away, and counted by the
+	// evict-callbacks-overflowed metric. Callbacks may then be called
+	// concurrently, and out of order.
+	OnEvictWorkers int `json:"onEvictWorkers"`
+	// OnEvictQueueSize is the number of evictions waiting for OnEvictWorkers.
+	OnEvictQueueSize int `json:"onEvictQueueSize"`
+	// Logger, if set, reports what the cache otherwise handles silently:
+	// bursts of Sets dropped by a full Set buffer, corrections made when
+	// reconciling the cost accounting, and panics in OnEvict, OnExpire,
+	// OnUpdate, OnExit, OnOversize and the other eviction callbacks, or in
+	// a Store or Codec while applying a Set. Those panics are recovered
+	// either way, and counted by the callback-panics-recovered metric.
+	Logger Logger `json:"-"`
 	// KeyToHash function is used to customize the key hashing algorithm.
 	// Each key will be hashed using the provided function. If keyToHash value
-	// is not set, the default keyToHash function is used.
-	KeyToHash func(key interface{}) uint64
+	// is not set, the default keyToHash function is used. It must not keep a
+	// reference to the key once it returns.
+	KeyToHash func(key interface{}) uint64 `json:"-"`
+	// Hasher, if set, hashes keys instead of KeyToHash, calling its fast path
+	// for the key's type (see XXH3Hasher and WyHasher). It can't be set along
+	// with KeyToHash.
+	Hasher Hasher `json:"-"`
+	// HashSeed seeds the hashing of keys to the access counters used for
+	// admission. Keys are hashed by KeyToHash before, and integers are
+	// their own hash by default, so without a seed, clients picking keys
+	// could make them all share counters, and get their keys admitted over
+	// hotter ones. If it's zero, every cache picks a random seed, except in
+	// DeterministicMode, where keys aren't seeded so runs are reproducible.
+	// Setting it makes admission decisions reproducible across runs, and
+	// it's left out of the JSON encoding of the Config to stay secret.
+	//
+	// It doesn't seed the hashes keys are stored and sharded by, which
+	// callbacks, Invalidation, Store and LowerTier rely on being the same
+	// in every cache and run. Keys picked to share a shard can still crowd
+	// it, which slows its writes down, but doesn't change what's admitted.
+	HashSeed uint64 `json:"-"`
+	// NumShards is the number of independently locked shards the key-value
+	// store is split into. It must be a power of two. If it's zero, a value
+	// scaled to GOMAXPROCS is used, which is usually what you want: more
+	// shards lower lock contention on machines with many cores, while fewer
+	// shards save memory in small deployments.
+	NumShards uint64 `json:"numShards"`
+	// LockFreeReads switches the store to copy-on-write shards, so Gets never
+	// acquire a lock and scale near-linearly with the number of cores. Every
+	// write copies the shard it touches, so this is only worth it for
+	// workloads that are overwhelmingly reads. Increasing NumShards keeps the
+	// copies small.
+	LockFreeReads bool `json:"lockFreeReads"`
+	// Store, if set, keeps the cache's values in a FileStore instead of
+	// memory, so they survive restarts. The values kept from a previous run
+	// are added to the cache by NewCache. NumShards and LockFreeReads don't
+	// apply to it.
+	Store *FileStore `json:"-"`
+	// LowerTier, if set, is a larger, slower cache, usually one with a
+	// FileStore, that evicted []byte values are demoted to instead of being
+	// dropped. Gets of keys missing from the cache look there, and move the
+	// keys they find back up. Values with a TTL, including DefaultTTL, move
+	// with the TTL they have left, and a FileStore keeps them in memory
+	// rather than in its file. Keys are passed down hashed, so the lower tier
+	// must only be used as a tier, and conditional writes like SetIfAbsent
+	// only look at this cache.
+	LowerTier *Cache `json:"-"`
+	// SetBufferBlocking makes Set wait for room in the Set buffer when it's
+	// full, instead of dropping the Set and returning false. This trades
+	// ingestion speed for not losing writes under contention, which is what
+	// batch loaders usually want.
+	SetBufferBlocking bool `json:"setBufferBlocking"`
+	// SetBufferTimeout bounds how long a blocking Set waits for room in the
+	// Set buffer before the Set is dropped. Zero means waiting indefinitely.
+	SetBufferTimeout time.Duration `json:"setBufferTimeout"`
+	// SetBufferSize is the number of Sets (and Dels) the Set buffer holds
+	// while waiting for the policy. If it's zero, DefaultSetBufferSize is
+	// used.
+	SetBufferSize int64 `json:"setBufferSize"`
+	// SetBufferStripes is the number of stripes the Set buffer is split
+	// into, so concurrent Sets of different keys don't contend on a single
+	// buffer. It must be a power of two. If it's zero, there's a stripe per
+	// GOMAXPROCS, as long as every stripe holds at least 256 Sets.
+	SetBufferStripes int64 `json:"setBufferStripes"`
+	// NumWorkers is the number of goroutines applying buffered Sets, 1 by
+	// default. Each worker has its own shard of the policy, holding an equal
+	// part of MaxCost, so workers don't wait on each other, and a slow
+	// OnEvict only holds up the Sets of its shard. OnEvict, OnEvictWithFlags
+	// and OnExpire may then be called concurrently. It must be a power of
+	// two, and it's ignored in DeterministicMode.
+	//
+	// The default used to be two goroutines sharing the whole policy, which
+	// applied the Sets of a key out of order and mostly waited on the policy
+	// lock. More workers split the policy, so each shard evicts among fewer
+	// keys. BenchmarkCacheSetWorkers measures what they gain on a machine.
+	NumWorkers int64 `json:"numWorkers"`
+	// SetDropPolicy chooses which Sets are dropped when the Set buffer is
+	// full. The default is DropNewest.
+	SetDropPolicy SetDropPolicy `json:"setDropPolicy"`
+	// Codec, if set, is applied to every []byte value on Set and reversed on
+	// Get (see SnappyCodec). The cost of an encoded value is its encoded
+	// length, regardless of the cost passed to Set, so MaxCost should be
+	// expressed in bytes when using a Codec. Values of other types are stored
+	// as they are.
+	Codec Codec `json:"-"`
+	// Clock is the source of time used by the cache, such as for
+	// SetBufferTimeout. If it's nil, SystemClock is used. Tests can pass a
+	// ManualClock to fast-forward time.
+	Clock Clock `json:"-"`
+	// DeterministicMode processes every Get, Set and Del before returning,
+	// instead of buffering them for background goroutines. Nothing is ever
+	// dropped and the cache's state right after a call is predictable, so
+	// tests of code using the cache don't need to sleep. It's much slower
+	// under contention and isn't meant for production.
+	DeterministicMode bool `json:"deterministicMode"`
+	// DebugInvariants makes the cache cross-check the policy's cost
+	// accounting against the contents of the store whenever the Set buffer
+	// is drained, and panic with a description of the divergence if they
+	// disagree. Every check walks the whole cache, so it's only meant for
+	// tests and debugging.
+	DebugInvariants bool `json:"debugInvariants"`
+	// HotKeys, if set, is the number of the most accessed keys tracked for
+	// Cache.HotKeys. Tracking takes a heap update for every access reaching
+	// the policy, and only keys accessed more often than the least accessed
+	// tracked key are guaranteed to show up, so it should be a few times
+	// bigger than the number of keys you're interested in.
+	HotKeys int `json:"hotKeys"`
+	// CostAwareAdmission makes the policy weigh a key's access frequency
+	// against its cost: a new key only evicts keys with fewer accesses per
+	// unit of cost than its own, and those are evicted first. Without it,
+	// a large key that's accessed a little more often than a few small hot
+	// keys evicts all of them. It's worth enabling when costs vary widely.
+	CostAwareAdmission bool `json:"costAwareAdmission"`
+	// AdmitAfterRejections, if set, admits a key once the policy rejected it
+	// that many times since the access counters were last halved. Otherwise,
+	// keys that are only Set after a miss may never be accessed often enough
+	// to be admitted, even as they become popular.
+	AdmitAfterRejections int `json:"admitAfterRejections"`
+	// FastFill admits every key as long as the cache isn't full, evicting
+	// what it takes to make room, instead of only the keys that fit. A cold
+	// cache fills up faster that way, since the access counters can't tell
+	// keys apart until they saw enough accesses.
+	FastFill bool `json:"fastFill"`
+	// AdmissionGrace, if set, keeps newly admitted keys from being evicted
+	// until that many more keys were admitted. Under heavy write pressure,
+	// keys may otherwise be admitted only to be evicted before they were ever
+	// accessed. Protected keys are still evicted if nothing else is left, or
+	// if most keys are protected and sampling skipped too many of them. It
+	// only applies to EvictSampledLFU.
+	AdmissionGrace int `json:"admissionGrace"`
+	// EvictionSampleSize is the number of keys the policy samples when
+	// looking for a key to evict, DefaultEvictionSampleSize by default.
+	// Larger samples find colder victims at the cost of slower Sets.
+	EvictionSampleSize int `json:"evictionSampleSize"`
+	// AdaptiveEvictionSample lets the sample grow up to four times
+	// EvictionSampleSize while the hits of sampled keys vary a lot, as they do
+	// when a few cold keys hide among hot ones, and shrink back otherwise.
+	// Both only apply to EvictSampledLFU.
+	AdaptiveEvictionSample bool `json:"adaptiveEvictionSample"`
+	// EvictionSampleSeed, if set, seeds the source the keys sampled for
+	// eviction are drawn from, so the same keys are evicted on every run
+	// given the same Sets and Gets, like in simulations. Otherwise, keys are
+	// sampled in the order Go iterates maps in, which changes from run to
+	// run. DeterministicMode seeds it with 1 if it's zero. It only applies
+	// to EvictSampledLFU.
+	EvictionSampleSeed int64 `json:"evictionSampleSeed"`
+	// EvictionPolicy chooses how keys are admitted and evicted. The default
+	// is EvictSampledLFU.
+	EvictionPolicy EvictionPolicy `json:"evictionPolicy"`
+	// ScanWindow, if set, makes admission watch out for scans, like a batch
+	// job reading a whole table once, which would evict the working set for
+	// keys that are never read again. Keys going through admission are
+	// counted in windows of ScanWindow keys, and while at least
+	// ScanThreshold of a window were new keys, accessed at most once, every
+	// new key is rejected, for at most 16 windows. Scans are counted by the
+	// scan-mode-activations metric. Only the default EvictSampledLFU policy
+	// uses it.
+	ScanWindow int `json:"scanWindow"`
+	// ScanThreshold is the share of new keys that makes a window of
+	// ScanWindow keys a scan. It defaults to 0.9.
+	ScanThreshold float64 `json:"scanThreshold"`
+	// IdleDecay, if set, halves the hits of keys for every IdleDecay they
+	// weren't accessed for when looking for a victim, so keys that were
+	// popular once but went idle are evicted before keys in use, rather than
+	// once their hits were halved often enough by the access counters. Only
+	// the default EvictSampledLFU policy uses it.
+	IdleDecay time.Duration `json:"idleDecay"`
+	// AutoTune, if set, makes the cache tune the eviction sample size, how
+	// often the access counters are halved and AdmissionGrace by hill
+	// climbing: every AutoTune, it changes one of them a notch, and keeps
+	// the change if the hit ratio improved over the next AutoTune, or
+	// reverts it otherwise. The values in use are reported by
+	// Metrics().Tuning(), and AutoTune keeps metrics like Metrics does. It
+	// has no effect in DeterministicMode, or with policies other than the
+	// default EvictSampledLFU.
+	AutoTune time.Duration `json:"autoTune"`
+	// TrackMissPenalty, if set, makes the cache remember the miss penalty of
+	// keys, which is how long GetOrCompute and GetOrLoad took to load them,
+	// or what was given to WithMissPenalty, and add it up for every Get of a
+	// cached key in Metrics().MissPenaltySaved(). Gets are counted when
+	// they're applied to the policy, so the Gets it drops aren't. Only the
+	// default EvictSampledLFU policy uses it.
+	TrackMissPenalty bool `json:"trackMissPenalty"`
+	// PenaltyAwareAdmission tracks miss penalties like TrackMissPenalty, and
+	// multiplies the hits of keys by their miss penalty when admitting and
+	// evicting them, so keys that are slow to load are kept over keys that
+	// are accessed as often but are quick to load again. Keys without a
+	// miss penalty count as loading in the mean time of those with one.
+	PenaltyAwareAdmission bool `json:"penaltyAwareAdmission"`
+	// SizingCurve, if set, makes the cache estimate the hit ratio it would
+	// have at every one of these multiples of MaxCost, such as
+	// []float64{0.5, 1, 2}, by feeding a miniature Shadow per multiple a
+	// sample of the keys. Metrics().SizingCurve() reports the estimates, and
+	// SizingCurve keeps metrics like Metrics does. The multiples apply to
+	// the MaxCost the cache was created with, and must be positive.
+	SizingCurve []float64 `json:"sizingCurve,omitempty"`
+	// SizingSampleRate is the share of keys fed to the shadows of
+	// SizingCurve, whose MaxCost is scaled down by as much. It defaults to
+	// 0.01, which suits caches of at least about 100k keys: a sample too
+	// small to hold a few hundred keys makes for noisy estimates.
+	SizingSampleRate float64 `json:"sizingSampleRate"`
+	// DefaultTTL, if set, is the TTL of keys added without one, by Set
+	// without WithTTL or WithIdleTTL as well as by GetOrCompute, GetAndSet,
+	// Warm, SetWithTags and the like, so caches whose keys all go stale alike
+	// don't need to pass it with every Set. Replace, SetIfVersion and
+	// GetAndSet of a cached key keep the TTL it had.
+	DefaultTTL time.Duration `json:"defaultTTL"`
+	// TTLJitter randomizes the TTL of every key added with one, whether
+	// it's passed to SetWithTTL, SetWithIdleTTL, WithTTL or WithIdleTTL or
+	// it's DefaultTTL, by up to that fraction either way, so keys set at the
+	// same time don't all expire at once and stampede whatever they're
+	// cached from. For example, 0.1 turns a TTL of a minute into anything
+	// between 54 and 66 seconds. It must be less than 1.
+	TTLJitter float64 `json:"ttlJitter"`
+	// MemoryPressureThreshold, if set, makes the cache shrink when the
+	// memory used by the Go runtime exceeds that fraction of its memory
+	// limit (GOMEMLIMIT), so the cache gives way before the garbage collector
+	// has to work overtime. Usage is checked every second, and every check
+	// above the threshold evicts MemoryPressureEvict of the cache's cost. It
+	// has no effect without a memory limit, or in DeterministicMode.
+	MemoryPressureThreshold float64 `json:"memoryPressureThreshold"`
+	// MemoryPressureEvict is the fraction of the cache's cost evicted
+	// whenever memory usage is above MemoryPressureThreshold. It defaults to
+	// 0.1.
+	MemoryPressureEvict float64 `json:"memoryPressureEvict"`
+	// Invalidation, if set, broadcasts every Del to other processes sharing
+	// it, and deletes the keys they broadcast, so replicas caching the same
+	// data stay coherent. See Invalidator.
+	Invalidation Invalidator `json:"-"`
+	// Peers, if set, picks the process that loads a key missing from the
+	// cache in GetOrLoad, so every key is loaded from the origin once instead
+	// of once per process. See PeerPicker.
+	Peers PeerPicker `json:"-"`
+	// LoadErrorTTL, if set, makes GetOrCompute and GetOrLoad remember the
+	// error of a failed load for that long, and return it instead of loading
+	// the key again, so a failing origin isn't hit by every miss.
+	LoadErrorTTL time.Duration `json:"loadErrorTTL"`
+	// CostClasses, if set, breaks the cost added and evicted down by size
+	// class in Metrics, so you can tell whether large keys are churning the
+	// cache. The bounds must be increasing, and every bound is the first cost
+	// of the next class, so []int64{1 << 10, 64 << 10} tracks keys under
+	// 1KB, keys from 1KB to 64KB, and keys of 64KB or more.
+	CostClasses []int64 `json:"costClasses,omitempty"`
+	// PopularityDeciles, if set, makes Metrics break the hit ratio of Gets
+	// down by the popularity of their key, so you can tell whether misses
+	// are of rarely read keys, which is expected, or of popular ones, which
+	// points at the policy. Every Get estimates its key's access frequency,
+	// locking the policy, so it's meant for analysis rather than production.
+	// It has no effect unless Metrics is set.
+	PopularityDeciles bool `json:"popularityDeciles"`
+	// ReadyAfter is how long the cache must stay over 90% of MaxCost before
+	// the channel returned by Ready is closed, so load balancers can hold
+	// heavy traffic off cold replicas. Saturation is checked every second,
+	// or on every Set in DeterministicMode.
+	ReadyAfter time.Duration `json:"readyAfter"`
+	// ReconcileInterval, if set, makes the cache run Reconcile that often,
+	// correcting any drift of its cost accounting in long running processes.
+	// It has no effect in DeterministicMode.
+	ReconcileInterval time.Duration `json:"reconcileInterval"`
 }
 
+// EvictionPolicy determines which keys are evicted when the cache is full.
+type EvictionPolicy int
+
+const (
+	// EvictSampledLFU admits keys with TinyLFU and evicts the least
+	// frequently accessed of a few sampled keys.
+	EvictSampledLFU EvictionPolicy = iota
+	// EvictGDSF admits every key and evicts the key with the fewest hits per
+	// unit of cost, aged so that formerly hot keys eventually go. It suits
+	// workloads where costs vary by orders of magnitude, like web objects.
+	EvictGDSF
+	// EvictLFUDA admits every key and evicts the key with the fewest hits,
+	// aged so that formerly hot keys eventually go. Compared to EvictGDSF,
+	// it favors byte hit ratio over object hit ratio.
+	EvictLFUDA
+	// EvictExactLFU admits every key and evicts the key with the fewest hits,
+	// or the least recently used of them, counting hits exactly instead of
+	// estimating them. It's meant for small caches, where sampling evicts
+	// hot keys too often and the access counters aren't worth their
+	// overhead.
+	EvictExactLFU
+	// EvictExactLRU admits every key and evicts the least recently used one.
+	// Like EvictExactLFU, it's meant for small caches.
+	EvictExactLRU
+	// EvictAuto picks EvictExactLFU if MaxCost is at most 10,000, as it is
+	// when it counts keys of a small cache, and EvictSampledLFU otherwise.
+	EvictAuto
+)
+
+// SetDropPolicy determines what happens to Sets when the Set buffer is full.
+type SetDropPolicy int
+
+const (
+	// DropNewest drops the incoming Set.
+	DropNewest SetDropPolicy = iota
+	// DropOldest drops the oldest buffered Set to make room for the incoming
+	// one. Buffered Dels are never dropped, they're applied right away.
+	DropOldest
+	// DropCoalesce merges every Set for a key that's already waiting in the
+	// buffer into the waiting Set, so repeated Sets of hot keys only take up
+	// a single slot. The value and options of the last Set win. Sets aren't
+	// merged across a Del, GetOrCompute or SetIfAbsent of the key buffered
+	// in between. If the buffer is still full, the incoming Set is dropped.
+	DropCoalesce
+)
+
+// GetDrainPolicy determines when the accesses recorded in the Get buffer are
+// handed to the policy, where they start counting for admission and eviction.
+type GetDrainPolicy int
+
+const (
+	// DrainWhenFull drains a stripe once it holds GetBufferStripeSize keys.
+	// It's the cheapest, but accesses to rarely used stripes may wait a
+	// while before they count.
+	DrainWhenFull GetDrainPolicy = iota
+	// DrainOnInterval also drains every stripe every GetDrainInterval, so
+	// accesses wait at most that long. It needs a fixed number of stripes,
+	// so GetBufferStripes defaults to one per CPU.
+	DrainOnInterval
+	// DrainEveryGet hands every access to the policy right away, which is
+	// the shortest delay but contends on the policy the most.
+	DrainEveryGet
+)
+
+// itemFlag tells processItem what to do with an item.
+type itemFlag byte
+
+const (
+	// itemNew adds the item to the cache if the policy admits it.
+	itemNew itemFlag = iota
+	// itemDelete deletes the key from the cache.
+	itemDelete
+	// itemUpdate updates the cost of a key whose value was already updated
+	// in place.
+	itemUpdate
+)
+
 // item is passed to setBuf so items can eventually be added to the cache
 type item struct {
-	key  uint64
-	val  interface{}
-	cost int64
-	del  bool
+	flag    itemFlag
+	key     uint64
+	val     interface{}
+	cost    int64
+	version uint64
+	// entryFlags are the caller's flags, stored along with the value
+	entryFlags uint32
+	// tags, if set, replace the tags of the key once it's added
+	tags []string
+	// wg, if set, is marked as done once the item has been processed
+	wg *sync.WaitGroup
+	// merged is set once a Set was coalesced into the item
+	merged bool
+	// ifAbsent drops the item if the key is already in the cache
+	ifAbsent bool
+	// added is set once the item was added to the cache
+	added bool
+	// warm skips admission, but drops the item if it doesn't fit
+	warm bool
+	// pin keeps the key from being evicted once it's added or updated
+	pin bool
+	// noAdmit skips admission, evicting other keys to make room
+	noAdmit bool
+	// priority is the key's eviction priority, if prioritize is set
+	priority   int
+	prioritize bool
+	// penalty, if set, is the key's miss penalty, given to the policy before
+	// the key is added or updated
+	penalty time.Duration
+}
+
+// itemPool recycles items once they've been processed or evicted, so busy
+// caches don't allocate a new one for every Set and eviction.
+var itemPool = sync.Pool{
+	New: func() interface{} { return new(item) },
+}
+
+// getItem returns an empty item from itemPool.
+func getItem() *item {
+	return itemPool.Get().(*item)
+}
+
+// putItem clears i and returns it to itemPool. Nothing may use i afterwards.
+func putItem(i *item) {
+	*i = item{}
+	itemPool.Put(i)
 }
 
 // NewCache returns a new Cache instance and any configuration errors, if any.
 func NewCache(config *Config) (*Cache, error) {
 	switch {
-	case config.NumCounters == 0:
-		return nil, errors.New("NumCounters can't be zero.")
-	case config.MaxCost == 0:
+	case config.NumCounters < 0:
+		return nil, errors.New("NumCounters can't be negative.")
+	case config.AverageCost < 0:
+		return nil, errors.New("AverageCost can't be negative.")
+	case config.MaxCost == 0 && config.MaxCostPercent == 0:
 		return nil, errors.New("MaxCost can't be zero.")
-	case config.BufferItems == 0:
-		return nil, errors.New("BufferItems can't be zero.")
+	case config.MaxCostPercent < 0 || config.MaxCostPercent > 100:
+		return nil, errors.New("MaxCostPercent must be between 0 and 100.")
+	case config.GetBufferStripeSize < 0 || config.GetBufferSize < 0 ||
+		config.BufferItems < 0:
+		return nil, errors.New("GetBufferStripeSize can't be negative.")
+	case config.NumShards&(config.NumShards-1) != 0:
+		return nil, errors.New("NumShards must be a power of two.")
+	case config.GetBufferStripes&(config.GetBufferStripes-1) != 0:
+		return nil, errors.New("GetBufferStripes must be a power of two.")
+	case config.GetDrainPolicy < DrainWhenFull ||
+		config.GetDrainPolicy > DrainEveryGet:
+		return nil, errors.New("GetDrainPolicy is unknown.")
+	case config.GetDrainInterval < 0:
+		return nil, errors.New("GetDrainInterval can't be negative.")
+	case config.SetBufferSize < 0:
+		return nil, errors.New("SetBufferSize can't be negative.")
+	case config.SetBufferStripes < 0 ||
+		config.SetBufferStripes&(config.SetBufferStripes-1) != 0:
+		return nil, errors.New("SetBufferStripes must be a power of two.")
+	case config.OnEvictWorkers < 0:
+		return nil, errors.New("OnEvictWorkers can't be negative.")
+	case config.OnEvictQueueSize < 0:
+		return nil, errors.New("OnEvictQueueSize can't be negative.")
+	case config.NumWorkers < 0 ||
+		config.NumWorkers&(config.NumWorkers-1) != 0:
+		return nil, errors.New("NumWorkers must be a power of two.")
+	case config.SetBufferStripes != 0 &&
+		config.SetBufferStripes < config.NumWorkers:
+		return nil, errors.New("SetBufferStripes can't be less than NumWorkers.")
+	case config.HotKeys < 0:
+		return nil, errors.New("HotKeys can't be negative.")
+	case config.AdmitAfterRejections < 0:
+		return nil, errors.New("AdmitAfterRejections can't be negative.")
+	case config.AdmissionGrace < 0:
+		return nil, errors.New("AdmissionGrace can't be negative.")
+	case config.EvictionSampleSize < 0:
+		return nil, errors.New("EvictionSampleSize can't be negative.")
+	case config.EvictionPolicy < EvictSampledLFU ||
+		config.EvictionPolicy > EvictAuto:
+		return nil, errors.New("EvictionPolicy is unknown.")
+	case config.DefaultTTL < 0:
+		return nil, errors.New("DefaultTTL can't be negative.")
+	case config.TTLJitter < 0 || config.TTLJitter >= 1:
+		return nil, errors.New("TTLJitter must be between 0 and 1.")
+	case config.MemoryPressureThreshold < 0 || config.MemoryPressureThreshold > 1:
+		return nil, errors.New("MemoryPressureThreshold must be between 0 and 1.")
+	case config.MemoryPressureEvict < 0 || config.MemoryPressureEvict > 1:
+		return nil, errors.New("MemoryPressureEvict must be between 0 and 1.")
+	case config.ScanWindow < 0:
+		return nil, errors.New("ScanWindow can't be negative.")
+	case config.ScanThreshold < 0 || config.ScanThreshold > 1:
+		return nil, errors.New("ScanThreshold must be between 0 and 1.")
+	case config.IdleDecay < 0:
+		return nil, errors.New("IdleDecay can't be negative.")
+	case config.AutoTune < 0:
+		return nil, errors.New("AutoTune can't be negative.")
+	case !positive(config.SizingCurve):
+		return nil, errors.New("SizingCurve multiples must be positive.")
+	case config.SizingSampleRate < 0 || config.SizingSampleRate > 1:
+		return nil, errors.New("SizingSampleRate must be between 0 and 1.")
+	case config.MaxKeyCost < 0:
+		return nil, errors.New("MaxKeyCost can't be negative.")
+	case config.ReconcileInterval < 0:
+		return nil, errors.New("ReconcileInterval can't be negative.")
+	case config.LoadErrorTTL < 0:
+		return nil, errors.New("LoadErrorTTL can't be negative.")
+	case config.ReadyAfter < 0:
+		return nil, errors.New("ReadyAfter can't be negative.")
+	case !increasing(config.CostClasses):
+		return nil, errors.New("CostClasses must be positive and increasing.")
+	case config.KeyToHash != nil && config.Hasher != nil:
+		return nil, errors.New("KeyToHash and Hasher can't both be set.")
+	}
+	setBufferSize := config.SetBufferSize
+	if setBufferSize == 0 {
+		setBufferSize = DefaultSetBufferSize
+	}
+	maxCost := config.MaxCost
+	if config.MaxCostPercent > 0 {
+		if percent := percentMaxCost(config.MaxCostPercent); percent > 0 {
+			maxCost = percent
+		}
+	}
+	if maxCost == 0 {
+		return nil, errors.New("MaxCostPercent needs a memory limit or " +
+			"a known system memory, or MaxCost as a fallback.")
+	}
+	numCounters := config.NumCounters
+	if numCounters == 0 {
+		averageCost := config.AverageCost
+		if averageCost == 0 {
+			averageCost = 1
+		}
+		numCounters = countersFor(maxCost / averageCost)
+	}
+	evictionPolicy := resolveEvictionPolicy(config.EvictionPolicy, maxCost)
+	opts := newPolicyOptions(config)
+	stop, background := make(chan struct{}), &sync.WaitGroup{}
+	opts.stop, opts.stopped = stop, background
+	if opts.hashSeed == 0 && !config.DeterministicMode {
+		opts.hashSeed = randomSeed()
+	}
+	create := func(numCounters, maxCost int64, opts policyOptions) policy {
+		return newEvictionPolicy(evictionPolicy, numCounters, maxCost,
+			config.DeterministicMode, opts)
+	}
+	workers := config.NumWorkers
+	if workers == 0 || config.DeterministicMode {
+		workers = 1
+	}
+	shards := 0
+	createShard := func() policy {
+		// Every shard is seeded differently, so they don't all sample their
+		// keys alike.
+		shardOpts := opts
+		if shardOpts.sampleSeed != 0 {
+			shardOpts.sampleSeed += int64(shards)
+		}
+		shards++
+		return create(shardCost(numCounters, int(workers)),
+			shardCost(maxCost, int(workers)), shardOpts)
+	}
+	var policy policy
+	if workers == 1 {
+		policy = create(numCounters, maxCost, opts)
+	} else {
+		policy = newShardedPolicy(workers, createShard)
+	}
+	var values store
+	if config.Store != nil {
+		values = config.Store
+	} else {
+		values = newStore(config.NumShards, config.LockFreeReads)
 	}
-	policy := newPolicy(config.NumCounters, config.MaxCost)
 	cache := &Cache{
-		store:  newStore(),
-		policy: policy,
-		getBuf: newRingBuffer(ringLossy, &ringConfig{
-			Consumer: policy,
-			Capacity: config.BufferItems,
-		}),
-		// TODO: size configuration for this? like BufferItems but for setBuf?
-		setBuf:    make(chan *item, 32*1024),
+		store:      values,
+		policy:     policy,
+		stop:       stop,
+		background: background,
+		setBuf: newSetBuffer(setBufferSize, config.SetBufferStripes,
+			workers),
+		processMu: make([]sync.Mutex, workers),
 		onEvict:   config.OnEvict,
+		onExpire:  config.OnExpire,
+		onUpdate:  config.OnUpdate,
 		keyToHash: config.KeyToHash,
+		codec:     config.Codec,
+		clock:     config.Clock,
+
+		setBlocking: config.SetBufferBlocking,
+		setTimeout:  config.SetBufferTimeout,
+		dropPolicy:  config.SetDropPolicy,
+
+		deterministic:   config.DeterministicMode,
+		debugInvariants: config.DebugInvariants,
+
+		config:       *config,
+		counters:     numCounters,
+		tuneCounters: config.NumCounters == 0,
+		expirations:  newExpirationMap(),
+		ttlJitter:    config.TTLJitter,
+
+		pressureThreshold: config.MemoryPressureThreshold,
+		pressureEvict:     config.MemoryPressureEvict,
+
+		invalidator: config.Invalidation,
+		peers:       config.Peers,
+
+		loadErrorTTL: config.LoadErrorTTL,
+
+		onEvictFlags: config.OnEvictWithFlags,
+		lowerTier:    config.LowerTier,
+
+		tags: newTagIndex(),
+
+		ready: make(chan struct{}),
+	}
+	if cache.pressureEvict == 0 {
+		cache.pressureEvict = defaultPressureEvict
+	}
+	if cache.clock == nil {
+		cache.clock = SystemClock
+	}
+	cache.reconciledAt = cache.clock.Now()
+	cache.health = newHealthState(int(workers), cache.reconciledAt)
+	if cache.dropPolicy == DropCoalesce {
+		cache.pending = make(map[uint64]*item)
+	}
+	if config.Hasher != nil {
+		cache.keyToHash = hashKeys(config.Hasher)
 	}
 	if cache.keyToHash == nil {
 		cache.keyToHash = z.KeyToHash
 	}
-	if config.Metrics {
+	cache.locks = NewKeyedMutex(cache.keyToHash)
+	if cache.lowerTier != nil {
+		cache.demoted = newDemotions(cache.lowerTier)
+	}
+	cache.loads = newLoadTracker()
+	cache.refs = newRefTracker()
+	autoTune := config.AutoTune > 0 && !config.DeterministicMode
+	if config.Metrics || autoTune || len(config.SizingCurve) > 0 {
 		cache.collectMetrics()
 	}
-	// We can possibly make this configurable. But having 2 goroutines
-	// processing this seems sufficient for now.
-	//
-	// TODO: Allow a way to stop these goroutines.
-	for i := 0; i < 2; i++ {
-		go cache.processItems()
+	if len(config.SizingCurve) > 0 {
+		cache.sizeShadows(maxCost)
+	}
+	if config.OnEvictWithTimes != nil && cache.births == nil {
+		cache.births = make(map[uint64]keyTimes)
+	}
+	if onExit := config.OnExit; onExit != nil {
+		cache.onExit = func(val interface{}) {
+			if val, ok := cache.decode(val); ok {
+				onExit(val)
+			}
+		}
+	}
+	if config.Store != nil {
+		cache.loadStore()
+	}
+	if cache.invalidator != nil {
+		cache.invalidator.Subscribe(cache.invalidate)
+	}
+	ring := &ringConfig{
+		Consumer: policy,
+		Capacity: config.getBufferStripeSize(),
+		Stripes:  config.GetBufferStripes,
+		Stats:    cache.stats,
+	}
+	switch {
+	case cache.deterministic:
+		// drain every Get to the policy right away
+		ring.Capacity, ring.Stripes = 1, 0
+	case config.GetDrainPolicy == DrainEveryGet:
+		ring.Capacity = 1
+	case config.GetDrainPolicy == DrainOnInterval && ring.Stripes == 0:
+		// pooled stripes can't be drained by anyone but their user
+		ring.Stripes = next2Power(int64(runtime.GOMAXPROCS(0)))
+	}
+	if ring.Stripes > 0 {
+		cache.getBuf = newRingBuffer(ringStriped, ring)
+	} else {
+		cache.getBuf = newRingBuffer(ringLossy, ring)
+	}
+	if config.OnEvictWorkers > 0 {
+		cache.dispatchEvictions(config.OnEvictWorkers, config.OnEvictQueueSize)
+	}
+	// Every worker processes its own stripes of setBuf, so the items of a
+	// key are applied in the order they were pushed. Close stops them.
+	if !cache.deterministic {
+		for w := 0; w < int(workers); w++ {
+			background.Add(1)
+			go cache.processItems(w)
+		}
+		background.Add(1)
+		go cache.maintain()
+		if config.GetDrainPolicy == DrainOnInterval {
+			interval := config.GetDrainInterval
+			if interval == 0 {
+				interval = DefaultGetDrainInterval
+			}
+			background.Add(1)
+			go cache.drainGets(interval)
+		}
+		if policies := tunables(policy); autoTune && policies != nil {
+			background.Add(1)
+			go cache.autoTune(newTuner(cache, policies), config.AutoTune)
+		}
 	}
 	return cache, nil
 }
 
+// resolveEvictionPolicy returns the policy EvictAuto stands for given
+// maxCost, or evictionPolicy itself.
+func resolveEvictionPolicy(evictionPolicy EvictionPolicy,
+	maxCost int64) EvictionPolicy {
+	if evictionPolicy != EvictAuto {
+		return evictionPolicy
+	}
+	if maxCost <= exactLimit {
+		return EvictExactLFU
+	}
+	return EvictSampledLFU
+}
+
+// countersFor returns the number of access counters for a cache holding keys
+// keys, 10 per key up to DefaultMaxCounters.
+func countersFor(keys int64) int64 {
+	if keys < 1 {
+		keys = 1
+	}
+	if keys > DefaultMaxCounters/10 {
+		return DefaultMaxCounters
+	}
+	return keys * 10
+}
+
+// retuneCounters resizes the access counters once the number of cached keys
+// strays from what they were sized for by more than a factor of 2, if
+// NumCounters was derived. Counters only shrink once the cache is nearly
+// full, as it may still be filling up. The caller must hold every lock in
+// processMu.
+func (c *Cache) retuneCounters() {
+	if !c.tuneCounters {
+		return
+	}
+	want := countersFor(int64(c.store.Len()))
+	full := c.policy.Cap() <= c.policy.MaxCost()/10
+	if want > 2*c.counters || full && 2*want < c.counters {
+		c.policy.ResizeCounters(want)
+		atomic.StoreInt64(&c.counters, want)
+	}
+}
+
+// newEvictionPolicy returns a policy implementing evictionPolicy, which
+// can't be EvictAuto. Unless sync is true, the default policy takes accesses
+// in on a goroutine of its own.
+func newEvictionPolicy(evictionPolicy EvictionPolicy, numCounters,
+	maxCost int64, sync bool, opts policyOptions) policy {
+	switch {
+	case evictionPolicy == EvictExactLFU:
+		return newExactLFUPolicy(maxCost, opts)
+	case evictionPolicy == EvictExactLRU:
+		return newExactLRUPolicy(maxCost, opts)
+	case evictionPolicy == EvictGDSF:
+		return newGDSFPolicy(numCounters, maxCost, opts)
+	case evictionPolicy == EvictLFUDA:
+		return newLFUDAPolicy(numCounters, maxCost, opts)
+	case sync:
+		return newSyncPolicy(numCounters, maxCost, opts)
+	default:
+		return newPolicy(numCounters, maxCost, opts)
+	}
+}
+
+// getBufferStripeSize returns the size of the Get buffer stripes, from
+// GetBufferStripeSize or the deprecated fields it replaced.
+func (config *Config) getBufferStripeSize() int64 {
+	switch {
+	case config.GetBufferStripeSize > 0:
+		return config.GetBufferStripeSize
+	case config.GetBufferSize > 0:
+		return config.GetBufferSize
+	case config.BufferItems > 0:
+		return config.BufferItems
+	}
+	return DefaultGetBufferStripeSize
+}
+
+// newPolicyOptions returns the optional features of the policy set in
+// config.
+func newPolicyOptions(config *Config) policyOptions {
+	opts := policyOptions{
+		hotKeys:        config.HotKeys,
+		byCost:         config.CostAwareAdmission,
+		admitAfter:     config.AdmitAfterRejections,
+		fill:           config.FastFill,
+		grace:          config.AdmissionGrace,
+		sampleSize:     config.EvictionSampleSize,
+		adaptiveSample: config.AdaptiveEvictionSample,
+		sampleSeed:     config.EvictionSampleSeed,
+		scanWindow:     config.ScanWindow,
+		scanThreshold:  config.ScanThreshold,
+		idleDecay:      config.IdleDecay,
+		clock:          config.Clock,
+		penalties:      config.TrackMissPenalty || config.PenaltyAwareAdmission,
+		weighPenalties: config.PenaltyAwareAdmission,
+		hashSeed:       config.HashSeed,
+	}
+	if opts.sampleSize == 0 && opts.adaptiveSample {
+		opts.sampleSize = DefaultEvictionSampleSize
+	}
+	if opts.sampleSeed == 0 && config.DeterministicMode {
+		opts.sampleSeed = 1
+	}
+	if opts.scanThreshold == 0 {
+		opts.scanThreshold = defaultScanThreshold
+	}
+	if opts.clock == nil {
+		opts.clock = SystemClock
+	}
+	return opts
+}
+
 // Get returns the value (if any) and a boolean representing whether the
 // value was found or not. The value can be nil and the boolean can be true at
 // the same time.
@@ -146,15 +1104,155 @@ func (c *Cache) Get(key interface{}) (interface{}, bool) {
 	if c == nil {
 		return nil, false
 	}
-	hash := c.keyToHash(key)
+	return c.get(c.keyToHash(noescape(key)))
+}
+
+// Peek is like Get, but doesn't count as an access: the key's estimated
+// frequency, its recency, its idle TTL and the hit and miss metrics stay as
+// they were, and keys missing from memory aren't looked up in LowerTier. It's
+// meant for background scanners and auditors, which shouldn't sway what the
+// cache admits and evicts.
+func (c *Cache) Peek(key interface{}) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	val, ok := c.store.Get(c.keyToHash(key))
+	if ok {
+		val, ok = c.peek(val)
+	}
+	if ok {
+		val, ok = c.decode(val)
+	}
+	return val, ok
+}
+
+// Has reports whether the key is cached, without returning its value, which
+// spares decoding values stored by Codec. It counts as an access like Get, so
+// keys checked often stay cached, but not as a hit or miss, so existence
+// checks like dedup filters don't skew the hit ratio. Keys in LowerTier are
+// found without being promoted. Use Peek for checks that shouldn't count as
+// an access either.
+func (c *Cache) Has(key interface{}) bool {
+	if c == nil {
+		return false
+	}
+	return c.has(c.keyToHash(noescape(key)))
+}
+
+// has is Has for an already hashed key.
+func (c *Cache) has(hash uint64) bool {
+	c.getBuf.Push(hash)
+	if val, ok := c.store.Get(hash); ok {
+		if _, ok = c.unwrap(val); ok {
+			return true
+		}
+	}
+	return c.lowerTier != nil && c.lowerTier.has(hash)
+}
+
+// noescape hides key from escape analysis, so Get and Set don't force their
+// callers to box keys on the heap. This is safe because keyToHash doesn't
+// keep the key.
+//
+//go:nosplit
+func noescape(key interface{}) interface{} {
+	p := uintptr(unsafe.Pointer(&key))
+	return **(**interface{})(unsafe.Pointer(&p))
+}
+
+// GetMany is like calling Get for each key, returning the values and whether
+// they were found in the same order as keys. The accesses are recorded in the
+// Get buffer as one batch, which drains at most once, so looking up many keys
+// at a time contends on the buffer far less than as many Gets.
+func (c *Cache) GetMany(keys []interface{}) ([]interface{}, []bool) {
+	vals, found := make([]interface{}, len(keys)), make([]bool, len(keys))
+	if c == nil {
+		return vals, found
+	}
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = c.keyToHash(key)
+	}
+	c.getBuf.PushMany(hashes)
+	for i, hash := range hashes {
+		vals[i], found[i] = c.lookup(hash, nil, nil)
+	}
+	return vals, found
+}
+
+// get is Get for an already hashed key.
+func (c *Cache) get(hash uint64) (interface{}, bool) {
 	c.getBuf.Push(hash)
-	val, ok := c.store.Get(hash)
+	return c.lookup(hash, nil, nil)
+}
+
+// lookup is get once the access was recorded in the Get buffer. If info isn't
+// nil, it's filled in with the description of the value found, and if h isn't
+// nil, it's set to a handle to the value.
+func (c *Cache) lookup(hash uint64, info *EntryInfo, h **Handle) (interface{},
+	bool) {
+	var ref *valueRef
+	if h != nil {
+		// the value is referenced before the lock is released, so exit
+		// can't miss it once it's taken out of the store
+		c.refs.Lock()
+	}
+	stored, version, flags, ok := c.store.GetVersion(hash)
+	val := stored
+	if ok {
+		val, ok = c.unwrap(stored)
+	}
+	if h != nil {
+		if ok {
+			ref = c.refs.acquire(val)
+		}
+		c.refs.Unlock()
+	}
+	if ok {
+		if val, ok = c.decode(val); !ok && ref != nil {
+			(&Handle{cache: c, ref: ref}).Release()
+			ref = nil
+		}
+	}
+	if !ok && c.lowerTier != nil {
+		if val, ref, ok = c.promote(hash, h != nil); ok {
+			// the promoted value is only described once its Set is applied
+			stored, version, flags, _ = c.store.GetVersion(hash)
+		}
+	}
 	if ok {
 		c.stats.Add(hit, hash, 1)
 	} else {
 		c.stats.Add(miss, hash, 1)
 	}
-	return val, ok
+	c.countPopularity(hash, ok)
+	for _, shadow := range c.loadShadows() {
+		shadow.get(hash)
+	}
+	if !ok {
+		return nil, false
+	}
+	if info != nil {
+		info.Version, info.Flags = version, flags
+		if v, ok := stored.(*expiringValue); ok {
+			info.Expiration = v.deadline()
+		}
+	}
+	if h != nil {
+		*h = &Handle{cache: c, ref: ref, val: val}
+	}
+	return val, true
+}
+
+// EstimateFrequency returns how often the key was accessed recently, as
+// estimated by the admission policy. Estimates are small (at most 16) and
+// halved periodically, so they're only useful to compare keys with each other.
+// Accesses are buffered, so the most recent ones may not be counted yet.
+func (c *Cache) EstimateFrequency(key interface{}) uint8 {
+	if c == nil {
+		return 0
+	}
+	return uint8(c.policy.Estimate(c.keyToHash(key)))
 }
 
 // Set attempts to add the key-value item to the cache. If it returns false,
@@ -162,22 +1260,373 @@ func (c *Cache) Get(key interface{}) (interface{}, bool) {
 // it returns true, there's still a chance it could be dropped by the policy if
 // its determined that the key-value item isn't worth keeping, but otherwise the
 // item will be added and other items will be evicted in order to make room.
-func (c *Cache) Set(key interface{}, val interface{}, cost int64) bool {
+//
+// If the key is already in the cache, its value is updated right away instead,
+// and the Set is never dropped or rejected.
+//
+// Options like WithTTL, WithTags and WithPin change how the key is stored.
+func (c *Cache) Set(key interface{}, val interface{}, cost int64,
+	opts ...SetOption) bool {
 	if c == nil {
 		return false
 	}
-	hash := c.keyToHash(key)
-	// TODO: Add a c.store.UpdateIfPresent here. This would catch any value updates and avoid having
-	// to push the key in setBuf.
+	var o setOptions
+	if len(opts) > 0 {
+		if o = newSetOptions(opts); o.exp.ttl < 0 {
+			return false
+		}
+	}
+	return c.set(c.keyToHash(noescape(key)), val, cost, o, nil)
+}
+
+// SetContext is like Set, but when the Set buffer is full it waits for room
+// until ctx is done, regardless of Config.SetBufferBlocking. It returns false
+// if the Set was dropped because ctx was done first.
+func (c *Cache) SetContext(ctx context.Context, key interface{}, val interface{},
+	cost int64) bool {
+	if c == nil {
+		return false
+	}
+	return c.set(c.keyToHash(key), val, cost, setOptions{}, ctx.Done())
+}
 
+// set is Set for an already hashed key, stored as described by opts. If done
+// is non-nil, set blocks until there's room in the Set buffer or done is
+// closed.
+func (c *Cache) set(hash uint64, val interface{}, cost int64, opts setOptions,
+	done <-chan struct{}) bool {
+	if !opts.expires {
+		opts.exp = c.defaultExpiry()
+	}
+	orig := val
+	val, cost = c.encode(val, cost)
+	for _, shadow := range c.loadShadows() {
+		shadow.set(hash, cost)
+	}
+	if c.oversized(hash, orig, cost) {
+		c.getAndDel(hash)
+		return false
+	}
+	version := c.nextVersion()
+	val = c.expire(val, cost, opts.exp, version)
+	// keys that are already cached don't need to go through admission again
+	if prev, ok := c.store.Update(hash, val, version, opts.flags); ok {
+		if opts.tags != nil {
+			c.tags.tag(hash, opts.tags)
+		}
+		c.track(hash, val)
+		c.overwrite(hash, prev, val)
+		c.updateCost(hash, cost, opts)
+		return true
+	}
+	// the key may have been demoted, and must not come back with its old
+	// value if this Set is dropped
+	c.forget(hash)
+	i := getItem()
+	i.key, i.val, i.cost, i.version = hash, val, cost, version
+	i.entryFlags, i.tags = opts.flags, opts.tags
+	i.pin, i.noAdmit = opts.pin, opts.noAdmit
+	i.priority, i.prioritize = opts.priority, opts.prioritize
+	i.penalty = opts.penalty
+	if opts.noAdmit {
+		// like warmed keys, forced keys are counted as accessed once so
+		// they hold up against the next new key
+		c.getBuf.Push(hash)
+	}
+	return c.add(i, done)
+}
+
+// oversized reports whether cost is more than Config.MaxKeyCost, in which
+// case val is handed to OnOversize instead of being cached.
+func (c *Cache) oversized(hash uint64, val interface{}, cost int64) bool {
+	if c.config.MaxKeyCost == 0 || cost <= c.config.MaxKeyCost {
+		return false
+	}
+	c.stats.Add(oversizeSets, hash, 1)
+	if c.config.OnOversize != nil {
+		c.callback("OnOversize", func() { c.config.OnOversize(hash, val, cost) })
+	}
+	return true
+}
+
+// add passes a Set of a key that wasn't in the cache on to the policy,
+// returning false if it was dropped. If done is non-nil, add blocks until
+// there's room in the Set buffer or done is closed.
+func (c *Cache) add(i *item, done <-chan struct{}) bool {
+	if c.deterministic {
+		c.process(i)
+		putItem(i)
+		return true
+	}
+	if c.pending != nil {
+		if c.coalesce(i) {
+			return true
+		}
+	}
 	// attempt to add the (possibly) new item to the setBuf where it will later
 	// be processed by the policy and evaluated
+	if c.push(i, done) {
+		return true
+	}
+	c.dropSet(i.key)
+	defer putItem(i)
+	if c.pending != nil && c.unpend(i) {
+		// our value was already replaced by later Sets, which were told they
+		// succeeded, so the value they left behind is what gets dropped
+		c.exit(i.val)
+		return true
+	}
+	return false
+}
+
+// coalesce merges i into the item already waiting in setBuf for the same key
+// and returns true, or registers i as the waiting item and returns false.
+func (c *Cache) coalesce(i *item) bool {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if p, ok := c.pending[i.key]; ok {
+		prev := p.val
+		p.val, p.cost, p.version, p.merged = i.val, i.cost, i.version, true
+		p.entryFlags = i.entryFlags
+		if i.tags != nil {
+			p.tags = i.tags
+		}
+		if i.penalty > 0 {
+			p.penalty = i.penalty
+		}
+		// the options of the last Set apply, like they would if the Sets
+		// were applied one after the other
+		p.pin, p.noAdmit = i.pin, i.noAdmit
+		p.priority, p.prioritize = i.priority, i.prioritize
+		c.stats.Add(coalesceSets, i.key, 1)
+		c.exit(prev)
+		return true
+	}
+	c.pending[i.key] = i
+	return false
+}
+
+// stopCoalescing keeps later Sets of hash from being merged into a Set
+// buffered before a write of hash that isn't coalesced, like a Del, so the
+// writes are still applied in order.
+func (c *Cache) stopCoalescing(hash uint64) {
+	if c.pending == nil {
+		return
+	}
+	c.pendingMu.Lock()
+	delete(c.pending, hash)
+	c.pendingMu.Unlock()
+}
+
+// unpend stops coalescing Sets into i and reports whether any were merged
+// into it. Once it returns, i won't be modified.
+func (c *Cache) unpend(i *item) bool {
+	c.pendingMu.Lock()
+	defer c.pendingMu.Unlock()
+	if c.pending[i.key] == i {
+		delete(c.pending, i.key)
+	}
+	return i.merged
+}
+
+// updateCost passes the new cost of a key updated in place on to the policy,
+// pinning or prioritizing the key as set by opts. It's buffered like any
+// other item, but applied right away if setBuf is full, so the policy never
+// loses track of what the cache holds.
+func (c *Cache) updateCost(hash uint64, cost int64, opts setOptions) {
+	c.rewritten(hash)
+	i := getItem()
+	i.flag, i.key, i.cost, i.pin = itemUpdate, hash, cost, opts.pin
+	i.priority, i.prioritize = opts.priority, opts.prioritize
+	i.penalty = opts.penalty
+	if c.deterministic {
+		c.process(i)
+		putItem(i)
+		return
+	}
+	select {
+	case c.setBuf.stripe(hash) <- i:
+		c.setBuf.signal(hash)
+	default:
+		c.policy.Update(hash, cost)
+		if opts.pin {
+			c.policy.Pin(hash)
+		}
+		if opts.prioritize {
+			c.policy.Prioritize(hash, opts.priority)
+		}
+		if opts.penalty > 0 {
+			c.policy.Penalize(hash, opts.penalty)
+		}
+		putItem(i)
+	}
+}
+
+// nextVersion returns the version of a new write.
+func (c *Cache) nextVersion() uint64 {
+	return atomic.AddUint64(&c.version, 1)
+}
+
+// push adds the item to setBuf, returning false if it couldn't be added
+// without blocking. If done is non-nil or the cache was configured with
+// SetBufferBlocking, push waits for room instead.
+func (c *Cache) push(i *item, done <-chan struct{}) bool {
+	// i may be applied and recycled as soon as it's pushed
+	key := i.key
+	if c.pushTo(c.setBuf.stripe(key), i, done) {
+		c.setBuf.signal(key)
+		return true
+	}
+	return false
+}
+
+// pushTo is push for the stripe of the item's key.
+func (c *Cache) pushTo(stripe chan *item, i *item, done <-chan struct{}) bool {
 	select {
-	case c.setBuf <- &item{key: hash, val: val, cost: cost}:
+	case stripe <- i:
+		return true
+	default:
+	}
+	var timeout <-chan time.Time
+	if done == nil {
+		if c.dropPolicy == DropOldest && c.dropOldest(stripe) {
+			select {
+			case stripe <- i:
+				return true
+			default:
+			}
+		}
+		if !c.setBlocking {
+			// drop the set and avoid blocking
+			return false
+		}
+		if c.setTimeout > 0 {
+			timeout = c.clock.After(c.setTimeout)
+		}
+	}
+	select {
+	case stripe <- i:
+		return true
+	case <-done:
+		return false
+	case <-timeout:
+		return false
+	}
+}
+
+// GetOrCompute returns the value for key if it's present. Otherwise it calls
+// compute and adds the returned value to the cache with the returned cost.
+// Concurrent callers for the same key wait for the first one to finish, so
+// compute runs once instead of once per caller. If compute returns an error,
+// nothing is added to the cache and the error is returned.
+//
+// Unlike Set, the computed value is never dropped by the Set buffer, and
+// GetOrCompute only returns once the value was either added or rejected by
+// the policy.
+//
+// GetOrCompute must not be called from OnEvict, OnExit or the other
+// callbacks, and neither must GetOrLoad. They're called while Sets are
+// applied, which GetOrCompute waits on with the key locked. So it either
+// waits on itself, or on the lock of a key whose GetOrCompute is waiting for
+// the callback to return, and deadlocks.
+func (c *Cache) GetOrCompute(key interface{},
+	compute func() (interface{}, int64, error)) (interface{}, error) {
+	if c == nil {
+		val, _, err := compute()
+		return val, err
+	}
+	hash := c.keyToHash(key)
+	if val, ok := c.get(hash); ok {
+		return val, nil
+	}
+	l := c.loads.wait(hash)
+	c.locks.LockHash(hash)
+	defer c.locks.UnlockHash(hash)
+	c.loads.waited(l)
+	// another caller may have computed the value while we were waiting
+	if val, ok := c.store.Get(hash); ok {
+		if val, ok = c.unwrap(val); ok {
+			if val, ok = c.decode(val); ok {
+				c.stats.Add(loadCoalesce, hash, 1)
+				return val, nil
+			}
+		}
+	}
+	// or failed to, recently
+	if c.loadErrorTTL > 0 {
+		if err, ok := c.loads.failed(hash, c.clock.Now()); ok {
+			c.stats.Add(loadErrorServe, hash, 1)
+			return nil, err
+		}
+	}
+	val, cost, took, err := c.runLoad(hash, key, compute)
+	if err != nil {
+		c.stats.Add(loadError, hash, 1)
+		if c.loadErrorTTL > 0 {
+			c.loads.fail(hash, err, c.clock.Now().Add(c.loadErrorTTL))
+		}
+		return nil, err
+	}
+	stored, cost := c.encode(val, cost)
+	if c.oversized(hash, val, cost) {
+		return val, nil
+	}
+	version := c.nextVersion()
+	stored = c.expire(stored, cost, c.defaultExpiry(), version)
+	i := &item{key: hash, val: stored, cost: cost, version: version,
+		penalty: took}
+	if c.deterministic {
+		c.process(i)
+		return val, nil
+	}
+	i.wg = &sync.WaitGroup{}
+	i.wg.Add(1)
+	c.stopCoalescing(hash)
+	c.setBuf.stripe(hash) <- i
+	c.setBuf.signal(hash)
+	i.wg.Wait()
+	return val, nil
+}
+
+// runLoad calls compute to load the value of a key. The load is finished even if
+// compute panics, so it isn't reported as in flight forever.
+func (c *Cache) runLoad(hash uint64, key interface{},
+	compute func() (interface{}, int64, error)) (val interface{}, cost int64,
+	took time.Duration, err error) {
+	c.loads.start(hash, key, c.clock.Now())
+	c.stats.Add(loadStart, hash, 1)
+	defer func() {
+		took = c.loads.finish(hash, c.clock.Now())
+		c.stats.Add(loadFinish, hash, 1)
+		c.stats.Add(loadTime, hash, uint64(took))
+	}()
+	val, cost, err = compute()
+	return val, cost, took, err
+}
+
+// KeyedMutex returns the per-key locks used by GetOrCompute. Locking a key
+// blocks GetOrCompute calls computing it.
+func (c *Cache) KeyedMutex() *KeyedMutex {
+	if c == nil {
+		return nil
+	}
+	return c.locks
+}
+
+// dropOldest makes room in a stripe of setBuf by dropping its oldest item,
+// returning false if the stripe turned out to be empty. Items that can't be
+// dropped, like Dels and cost updates, are applied right away instead.
+func (c *Cache) dropOldest(stripe chan *item) bool {
+	select {
+	case old := <-stripe:
+		if old.flag != itemNew || old.wg != nil {
+			c.handle(old)
+		} else {
+			c.dropSet(old.key)
+			c.exit(old.val)
+		}
 		return true
 	default:
-		// drop the set and avoid blocking
-		c.stats.Add(dropSets, hash, 1)
 		return false
 	}
 }
@@ -187,40 +1636,359 @@ func (c *Cache) Del(key interface{}) {
 	if c == nil {
 		return
 	}
-	c.setBuf <- &item{key: c.keyToHash(key), del: true}
+	hash := c.keyToHash(key)
+	c.del(hash, c.nextVersion())
+	c.publish(hash)
 }
 
-// Close stops all goroutines and closes all channels.
-func (c *Cache) Close() {}
+// del is Del for an already hashed key, deleting values up to version.
+func (c *Cache) del(hash uint64, version uint64) {
+	c.forget(hash)
+	for _, shadow := range c.loadShadows() {
+		shadow.del(hash)
+	}
+	i := getItem()
+	i.flag, i.key, i.version = itemDelete, hash, version
+	if c.deterministic {
+		c.process(i)
+		putItem(i)
+		return
+	}
+	c.stopCoalescing(hash)
+	c.setBuf.stripe(hash) <- i
+	c.setBuf.signal(hash)
+}
 
-// processItems is ran by goroutines processing the Set buffer.
-func (c *Cache) processItems() {
-	for item := range c.setBuf {
-		if item.del {
-			c.policy.Del(item.key)
-			c.store.Del(item.key)
+// Close removes the values still cached and hands them to OnExit, if it's
+// set, along with the values of Sets still buffered once they're applied.
+// Values kept in the file of Config.Store stay there for the next run.
+// Named caches are removed from NamedCaches. The goroutines of the cache
+// stop, and Close waits for them: those applying Sets once they've applied
+// the ones buffered, and those started for OnEvictWorkers once they've
+// handled the evictions queued. The cache must not be used after Close.
+func (c *Cache) Close() {
+	if c == nil {
+		return
+	}
+	c.unregister()
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	c.background.Wait()
+	c.stopEvictions()
+	if c.onExit == nil {
+		return
+	}
+	c.lockAll()
+	defer c.unlockAll()
+	c.closed = true
+	var keys []uint64
+	c.store.Range(func(key uint64, _ interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	// the store can't be modified while it's walked
+	for _, key := range keys {
+		if c.config.Store != nil && c.config.Store.persists(key) {
+			// the value is kept in the file for the next run
 			continue
 		}
-		victims, added := c.policy.Add(item.key, item.cost)
-		if added {
-			// item was accepted by the policy, so add to the hashmap
-			c.store.Set(item.key, item.val)
-		}
-		// delete victims that are no longer worthy of being in the cache
-		for _, victim := range victims {
-			// eviction callback
-			if c.onEvict != nil {
-				victim.val, _ = c.store.Get(victim.key)
-				c.onEvict(victim.key, victim.val, victim.cost)
+		if val, _, ok := c.store.Del(key, math.MaxUint64); ok {
+			c.policy.Del(key)
+			c.exit(val)
+		}
+	}
+}
+
+// processItems is ran by the goroutine of a worker processing the Set
+// buffer. It drains the worker's stripes round-robin, one item at a time,
+// and once more when the cache is stopped, so Close sees every buffered Set.
+func (c *Cache) processItems(worker int) {
+	defer c.background.Done()
+	stripes := c.setBuf.workerStripes(worker, len(c.processMu))
+	ready := c.setBuf.ready[worker]
+	for {
+		select {
+		case <-ready:
+			c.drainStripes(worker, stripes)
+		case <-c.stop:
+			c.drainStripes(worker, stripes)
+			return
+		}
+	}
+}
+
+// drainStripes applies the items of a worker's stripes until they're empty.
+func (c *Cache) drainStripes(worker int, stripes []chan *item) {
+	for handled := true; handled; {
+		handled = false
+		for _, stripe := range stripes {
+			select {
+			case item := <-stripe:
+				c.health.applying(worker, c.clock.Now())
+				c.handleRecovering(item)
+				c.health.applied(worker)
+				handled = true
+			default:
 			}
-			// delete from hashmap
-			c.store.Del(victim.key)
 		}
 	}
 }
 
+// maintain removes expired keys, resizes the cache and its access counters,
+// relieves memory pressure, checks whether the cache is ready and reconciles
+// its cost accounting when due every expirationInterval, until the cache is
+// stopped.
+func (c *Cache) maintain() {
+	defer c.background.Done()
+	for {
+		select {
+		case <-c.clock.After(expirationInterval):
+		case <-c.stop:
+			return
+		}
+		c.lockAll()
+		c.removeExpired()
+		c.resize()
+		c.retuneCounters()
+		c.relievePressure()
+		c.checkReady()
+		c.reconcileIfDue()
+		c.loads.expireErrors(c.clock.Now())
+		c.unlockAll()
+		if c.lowerTier != nil {
+			c.demoted.prune(c.lowerTier)
+		}
+		c.maintained(c.logDrops())
+	}
+}
+
+// drainGets drains the Get buffer every interval, for DrainOnInterval, until
+// the cache is stopped.
+func (c *Cache) drainGets(interval time.Duration) {
+	defer c.background.Done()
+	for {
+		select {
+		case <-c.clock.After(interval):
+		case <-c.stop:
+			return
+		}
+		c.getBuf.Drain()
+	}
+}
+
+// handleRecovering is handle for the goroutines applying Sets, which recover
+// panics in user code they run, like a Store or Codec, as those would
+// otherwise stop them for good and leave every later Set stuck in setBuf.
+func (c *Cache) handleRecovering(item *item) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.stats.Add(callbackPanics, 0, 1)
+			c.logf("recovered panic applying a Set of key %d: %v", item.key, r)
+		}
+	}()
+	c.handle(item)
+}
+
+// handle processes an item taken out of setBuf.
+func (c *Cache) handle(item *item) {
+	if c.pending != nil && item.flag == itemNew {
+		c.unpend(item)
+	}
+	if item.wg != nil {
+		// GetOrCompute or SetIfAbsent is waiting on the item, so it can't
+		// be reused, and must hear back even if processing it panics
+		defer item.wg.Done()
+		c.process(item)
+		return
+	}
+	c.process(item)
+	putItem(item)
+}
+
+// process applies an item, either taken out of setBuf or applied right away
+// in DeterministicMode.
+func (c *Cache) process(item *item) {
+	mu := c.processLock(item.key)
+	func() {
+		// a panic in user code mustn't leave the lock held
+		mu.Lock()
+		defer mu.Unlock()
+		c.processItem(item)
+		if c.deterministic {
+			// there's no goroutine removing expired keys in the background,
+			// and a single worker
+			c.removeExpired()
+			c.checkReady()
+		}
+	}()
+	if c.debugInvariants && c.setBuf.Len() == 0 {
+		c.lockAll()
+		c.checkInvariants()
+		c.unlockAll()
+	}
+}
+
+// processItem applies a single item from the Set buffer. The caller must hold
+// the processMu lock of the item's key.
+func (c *Cache) processItem(item *item) {
+	switch item.flag {
+	case itemDelete:
+		// the key may have been set again after the Del
+		if val, _, ok := c.store.Del(item.key, item.version); ok {
+			c.exit(val)
+		}
+		// GetAndDelete takes keys out of the store before the Del is
+		// applied, and keys are only added under processMu, so any key
+		// that's missing from the store has to go
+		if _, ok := c.store.Get(item.key); !ok {
+			c.policy.Del(item.key)
+			c.died(item.key)
+			c.tags.untag(item.key)
+		}
+		return
+	case itemUpdate:
+		c.policy.Update(item.key, item.cost)
+		if item.penalty > 0 {
+			c.policy.Penalize(item.key, item.penalty)
+		}
+		if item.pin {
+			c.policy.Pin(item.key)
+		}
+		if item.prioritize {
+			c.policy.Prioritize(item.key, item.priority)
+		}
+		return
+	}
+	// keys are only added here, under processMu, so they can't be added
+	// concurrently
+	if c.closed || item.ifAbsent && c.live(item.key) {
+		c.exit(item.val)
+		return
+	}
+	// there's always room for items that fit, so Add won't reject them
+	if item.warm && item.cost > c.shardPolicy(item.key).Cap() &&
+		!c.policy.Has(item.key) {
+		c.exit(item.val)
+		return
+	}
+	if item.noAdmit {
+		c.makeRoom(item.key, item.cost)
+	}
+	if item.penalty > 0 {
+		c.policy.Penalize(item.key, item.penalty)
+	}
+	victims, added := c.policy.Add(item.key, item.cost)
+	if added {
+		if item.pin {
+			c.policy.Pin(item.key)
+		}
+		if item.prioritize {
+			c.policy.Prioritize(item.key, item.priority)
+		}
+		// item was accepted by the policy, so add to the hashmap, unless the
+		// key was updated in place in the meantime
+		if old, ok := c.store.Set(item.key, item.val, item.version,
+			item.entryFlags); ok {
+			c.overwrite(item.key, old, item.val)
+		}
+		item.added = true
+		if item.tags != nil {
+			c.tags.tag(item.key, item.tags)
+		}
+		c.track(item.key, item.val)
+		c.born(item.key)
+	} else {
+		// the value never made it into the hashmap
+		c.exit(item.val)
+	}
+	c.evict(victims)
+}
+
+// makeRoom evicts keys from the policy of key until a new key costing cost
+// fits without going through admission. The caller must hold the processMu
+// lock of key.
+func (c *Cache) makeRoom(key uint64, cost int64) {
+	p := c.shardPolicy(key)
+	if cost > p.MaxCost() || p.Has(key) {
+		return
+	}
+	if need := cost - p.Cap(); need > 0 {
+		c.evict(p.Evict(need))
+	}
+}
+
+// evict deletes victims of the policy from the store. The caller must hold
+// the processMu locks of their keys.
+func (c *Cache) evict(victims []*item) {
+	// delete victims that are no longer worthy of being in the cache
+	for _, victim := range victims {
+		// delete from hashmap
+		var (
+			flags uint32
+			ok    bool
+		)
+		if victim.val, flags, ok = c.store.Del(victim.key, math.MaxUint64); !ok {
+			c.died(victim.key)
+			c.tags.untag(victim.key)
+			putItem(victim)
+			continue
+		}
+		var added, updated time.Time
+		if times, ok := c.victim(victim.key); ok {
+			added, updated = times.times()
+		}
+		c.tags.untag(victim.key)
+		c.evictions.add(Eviction{
+			Key:     victim.key,
+			Cost:    victim.cost,
+			Time:    c.clock.Now(),
+			Added:   added,
+			Updated: updated,
+		})
+		c.demote(victim.key, victim.val, victim.cost, flags)
+		c.notifyEviction(eviction{
+			key:     victim.key,
+			val:     victim.val,
+			cost:    victim.cost,
+			flags:   flags,
+			added:   added,
+			updated: updated,
+		})
+		putItem(victim)
+	}
+}
+
+// overwrite hands a value overwritten by next to OnUpdate, if set, and then to
+// the onExit hook.
+func (c *Cache) overwrite(hash uint64, prev, next interface{}) {
+	if c.onUpdate != nil {
+		p, ok := c.decode(value(prev))
+		n, nok := c.decode(value(next))
+		if ok && nok {
+			c.callback("OnUpdate", func() { c.onUpdate(hash, p, n) })
+		}
+	}
+	c.exit(prev)
+}
+
+// exit hands a value that left the cache to the onExit hook, if any, unless
+// it's deferred until the value's handles are released.
+func (c *Cache) exit(val interface{}) {
+	if c.onExit != nil && !c.refs.deferExit(value(val)) {
+		c.callback("OnExit", func() { c.onExit(value(val)) })
+	}
+}
+
 func (c *Cache) collectMetrics() {
 	c.stats = newMetrics()
+	c.births = make(map[uint64]keyTimes)
+	if len(c.config.CostClasses) > 0 {
+		c.stats.trackClasses(c.config.CostClasses)
+	}
+	if c.config.PopularityDeciles {
+		c.stats.trackPopularity()
+	}
 	c.policy.CollectMetrics(c.stats)
 }
 
@@ -232,6 +2000,20 @@ func (c *Cache) Metrics() *metrics {
 	return c.stats
 }
 
+// ConfigSnapshot returns a copy of the Config the cache was created with,
+// with MaxCost set to the limit in use, which may come from MaxCostPercent.
+// Unlike String and Dump, its JSON encoding is meant to stay stable.
+func (c *Cache) ConfigSnapshot() Config {
+	if c == nil {
+		return Config{}
+	}
+	config := c.config
+	config.MaxCost = c.policy.MaxCost()
+	config.NumCounters = atomic.LoadInt64(&c.counters)
+	config.CostClasses = append([]int64(nil), c.config.CostClasses...)
+	return config
+}
+
 type metricType int
 
 const (
@@ -243,19 +2025,60 @@ const (
 	keyAdd
 	keyUpdate
 	keyEvict
+	// keyExpire counts keys removed because they expired.
+	keyExpire
 
 	// The following 2 keep track of cost of keys added and evicted.
 	costAdd
 	costEvict
 
-	// The following keep track of how many sets were dropped or rejected later.
+	// dropSets counts Sets dropped because the Set buffer was full, and
+	// rejectSets those rejected later by admission.
 	dropSets
 	rejectSets
+	// coalesceSets counts Sets merged into a buffered Set for the same key.
+	coalesceSets
 
 	// The following 2 keep track of how many gets were kept and dropped on the floor.
 	dropGets
 	keepGets
 
+	// overflowEvictions counts eviction callbacks called right away because
+	// the queue of OnEvictWorkers was full.
+	overflowEvictions
+	// oversizeSets counts Sets of values costing more than MaxKeyCost, or
+	// more than the policy can hold at all.
+	oversizeSets
+	// scanActivations counts the scans detected by Config.ScanWindow.
+	scanActivations
+	// The following 2 count the cost and keys corrected by Reconcile.
+	reconciledCost
+	reconciledKeys
+	// The following 2 count the keys moved down to and up from
+	// Config.LowerTier.
+	keyDemote
+	keyPromote
+	// The following 5 keep track of the keys computed by GetOrCompute and
+	// GetOrLoad: loads started, finished and failed, the callers served by
+	// another caller's load, and the total time spent loading.
+	loadStart
+	loadFinish
+	loadError
+	loadCoalesce
+	loadTime
+	// loadErrorServe counts the errors of failed loads served again because
+	// of Config.LoadErrorTTL.
+	loadErrorServe
+	// missPenaltySaved adds up the miss penalty of the keys of hits, in
+	// nanoseconds, for Config.TrackMissPenalty.
+	missPenaltySaved
+	// tuneKeep and tuneRevert count the changes Config.AutoTune kept and
+	// reverted.
+	tuneKeep
+	tuneRevert
+	// callbackPanics counts panics in user callbacks that were recovered.
+	callbackPanics
+
 	// This should be the final enum. Other enums should be set before this.
 	doNotUse
 )
@@ -272,6 +2095,8 @@ func stringFor(t metricType) string {
 		return "keys-updated"
 	case keyEvict:
 		return "keys-evicted"
+	case keyExpire:
+		return "keys-expired"
 	case costAdd:
 		return "cost-added"
 	case costEvict:
@@ -280,10 +2105,46 @@ func stringFor(t metricType) string {
 		return "sets-dropped"
 	case rejectSets:
 		return "sets-rejected" // by policy.
+	case coalesceSets:
+		return "sets-coalesced"
 	case dropGets:
 		return "gets-dropped"
 	case keepGets:
 		return "gets-kept"
+	case overflowEvictions:
+		return "evict-callbacks-overflowed"
+	case oversizeSets:
+		return "sets-oversized"
+	case scanActivations:
+		return "scan-mode-activations"
+	case reconciledCost:
+		return "cost-reconciled"
+	case reconciledKeys:
+		return "keys-reconciled"
+	case keyDemote:
+		return "keys-demoted"
+	case keyPromote:
+		return "keys-promoted"
+	case loadStart:
+		return "loads-started"
+	case loadFinish:
+		return "loads-finished"
+	case loadError:
+		return "loads-failed"
+	case loadCoalesce:
+		return "loads-coalesced"
+	case loadTime:
+		return "load-time-ns"
+	case loadErrorServe:
+		return "load-errors-served"
+	case missPenaltySaved:
+		return "miss-penalty-saved-ns"
+	case tuneKeep:
+		return "tune-changes-kept"
+	case tuneRevert:
+		return "tune-changes-reverted"
+	case callbackPanics:
+		return "callback-panics-recovered"
 	default:
 		return "unidentified"
 	}
@@ -293,11 +2154,27 @@ func stringFor(t metricType) string {
 // cost to maintaining the counters, so it's best to wrap Policies via the
 // Recorder type when hit ratio analysis is needed.
 type metrics struct {
-	all [doNotUse][]*uint64
+	// resetAt is when the metrics were last reset, in nanoseconds. It comes
+	// first to be 64-bit aligned for atomic operations.
+	resetAt int64
+	all     [doNotUse][]*uint64
+	// classes are the bounds of Config.CostClasses
+	classes []int64
+	// classCosts counts the cost added and evicted in every class, padded
+	// like all
+	classCosts [][2][]*uint64
+	victims    *victimStats
+	// popularity, if set, counts Gets by the popularity of their key
+	popularity *popularityStats
+	// tuning, if set, holds the values of the parameters tuned by
+	// Config.AutoTune
+	tuning *tuningStats
+	// sizing, if set, holds the shadows of Config.SizingCurve
+	sizing []sizingShadow
 }
 
 func newMetrics() *metrics {
-	s := &metrics{}
+	s := &metrics{victims: newVictimStats(), resetAt: time.Now().UnixNano()}
 	for i := 0; i < doNotUse; i++ {
 		s.all[i] = make([]*uint64, 256)
 		slice := s.all[i]
@@ -317,6 +2194,9 @@ func (p *metrics) Add(t metricType, hash, delta uint64) {
 	// atomic counters which would be incremented.
 	idx := (hash % 25) * 10
 	atomic.AddUint64(valp[idx], delta)
+	if p.classCosts != nil && (t == costAdd || t == costEvict) {
+		p.addClass(t, hash, delta)
+	}
 }
 
 func (p *metrics) Get(t metricType) uint64 {
@@ -331,15 +2211,199 @@ func (p *metrics) Get(t metricType) uint64 {
 	return total
 }
 
+// GetsDropped returns the number of Get accesses that were dropped before
+// reaching the policy, either by a busy Get buffer stripe or because the
+// policy was too busy to accept a drained stripe.
+func (p *metrics) GetsDropped() uint64 {
+	return p.Get(dropGets)
+}
+
+// GetsKept returns the number of Get accesses that reached the policy.
+func (p *metrics) GetsKept() uint64 {
+	return p.Get(keepGets)
+}
+
+// SetsDropped returns the number of Sets dropped because the Set buffer was
+// full. If it keeps growing, SetBufferSize or SetBufferStripes are too small
+// for the write load.
+func (p *metrics) SetsDropped() uint64 {
+	return p.Get(dropSets)
+}
+
+// SetsRejected returns the number of new keys the admission policy turned
+// down in favor of keys it deemed more valuable. Rejecting rarely used keys
+// is what admission is for, but a high share of rejections with a low hit
+// ratio suggests MaxCost is too small.
+func (p *metrics) SetsRejected() uint64 {
+	return p.Get(rejectSets)
+}
+
+// SetsOversized returns the number of Sets of values costing more than
+// MaxKeyCost, or more than the whole cache (or its shard) can hold.
+func (p *metrics) SetsOversized() uint64 {
+	return p.Get(oversizeSets)
+}
+
+// SetsCoalesced returns the number of Sets merged into a Set of the same key
+// still waiting in the Set buffer. Only the last value was cached, which is
+// expected behavior rather than a loss.
+func (p *metrics) SetsCoalesced() uint64 {
+	return p.Get(coalesceSets)
+}
+
+// LoadsInflight returns the number of keys GetOrCompute and GetOrLoad are
+// loading right now. Cache.InflightLoads tells which ones.
+func (p *metrics) LoadsInflight() uint64 {
+	// loads finishing while the counters are read, or since the metrics
+	// were reset, mustn't underflow
+	finished := p.Get(loadFinish)
+	started := p.Get(loadStart)
+	if finished > started {
+		return 0
+	}
+	return started - finished
+}
+
+// LoadsFailed returns the number of loads that returned an error.
+func (p *metrics) LoadsFailed() uint64 {
+	return p.Get(loadError)
+}
+
+// LoadsCoalesced returns the number of GetOrCompute and GetOrLoad callers
+// that waited for another caller's load instead of loading the key
+// themselves. Divided by the number of finished loads, it's the average
+// number of callers every load saved.
+func (p *metrics) LoadsCoalesced() uint64 {
+	return p.Get(loadCoalesce)
+}
+
+// LoadErrorsServed returns the number of GetOrCompute and GetOrLoad calls
+// that returned the error of a recently failed load instead of loading the
+// key again. See Config.LoadErrorTTL.
+func (p *metrics) LoadErrorsServed() uint64 {
+	return p.Get(loadErrorServe)
+}
+
+// TuneChangesKept returns the number of changes Config.AutoTune kept because
+// they improved the hit ratio.
+func (p *metrics) TuneChangesKept() uint64 {
+	return p.Get(tuneKeep)
+}
+
+// TuneChangesReverted returns the number of changes Config.AutoTune reverted
+// because they didn't improve the hit ratio.
+func (p *metrics) TuneChangesReverted() uint64 {
+	return p.Get(tuneRevert)
+}
+
+// CallbackPanics returns the number of panics in OnEvict, OnExpire and the
+// other callbacks that were recovered, see Config.Logger.
+func (p *metrics) CallbackPanics() uint64 {
+	return p.Get(callbackPanics)
+}
+
+// LoadLatency returns the average time a load took, including failed ones.
+func (p *metrics) LoadLatency() time.Duration {
+	loads := p.Get(loadFinish)
+	if loads == 0 {
+		return 0
+	}
+	return time.Duration(p.Get(loadTime) / loads)
+}
+
+// Snapshot returns the current value of every metric by name, such as
+// "keys-added" or "sets-dropped". Cost classes are named after their bounds,
+// such as "cost-added-1024-65536", and so are the buckets of the age of
+// evicted keys, in seconds, such as "keys-evicted-age-60-600".
+func (p *metrics) Snapshot() Snapshot {
+	if p == nil {
+		return nil
+	}
+	values := make(Snapshot, doNotUse)
+	for t := metricType(0); t < doNotUse; t++ {
+		values[stringFor(t)] = p.Get(t)
+	}
+	for _, class := range p.CostClasses() {
+		values[stringFor(costAdd)+"-"+class.name()] = class.Added
+		values[stringFor(costEvict)+"-"+class.name()] = class.Evicted
+	}
+	for i, n := range p.Victims().Ages {
+		values[stringFor(keyEvict)+"-age-"+ageName(i)] = n
+	}
+	return values
+}
+
+// Ratio returns the share of Gets that were hits, or 0 if there were no Gets.
+// HitRatio tells the two apart.
 func (p *metrics) Ratio() float64 {
+	ratio, _ := p.HitRatio()
+	return ratio
+}
+
+// HitRatio returns the share of Gets that were hits, and whether there were
+// any Gets to compute it from. Without Gets, it returns 0 and false, so
+// dashboards can skip the sample rather than plot a hit ratio of 0.
+func (p *metrics) HitRatio() (ratio float64, valid bool) {
 	if p == nil {
-		return 0.0
+		return 0.0, false
 	}
 	hits, misses := p.Get(hit), p.Get(miss)
 	if hits == 0 && misses == 0 {
-		return 0.0
+		return 0.0, false
+	}
+	return float64(hits) / float64(hits+misses), true
+}
+
+// MarshalJSON encodes the metrics as an object holding every metric of
+// Snapshot, plus "gets-total" and "hit-ratio" like String. The hit ratio is
+// 0 when "gets-total" is.
+func (p *metrics) MarshalJSON() ([]byte, error) {
+	if p == nil {
+		return []byte("null"), nil
+	}
+	values := make(map[string]interface{}, doNotUse+2)
+	for name, value := range p.Snapshot() {
+		values[name] = value
+	}
+	values["gets-total"] = p.Get(hit) + p.Get(miss)
+	values["hit-ratio"] = p.Ratio()
+	return json.Marshal(values)
+}
+
+// MarshalText encodes the metrics as lines of a name and a value separated
+// by a space, sorted by name: every metric of Snapshot, "gets-total",
+// "hit-ratio" and "hit-ratio-valid", which is 0 when there were no Gets to
+// compute the hit ratio from, and 1 otherwise. Unlike String, it's meant to
+// be parsed.
+func (p *metrics) MarshalText() ([]byte, error) {
+	if p == nil {
+		return nil, nil
+	}
+	snapshot := p.Snapshot()
+	names := make([]string, 0, len(snapshot))
+	for name := range snapshot {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s %d\n", name, snapshot[name])
+	}
+	ratio, valid := p.HitRatio()
+	fmt.Fprintf(&buf, "gets-total %d\n", p.Get(hit)+p.Get(miss))
+	fmt.Fprintf(&buf, "hit-ratio %g\n", ratio)
+	if valid {
+		buf.WriteString("hit-ratio-valid 1\n")
+	} else {
+		buf.WriteString("hit-ratio-valid 0\n")
+	}
+	for _, point := range p.SizingCurve() {
+		if point.Valid {
+			fmt.Fprintf(&buf, "sizing-hit-ratio-%gx %g\n", point.Multiple,
+				point.HitRatio)
+		}
 	}
-	return float64(hits) / float64(hits+misses)
+	return buf.Bytes(), nil
 }
 
 func (p *metrics) String() string {
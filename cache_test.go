@@ -18,20 +18,28 @@ package ristretto
 
 import (
 	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math/rand"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/dgraph-io/ristretto/sim"
+	"github.com/dgraph-io/ristretto/z"
 )
 
 // TestCache is used to pass instances of Ristretto and Clairvoyant around and
 // compare their performance.
 type TestCache interface {
 	Get(interface{}) (interface{}, bool)
-	Set(interface{}, interface{}, int64) bool
+	Set(interface{}, interface{}, int64, ...SetOption) bool
 	Metrics() *metrics
 }
 
@@ -58,6 +66,7 @@ func newBenchmark(bencher func(uint64)) func(b *testing.B) {
 	return func(b *testing.B) {
 		b.SetParallelism(1)
 		b.SetBytes(1)
+		b.ReportAllocs()
 		b.ResetTimer()
 		b.RunParallel(func(pb *testing.PB) {
 			for i := uint64(0); pb.Next(); i++ {
@@ -74,6 +83,58 @@ func BenchmarkCacheGetOne(b *testing.B) {
 	newBenchmark(func(i uint64) { cache.Get(1) })(b)
 }
 
+// BenchmarkCacheGetString Gets string keys, some of which are in the cache.
+func BenchmarkCacheGetString(b *testing.B) {
+	cache := newCache(false)
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		cache.Set(keys[i], nil, 1)
+	}
+	newBenchmark(func(i uint64) { cache.Get(keys[i&1023]) })(b)
+}
+
+// BenchmarkCacheGetMany Gets 64 keys at a time, either one by one or with
+// GetMany.
+func BenchmarkCacheGetMany(b *testing.B) {
+	cache := newCache(false)
+	keys := make([]interface{}, 1024)
+	for i := range keys {
+		keys[i] = uint64(i)
+		cache.Set(keys[i], nil, 1)
+	}
+	batch := func(i uint64) []interface{} {
+		start := (i * 64) & 1023
+		return keys[start : start+64]
+	}
+	b.Run("individual", newBenchmark(func(i uint64) {
+		for _, key := range batch(i) {
+			cache.Get(key)
+		}
+	}))
+	b.Run("batch", newBenchmark(func(i uint64) { cache.GetMany(batch(i)) }))
+}
+
+// BenchmarkCacheGetStripes Gets the same key from every CPU, with the default
+// pool of Get buffer stripes and with a stripe per CPU. Run it with -cpu 32,64
+// to see how they scale.
+func BenchmarkCacheGetStripes(b *testing.B) {
+	for _, stripes := range []int64{0, next2Power(int64(runtime.GOMAXPROCS(0)))} {
+		cache, err := NewCache(&Config{
+			NumCounters:      capacity * 10,
+			MaxCost:          capacity,
+			GetBufferStripes: stripes,
+		})
+		if err != nil {
+			panic(err)
+		}
+		cache.Set(1, nil, 1)
+		b.Run(fmt.Sprintf("stripes-%d", stripes), newBenchmark(func(i uint64) {
+			cache.Get(1)
+		}))
+	}
+}
+
 // BenchmarkCacheSetOne Sets the same key-value item over and over.
 func BenchmarkCacheSetOne(b *testing.B) {
 	cache := newCache(false)
@@ -86,6 +147,28 @@ func BenchmarkCacheSetUni(b *testing.B) {
 	newBenchmark(func(i uint64) { cache.Set(i, nil, 1) })(b)
 }
 
+// BenchmarkCacheSetWorkers Sets new keys with a blocking Set buffer, so
+// Sets wait for NumWorkers workers to apply them.
+func BenchmarkCacheSetWorkers(b *testing.B) {
+	for _, workers := range []int64{1, 2, 4} {
+		cache, err := NewCache(&Config{
+			NumCounters:       capacity * 10,
+			MaxCost:           capacity,
+			BufferItems:       64,
+			NumWorkers:        workers,
+			SetBufferBlocking: true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		var key uint64
+		b.Run(fmt.Sprintf("workers-%d", workers), newBenchmark(func(uint64) {
+			cache.Set(atomic.AddUint64(&key, 1), nil, 1)
+		}))
+		cache.Close()
+	}
+}
+
 // newRatioTest simulates a workload for a TestCache so you can just run the
 // returned test and call cache.metrics() to get a basic idea of performance.
 func newRatioTest(cache TestCache) func(t *testing.T) {
@@ -124,87 +207,858 @@ func TestCacheOnEvict(t *testing.T) {
 			defer mu.Unlock()
 			evictions[key] = value.(int)
 		},
-	})
-	if err != nil {
-		panic(err)
-	}
-	for i := 0; i < 256; i++ {
-		cache.Set(i, i, 1)
-	}
-	time.Sleep(time.Second / 100)
-	mu.Lock()
-	defer mu.Unlock()
-	if len(evictions) != 156 {
-		t.Fatal("onEvict not being called")
-	}
-	for k, v := range evictions {
-		if k != uint64(v) {
-			t.Fatal("onEvict key-val mismatch")
-		}
-	}
-}
-
-func TestCacheKeyToHash(t *testing.T) {
-	cache, err := NewCache(&Config{
-		NumCounters: 1000,
-		MaxCost:     100,
-		BufferItems: 1,
-		KeyToHash: func(key interface{}) uint64 {
-			i, ok := key.(int)
-			if !ok {
-				panic("failed to type assert")
-			}
-			return uint64(i + 2)
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 256; i++ {
+		cache.Set(i, i, 1)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(evictions) != 156 {
+		t.Fatal("onEvict not being called")
+	}
+	for k, v := range evictions {
+		if k != uint64(v) {
+			t.Fatal("onEvict key-val mismatch")
+		}
+	}
+}
+
+func TestCacheKeyToHash(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 1000,
+		MaxCost:     100,
+		BufferItems: 1,
+		KeyToHash: func(key interface{}) uint64 {
+			i, ok := key.(int)
+			if !ok {
+				panic("failed to type assert")
+			}
+			return uint64(i + 2)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 10; i++ {
+		if uint64(i+2) != cache.keyToHash(i) {
+			t.Fatal("keyToHash hash mismatch")
+		}
+	}
+}
+
+func TestCacheGetOrCompute(t *testing.T) {
+	cache := newCache(true)
+	mu := &sync.Mutex{}
+	computed := 0
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, err := cache.GetOrCompute(1, func() (interface{}, int64, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				computed++
+				return 1, 1, nil
+			})
+			if err != nil || val.(int) != 1 {
+				t.Errorf("unexpected GetOrCompute result")
+			}
+		}()
+	}
+	wg.Wait()
+	if computed != 1 {
+		t.Fatalf("expected 1 compute but got %d\n", computed)
+	}
+	if _, err := cache.GetOrCompute(2, func() (interface{}, int64, error) {
+		return nil, 0, errors.New("failed")
+	}); err == nil {
+		t.Fatal("compute error should be returned")
+	}
+	if _, ok := cache.Get(2); ok {
+		t.Fatal("failed computes shouldn't be cached")
+	}
+}
+
+func TestCachePeek(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	cache.Set(1, 1, 1)
+	cache.Set(2, 2, 1, WithIdleTTL(time.Second))
+	for i := 0; i < 10; i++ {
+		if val, ok := cache.Peek(1); !ok || val.(int) != 1 {
+			t.Fatal("Peek should return the value")
+		}
+	}
+	if _, ok := cache.Peek(3); ok {
+		t.Fatal("Peek shouldn't find missing keys")
+	}
+	if cache.EstimateFrequency(1) != 0 {
+		t.Fatal("Peek shouldn't count as an access")
+	}
+	if m := cache.Metrics(); m.Get(hit) != 0 || m.Get(miss) != 0 {
+		t.Fatal("Peek shouldn't count hits or misses")
+	}
+	// peeking doesn't keep idle keys alive
+	clock.Advance(time.Second / 2)
+	cache.Peek(2)
+	clock.Advance(time.Second / 2)
+	if _, ok := cache.Peek(2); ok {
+		t.Fatal("Peek shouldn't refresh idle TTLs")
+	}
+}
+
+func TestCacheHas(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	cache.Set(1, 1, 1)
+	cache.Set(2, 2, 1, WithTTL(time.Second))
+	if !cache.Has(1) || !cache.Has(2) || cache.Has(3) {
+		t.Fatal("Has should find cached keys only")
+	}
+	if m := cache.Metrics(); m.Get(hit) != 0 || m.Get(miss) != 0 {
+		t.Fatal("Has shouldn't count hits or misses")
+	}
+	if cache.EstimateFrequency(1) == 0 {
+		t.Fatal("Has should count as an access")
+	}
+	clock.Advance(time.Second)
+	if cache.Has(2) {
+		t.Fatal("Has shouldn't find expired keys")
+	}
+	var nilCache *Cache
+	if nilCache.Has(1) {
+		t.Fatal("nil caches have no keys")
+	}
+}
+
+func TestCacheOnUpdate(t *testing.T) {
+	type update struct {
+		key        uint64
+		prev, next interface{}
+	}
+	var updates []update
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		DeterministicMode: true,
+		OnUpdate: func(key uint64, prev, next interface{}) {
+			updates = append(updates, update{key, prev, next})
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, "a", 1)
+	if len(updates) != 0 {
+		t.Fatal("adding a key isn't an update")
+	}
+	cache.Set(1, "b", 1)
+	cache.Replace(1, "c", 1)
+	cache.GetAndSet(1, "d", 1)
+	want := []update{{1, "a", "b"}, {1, "b", "c"}, {1, "c", "d"}}
+	if len(updates) != len(want) {
+		t.Fatalf("expected %d updates but got %v\n", len(want), updates)
+	}
+	for i := range want {
+		if updates[i] != want[i] {
+			t.Fatalf("expected update %v but got %v\n", want[i], updates[i])
+		}
+	}
+	cache.Del(1)
+	if len(updates) != len(want) {
+		t.Fatal("deleting a key isn't an update")
+	}
+}
+
+func TestCacheOnExit(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	exits := make(map[interface{}]int)
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           3,
+		DeterministicMode: true,
+		Clock:             clock,
+		OnExit: func(val interface{}) {
+			exits[val]++
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	// overwritten, evicted and rejected values
+	var taken []int
+	for i := 0; i < 10; i++ {
+		if cache.Set(i%5, i, 1) {
+			taken = append(taken, i)
+		}
+	}
+	// expired and deleted values
+	for _, i := range []int{10, 11} {
+		if cache.Set(i, i, 1, WithTTL(time.Second)) {
+			taken = append(taken, i)
+		}
+	}
+	clock.Advance(time.Second)
+	cache.Get(10)
+	cache.Del(11)
+	// and the values left on Close
+	cache.Close()
+	if len(exits) != len(taken) {
+		t.Fatalf("expected %d exits but got %v\n", len(taken), exits)
+	}
+	for _, val := range taken {
+		if exits[val] != 1 {
+			t.Fatalf("value %d exited %d times\n", val, exits[val])
+		}
+	}
+}
+
+func TestCacheCloseStopsGoroutines(t *testing.T) {
+	before := runtime.NumGoroutine()
+	var exits int32
+	cache, err := NewCache(&Config{
+		NumCounters:    1000,
+		MaxCost:        100,
+		BufferItems:    64,
+		NumWorkers:     4,
+		GetDrainPolicy: DrainOnInterval,
+		AutoTune:       time.Millisecond,
+		OnExit: func(interface{}) {
+			atomic.AddInt32(&exits, 1)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	var sets int32
+	for i := 0; i < 100; i++ {
+		if cache.Set(i, i, 1) {
+			sets++
+		}
+		cache.Get(i)
+	}
+	cache.Close()
+	// Sets still buffered were applied before the cache was emptied
+	if n := atomic.LoadInt32(&exits); n != sets {
+		t.Fatalf("expected %d values to exit, got %d\n", sets, n)
+	}
+	if n := runtime.NumGoroutine(); n > before {
+		t.Fatalf("expected %d goroutines after Close, got %d\n", before, n)
+	}
+}
+
+func TestCacheRetuneCounters(t *testing.T) {
+	newCache := func(averageCost int64) *Cache {
+		cache, err := NewCache(&Config{
+			MaxCost:           1000,
+			AverageCost:       averageCost,
+			DeterministicMode: true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return cache
+	}
+	counters := func(cache *Cache) int64 {
+		cache.lockAll()
+		defer cache.unlockAll()
+		cache.retuneCounters()
+		if n := cache.policy.(*defaultPolicy).admit.resetAt; n != cache.counters {
+			t.Fatalf("expected %d counters but the policy has %d\n",
+				cache.counters, n)
+		}
+		return cache.ConfigSnapshot().NumCounters
+	}
+	// keys cost less than expected, so there are more of them
+	cache := newCache(100)
+	if n := counters(cache); n != 100 {
+		t.Fatalf("expected 100 derived counters but got %d\n", n)
+	}
+	for key := 0; key < 100; key++ {
+		cache.Set(key, key, 1)
+	}
+	if n := counters(cache); n != 1000 {
+		t.Fatalf("expected the counters to grow to 1000 but got %d\n", n)
+	}
+	// keys cost more than expected, which only shows once the cache is full
+	cache = newCache(1)
+	for key := 0; key < 5; key++ {
+		cache.Set(key, key, 100)
+	}
+	if n := counters(cache); n != 10000 {
+		t.Fatalf("counters shouldn't shrink while filling but got %d\n", n)
+	}
+	for key := 5; key < 10; key++ {
+		cache.Set(key, key, 100)
+	}
+	if n := counters(cache); n != 100 {
+		t.Fatalf("expected the counters to shrink to 100 but got %d\n", n)
+	}
+	// counters that were set aren't retuned
+	cache, _ = NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           1000,
+		DeterministicMode: true,
+	})
+	for key := 0; key < 100; key++ {
+		cache.Set(key, key, 1)
+	}
+	if n := counters(cache); n != 100 {
+		t.Fatalf("expected the counters to stay at 100 but got %d\n", n)
+	}
+}
+
+func TestCacheGetDrainPolicy(t *testing.T) {
+	for _, drain := range []GetDrainPolicy{DrainWhenFull, DrainOnInterval, DrainEveryGet} {
+		cache, err := NewCache(&Config{
+			NumCounters:      100,
+			MaxCost:          10,
+			GetBufferStripes: 1,
+			GetDrainPolicy:   drain,
+			GetDrainInterval: time.Millisecond,
+		})
+		if err != nil {
+			panic(err)
+		}
+		for i := 0; i < 3; i++ {
+			cache.Get(1)
+		}
+		// accesses are handed to the policy in the background
+		counted := false
+		for i := 0; i < 100 && !counted; i++ {
+			time.Sleep(time.Millisecond)
+			counted = cache.EstimateFrequency(1) > 0
+		}
+		if counted != (drain != DrainWhenFull) {
+			t.Fatalf("policy %d: unexpected access count\n", drain)
+		}
+		cache.Close()
+	}
+}
+
+func TestCacheGetMany(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	cache.Set(1, 10, 1)
+	cache.Set(3, 30, 1)
+	vals, found := cache.GetMany([]interface{}{1, 2, 3})
+	if len(vals) != 3 || vals[0] != 10 || vals[2] != 30 || vals[1] != nil {
+		t.Fatalf("unexpected values %v\n", vals)
+	}
+	if !found[0] || found[1] || !found[2] {
+		t.Fatalf("unexpected results %v\n", found)
+	}
+	if m := cache.Metrics(); m.Get(hit) != 2 || m.Get(miss) != 1 {
+		t.Fatal("GetMany should count a hit or miss per key")
+	}
+	if cache.EstimateFrequency(1) == 0 || cache.EstimateFrequency(2) == 0 {
+		t.Fatal("GetMany should count as an access of every key")
+	}
+	var nilCache *Cache
+	if _, found := nilCache.GetMany([]interface{}{1}); len(found) != 1 || found[0] {
+		t.Fatal("nil caches have no keys")
+	}
+}
+
+func TestCacheGetBuffer(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:         100,
+		MaxCost:             10,
+		BufferItems:         64,
+		GetBufferStripeSize: 2,
+		GetBufferStripes:    1,
+		Metrics:             true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 10; i++ {
+		cache.Get(i)
+	}
+	m := cache.Metrics()
+	if m.GetsKept()+m.GetsDropped() != 10 {
+		t.Fatalf("expected 10 accesses but got %d\n", m.GetsKept()+m.GetsDropped())
+	}
+}
+
+func TestCacheGetBufferStripeSize(t *testing.T) {
+	for _, test := range []struct {
+		config Config
+		want   int64
+	}{
+		{Config{}, DefaultGetBufferStripeSize},
+		{Config{BufferItems: 8}, 8},
+		{Config{BufferItems: 8, GetBufferSize: 16}, 16},
+		{Config{BufferItems: 8, GetBufferSize: 16, GetBufferStripeSize: 32}, 32},
+	} {
+		if got := test.config.getBufferStripeSize(); got != test.want {
+			t.Fatalf("expected stripes of %d keys but got %d\n", test.want, got)
+		}
+	}
+	// BufferItems isn't needed anymore
+	cache, err := NewCache(&Config{NumCounters: 100, MaxCost: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Close()
+}
+
+func TestCacheGetAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items at random under the race detector")
+	}
+	for _, metrics := range []bool{false, true} {
+		cache := newCache(metrics)
+		keys := make([]string, 100)
+		for i := range keys {
+			keys[i] = fmt.Sprintf("key-%d", i)
+			cache.Set(keys[i], i, 1)
+			cache.Set(uint64(i+1000), i, 1)
+		}
+		time.Sleep(time.Second / 100)
+		// every run drains the Get buffer several times
+		allocs := testing.AllocsPerRun(100, func() {
+			for i := 0; i < 1000; i++ {
+				cache.Get(keys[i%100])
+			}
+		})
+		if allocs != 0 {
+			t.Fatalf("Get with string keys allocated %v times\n", allocs)
+		}
+		allocs = testing.AllocsPerRun(100, func() {
+			for i := 0; i < 1000; i++ {
+				cache.Get(uint64(i + 1000))
+			}
+		})
+		if allocs != 0 {
+			t.Fatalf("Get with uint64 keys allocated %v times\n", allocs)
+		}
+		cache.Close()
+	}
+}
+
+func TestCacheSetAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items at random under the race detector")
+	}
+	cache, err := NewCache(&Config{
+		NumCounters:       capacity * 10,
+		MaxCost:           capacity,
+		BufferItems:       64,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	// items are recycled, whether they're added, updated or evicted
+	key := uint64(0)
+	allocs := testing.AllocsPerRun(capacity*10, func() {
+		key++
+		cache.Set(key, nil, 1)
+		cache.Set(key, nil, 2)
+	})
+	if allocs != 0 {
+		t.Fatalf("Set allocated %v times\n", allocs)
+	}
+}
+
+func TestCacheEstimateFrequency(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 8; i++ {
+		cache.Get(1)
+	}
+	if freq := cache.EstimateFrequency(1); freq < 2 {
+		t.Fatalf("expected a hot key but got frequency %d\n", freq)
+	}
+	if freq := cache.EstimateFrequency(2); freq != 0 {
+		t.Fatalf("expected a cold key but got frequency %d\n", freq)
+	}
+	var nilCache *Cache
+	if nilCache.EstimateFrequency(1) != 0 {
+		t.Fatal("nil Cache should estimate 0")
+	}
+}
+
+// TestCacheRatios gives us a rough idea of the hit ratio relative to the
+// theoretical optimum. Useful for quickly seeing the effects of changes.
+func TestCacheRatios(t *testing.T) {
+	cache := newCache(true)
+	optimal := NewClairvoyant(capacity)
+	newRatioTest(cache)(t)
+	newRatioTest(optimal)(t)
+	t.Logf("ristretto: %.2f\n", cache.Metrics().Ratio())
+	t.Logf("- optimal: %.2f\n", optimal.Metrics().Ratio())
+}
+
+// TestCacheSizedRatios compares the object and byte hit ratios of every
+// eviction policy on a trace where item sizes vary by orders of magnitude.
+func TestCacheSizedRatios(t *testing.T) {
+	policies := []struct {
+		name   string
+		policy EvictionPolicy
+	}{
+		{"sampledlfu", EvictSampledLFU},
+		{"gdsf", EvictGDSF},
+		{"lfuda", EvictLFUDA},
+		{"exactlfu", EvictExactLFU},
+		{"exactlru", EvictExactLRU},
+	}
+	// objects and bytes hold the object and byte hit ratio of each policy
+	objects := make(map[string]float64)
+	bytes := make(map[string]float64)
+	for _, p := range policies {
+		cache, err := NewCache(&Config{
+			NumCounters:       capacity * 10,
+			MaxCost:           capacity * 100,
+			BufferItems:       64,
+			DeterministicMode: true,
+			EvictionPolicy:    p.policy,
+		})
+		if err != nil {
+			panic(err)
+		}
+		keys := sim.NewSized(sim.NewZipfian(1.0001, 1, capacity*100), 1, 1000)
+		var hits, total, hitBytes uint64
+		for i := 0; i < capacity*20; i++ {
+			key, size, err := keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			total += size
+			if _, ok := cache.Get(key); ok {
+				hits++
+				hitBytes += size
+				continue
+			}
+			cache.Set(key, nil, int64(size))
+		}
+		objects[p.name] = float64(hits) / float64(capacity*20)
+		bytes[p.name] = float64(hitBytes) / float64(total)
+		t.Logf("%10s: objects %.2f, bytes %.2f\n", p.name,
+			objects[p.name], bytes[p.name])
+		if objects[p.name] < 0.3 || bytes[p.name] < 0.3 {
+			t.Fatalf("%s: expected hit ratios of at least 0.3\n", p.name)
+		}
+		cache.Close()
+	}
+	// GDSF keeps small keys over large ones, so it gains more on the object
+	// hit ratio than on the byte hit ratio compared to LFUDA
+	if objects["gdsf"] <= objects["lfuda"] {
+		t.Fatal("GDSF should have a better object hit ratio than LFUDA")
+	}
+	if objects["gdsf"]-objects["lfuda"] <= bytes["gdsf"]-bytes["lfuda"] {
+		t.Fatal("GDSF should favor the object hit ratio over the byte one")
+	}
+	// keys are Zipfian, so their frequency tells more than their recency
+	if objects["exactlfu"] <= objects["exactlru"] {
+		t.Fatal("exact LFU should have a better hit ratio than exact LRU")
+	}
+}
+
+var newCacheInvalidConfigTests = []struct {
+	conf Config
+	desc string
+}{
+	{
+		conf: Config{
+			NumCounters: -1,
+			MaxCost:     1,
+			BufferItems: 1,
+		},
+		desc: "NumCounters is negative",
+	},
+	{
+		conf: Config{
+			MaxCost:     1,
+			AverageCost: -1,
+		},
+		desc: "AverageCost is negative",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     0,
+			BufferItems: 1,
+		},
+		desc: "MaxCost is 0",
+	},
+	{
+		conf: Config{
+			NumCounters:         1,
+			MaxCost:             1,
+			GetBufferStripeSize: -1,
+		},
+		desc: "GetBufferStripeSize is negative",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			NumShards:   3,
+		},
+		desc: "NumShards isn't a power of two",
+	},
+	{
+		conf: Config{
+			NumCounters:      1,
+			MaxCost:          1,
+			BufferItems:      1,
+			GetBufferStripes: 3,
+		},
+		desc: "GetBufferStripes isn't a power of two",
+	},
+	{
+		conf: Config{
+			NumCounters:    1,
+			MaxCost:        1,
+			GetDrainPolicy: DrainEveryGet + 1,
+		},
+		desc: "GetDrainPolicy is unknown",
+	},
+	{
+		conf: Config{
+			NumCounters:      1,
+			MaxCost:          1,
+			GetDrainInterval: -1,
+		},
+		desc: "GetDrainInterval is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:      1,
+			MaxCost:          1,
+			BufferItems:      1,
+			SetBufferStripes: 3,
+		},
+		desc: "SetBufferStripes isn't a power of two",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			NumWorkers:  3,
+		},
+		desc: "NumWorkers isn't a power of two",
+	},
+	{
+		conf: Config{
+			NumCounters:    1,
+			MaxCost:        1,
+			BufferItems:    1,
+			OnEvictWorkers: -1,
+		},
+		desc: "OnEvictWorkers is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:      1,
+			MaxCost:          1,
+			BufferItems:      1,
+			OnEvictQueueSize: -1,
+		},
+		desc: "OnEvictQueueSize is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:      1,
+			MaxCost:          1,
+			BufferItems:      1,
+			SetBufferStripes: 2,
+			NumWorkers:       4,
+		},
+		desc: "SetBufferStripes is less than NumWorkers",
+	},
+	{
+		conf: Config{
+			NumCounters:    1,
+			MaxCost:        1,
+			BufferItems:    1,
+			EvictionPolicy: EvictAuto + 1,
+		},
+		desc: "EvictionPolicy is unknown",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			TTLJitter:   1,
+		},
+		desc: "TTLJitter is 1",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			DefaultTTL:  -1,
+		},
+		desc: "DefaultTTL is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:             1,
+			MaxCost:                 1,
+			BufferItems:             1,
+			MemoryPressureThreshold: 2,
+		},
+		desc: "MemoryPressureThreshold is over 1",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			CostClasses: []int64{64, 64},
+		},
+		desc: "CostClasses aren't increasing",
+	},
+	{
+		conf: Config{
+			NumCounters:          1,
+			MaxCost:              1,
+			BufferItems:          1,
+			AdmitAfterRejections: -1,
+		},
+		desc: "AdmitAfterRejections is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:    1,
+			MaxCost:        1,
+			BufferItems:    1,
+			AdmissionGrace: -1,
+		},
+		desc: "AdmissionGrace is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:        1,
+			MaxCost:            1,
+			BufferItems:        1,
+			EvictionSampleSize: -1,
+		},
+		desc: "EvictionSampleSize is negative",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			ReadyAfter:  -1,
+		},
+		desc: "ReadyAfter is negative",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			MaxKeyCost:  -1,
+		},
+		desc: "MaxKeyCost is negative",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			ScanWindow:  -1,
+		},
+		desc: "ScanWindow is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:       1,
+			MaxCost:           1,
+			BufferItems:       1,
+			ReconcileInterval: -1,
+		},
+		desc: "ReconcileInterval is negative",
+	},
+	{
+		conf: Config{
+			NumCounters:  1,
+			MaxCost:      1,
+			BufferItems:  1,
+			LoadErrorTTL: -1,
 		},
-	})
-	if err != nil {
-		panic(err)
-	}
-	for i := 0; i < 10; i++ {
-		if uint64(i+2) != cache.keyToHash(i) {
-			t.Fatal("keyToHash hash mismatch")
-		}
-	}
-}
-
-// TestCacheRatios gives us a rough idea of the hit ratio relative to the
-// theoretical optimum. Useful for quickly seeing the effects of changes.
-func TestCacheRatios(t *testing.T) {
-	cache := newCache(true)
-	optimal := NewClairvoyant(capacity)
-	newRatioTest(cache)(t)
-	newRatioTest(optimal)(t)
-	t.Logf("ristretto: %.2f\n", cache.Metrics().Ratio())
-	t.Logf("- optimal: %.2f\n", optimal.Metrics().Ratio())
-}
-
-var newCacheInvalidConfigTests = []struct {
-	conf Config
-	desc string
-}{
+		desc: "LoadErrorTTL is negative",
+	},
 	{
 		conf: Config{
-			NumCounters: 0,
+			NumCounters: 1,
 			MaxCost:     1,
 			BufferItems: 1,
+			IdleDecay:   -1,
 		},
-		desc: "NumCounters is 0",
+		desc: "IdleDecay is negative",
 	},
 	{
 		conf: Config{
 			NumCounters: 1,
-			MaxCost:     0,
+			MaxCost:     1,
 			BufferItems: 1,
+			AutoTune:    -1,
 		},
-		desc: "MaxCost is 0",
+		desc: "AutoTune is negative",
+	},
+	{
+		conf: Config{
+			NumCounters: 1,
+			MaxCost:     1,
+			BufferItems: 1,
+			SizingCurve: []float64{1, 0},
+		},
+		desc: "SizingCurve has a multiple of 0",
+	},
+	{
+		conf: Config{
+			NumCounters:      1,
+			MaxCost:          1,
+			BufferItems:      1,
+			SizingSampleRate: 2,
+		},
+		desc: "SizingSampleRate is more than 1",
+	},
+	{
+		conf: Config{
+			NumCounters:   1,
+			MaxCost:       1,
+			BufferItems:   1,
+			ScanThreshold: 2,
+		},
+		desc: "ScanThreshold is more than 1",
 	},
 	{
 		conf: Config{
 			NumCounters: 1,
 			MaxCost:     1,
-			BufferItems: 0,
+			BufferItems: 1,
+			KeyToHash:   z.KeyToHash,
+			Hasher:      XXH3Hasher,
 		},
-		desc: "BufferItems is 0",
+		desc: "KeyToHash and Hasher are both set",
 	},
 }
 
@@ -233,6 +1087,145 @@ func TestCacheNil(t *testing.T) {
 	}
 }
 
+func TestCacheConfigSnapshot(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+		CostClasses: []int64{4},
+		OnEvict:     func(uint64, interface{}, int64) {},
+		Hasher:      XXH3Hasher,
+	})
+	if err != nil {
+		panic(err)
+	}
+	config := cache.ConfigSnapshot()
+	if config.NumCounters != 100 || config.OnEvict == nil {
+		t.Fatal("the snapshot should be a copy of the Config")
+	}
+	config.CostClasses[0] = 8
+	if cache.ConfigSnapshot().CostClasses[0] != 4 {
+		t.Fatal("the snapshot shouldn't share cost classes with the cache")
+	}
+	data, err := json.Marshal(cache.ConfigSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if fields["numCounters"] != 100.0 || fields["maxCost"] != 10.0 {
+		t.Fatalf("unexpected encoding: %s\n", data)
+	}
+	if _, ok := fields["OnEvict"]; ok {
+		t.Fatal("callbacks shouldn't be encoded")
+	}
+	var decoded Config
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.NumCounters != 100 || decoded.CostClasses[0] != 4 {
+		t.Fatal("the encoding should decode to the same Config")
+	}
+	var nilCache *Cache
+	if nilCache.ConfigSnapshot().NumCounters != 0 {
+		t.Fatal("nil Cache should have an empty Config")
+	}
+}
+
+func TestMetricsMarshalJSON(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	cache.Get(1)
+	cache.Get(2)
+	data, err := json.Marshal(cache.Metrics())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values map[string]float64
+	if err := json.Unmarshal(data, &values); err != nil {
+		t.Fatal(err)
+	}
+	if values["hit"] != 1 || values["miss"] != 1 || values["keys-added"] != 1 {
+		t.Fatalf("unexpected encoding: %s\n", data)
+	}
+	if values["gets-total"] != 2 || values["hit-ratio"] != 0.5 {
+		t.Fatalf("unexpected encoding: %s\n", data)
+	}
+	var nilMetrics *metrics
+	if data, _ := nilMetrics.MarshalJSON(); string(data) != "null" {
+		t.Fatal("nil metrics should encode as null")
+	}
+}
+
+func TestMetricsMarshalText(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	m := cache.Metrics()
+	// no Gets, no hit ratio
+	if ratio, valid := m.HitRatio(); ratio != 0 || valid {
+		t.Fatalf("expected no hit ratio but got %v, %v\n", ratio, valid)
+	}
+	if m.Ratio() != 0 {
+		t.Fatal("Ratio should be 0 without Gets")
+	}
+	data, err := m.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "hit-ratio 0\nhit-ratio-valid 0\n") {
+		t.Fatalf("unexpected encoding: %s\n", data)
+	}
+	cache.Set(1, 1, 1)
+	cache.Get(1)
+	cache.Get(2)
+	if ratio, valid := m.HitRatio(); ratio != 0.5 || !valid {
+		t.Fatalf("expected a hit ratio of 0.5 but got %v, %v\n", ratio, valid)
+	}
+	if data, err = m.MarshalText(); err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]string)
+	var names []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			t.Fatalf("malformed line %q\n", line)
+		}
+		values[fields[0]] = fields[1]
+		names = append(names, fields[0])
+	}
+	if !sort.StringsAreSorted(names[:len(names)-3]) {
+		t.Fatal("metrics should be sorted by name")
+	}
+	if values["hit"] != "1" || values["miss"] != "1" || values["gets-total"] != "2" ||
+		values["hit-ratio"] != "0.5" || values["hit-ratio-valid"] != "1" {
+		t.Fatalf("unexpected encoding: %s\n", data)
+	}
+	var nilMetrics *metrics
+	if data, _ := nilMetrics.MarshalText(); len(data) != 0 {
+		t.Fatal("nil metrics should encode as nothing")
+	}
+}
+
 func TestCacheDel(t *testing.T) {
 	cache := newCache(true)
 	// fill the cache with data
@@ -349,6 +1342,367 @@ func TestCacheSetDrops(t *testing.T) {
 	}
 }
 
+func TestCacheSetBufferBlocking(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		SetBufferBlocking: true,
+		SetBufferTimeout:  time.Millisecond,
+	})
+	if err != nil {
+		panic(err)
+	}
+	// stall the Set buffer by holding the policy lock
+	p := cache.policy.(*defaultPolicy)
+	p.Lock()
+	dropped := false
+	for i := 0; i < cache.setBuf.Cap()+10 && !dropped; i++ {
+		dropped = !cache.Set(i, i, 1)
+	}
+	if !dropped {
+		t.Fatal("blocking Set should give up after the timeout")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if cache.SetContext(ctx, 1, 1, 1) {
+		t.Fatal("SetContext should stop waiting once ctx is done")
+	}
+	p.Unlock()
+	if !cache.SetContext(context.Background(), 1, 1, 1) {
+		t.Fatal("SetContext should succeed once there's room")
+	}
+}
+
+func TestCacheSetBufferClock(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		SetBufferSize:     1,
+		SetBufferBlocking: true,
+		SetBufferTimeout:  time.Hour,
+		Clock:             clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	// stall the Set buffer by holding the policy lock, and fill it up
+	p := cache.policy.(*defaultPolicy)
+	p.Lock()
+	defer p.Unlock()
+	cache.Set(1, 1, 1)
+	for cache.setBuf.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cache.Set(2, 2, 1)
+	done := make(chan bool)
+	go func() { done <- cache.Set(3, 3, 1) }()
+	// the timeout only expires once the clock is moved past it
+	for {
+		select {
+		case ok := <-done:
+			if ok {
+				t.Fatal("blocking Set should give up after the timeout")
+			}
+			if clock.Now().Sub(time.Unix(0, 0)) < time.Hour {
+				t.Fatal("blocking Set gave up before the timeout")
+			}
+			return
+		default:
+		}
+		clock.Advance(time.Minute)
+		time.Sleep(time.Millisecond / 10)
+	}
+}
+
+func TestCacheDeterministicMode(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		SetBufferSize:     1,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 10; i++ {
+		if !cache.Set(i, i, 1) {
+			t.Fatal("Sets shouldn't be dropped")
+		}
+		if _, ok := cache.Get(i); !ok {
+			t.Fatal("Sets should be applied before returning")
+		}
+	}
+	cache.Del(0)
+	if _, ok := cache.Get(0); ok {
+		t.Fatal("Dels should be applied before returning")
+	}
+	if kept := cache.Metrics().GetsKept(); kept != 11 {
+		t.Fatalf("expected 11 counted Gets but got %d\n", kept)
+	}
+}
+
+func TestCacheSetUpdateInPlace(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:   100,
+		MaxCost:       10,
+		BufferItems:   64,
+		SetBufferSize: 4,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	time.Sleep(time.Second / 100)
+	// stall and fill the Set buffer, so new keys are dropped
+	p := cache.policy.(*defaultPolicy)
+	p.Lock()
+	for i := 2; i < 2+cache.setBuf.Cap()*2; i++ {
+		cache.Set(i, i, 1)
+	}
+	// updates to cached keys reach the store right away anyway
+	updated := make(chan bool)
+	go func() { updated <- cache.Set(1, 2, 3) }()
+	for i := 0; ; i++ {
+		if val, _ := cache.Get(1); val.(int) == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("update of a cached key wasn't applied in place")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.Unlock()
+	if !<-updated {
+		t.Fatal("update of a cached key was dropped")
+	}
+	time.Sleep(time.Second / 100)
+	p.Lock()
+	defer p.Unlock()
+	if cost := p.evict.keyCosts[cache.keyToHash(1)]; cost != 3 {
+		t.Fatalf("expected the updated cost to reach the policy but got %d\n",
+			cost)
+	}
+}
+
+func TestCacheAdmissionGrace(t *testing.T) {
+	// churned counts keys evicted before grace more keys were set
+	churn := func(grace int) (churned int) {
+		var set int
+		cache, err := NewCache(&Config{
+			NumCounters: 1000,
+			MaxCost:     10,
+			BufferItems: 64,
+			Metrics:     true,
+			OnEvict: func(key uint64, _ interface{}, _ int64) {
+				if set-int(key) <= 5 {
+					churned++
+				}
+			},
+			AdmissionGrace:    grace,
+			DeterministicMode: true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		for ; set < 100; set++ {
+			cache.Set(set, set, 1)
+		}
+		if evicted := cache.Metrics().Get(keyEvict); evicted != 90 {
+			t.Fatalf("grace=%d: expected 90 evictions but got %d\n",
+				grace, evicted)
+		}
+		return churned
+	}
+	if churned := churn(0); churned == 0 {
+		t.Fatal("new keys should be evicted without a grace period")
+	}
+	if churned := churn(5); churned != 0 {
+		t.Fatalf("%d keys were evicted during their grace period\n", churned)
+	}
+}
+
+func TestCacheMaxKeyCost(t *testing.T) {
+	var oversized []interface{}
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     100,
+		BufferItems: 64,
+		Metrics:     true,
+		MaxKeyCost:  10,
+		OnOversize: func(key uint64, value interface{}, cost int64) {
+			if cost != 11 {
+				t.Errorf("expected a cost of 11 but got %d\n", cost)
+			}
+			oversized = append(oversized, value)
+		},
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	if !cache.Set(1, 1, 10) {
+		t.Fatal("keys costing MaxKeyCost should be set")
+	}
+	// the previous value is stale, so it's deleted too
+	if cache.Set(1, 2, 11) {
+		t.Fatal("keys costing more than MaxKeyCost shouldn't be set")
+	}
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("oversized Sets should delete the previous value")
+	}
+	if cache.SetIfAbsent(2, 3, 11) {
+		t.Fatal("keys costing more than MaxKeyCost shouldn't be set")
+	}
+	if val, err := cache.GetOrCompute(3, func() (interface{}, int64, error) {
+		return 4, 11, nil
+	}); err != nil || val.(int) != 4 {
+		t.Fatal("GetOrCompute should return oversized values")
+	}
+	if _, ok := cache.Get(3); ok {
+		t.Fatal("oversized computed values shouldn't be cached")
+	}
+	if len(oversized) != 3 || oversized[0].(int) != 2 || oversized[2].(int) != 4 {
+		t.Fatalf("unexpected values passed to OnOversize: %v\n", oversized)
+	}
+	if n := cache.Metrics().Get(oversizeSets); n != 3 {
+		t.Fatalf("expected 3 oversized Sets but got %d\n", n)
+	}
+}
+
+func TestCacheSetsByCause(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	// hot keys filling the cache
+	for key := 0; key < 10; key++ {
+		cache.Set(key, key, 1)
+		cache.Get(key)
+		cache.Get(key)
+	}
+	if cache.Set(100, 100, 1); cache.Metrics().SetsRejected() != 1 {
+		t.Fatal("cold keys should be rejected by admission")
+	}
+	if cache.Set(101, 101, 11); cache.Metrics().SetsOversized() != 1 {
+		t.Fatal("keys costing more than MaxCost should be counted as oversized")
+	}
+	m := cache.Metrics()
+	if m.SetsRejected() != 1 || m.SetsDropped() != 0 || m.SetsCoalesced() != 0 {
+		t.Fatal("Sets should only be counted under their own cause")
+	}
+}
+
+func TestCacheSetDropPolicy(t *testing.T) {
+	newStalled := func(drop SetDropPolicy) (*Cache, func()) {
+		cache, err := NewCache(&Config{
+			NumCounters:   100,
+			MaxCost:       100,
+			BufferItems:   64,
+			SetBufferSize: 4,
+			SetDropPolicy: drop,
+			Metrics:       true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		if cache.setBuf.Cap() != 4 {
+			t.Fatal("SetBufferSize not applied")
+		}
+		// stall the Set buffer by holding the policy lock, and wait for the
+		// worker goroutine to be stuck on it
+		p := cache.policy.(*defaultPolicy)
+		p.Lock()
+		cache.Set(-1, -1, 1)
+		for cache.setBuf.Len() != 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return cache, func() {
+			p.Unlock()
+			time.Sleep(time.Second / 100)
+		}
+	}
+	t.Run("oldest", func(t *testing.T) {
+		cache, resume := newStalled(DropOldest)
+		for i := 0; i < 8; i++ {
+			if !cache.Set(i, i, 1) {
+				t.Fatal("DropOldest shouldn't drop incoming Sets")
+			}
+		}
+		resume()
+		for i := 0; i < 8; i++ {
+			if _, ok := cache.Get(i); ok != (i >= 4) {
+				t.Fatalf("key %d: expected only the newest Sets to be kept\n", i)
+			}
+		}
+		if dropped := cache.Metrics().SetsDropped(); dropped != 4 {
+			t.Fatalf("expected 4 dropped Sets but got %d\n", dropped)
+		}
+	})
+	t.Run("coalesce", func(t *testing.T) {
+		cache, resume := newStalled(DropCoalesce)
+		for i := 0; i < 100; i++ {
+			if !cache.Set(1, i, 1) {
+				t.Fatal("repeated Sets should be coalesced instead of dropped")
+			}
+		}
+		if cache.setBuf.Len() != 1 {
+			t.Fatal("coalesced Sets should take a single buffer slot")
+		}
+		resume()
+		if val, ok := cache.Get(1); !ok || val.(int) != 99 {
+			t.Fatal("the last coalesced value should win")
+		}
+		if merged := cache.Metrics().SetsCoalesced(); merged != 99 {
+			t.Fatalf("expected 99 coalesced Sets but got %d\n", merged)
+		}
+	})
+	t.Run("coalesce-in-order", func(t *testing.T) {
+		cache, resume := newStalled(DropCoalesce)
+		cache.Set(1, 0, 1)
+		// a GetOrCompute buffered in between keeps the next Set from
+		// being merged into the first one, and applied before it
+		go cache.GetOrCompute(1, func() (interface{}, int64, error) {
+			return 1, 1, nil
+		})
+		for cache.setBuf.Len() != 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cache.Set(1, 2, 1, WithPin())
+		if cache.setBuf.Len() != 3 {
+			t.Fatal("Sets shouldn't be merged across other writes of the key")
+		}
+		resume()
+		if val, ok := cache.Get(1); !ok || val.(int) != 2 {
+			t.Fatalf("expected the last Set to win but got %v\n", val)
+		}
+		// the options of merged Sets apply too
+		cache, resume = newStalled(DropCoalesce)
+		cache.Set(2, 0, 1)
+		cache.Set(2, 1, 1, WithPin())
+		resume()
+		p := cache.policy.(*defaultPolicy)
+		p.Lock()
+		_, pinned := p.evict.pinned[2]
+		p.Unlock()
+		if !pinned {
+			t.Fatal("the options of the last merged Set should apply")
+		}
+	})
+}
+
 // Clairvoyant is a mock cache providing us with optimal hit ratios to compare
 // with Ristretto's. It looks ahead and evicts the absolute least valuable item,
 // which we try to approximate in a real cache.
@@ -376,7 +1730,8 @@ func (c *Clairvoyant) Get(key interface{}) (interface{}, bool) {
 
 // Set isn't important because it is only called after a Get (in the case of our
 // hit ratio benchmarks, at least).
-func (c *Clairvoyant) Set(key, value interface{}, cost int64) bool {
+func (c *Clairvoyant) Set(key, value interface{}, cost int64,
+	opts ...SetOption) bool {
 	return false
 }
 
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	start := time.Unix(0, 0)
+	clock := NewManualClock(start)
+	soon, later := clock.After(time.Second), clock.After(time.Minute)
+	clock.Advance(time.Second)
+	if !clock.Now().Equal(start.Add(time.Second)) {
+		t.Fatal("Advance didn't move Now")
+	}
+	select {
+	case <-soon:
+	default:
+		t.Fatal("due channel didn't fire")
+	}
+	select {
+	case <-later:
+		t.Fatal("channel fired before it was due")
+	default:
+	}
+	clock.Advance(time.Hour)
+	select {
+	case <-later:
+	default:
+		t.Fatal("due channel didn't fire")
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command ristretto-stress runs a configurable mix of Gets and Sets against a
+// cache from many goroutines, and reports throughput, drop rates and the hit
+// ratio, so performance reports are comparable. For example:
+//
+//	ristretto-stress -goroutines 64 -reads 0.75 -dist zipf -value-size 1024
+//
+// Run it with -h for every option.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/dgraph-io/ristretto/sim"
+)
+
+var (
+	duration   = flag.Duration("duration", 10*time.Second, "how long to run")
+	goroutines = flag.Int("goroutines", runtime.GOMAXPROCS(0), "number of goroutines")
+	reads      = flag.Float64("reads", 0.9, "fraction of operations that are Gets")
+	keys       = flag.Uint64("keys", 1000000, "number of distinct keys")
+	dist       = flag.String("dist", "zipf", "key distribution: zipf, uniform, scan or hotspot")
+	zipfS      = flag.Float64("zipf-s", 1.01, "skew of the zipf distribution, above 1")
+	valueSize  = flag.Uint64("value-size", 64, "size of values in bytes")
+	valueMax   = flag.Uint64("value-size-max", 0,
+		"if set, values are between value-size and this many bytes, log-uniformly")
+	maxCost    = flag.Int64("max-cost", 64<<20, "MaxCost, in bytes of values")
+	counters   = flag.Int64("counters", 0, "NumCounters, 10 per key that fits by default")
+	stripeSize = flag.Int64("get-buffer-stripe-size", 64, "GetBufferStripeSize")
+	blocking   = flag.Bool("blocking", false, "SetBufferBlocking")
+	dropPolicy = flag.String("drop-policy", "newest",
+		"SetDropPolicy: newest, oldest or coalesce")
+	seed = flag.Int64("seed", 1, "seed of the key distributions")
+)
+
+func main() {
+	flag.Parse()
+	if err := run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	if *valueMax < *valueSize {
+		*valueMax = *valueSize
+	}
+	config := &ristretto.Config{
+		NumCounters:         *counters,
+		MaxCost:             *maxCost,
+		GetBufferStripeSize: *stripeSize,
+		Metrics:             true,
+		SetBufferBlocking:   *blocking,
+	}
+	if config.NumCounters == 0 {
+		config.NumCounters = 10 * (*maxCost / int64(*valueSize))
+	}
+	switch *dropPolicy {
+	case "newest":
+		config.SetDropPolicy = ristretto.DropNewest
+	case "oldest":
+		config.SetDropPolicy = ristretto.DropOldest
+	case "coalesce":
+		config.SetDropPolicy = ristretto.DropCoalesce
+	default:
+		return fmt.Errorf("unknown drop policy %q", *dropPolicy)
+	}
+	cache, err := ristretto.NewCache(config)
+	if err != nil {
+		return err
+	}
+	defer cache.Close()
+	workloads := make([]sim.Simulator, *goroutines)
+	for i := range workloads {
+		if workloads[i], err = simulator(*seed + int64(i)); err != nil {
+			return err
+		}
+	}
+	value := make([]byte, *valueMax)
+
+	fmt.Printf("running %d goroutines for %v (%.0f%% Gets, %s keys)\n",
+		*goroutines, *duration, *reads*100, *dist)
+	var stop int32
+	var gets, sets, setsDropped uint64
+	wg := &sync.WaitGroup{}
+	start := time.Now()
+	for i := range workloads {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			next := workloads[i]
+			r := rand.New(rand.NewSource(*seed + int64(i)))
+			var g, s, d uint64
+			for atomic.LoadInt32(&stop) == 0 {
+				key, _ := next()
+				size := sizeOf(key)
+				if r.Float64() < *reads {
+					cache.Get(key)
+					g++
+					continue
+				}
+				if !cache.Set(key, value[:size], int64(size)) {
+					d++
+				}
+				s++
+			}
+			atomic.AddUint64(&gets, g)
+			atomic.AddUint64(&sets, s)
+			atomic.AddUint64(&setsDropped, d)
+		}(i)
+	}
+	time.Sleep(*duration)
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	stats := cache.Metrics()
+	fmt.Printf("throughput:     %.0f ops/s (%.0f Gets/s, %.0f Sets/s)\n",
+		float64(gets+sets)/elapsed, float64(gets)/elapsed, float64(sets)/elapsed)
+	fmt.Printf("hit ratio:      %.4f\n", stats.Ratio())
+	fmt.Printf("sets dropped:   %.4f\n", ratio(setsDropped, sets))
+	fmt.Printf("sets rejected:  %.4f\n", ratio(stats.Snapshot()["sets-rejected"], sets))
+	fmt.Printf("gets dropped:   %.4f\n",
+		ratio(stats.GetsDropped(), stats.GetsDropped()+stats.GetsKept()))
+	return nil
+}
+
+// simulator returns the key distribution chosen by the flags.
+func simulator(seed int64) (sim.Simulator, error) {
+	switch *dist {
+	case "zipf":
+		z := rand.NewZipf(rand.New(rand.NewSource(seed)), *zipfS, 1, *keys-1)
+		return func() (uint64, error) { return z.Uint64(), nil }, nil
+	case "uniform":
+		r := rand.New(rand.NewSource(seed))
+		return func() (uint64, error) { return uint64(r.Int63n(int64(*keys))), nil }, nil
+	case "scan":
+		return sim.NewScan(*keys)
+	case "hotspot":
+		return sim.NewHotspot(seed, *keys, float64(*keys)/100, 0.01)
+	}
+	return nil, fmt.Errorf("unknown distribution %q", *dist)
+}
+
+// sizeOf returns the size of the value of a key, which is between the
+// value-size and value-size-max flags and log-uniformly distributed. Unlike
+// sim.NewSized, it's cheap enough not to skew the throughput.
+func sizeOf(key uint64) uint64 {
+	if *valueMax == *valueSize {
+		return *valueSize
+	}
+	// splitmix64 spreads keys over [0, 1)
+	key += 0x9e3779b97f4a7c15
+	key = (key ^ key>>30) * 0xbf58476d1ce4e5b9
+	key = (key ^ key>>27) * 0x94d049bb133111eb
+	key ^= key >> 31
+	frac := float64(key>>11) / (1 << 53)
+	return uint64(float64(*valueSize) *
+		math.Pow(float64(*valueMax)/float64(*valueSize), frac))
+}
+
+func ratio(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
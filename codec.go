@@ -0,0 +1,74 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"github.com/golang/snappy"
+)
+
+// Codec transforms []byte values on their way in and out of the cache, for
+// example to compress them. Encode and Decode may append to and return dst,
+// which may be nil.
+type Codec interface {
+	Encode(dst, src []byte) []byte
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+// SnappyCodec is a Codec compressing values with Snappy, trading a little CPU
+// on every Set and Get for a bigger effective cache.
+var SnappyCodec Codec = snappyCodec{}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Encode(dst, src []byte) []byte {
+	return snappy.Encode(dst[:cap(dst)], src)
+}
+
+func (snappyCodec) Decode(dst, src []byte) ([]byte, error) {
+	return snappy.Decode(dst[:cap(dst)], src)
+}
+
+// encodedValue marks values stored after passing through Config.Codec, so
+// they can be told apart from values that weren't []byte to begin with.
+type encodedValue []byte
+
+// encode runs val through the codec if it's a []byte, returning the value to
+// store and its cost.
+func (c *Cache) encode(val interface{}, cost int64) (interface{}, int64) {
+	if c.codec == nil {
+		return val, cost
+	}
+	b, ok := val.([]byte)
+	if !ok {
+		return val, cost
+	}
+	enc := c.codec.Encode(nil, b)
+	return encodedValue(enc), int64(len(enc))
+}
+
+// decode reverses encode.
+func (c *Cache) decode(val interface{}) (interface{}, bool) {
+	enc, ok := val.(encodedValue)
+	if !ok {
+		return val, true
+	}
+	b, err := c.codec.Decode(nil, enc)
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
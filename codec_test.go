@@ -0,0 +1,61 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSnappyCodec(t *testing.T) {
+	src := bytes.Repeat([]byte("ristretto"), 100)
+	enc := SnappyCodec.Encode(nil, src)
+	if len(enc) >= len(src) {
+		t.Fatal("value wasn't compressed")
+	}
+	dec, err := SnappyCodec.Decode(nil, enc)
+	if err != nil || !bytes.Equal(dec, src) {
+		t.Fatal("encode/decode error")
+	}
+}
+
+func TestCacheCodec(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1 << 20,
+		BufferItems: 64,
+		Codec:       SnappyCodec,
+
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	src := bytes.Repeat([]byte("ristretto"), 100)
+	cache.Set(1, src, int64(len(src)))
+	cache.Set(2, "not bytes", 1)
+	if val, ok := cache.Get(1); !ok || !bytes.Equal(val.([]byte), src) {
+		t.Fatal("codec set/get error")
+	}
+	if val, ok := cache.Get(2); !ok || val.(string) != "not bytes" {
+		t.Fatal("non-byte values shouldn't be encoded")
+	}
+	// cost is computed on the compressed size
+	if used := 1<<20 - cache.policy.Cap(); used >= int64(len(src)) {
+		t.Fatalf("expected compressed cost but got %d\n", used)
+	}
+}
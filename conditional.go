@@ -0,0 +1,247 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"time"
+)
+
+// EntryInfo describes a value returned by GetWithInfo.
+type EntryInfo struct {
+	// Version increases with every write to the key, so it tells whether
+	// the value was replaced since it was read.
+	Version uint64
+	// Expiration is the time the value expires, or zero if it doesn't.
+	Expiration time.Time
+	// Flags are the flags the value was set with (see SetWithFlags).
+	Flags uint32
+}
+
+// GetWithInfo is like Get, but also describes the value that was found. A
+// value promoted from LowerTier is only described once the Set moving it up
+// is applied, and has a zero EntryInfo until then.
+func (c *Cache) GetWithInfo(key interface{}) (interface{}, EntryInfo, bool) {
+	if c == nil {
+		return nil, EntryInfo{}, false
+	}
+	hash := c.keyToHash(key)
+	c.getBuf.Push(hash)
+	var info EntryInfo
+	val, ok := c.lookup(hash, &info, nil)
+	return val, info, ok
+}
+
+// SetIfVersion updates the value of a key, but only if its version is still
+// the one returned by GetWithInfo. Writers refreshing a value from somewhere
+// else can use it to avoid overwriting a newer value with what they read
+// before it was written. It returns false if the key is missing or was
+// written since. The new value keeps the TTL and flags of the value it
+// replaces.
+func (c *Cache) SetIfVersion(key, val interface{}, cost int64,
+	version uint64) bool {
+	if c == nil {
+		return false
+	}
+	hash := c.keyToHash(key)
+	orig := val
+	val, cost = c.encode(val, cost)
+	if c.oversized(hash, orig, cost) {
+		// the value it would have replaced goes, unless it's newer
+		c.getAndDelUpTo(hash, version)
+		return false
+	}
+	stored, current, flags, ok := c.store.GetVersion(hash)
+	if !ok || current != version {
+		return false
+	}
+	next := c.nextVersion()
+	val = keepExpiry(stored, val, cost, next)
+	prev, ok := c.store.CompareAndSwap(hash, val, version, next, flags)
+	if !ok {
+		return false
+	}
+	c.track(hash, val)
+	c.overwrite(hash, prev, val)
+	c.updateCost(hash, cost, setOptions{})
+	return true
+}
+
+// SetIfAbsent is like Set, but only adds the key if it isn't in the cache
+// yet, and never updates it. It waits for the Set to be applied, and returns
+// whether the key was added: false if the key was present, including if it
+// was added while the Set was buffered, or if the Set was dropped or rejected
+// by the policy.
+//
+// Since it waits for the Set to be applied, SetIfAbsent must not be called
+// from OnEvict, OnExit or the other callbacks called while Sets are applied,
+// where it waits on itself and deadlocks, like GetOrCompute.
+func (c *Cache) SetIfAbsent(key, val interface{}, cost int64) bool {
+	if c == nil {
+		return false
+	}
+	hash := c.keyToHash(key)
+	if c.live(hash) {
+		return false
+	}
+	orig := val
+	val, cost = c.encode(val, cost)
+	if c.oversized(hash, orig, cost) {
+		return false
+	}
+	version := c.nextVersion()
+	i := getItem()
+	i.key, i.cost, i.version, i.ifAbsent = hash, cost, version, true
+	i.val = c.expire(val, cost, c.defaultExpiry(), version)
+	defer putItem(i)
+	if c.deterministic {
+		c.process(i)
+		return i.added
+	}
+	// the key is checked again when the item is applied, under the lock
+	// keys are added with, and Sets of the key buffered before it are
+	// applied first
+	i.wg = &sync.WaitGroup{}
+	i.wg.Add(1)
+	c.stopCoalescing(hash)
+	if !c.push(i, nil) {
+		c.dropSet(hash)
+		return false
+	}
+	i.wg.Wait()
+	return i.added
+}
+
+// Replace is like Set, but only updates the key if it's in the cache, and
+// never adds it. It returns false if the key was missing. The new value keeps
+// the TTL and flags of the value it replaces.
+func (c *Cache) Replace(key, val interface{}, cost int64) bool {
+	if c == nil {
+		return false
+	}
+	hash := c.keyToHash(key)
+	orig := val
+	val, cost = c.encode(val, cost)
+	if c.oversized(hash, orig, cost) {
+		// the value it would have replaced goes, if there's one
+		if _, version, _, ok := c.store.GetVersion(hash); ok && c.live(hash) {
+			c.getAndDelUpTo(hash, version)
+		}
+		return false
+	}
+	for {
+		stored, version, flags, ok := c.store.GetVersion(hash)
+		if !ok || !c.live(hash) {
+			return false
+		}
+		next := c.nextVersion()
+		wrapped := keepExpiry(stored, val, cost, next)
+		// retry if the key was written in the meantime
+		if prev, ok := c.store.CompareAndSwap(hash, wrapped, version,
+			next, flags); ok {
+			c.track(hash, wrapped)
+			c.overwrite(hash, prev, wrapped)
+			c.updateCost(hash, cost, setOptions{})
+			return true
+		}
+	}
+}
+
+// live reports whether the key is in the cache and hasn't expired.
+func (c *Cache) live(hash uint64) bool {
+	stored, ok := c.store.Get(hash)
+	if !ok {
+		return false
+	}
+	v, ok := stored.(*expiringValue)
+	return !ok || !v.expired(c.clock.Now())
+}
+
+// GetAndDelete deletes the key and returns the value it had, if any. When
+// several callers delete the same key concurrently, only one of them gets its
+// value.
+func (c *Cache) GetAndDelete(key interface{}) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	hash := c.keyToHash(key)
+	val, ok := c.getAndDel(hash)
+	c.publish(hash)
+	return val, ok
+}
+
+// getAndDel is GetAndDelete for an already hashed key, without broadcasting
+// the Del.
+func (c *Cache) getAndDel(hash uint64) (interface{}, bool) {
+	return c.getAndDelUpTo(hash, c.nextVersion())
+}
+
+// getAndDelUpTo is getAndDel, leaving values newer than version alone.
+func (c *Cache) getAndDelUpTo(hash uint64, version uint64) (interface{}, bool) {
+	stored, _, ok := c.store.Del(hash, version)
+	// the policy and buffered Sets of the key are taken care of by a regular
+	// Del
+	c.del(hash, version)
+	if !ok {
+		return nil, false
+	}
+	val, ok := c.peek(stored)
+	if ok {
+		val, ok = c.decode(val)
+	}
+	c.exit(stored)
+	return val, ok
+}
+
+// GetAndSet sets the value of a key like Set and returns the value it
+// replaced, if any. If the key was missing, the value is added like Set,
+// with DefaultTTL, and may still be dropped or rejected by the policy.
+// Otherwise the new value keeps the TTL and flags of the value it replaces.
+func (c *Cache) GetAndSet(key, val interface{}, cost int64) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+	hash := c.keyToHash(key)
+	orig := val
+	val, cost = c.encode(val, cost)
+	if c.oversized(hash, orig, cost) {
+		return c.getAndDel(hash)
+	}
+	for {
+		stored, version, flags, ok := c.store.GetVersion(hash)
+		next := c.nextVersion()
+		if !ok {
+			c.add(&item{key: hash, cost: cost, version: next,
+				val: c.expire(val, cost, c.defaultExpiry(), next)}, nil)
+			return nil, false
+		}
+		wrapped := keepExpiry(stored, val, cost, next)
+		// retry if the key was written in the meantime
+		prev, ok := c.store.CompareAndSwap(hash, wrapped, version, next, flags)
+		if !ok {
+			continue
+		}
+		c.track(hash, wrapped)
+		c.updateCost(hash, cost, setOptions{})
+		old, ok := c.peek(prev)
+		if ok {
+			old, ok = c.decode(old)
+		}
+		c.overwrite(hash, prev, wrapped)
+		return old, ok
+	}
+}
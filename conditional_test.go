@@ -0,0 +1,269 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func newConditionalCache() *Cache {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		DeterministicMode: true,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestCacheGetWithInfo(t *testing.T) {
+	cache := newConditionalCache()
+	if _, _, ok := cache.GetWithInfo(1); ok {
+		t.Fatal("missing keys shouldn't be found")
+	}
+	cache.Set(1, 1, 1)
+	_, first, ok := cache.GetWithInfo(1)
+	if !ok || first.Version == 0 || !first.Expiration.IsZero() {
+		t.Fatalf("unexpected info %+v\n", first)
+	}
+	cache.SetWithTTL(1, 2, 1, time.Minute)
+	val, second, _ := cache.GetWithInfo(1)
+	if val.(int) != 2 || second.Version <= first.Version {
+		t.Fatal("writes should increase the version")
+	}
+	if !second.Expiration.Equal(time.Unix(60, 0)) {
+		t.Fatalf("unexpected expiration %v\n", second.Expiration)
+	}
+}
+
+func TestCacheSetIfVersion(t *testing.T) {
+	cache := newConditionalCache()
+	if cache.SetIfVersion(1, 1, 1, 0) {
+		t.Fatal("missing keys shouldn't be set")
+	}
+	cache.Set(1, 1, 1)
+	_, info, _ := cache.GetWithInfo(1)
+	// another writer gets there first
+	cache.Set(1, 2, 1)
+	if cache.SetIfVersion(1, 3, 1, info.Version) {
+		t.Fatal("newer values shouldn't be overwritten")
+	}
+	_, info, _ = cache.GetWithInfo(1)
+	if !cache.SetIfVersion(1, 3, 2, info.Version) {
+		t.Fatal("expected version should be overwritten")
+	}
+	if val, _ := cache.Get(1); val.(int) != 3 {
+		t.Fatal("value wasn't set")
+	}
+	if costs, _ := cache.policy.Costs(); costs[cache.keyToHash(1)] != 2 {
+		t.Fatal("cost wasn't updated")
+	}
+}
+
+func TestCacheConditionalKeepFlags(t *testing.T) {
+	cache := newConditionalCache()
+	cache.Set(1, 1, 1, WithFlags(7))
+	flags := func() uint32 {
+		_, info, _ := cache.GetWithInfo(1)
+		return info.Flags
+	}
+	_, info, _ := cache.GetWithInfo(1)
+	if !cache.SetIfVersion(1, 2, 1, info.Version) || flags() != 7 {
+		t.Fatalf("SetIfVersion should keep the flags, got %d\n", flags())
+	}
+	if !cache.Replace(1, 3, 1) || flags() != 7 {
+		t.Fatalf("Replace should keep the flags, got %d\n", flags())
+	}
+	if _, ok := cache.GetAndSet(1, 4, 1); !ok || flags() != 7 {
+		t.Fatalf("GetAndSet should keep the flags, got %d\n", flags())
+	}
+}
+
+func TestCacheSetIfVersionOversized(t *testing.T) {
+	cache := newConditionalCache()
+	cache.config.MaxKeyCost = 5
+	cache.Set(1, 1, 1)
+	_, stale, _ := cache.GetWithInfo(1)
+	cache.Set(1, 2, 1)
+	if cache.SetIfVersion(1, 3, 6, stale.Version) {
+		t.Fatal("oversized values shouldn't be set")
+	}
+	if val, ok := cache.Get(1); !ok || val.(int) != 2 {
+		t.Fatal("oversized values shouldn't delete newer values")
+	}
+	_, info, _ := cache.GetWithInfo(1)
+	cache.SetIfVersion(1, 3, 6, info.Version)
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("oversized values should delete the value they'd replace")
+	}
+	if cache.Replace(2, 2, 6) {
+		t.Fatal("oversized values shouldn't replace anything")
+	}
+}
+
+func TestCacheSetIfAbsent(t *testing.T) {
+	cache := newConditionalCache()
+	if !cache.SetIfAbsent(1, 1, 1) {
+		t.Fatal("missing keys should be set")
+	}
+	if cache.SetIfAbsent(1, 2, 1) {
+		t.Fatal("present keys shouldn't be set")
+	}
+	if val, _ := cache.Get(1); val.(int) != 1 {
+		t.Fatal("present keys shouldn't be updated")
+	}
+	// expired keys count as missing
+	cache.SetWithTTL(2, 1, 1, time.Second)
+	cache.clock.(*ManualClock).Advance(time.Second)
+	if !cache.SetIfAbsent(2, 2, 1) {
+		t.Fatal("expired keys should be set")
+	}
+	if val, ok := cache.Get(2); !ok || val.(int) != 2 {
+		t.Fatal("expired keys should be replaced")
+	}
+	if cache.SetIfAbsent(3, 3, 11) {
+		t.Fatal("keys rejected by the policy shouldn't be reported as set")
+	}
+}
+
+func TestCacheSetIfAbsentBuffered(t *testing.T) {
+	cache := newCache(false)
+	defer cache.Close()
+	// both Sets are buffered before either is applied
+	cache.lockAll()
+	cache.Set(1, 1, 1)
+	added := make(chan bool)
+	go func() {
+		added <- cache.SetIfAbsent(1, 2, 1)
+	}()
+	select {
+	case <-added:
+		t.Fatal("SetIfAbsent should wait for its Set to be applied")
+	case <-time.After(10 * time.Millisecond):
+	}
+	cache.unlockAll()
+	if <-added {
+		t.Fatal("SetIfAbsent should report keys added while it was buffered")
+	}
+	cache.lockAll()
+	cache.unlockAll()
+	if val, ok := cache.Get(1); ok && val.(int) != 1 {
+		t.Fatal("SetIfAbsent overwrote a buffered Set")
+	}
+}
+
+func TestCacheReplace(t *testing.T) {
+	cache := newConditionalCache()
+	if cache.Replace(1, 1, 1) {
+		t.Fatal("missing keys shouldn't be replaced")
+	}
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("missing keys shouldn't be added")
+	}
+	cache.Set(1, 1, 1)
+	if !cache.Replace(1, 2, 3) {
+		t.Fatal("present keys should be replaced")
+	}
+	if val, _ := cache.Get(1); val.(int) != 2 {
+		t.Fatal("value wasn't replaced")
+	}
+	if costs, _ := cache.policy.Costs(); costs[cache.keyToHash(1)] != 3 {
+		t.Fatal("cost wasn't updated")
+	}
+	cache.SetWithTTL(2, 1, 1, time.Second)
+	cache.clock.(*ManualClock).Advance(time.Second)
+	if cache.Replace(2, 2, 1) {
+		t.Fatal("expired keys shouldn't be replaced")
+	}
+}
+
+func TestCacheGetAndDelete(t *testing.T) {
+	cache := newConditionalCache()
+	if _, ok := cache.GetAndDelete(1); ok {
+		t.Fatal("missing keys shouldn't be found")
+	}
+	cache.Set(1, 1, 1)
+	if val, ok := cache.GetAndDelete(1); !ok || val.(int) != 1 {
+		t.Fatal("expected the deleted value")
+	}
+	if _, ok := cache.GetAndDelete(1); ok {
+		t.Fatal("keys should only be deleted once")
+	}
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("key wasn't deleted")
+	}
+	if costs, used := cache.policy.Costs(); len(costs) != 0 || used != 0 {
+		t.Fatal("policy still accounts for the deleted key")
+	}
+}
+
+func TestCacheGetAndDeleteBuffered(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:     100,
+		MaxCost:         10,
+		BufferItems:     64,
+		DebugInvariants: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	cache.Set(1, 1, 1)
+	for {
+		if _, ok := cache.Get(1); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// the Del reaches the policy after the key left the store
+	cache.lockAll()
+	val, ok := cache.GetAndDelete(1)
+	cache.unlockAll()
+	if !ok || val.(int) != 1 {
+		t.Fatal("expected the deleted value")
+	}
+	for start := time.Now(); cache.policy.Has(cache.keyToHash(1)); {
+		if time.Since(start) > time.Second {
+			t.Fatal("policy still accounts for the deleted key")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCacheGetAndSet(t *testing.T) {
+	cache := newConditionalCache()
+	if _, ok := cache.GetAndSet(1, 1, 1); ok {
+		t.Fatal("missing keys shouldn't be found")
+	}
+	if val, ok := cache.Get(1); !ok || val.(int) != 1 {
+		t.Fatal("missing keys should be added")
+	}
+	if val, ok := cache.GetAndSet(1, 2, 2); !ok || val.(int) != 1 {
+		t.Fatal("expected the replaced value")
+	}
+	if val, _ := cache.Get(1); val.(int) != 2 {
+		t.Fatal("value wasn't replaced")
+	}
+	if costs, _ := cache.policy.Costs(); costs[cache.keyToHash(1)] != 2 {
+		t.Fatal("cost wasn't updated")
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// CostClass is the cost added to and evicted from the cache by keys of a
+// size class.
+type CostClass struct {
+	// Min is the smallest cost of a key in the class.
+	Min int64 `json:"min"`
+	// Max is the cost the keys in the class are smaller than, or zero for
+	// the last class, which is unbounded.
+	Max     int64  `json:"max"`
+	Added   uint64 `json:"added"`
+	Evicted uint64 `json:"evicted"`
+}
+
+func (c CostClass) String() string {
+	if c.Max == 0 {
+		return fmt.Sprintf("[%d,inf)", c.Min)
+	}
+	return fmt.Sprintf("[%d,%d)", c.Min, c.Max)
+}
+
+// name identifies the class in metric names, which exporters restrict to
+// alphanumerics and a few separators.
+func (c CostClass) name() string {
+	if c.Max == 0 {
+		return fmt.Sprintf("%d-inf", c.Min)
+	}
+	return fmt.Sprintf("%d-%d", c.Min, c.Max)
+}
+
+// increasing reports whether bounds are valid Config.CostClasses.
+func increasing(bounds []int64) bool {
+	for i, bound := range bounds {
+		if bound <= 0 || (i > 0 && bound <= bounds[i-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// trackClasses makes the metrics break the cost added and evicted down by
+// the classes bounds splits costs into.
+func (p *metrics) trackClasses(bounds []int64) {
+	p.classes = append([]int64(nil), bounds...)
+	p.classCosts = make([][2][]*uint64, len(bounds)+1)
+	for i := range p.classCosts {
+		for j := range p.classCosts[i] {
+			p.classCosts[i][j] = make([]*uint64, 256)
+			for k := range p.classCosts[i][j] {
+				p.classCosts[i][j][k] = new(uint64)
+			}
+		}
+	}
+}
+
+// addClass counts cost added or evicted in the class of the cost.
+func (p *metrics) addClass(t metricType, hash, cost uint64) {
+	class := sort.Search(len(p.classes), func(i int) bool {
+		return int64(cost) < p.classes[i]
+	})
+	i := 0
+	if t == costEvict {
+		i = 1
+	}
+	atomic.AddUint64(p.classCosts[class][i][(hash%25)*10], cost)
+}
+
+// CostClasses returns the cost added and evicted by every class of
+// Config.CostClasses, smallest first. It returns nil if CostClasses isn't
+// set.
+func (p *metrics) CostClasses() []CostClass {
+	if p == nil || p.classCosts == nil {
+		return nil
+	}
+	classes := make([]CostClass, len(p.classCosts))
+	for i := range classes {
+		c := &classes[i]
+		if i > 0 {
+			c.Min = p.classes[i-1]
+		}
+		if i < len(p.classes) {
+			c.Max = p.classes[i]
+		}
+		for _, v := range p.classCosts[i][0] {
+			c.Added += atomic.LoadUint64(v)
+		}
+		for _, v := range p.classCosts[i][1] {
+			c.Evicted += atomic.LoadUint64(v)
+		}
+	}
+	return classes
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheCostClasses(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           1000,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+		CostClasses:       []int64{10, 100},
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 5)
+	cache.Set(2, 2, 9)
+	cache.Set(3, 3, 10)
+	cache.Set(4, 4, 500)
+	cache.Del(3)
+	classes := cache.Metrics().CostClasses()
+	expected := []CostClass{
+		{Min: 0, Max: 10, Added: 14},
+		{Min: 10, Max: 100, Added: 10, Evicted: 10},
+		{Min: 100, Max: 0, Added: 500},
+	}
+	if len(classes) != len(expected) {
+		t.Fatalf("expected %d classes but got %d\n", len(expected), len(classes))
+	}
+	for i := range expected {
+		if classes[i] != expected[i] {
+			t.Fatalf("expected %+v but got %+v\n", expected[i], classes[i])
+		}
+	}
+	snapshot := cache.Metrics().Snapshot()
+	if snapshot["cost-added-100-inf"] != 500 ||
+		snapshot["cost-evicted-10-100"] != 10 {
+		t.Fatalf("classes are missing from the snapshot: %v\n", snapshot)
+	}
+}
+
+func TestMetricsCostClassesUnset(t *testing.T) {
+	if classes := newMetrics().CostClasses(); classes != nil {
+		t.Fatalf("expected no classes but got %v\n", classes)
+	}
+}
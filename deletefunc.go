@@ -0,0 +1,46 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// DeleteFunc deletes every key whose value f returns true for, like Del, and
+// returns how many there were, for example to drop every value computed
+// before a deploy. Keys set after DeleteFunc was called are kept, and so are
+// Sets that are still buffered. f must not call the cache, and every call
+// walks the whole cache.
+func (c *Cache) DeleteFunc(f func(key uint64, val interface{}) bool) int {
+	if c == nil {
+		return 0
+	}
+	version := c.nextVersion()
+	var keys []uint64
+	c.store.Range(func(key uint64, stored interface{}) bool {
+		val, ok := c.peek(stored)
+		if ok {
+			val, ok = c.decode(val)
+		}
+		if ok && f(key, val) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	// the store can't be modified while it's walked
+	for _, hash := range keys {
+		c.del(hash, version)
+		c.publish(hash)
+	}
+	return len(keys)
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheDeleteFunc(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	for key := 0; key < 8; key++ {
+		cache.Set(key, key, 1)
+	}
+	cache.SetWithTTL(8, 8, 1, time.Second)
+	clock.Advance(time.Second)
+	n := cache.DeleteFunc(func(key uint64, val interface{}) bool {
+		if val.(int) == 8 {
+			t.Fatal("expired values shouldn't be passed to f")
+		}
+		return val.(int)%2 == 0
+	})
+	if n != 4 {
+		t.Fatalf("expected 4 deleted keys but got %d\n", n)
+	}
+	for key := 0; key < 8; key++ {
+		if _, ok := cache.Get(key); ok != (key%2 == 1) {
+			t.Fatalf("key %d: expected only odd keys to be kept\n", key)
+		}
+	}
+	if _, used := cache.policy.Costs(); used != 5 {
+		t.Fatalf("deleted keys should give their cost back, got %d\n", used)
+	}
+	if len(evicted) != 0 {
+		t.Fatal("deleted keys shouldn't be evicted")
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is the value of every metric by name at some point, as returned
+// by Snapshot.
+type Snapshot map[string]uint64
+
+// Delta returns how much every metric grew since an earlier snapshot, so
+// scrapers can compute rates without the cache keeping windows of its own.
+// Metrics that were reset since then count from zero again, so they never
+// go backwards.
+func (p *metrics) Delta(since Snapshot) Snapshot {
+	if p == nil {
+		return nil
+	}
+	delta := p.Snapshot()
+	for name, value := range delta {
+		if prev := since[name]; prev <= value {
+			delta[name] = value - prev
+		}
+	}
+	return delta
+}
+
+// Reset zeroes every metric, and makes ResetAt return the current time.
+// Metrics added concurrently may or may not be counted after the reset.
+func (p *metrics) Reset() {
+	if p == nil {
+		return
+	}
+	for _, valp := range p.all {
+		for _, v := range valp {
+			atomic.StoreUint64(v, 0)
+		}
+	}
+	for _, class := range p.classCosts {
+		for _, valp := range class {
+			for _, v := range valp {
+				atomic.StoreUint64(v, 0)
+			}
+		}
+	}
+	p.victims.reset()
+	if p.popularity != nil {
+		p.popularity.reset()
+	}
+	atomic.StoreInt64(&p.resetAt, time.Now().UnixNano())
+}
+
+// Swap zeroes every metric like Reset, and returns metrics holding their
+// values until then, which don't count anything anymore. Unlike Reset, it
+// counts every metric added concurrently exactly once, either in the
+// returned metrics or in p, so services can report exact counts for every
+// interval, and tests can count from zero without creating another cache.
+func (p *metrics) Swap() *metrics {
+	if p == nil {
+		return nil
+	}
+	old := &metrics{classes: p.classes, victims: p.victims.swap(),
+		tuning: p.tuning, sizing: p.sizing}
+	if p.popularity != nil {
+		old.popularity = p.popularity.swap()
+	}
+	for i, valp := range p.all {
+		old.all[i] = swapCounters(valp)
+	}
+	if p.classCosts != nil {
+		old.classCosts = make([][2][]*uint64, len(p.classCosts))
+		for i, class := range p.classCosts {
+			for j, valp := range class {
+				old.classCosts[i][j] = swapCounters(valp)
+			}
+		}
+	}
+	old.resetAt = atomic.SwapInt64(&p.resetAt, time.Now().UnixNano())
+	return old
+}
+
+// swapCounters zeroes the counters, and returns copies of their values until
+// then.
+func swapCounters(valp []*uint64) []*uint64 {
+	values := make([]uint64, len(valp))
+	old := make([]*uint64, len(valp))
+	for i, v := range valp {
+		values[i] = atomic.SwapUint64(v, 0)
+		old[i] = &values[i]
+	}
+	return old
+}
+
+// ResetAt returns when the metrics were last reset, or created if they never
+// were. Scrapers seeing it change know that counters started over.
+func (p *metrics) ResetAt() time.Time {
+	if p == nil {
+		return time.Time{}
+	}
+	return time.Unix(0, atomic.LoadInt64(&p.resetAt))
+}
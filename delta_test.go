@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetricsDelta(t *testing.T) {
+	m := newMetrics()
+	m.trackClasses([]int64{10})
+	m.Add(hit, 1, 5)
+	m.Add(costAdd, 1, 20)
+	m.victims.add(1, time.Second)
+	since := m.Snapshot()
+	m.Add(hit, 2, 3)
+	delta := m.Delta(since)
+	if delta["hit"] != 3 || delta["miss"] != 0 || delta["cost-added"] != 0 {
+		t.Fatalf("unexpected delta %v\n", delta)
+	}
+
+	createdAt := m.ResetAt()
+	time.Sleep(time.Millisecond)
+	m.Reset()
+	if !m.ResetAt().After(createdAt) {
+		t.Fatal("ResetAt should move forward on Reset")
+	}
+	if m.Get(hit) != 0 || m.Get(costAdd) != 0 || m.CostClasses()[1].Added != 0 ||
+		m.Victims().Count != 0 {
+		t.Fatal("Reset should zero every metric")
+	}
+	// counters that started over don't go backwards
+	m.Add(hit, 1, 2)
+	if delta := m.Delta(since); delta["hit"] != 2 {
+		t.Fatalf("expected 2 hits since the reset but got %d\n", delta["hit"])
+	}
+
+	var nilMetrics *metrics
+	nilMetrics.Reset()
+	if nilMetrics.Delta(since) != nil || !nilMetrics.ResetAt().IsZero() {
+		t.Fatal("nil metrics should have no delta")
+	}
+}
+
+func TestMetricsSwap(t *testing.T) {
+	m := newMetrics()
+	m.trackClasses([]int64{10})
+	m.Add(hit, 1, 5)
+	m.Add(costAdd, 1, 20)
+	m.victims.add(1, time.Second)
+	createdAt := m.ResetAt()
+	time.Sleep(time.Millisecond)
+	old := m.Swap()
+	if old.Get(hit) != 5 || old.CostClasses()[1].Added != 20 ||
+		old.Victims().Count != 1 || !old.ResetAt().Equal(createdAt) {
+		t.Fatal("swapped metrics should hold the values until the swap")
+	}
+	if m.Get(hit) != 0 || m.CostClasses()[1].Added != 0 ||
+		m.Victims().Count != 0 || !m.ResetAt().After(createdAt) {
+		t.Fatal("Swap should zero every metric")
+	}
+	// swapped metrics stop counting
+	m.Add(hit, 1, 1)
+	if old.Get(hit) != 5 || m.Get(hit) != 1 {
+		t.Fatal("only the new metrics should count")
+	}
+
+	// every metric added concurrently is counted exactly once
+	m.Reset()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(hash uint64) {
+			defer wg.Done()
+			for j := 0; j < 10000; j++ {
+				m.Add(hit, hash, 1)
+			}
+		}(uint64(i))
+	}
+	var swapped uint64
+	for i := 0; i < 10; i++ {
+		swapped += m.Swap().Get(hit)
+	}
+	wg.Wait()
+	if total := swapped + m.Get(hit); total != 40000 {
+		t.Fatalf("expected 40000 hits but got %d\n", total)
+	}
+
+	var nilMetrics *metrics
+	if nilMetrics.Swap() != nil {
+		t.Fatal("nil metrics should swap to nil")
+	}
+}
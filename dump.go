@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// cacheDump is the JSON document written by Cache.Dump.
+type cacheDump struct {
+	Name    string            `json:"name,omitempty"`
+	Config  configDump        `json:"config"`
+	Store   storeDump         `json:"store"`
+	Policy  policyDump        `json:"policy"`
+	Buffers bufferDump        `json:"buffers"`
+	Metrics map[string]uint64 `json:"metrics,omitempty"`
+	// PopularityDeciles is set if Config.PopularityDeciles is
+	PopularityDeciles []PopularityDecile `json:"popularityDeciles,omitempty"`
+}
+
+// configDump is the Config the cache runs with: ConfigSnapshot, with the
+// defaults the cache resolved filled in, and whether the settings that can't
+// be encoded are set. Embedding Config keeps new settings from being left out.
+type configDump struct {
+	Config
+	Codec     bool `json:"codec"`
+	Logger    bool `json:"logger"`
+	FileStore bool `json:"fileStore"`
+	LowerTier bool `json:"lowerTier"`
+}
+
+type storeDump struct {
+	Keys int `json:"keys"`
+	// Shards holds the number of keys in each shard
+	Shards []int `json:"shards,omitempty"`
+}
+
+type policyDump struct {
+	Keys int   `json:"keys"`
+	Cost int64 `json:"cost"`
+	Room int64 `json:"room"`
+	// SketchUsed and SketchMaxed are the fractions of access counters that
+	// are non-zero and maxed out
+	SketchUsed  float64 `json:"sketchUsed"`
+	SketchMaxed float64 `json:"sketchMaxed"`
+}
+
+type bufferDump struct {
+	SetLen int `json:"setLen"`
+	SetCap int `json:"setCap"`
+	// GetStripes is zero for the default pool of Get buffer stripes
+	GetStripes int `json:"getStripes"`
+	SetStripes int `json:"setStripes"`
+}
+
+// Dump writes a JSON document describing the cache's name, configuration and
+// internal state to w: the number of keys in every store shard, the policy's
+// cost accounting and access counters, the Set buffer's occupancy and, if
+// Config.Metrics is set, every metric, along with the hit ratio by
+// popularity if Config.PopularityDeciles is set. It's meant for debugging,
+// and walks the policy's keys while holding its lock.
+func (c *Cache) Dump(w io.Writer) error {
+	if c == nil {
+		return nil
+	}
+	d := &cacheDump{
+		Name: c.name,
+		Config: configDump{
+			Config:    c.ConfigSnapshot(),
+			Codec:     c.codec != nil,
+			Logger:    c.config.Logger != nil,
+			FileStore: c.config.Store != nil,
+			LowerTier: c.lowerTier != nil,
+		},
+		Buffers: bufferDump{
+			SetLen:     c.setBuf.Len(),
+			SetCap:     c.setBuf.Cap(),
+			GetStripes: len(c.getBuf.stripes),
+			SetStripes: len(c.setBuf.stripes),
+		},
+	}
+	config := &d.Config.Config
+	config.GetBufferStripeSize = c.config.getBufferStripeSize()
+	config.SetBufferSize = int64(c.setBuf.Cap())
+	config.SetBufferStripes = int64(len(c.setBuf.stripes))
+	config.NumWorkers = int64(len(c.processMu))
+	if config.OnEvictWorkers > 0 && config.OnEvictQueueSize == 0 {
+		config.OnEvictQueueSize = defaultEvictQueueSize
+	}
+	config.MemoryPressureThreshold = c.pressureThreshold
+	config.MemoryPressureEvict = c.pressureEvict
+	if sm, ok := c.store.(*shardedMap); ok {
+		config.NumShards = uint64(len(sm.shards))
+		d.Store.Shards = make([]int, len(sm.shards))
+		for i, shard := range sm.shards {
+			d.Store.Shards[i] = shard.Len()
+			d.Store.Keys += d.Store.Shards[i]
+		}
+	} else {
+		d.Store.Keys = c.store.Len()
+	}
+	costs, used := c.policy.Costs()
+	d.Policy.Keys, d.Policy.Cost, d.Policy.Room = len(costs), used, c.policy.Cap()
+	d.Policy.SketchUsed, d.Policy.SketchMaxed = c.policy.Saturation()
+	d.Metrics = c.stats.Snapshot()
+	d.PopularityDeciles = c.stats.PopularityDeciles()
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// DebugString returns the document written by Dump.
+func (c *Cache) DebugString() string {
+	var buf bytes.Buffer
+	c.Dump(&buf)
+	return buf.String()
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCacheDump(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		NumShards:         4,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i, 2)
+	}
+	d := &cacheDump{}
+	if err := json.Unmarshal([]byte(cache.DebugString()), d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Config.NumShards != 4 || len(d.Store.Shards) != 4 {
+		t.Fatal("expected 4 shards")
+	}
+	if d.Store.Keys != 5 || d.Policy.Keys != 5 {
+		t.Fatalf("expected 5 keys but got %d stored and %d in the policy\n",
+			d.Store.Keys, d.Policy.Keys)
+	}
+	if d.Policy.Cost != 10 || d.Policy.Room != 0 {
+		t.Fatalf("expected a full cache but got cost %d and room %d\n",
+			d.Policy.Cost, d.Policy.Room)
+	}
+	if d.Metrics["keys-added"] != 5 {
+		t.Fatal("metrics weren't dumped")
+	}
+	// every setting of the Config is dumped
+	var dumped struct {
+		Config map[string]interface{} `json:"config"`
+	}
+	if err := json.Unmarshal([]byte(cache.DebugString()), &dumped); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := json.Marshal(cache.ConfigSnapshot())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(snapshot, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for field := range fields {
+		if _, ok := dumped.Config[field]; !ok {
+			t.Fatalf("%s is missing from the dump\n", field)
+		}
+	}
+	if d.Config.NumWorkers != 1 || d.Config.SetBufferSize == 0 {
+		t.Fatal("the dump should hold the settings the cache resolved")
+	}
+	var nilCache *Cache
+	if nilCache.DebugString() != "" {
+		t.Fatal("nil Cache should dump nothing")
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "time"
+
+// eviction is an evicted value waiting for the eviction callbacks.
+type eviction struct {
+	key   uint64
+	val   interface{}
+	cost  int64
+	flags uint32
+	// added and updated are zero unless births are tracked
+	added, updated time.Time
+}
+
+// defaultEvictQueueSize is the size of the eviction queue if
+// Config.OnEvictQueueSize is zero.
+const defaultEvictQueueSize = 1024
+
+// dispatchEvictions starts workers calling the eviction callbacks for
+// evictions queued by notifyEviction.
+func (c *Cache) dispatchEvictions(workers, queueSize int) {
+	if queueSize == 0 {
+		queueSize = defaultEvictQueueSize
+	}
+	c.evictQueue = make(chan eviction, queueSize)
+	c.evictWorkers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(queue <-chan eviction) {
+			defer c.evictWorkers.Done()
+			for e := range queue {
+				c.onEviction(e)
+			}
+		}(c.evictQueue)
+	}
+}
+
+// stopEvictions stops the workers started by dispatchEvictions, once they've
+// called the eviction callbacks for every queued eviction. Later evictions
+// call them right away.
+func (c *Cache) stopEvictions() {
+	// evictQueue is only sent to under processMu
+	c.lockAll()
+	queue := c.evictQueue
+	c.evictQueue = nil
+	c.unlockAll()
+	if queue == nil {
+		return
+	}
+	close(queue)
+	c.evictWorkers.Wait()
+}
+
+// notifyEviction calls the eviction callbacks, or queues the eviction for the
+// workers calling them, if any. The value only exits the cache once the
+// callbacks returned, so they can still read it.
+func (c *Cache) notifyEviction(e eviction) {
+	if c.onEvict == nil && c.onEvictFlags == nil &&
+		c.config.OnEvictWithTimes == nil {
+		c.exit(e.val)
+		return
+	}
+	if c.evictQueue != nil {
+		select {
+		case c.evictQueue <- e:
+			return
+		default:
+			c.stats.Add(overflowEvictions, e.key, 1)
+		}
+	}
+	c.onEviction(e)
+}
+
+// onEviction calls the eviction callbacks for an evicted value.
+func (c *Cache) onEviction(e eviction) {
+	if val, ok := c.decode(value(e.val)); ok {
+		if c.onEvict != nil {
+			c.callback("OnEvict", func() { c.onEvict(e.key, val, e.cost) })
+		}
+		if c.onEvictFlags != nil {
+			c.callback("OnEvictWithFlags", func() {
+				c.onEvictFlags(e.key, val, e.cost, e.flags)
+			})
+		}
+		if c.config.OnEvictWithTimes != nil {
+			c.callback("OnEvictWithTimes", func() {
+				c.config.OnEvictWithTimes(e.key, val, e.cost, e.added, e.updated)
+			})
+		}
+	}
+	c.exit(e.val)
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheOnEvictWorkers(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	evicted := make(chan uint64, 10)
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		Metrics:     true,
+		OnEvict: func(key uint64, _ interface{}, _ int64) {
+			if key == 1 {
+				close(started)
+				<-release
+			}
+			evicted <- key
+		},
+		OnEvictWorkers:    1,
+		OnEvictQueueSize:  1,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	cache.Set(2, 2, 1)
+	<-started
+	// the worker is stuck on key 1, so key 2 waits in the queue, and key 3
+	// doesn't fit in it anymore
+	cache.Set(3, 3, 1)
+	cache.Set(4, 4, 1)
+	if key := <-evicted; key != 3 {
+		t.Fatalf("expected key 3 to be evicted right away, not %d\n", key)
+	}
+	if overflowed := cache.Metrics().Get(overflowEvictions); overflowed != 1 {
+		t.Fatalf("expected 1 overflowed callback but got %d\n", overflowed)
+	}
+	close(release)
+	for _, want := range []uint64{1, 2} {
+		select {
+		case key := <-evicted:
+			if key != want {
+				t.Fatalf("expected key %d to be evicted, not %d\n", want, key)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("queued callbacks should be called")
+		}
+	}
+}
+
+func TestCacheOnEvictWorkersClose(t *testing.T) {
+	release := make(chan struct{})
+	var evicted int32
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		OnEvict: func(uint64, interface{}, int64) {
+			<-release
+			atomic.AddInt32(&evicted, 1)
+		},
+		OnEvictWorkers:    2,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for key := 0; key < 5; key++ {
+		cache.Set(key, key, 1)
+	}
+	closed := make(chan struct{})
+	go func() {
+		cache.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close should wait for the queued callbacks")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-closed
+	if n := atomic.LoadInt32(&evicted); n != 4 {
+		t.Fatalf("expected 4 callbacks before Close returned, got %d\n", n)
+	}
+	// evictions after Close don't go to the closed queue
+	cache.Set(5, 5, 1)
+	cache.Set(6, 6, 1)
+}
+
+func TestByteCacheOnEvictWorkers(t *testing.T) {
+	mismatch := make(chan uint64, 1)
+	evicted := make(chan struct{}, 1)
+	cache, err := NewByteCache(&Config{
+		NumCounters: 1000,
+		MaxCost:     64 * 10,
+		BufferItems: 64,
+		OnEvict: func(key uint64, value interface{}, _ int64) {
+			// the value must outlive its eviction until the callback ran
+			time.Sleep(time.Millisecond)
+			if !bytes.Equal(value.([]byte), []byte{byte(key)}) {
+				select {
+				case mismatch <- key:
+				default:
+				}
+			}
+			select {
+			case evicted <- struct{}{}:
+			default:
+			}
+		},
+		OnEvictWorkers:    1,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Set(uint64(i), []byte{byte(i)})
+	}
+	select {
+	case <-evicted:
+	case <-time.After(time.Second):
+		t.Fatal("onEvict not being called")
+	}
+	cache.Close()
+	select {
+	case key := <-mismatch:
+		t.Fatalf("onEvict key-val mismatch for key %d\n", key)
+	default:
+	}
+}
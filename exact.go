@@ -0,0 +1,300 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// exactLimit is the largest MaxCost for which EvictAuto picks EvictExactLFU.
+const exactLimit = 10000
+
+// exactPolicy tracks the exact number of hits and the last access of every
+// key, and evicts the least frequently used key, or the least recently used
+// one if lfu isn't set, instead of estimating frequencies with a sketch and
+// sampling victims. It admits every key that fits in the cache. It's only
+// meant for small caches, since every access costs O(log n).
+type exactPolicy struct {
+	sync.Mutex
+	entries exactHeap
+	keys    map[uint64]*exactEntry
+	// tick orders accesses
+	tick    uint64
+	maxCost int64
+	used    int64
+	hot     *spaceSaving
+	stats   *metrics
+}
+
+type exactEntry struct {
+	key  uint64
+	cost int64
+	hits int64
+	// tick is the time of the last access
+	tick uint64
+	// pos is the entry's position in the heap
+	pos int
+}
+
+// exactHeap is a min-heap on hits, if lfu is set, and then on the last
+// access.
+type exactHeap struct {
+	entries []*exactEntry
+	lfu     bool
+}
+
+func (h *exactHeap) Len() int { return len(h.entries) }
+func (h *exactHeap) Less(i, j int) bool {
+	a, b := h.entries[i], h.entries[j]
+	if h.lfu && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.tick < b.tick
+}
+func (h *exactHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].pos, h.entries[j].pos = i, j
+}
+func (h *exactHeap) Push(x interface{}) {
+	e := x.(*exactEntry)
+	e.pos = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+func (h *exactHeap) Pop() interface{} {
+	old := h.entries
+	e := old[len(old)-1]
+	h.entries = old[:len(old)-1]
+	return e
+}
+
+func newExactLFUPolicy(maxCost int64, opts policyOptions) policy {
+	return newExactPolicy(maxCost, true, opts)
+}
+
+func newExactLRUPolicy(maxCost int64, opts policyOptions) policy {
+	return newExactPolicy(maxCost, false, opts)
+}
+
+func newExactPolicy(maxCost int64, lfu bool, opts policyOptions) *exactPolicy {
+	p := &exactPolicy{
+		entries: exactHeap{lfu: lfu},
+		keys:    make(map[uint64]*exactEntry),
+		maxCost: maxCost,
+	}
+	if opts.hotKeys > 0 {
+		p.hot = newSpaceSaving(opts.hotKeys)
+	}
+	return p
+}
+
+func (p *exactPolicy) CollectMetrics(stats *metrics) {
+	p.stats = stats
+}
+
+func (p *exactPolicy) Push(keys []uint64) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, key := range keys {
+		if p.hot != nil {
+			p.hot.increment(key)
+		}
+		if e, ok := p.keys[key]; ok {
+			p.tick++
+			e.hits, e.tick = e.hits+1, p.tick
+			heap.Fix(&p.entries, e.pos)
+		}
+	}
+	p.stats.Add(keepGets, keys[0], uint64(len(keys)))
+	return true
+}
+
+func (p *exactPolicy) Add(key uint64, cost int64) ([]*item, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if cost > p.maxCost {
+		p.stats.Add(oversizeSets, key, 1)
+		return nil, false
+	}
+	if e, ok := p.keys[key]; ok {
+		p.update(e, cost)
+		return nil, true
+	}
+	victims := p.evictN(p.used + cost - p.maxCost)
+	p.tick++
+	e := &exactEntry{key: key, cost: cost, hits: 1, tick: p.tick}
+	heap.Push(&p.entries, e)
+	p.keys[key] = e
+	p.used += cost
+	p.stats.Add(keyAdd, key, 1)
+	p.stats.Add(costAdd, key, uint64(cost))
+	return victims, true
+}
+
+// evictN evicts the least valuable entries until at least cost was freed.
+// The caller must hold the lock.
+func (p *exactPolicy) evictN(cost int64) []*item {
+	var victims []*item
+	for freed := int64(0); freed < cost && p.entries.Len() > 0; {
+		victim := heap.Pop(&p.entries).(*exactEntry)
+		p.remove(victim)
+		victims = append(victims, newVictim(victim.key, victim.cost))
+		freed += victim.cost
+	}
+	return victims
+}
+
+func (p *exactPolicy) Evict(cost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	return p.evictN(cost)
+}
+
+// update changes the cost of an entry.
+func (p *exactPolicy) update(e *exactEntry, cost int64) {
+	p.stats.Add(keyUpdate, e.key, 1)
+	p.used += cost - e.cost
+	e.cost = cost
+}
+
+// remove forgets an entry that's no longer in the heap.
+func (p *exactPolicy) remove(e *exactEntry) {
+	p.stats.Add(keyEvict, e.key, 1)
+	p.stats.Add(costEvict, e.key, uint64(e.cost))
+	p.used -= e.cost
+	delete(p.keys, e.key)
+}
+
+func (p *exactPolicy) Update(key uint64, cost int64) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		p.update(e, cost)
+	}
+}
+
+func (p *exactPolicy) Has(key uint64) bool {
+	p.Lock()
+	defer p.Unlock()
+	_, has := p.keys[key]
+	return has
+}
+
+func (p *exactPolicy) Cost(key uint64) (int64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		return e.cost, true
+	}
+	return 0, false
+}
+
+// Estimate returns the exact hits of a key in the cache, and zero for any
+// other key.
+func (p *exactPolicy) Estimate(key uint64) int64 {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		return e.hits
+	}
+	return 0
+}
+
+func (p *exactPolicy) Del(key uint64) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		heap.Remove(&p.entries, e.pos)
+		p.remove(e)
+	}
+}
+
+// Pin does nothing, only the default policy pins keys.
+func (p *exactPolicy) Pin(key uint64) {}
+
+// Prioritize does nothing, only the default policy has priorities.
+func (p *exactPolicy) Prioritize(key uint64, priority int) {}
+
+func (p *exactPolicy) Costs() (map[uint64]int64, int64) {
+	p.Lock()
+	defer p.Unlock()
+	costs := make(map[uint64]int64, len(p.keys))
+	for key, e := range p.keys {
+		costs[key] = e.cost
+	}
+	return costs, p.used
+}
+
+func (p *exactPolicy) Recount() int64 {
+	p.Lock()
+	defer p.Unlock()
+	var sum int64
+	for _, e := range p.keys {
+		sum += e.cost
+	}
+	drift := p.used - sum
+	p.used = sum
+	return drift
+}
+
+// Saturation returns zeros, since exactPolicy has no access counters.
+func (p *exactPolicy) Saturation() (float64, float64) {
+	return 0, 0
+}
+
+// SketchStats returns zeros, since exactPolicy has no access counters.
+func (p *exactPolicy) SketchStats() SketchStats {
+	return SketchStats{}
+}
+
+func (p *exactPolicy) HotKeys(n int) []KeyCount {
+	p.Lock()
+	defer p.Unlock()
+	if p.hot == nil {
+		return nil
+	}
+	return p.hot.top(n)
+}
+
+// ResizeCounters does nothing, since exactPolicy counts hits exactly.
+func (p *exactPolicy) ResizeCounters(numCounters int64) {}
+
+// Penalize does nothing, since exactPolicy doesn't track penalties.
+func (p *exactPolicy) Penalize(key uint64, penalty time.Duration) {}
+
+func (p *exactPolicy) Cap() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.maxCost - p.used
+}
+
+func (p *exactPolicy) MaxCost() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.maxCost
+}
+
+func (p *exactPolicy) SetMaxCost(maxCost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	p.maxCost = maxCost
+	return p.evictN(p.used - maxCost)
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+)
+
+func TestExactLFUPolicy(t *testing.T) {
+	GeneratePolicyTest(func(_, maxCost int64, opts policyOptions) policy {
+		return newExactLFUPolicy(maxCost, opts)
+	})(t)
+}
+
+func TestExactLRUPolicy(t *testing.T) {
+	GeneratePolicyTest(func(_, maxCost int64, opts policyOptions) policy {
+		return newExactLRUPolicy(maxCost, opts)
+	})(t)
+}
+
+func TestExactPolicyEviction(t *testing.T) {
+	for _, lfu := range []bool{false, true} {
+		p := newExactPolicy(3, lfu, policyOptions{})
+		for key := uint64(1); key <= 3; key++ {
+			p.Add(key, 1)
+		}
+		// key 1 is the most frequently used, key 2 the most recently used
+		p.Push([]uint64{1, 1, 3, 2})
+		// LFU evicts the least recently used of the least frequently used
+		// keys, so the new key goes next, before keys that were ever hit
+		want := []uint64{1, 3}
+		if lfu {
+			want = []uint64{3, 11}
+		}
+		for i, key := range want {
+			victims, added := p.Add(uint64(11+i), 1)
+			if !added || len(victims) != 1 || victims[0].key != key {
+				t.Fatalf("lfu=%v: expected key %d to be evicted\n", lfu, key)
+			}
+		}
+		if lfu && p.Estimate(1) != 3 {
+			t.Fatal("hits should be counted exactly")
+		}
+		if _, added := p.Add(4, 4); added {
+			t.Fatal("keys costing more than MaxCost shouldn't be added")
+		}
+	}
+}
+
+func TestCacheEvictAuto(t *testing.T) {
+	for _, maxCost := range []int64{exactLimit, exactLimit + 1} {
+		cache, err := NewCache(&Config{
+			NumCounters:    100,
+			MaxCost:        maxCost,
+			BufferItems:    64,
+			EvictionPolicy: EvictAuto,
+		})
+		if err != nil {
+			panic(err)
+		}
+		_, exact := cache.policy.(*exactPolicy)
+		if exact != (maxCost <= exactLimit) {
+			t.Fatalf("MaxCost %d: unexpected policy %T\n", maxCost, cache.policy)
+		}
+		cache.Close()
+	}
+}
@@ -0,0 +1,675 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+const (
+	// fileMagic starts every file of a FileStore, followed by the number of
+	// slots, the size of a slot and, for encrypted FileStores, a non-zero
+	// word and a seal of fileKeyCheck at offset 24.
+	fileMagic      = "RSTRFS01"
+	fileHeaderSize = 64
+	// fileKeyCheck is authenticated by an empty value sealed into the header
+	// of encrypted FileStores, so opening them with another key fails right
+	// away.
+	fileKeyCheck = "ristretto"
+
+	// Every slot starts with its state, flags, key, the length and checksum
+	// of its value and, for overflowed values, the value's offset in the
+	// overflow log. Inline values follow.
+	slotHeaderSize = 32
+	minSlotSize    = 64
+
+	// fileSlotUsed marks slots holding a value, fileSlotEncoded values that
+	// went through Config.Codec, and fileSlotOverflow values in the overflow
+	// log.
+	fileSlotUsed     = 1
+	fileSlotEncoded  = 2
+	fileSlotOverflow = 4
+
+	// Every record of the overflow log starts with its key, the length of
+	// its value and the value's checksum.
+	logHeaderSize = 16
+	// logCompactMin is the garbage in the overflow log worth compacting it,
+	// once it's also more than half of the log.
+	logCompactMin = 1 << 20
+)
+
+// FileStore keeps the values of a cache in a memory-mapped file, so they
+// survive restarts, for caches of []byte values in front of slow storage,
+// like local SSD caches in front of object storage. It's passed to a single
+// cache by Config.Store.
+//
+// The file is split into fixed-size slots, one per key. Values that don't fit
+// in a slot are appended to an overflow log next to the file, which is
+// compacted once most of it is garbage. Only []byte values are kept in the
+// file. Other values, values set with a TTL and keys that don't find a free
+// slot are kept in memory, and are gone after a restart. Values are copied
+// on every Get, and checksummed, so values torn by a crash are dropped rather
+// than returned. Stores opened with OpenEncryptedFileStore encrypt values
+// before they reach the file.
+//
+// The operating system writes changes back to the file on its own, and Sync
+// forces it to. Close must be called once the cache was closed.
+type FileStore struct {
+	mu       sync.RWMutex
+	path     string
+	file     *os.File
+	data     []byte
+	slotSize int
+	// aead encrypts values, if set
+	aead cipher.AEAD
+	// free holds the free slots, the lowest last
+	free  []int32
+	index map[uint64]*fileEntry
+	// log is the overflow log, of which logDead bytes are garbage
+	log     *os.File
+	logSize int64
+	logDead int64
+}
+
+// fileEntry describes a key in a FileStore.
+type fileEntry struct {
+	version uint64
+	flags   uint32
+	// slot holds the value, or is -1 if the value is kept in memory
+	slot int32
+	// value is the value kept in memory
+	value interface{}
+}
+
+// OpenFileStore opens the FileStore at path, creating it with room for slots
+// keys if it doesn't exist. Every slot takes slotSize bytes, of which values
+// can use all but 32; longer values go to the overflow log at path.log. An
+// existing FileStore must be opened with the same slots and slotSize.
+func OpenFileStore(path string, slots, slotSize int) (*FileStore, error) {
+	return openFileStore(path, slots, slotSize, nil)
+}
+
+// OpenEncryptedFileStore is like OpenFileStore, but seals every value with
+// aead, such as AES-GCM, before it's written, so the values at rest can't be
+// read or tampered with without its key. Sealing adds the nonce and tag to
+// every value, which slots must make room for. An existing FileStore must be
+// opened with the same key, and can't switch between being encrypted or not.
+func OpenEncryptedFileStore(path string, slots, slotSize int,
+	aead cipher.AEAD) (*FileStore, error) {
+	if aead == nil {
+		return nil, errors.New("ristretto: encrypted FileStores need an AEAD")
+	}
+	if aead.NonceSize()+aead.Overhead() > fileHeaderSize-24 {
+		return nil, errors.New("ristretto: the AEAD's overhead is too large")
+	}
+	return openFileStore(path, slots, slotSize, aead)
+}
+
+func openFileStore(path string, slots, slotSize int,
+	aead cipher.AEAD) (*FileStore, error) {
+	if slots <= 0 || slots > math.MaxInt32 {
+		return nil, errors.New("ristretto: invalid number of slots")
+	}
+	if slotSize < minSlotSize || slotSize%8 != 0 {
+		return nil, fmt.Errorf(
+			"ristretto: slots must be a multiple of 8 of at least %d bytes",
+			minSlotSize)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{path: path, file: file, slotSize: slotSize, aead: aead,
+		index: make(map[uint64]*fileEntry)}
+	if err := s.open(slots); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// open maps the file, creating it if it's empty, and loads its keys.
+func (s *FileStore) open(slots int) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := int64(fileHeaderSize) + int64(slots)*int64(s.slotSize)
+	created := info.Size() == 0
+	if created {
+		if err := s.file.Truncate(size); err != nil {
+			return err
+		}
+	} else if info.Size() != size {
+		return fmt.Errorf("ristretto: %s was created with other slots", s.path)
+	}
+	if s.data, err = z.MmapFile(s.file, int(size)); err != nil {
+		return err
+	}
+	header := s.data[:fileHeaderSize]
+	if created {
+		copy(header, fileMagic)
+		binary.LittleEndian.PutUint32(header[8:], uint32(slots))
+		binary.LittleEndian.PutUint32(header[12:], uint32(s.slotSize))
+	} else if !bytes.Equal(header[:8], []byte(fileMagic)) ||
+		binary.LittleEndian.Uint32(header[8:]) != uint32(slots) ||
+		binary.LittleEndian.Uint32(header[12:]) != uint32(s.slotSize) {
+		return fmt.Errorf("ristretto: %s was created with other slots", s.path)
+	}
+	if err := s.checkKey(header, created); err != nil {
+		return err
+	}
+	s.log, err = os.OpenFile(s.path+".log", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if info, err = s.log.Stat(); err != nil {
+		return err
+	}
+	s.logSize, s.logDead = info.Size(), info.Size()
+	for i := int32(slots - 1); i >= 0; i-- {
+		key, ok := s.check(i)
+		if _, dup := s.index[key]; !ok || dup {
+			// the log's size already counts its records as garbage
+			binary.LittleEndian.PutUint32(s.slot(i), 0)
+			s.free = append(s.free, i)
+			continue
+		}
+		s.index[key] = &fileEntry{slot: i}
+		if s.overflows(i) {
+			s.logDead -= logHeaderSize + int64(s.length(i))
+		}
+	}
+	s.compactIfDue()
+	return nil
+}
+
+// checkKey seals fileKeyCheck into the header of new encrypted FileStores,
+// and checks that existing ones are opened with the key they were created
+// with.
+func (s *FileStore) checkKey(header []byte, created bool) error {
+	if created && s.aead != nil {
+		binary.LittleEndian.PutUint32(header[16:], 1)
+		sealed, err := s.seal([]byte(fileKeyCheck), nil)
+		if err != nil {
+			return err
+		}
+		copy(header[24:], sealed)
+		return nil
+	}
+	encrypted := binary.LittleEndian.Uint32(header[16:]) != 0
+	if encrypted != (s.aead != nil) {
+		return fmt.Errorf("ristretto: %s is encrypted, or isn't, unlike "+
+			"it's being opened", s.path)
+	}
+	if !encrypted {
+		return nil
+	}
+	n := s.aead.NonceSize() + s.aead.Overhead()
+	if _, err := s.unseal(header[24:24+n], []byte(fileKeyCheck)); err != nil {
+		return fmt.Errorf("ristretto: %s was encrypted with another key",
+			s.path)
+	}
+	return nil
+}
+
+// seal encrypts b with the AEAD, authenticating ad along with it, and
+// returns the nonce followed by the sealed value.
+func (s *FileStore) seal(ad, b []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	nonce := make([]byte, n, n+len(b)+s.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, b, ad), nil
+}
+
+// unseal reverses seal.
+func (s *FileStore) unseal(sealed, ad []byte) ([]byte, error) {
+	n := s.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("ristretto: sealed value is too short")
+	}
+	return s.aead.Open(sealed[n:n], sealed[:n], sealed[n:], ad)
+}
+
+// keyBytes returns the bytes of key.
+func keyBytes(key uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], key)
+	return b[:]
+}
+
+// check returns the key of slot i, and false if it doesn't hold a valid
+// value.
+func (s *FileStore) check(i int32) (uint64, bool) {
+	slot := s.slot(i)
+	if binary.LittleEndian.Uint32(slot)&fileSlotUsed == 0 {
+		return 0, false
+	}
+	_, ok := s.read(i)
+	return binary.LittleEndian.Uint64(slot[8:]), ok
+}
+
+func (s *FileStore) slot(i int32) []byte {
+	off := fileHeaderSize + int(i)*s.slotSize
+	return s.data[off : off+s.slotSize]
+}
+
+func (s *FileStore) overflows(i int32) bool {
+	return binary.LittleEndian.Uint32(s.slot(i))&fileSlotOverflow != 0
+}
+
+func (s *FileStore) length(i int32) uint32 {
+	return binary.LittleEndian.Uint32(s.slot(i)[16:])
+}
+
+// read returns a copy of the value in slot i, and false if it's corrupt.
+func (s *FileStore) read(i int32) (interface{}, bool) {
+	slot := s.slot(i)
+	state := binary.LittleEndian.Uint32(slot)
+	key, n := binary.LittleEndian.Uint64(slot[8:]), s.length(i)
+	sum := binary.LittleEndian.Uint32(slot[20:])
+	var b []byte
+	if state&fileSlotOverflow == 0 {
+		if int(n) > s.slotSize-slotHeaderSize {
+			return nil, false
+		}
+		b = append([]byte(nil), slot[slotHeaderSize:slotHeaderSize+n]...)
+	} else {
+		// a corrupt length mustn't allocate more than the log holds
+		off := int64(binary.LittleEndian.Uint64(slot[24:]))
+		if off < 0 || off+logHeaderSize+int64(n) > s.logSize {
+			return nil, false
+		}
+		b = make([]byte, n)
+		if !s.readLog(off, key, b) {
+			return nil, false
+		}
+	}
+	if crc32.ChecksumIEEE(b) != sum {
+		return nil, false
+	}
+	if s.aead != nil {
+		var err error
+		if b, err = s.unseal(b, keyBytes(key)); err != nil {
+			return nil, false
+		}
+	}
+	if state&fileSlotEncoded != 0 {
+		return encodedValue(b), true
+	}
+	return b, true
+}
+
+// readLog reads the value of key at off in the overflow log into b, and
+// returns false if the record at off isn't that value. The record must be
+// within the log.
+func (s *FileStore) readLog(off int64, key uint64, b []byte) bool {
+	var header [logHeaderSize]byte
+	if _, err := s.log.ReadAt(header[:], off); err != nil {
+		return false
+	}
+	if binary.LittleEndian.Uint64(header[:]) != key ||
+		binary.LittleEndian.Uint32(header[8:]) != uint32(len(b)) {
+		return false
+	}
+	_, err := s.log.ReadAt(b, off+logHeaderSize)
+	return err == nil && crc32.ChecksumIEEE(b) ==
+		binary.LittleEndian.Uint32(header[12:])
+}
+
+// appendLog appends the value of key to the overflow log, and returns its
+// offset.
+func (s *FileStore) appendLog(key uint64, b []byte) (int64, error) {
+	record := make([]byte, logHeaderSize+len(b))
+	binary.LittleEndian.PutUint64(record, key)
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(record[12:], crc32.ChecksumIEEE(b))
+	copy(record[logHeaderSize:], b)
+	off := s.logSize
+	if _, err := s.log.WriteAt(record, off); err != nil {
+		return 0, err
+	}
+	s.logSize += int64(len(record))
+	return off, nil
+}
+
+// write stores b in slot i, and returns false if it couldn't. The slot is
+// marked free until it's written, so a crash can't leave it half written.
+func (s *FileStore) write(i int32, key uint64, b []byte, encoded bool,
+	flags uint32) bool {
+	if s.aead != nil {
+		// values are bound to their key, so they can't be swapped
+		var err error
+		if b, err = s.seal(keyBytes(key), b); err != nil {
+			return false
+		}
+	}
+	s.release(i)
+	slot := s.slot(i)
+	state := uint32(fileSlotUsed)
+	if encoded {
+		state |= fileSlotEncoded
+	}
+	binary.LittleEndian.PutUint32(slot[4:], flags)
+	binary.LittleEndian.PutUint64(slot[8:], key)
+	binary.LittleEndian.PutUint32(slot[16:], uint32(len(b)))
+	binary.LittleEndian.PutUint32(slot[20:], crc32.ChecksumIEEE(b))
+	if len(b) <= s.slotSize-slotHeaderSize {
+		copy(slot[slotHeaderSize:], b)
+	} else {
+		off, err := s.appendLog(key, b)
+		if err != nil {
+			return false
+		}
+		state |= fileSlotOverflow
+		binary.LittleEndian.PutUint64(slot[24:], uint64(off))
+	}
+	binary.LittleEndian.PutUint32(slot, state)
+	return true
+}
+
+// release marks slot i as free, turning its overflowed value into garbage.
+func (s *FileStore) release(i int32) {
+	slot := s.slot(i)
+	state := binary.LittleEndian.Uint32(slot)
+	if state&fileSlotUsed != 0 && state&fileSlotOverflow != 0 {
+		s.logDead += logHeaderSize + int64(s.length(i))
+	}
+	binary.LittleEndian.PutUint32(slot, 0)
+}
+
+// persisted returns the bytes of values kept in the file.
+func persisted(value interface{}) ([]byte, bool, bool) {
+	switch v := value.(type) {
+	case []byte:
+		return v, false, true
+	case encodedValue:
+		return v, true, true
+	}
+	return nil, false, false
+}
+
+// put stores the value of e, in a slot if it can be persisted, and in memory
+// otherwise.
+func (s *FileStore) put(key uint64, e *fileEntry, value interface{},
+	version uint64, flags uint32) {
+	e.version, e.flags, e.value = version, flags, nil
+	b, encoded, ok := persisted(value)
+	if ok && e.slot < 0 && len(s.free) > 0 {
+		e.slot, s.free = s.free[len(s.free)-1], s.free[:len(s.free)-1]
+	}
+	if ok && e.slot >= 0 && s.write(e.slot, key, b, encoded, flags) {
+		s.compactIfDue()
+		return
+	}
+	s.drop(e)
+	e.value = value
+}
+
+// drop frees the slot of e, if it has one.
+func (s *FileStore) drop(e *fileEntry) {
+	if e.slot < 0 {
+		return
+	}
+	s.release(e.slot)
+	s.free = append(s.free, e.slot)
+	e.slot = -1
+}
+
+// get returns the value of e.
+func (s *FileStore) get(e *fileEntry) (interface{}, bool) {
+	if e.slot < 0 {
+		return e.value, true
+	}
+	return s.read(e.slot)
+}
+
+// persists reports whether the value of key is kept in the file, so it
+// survives the cache being closed.
+func (s *FileStore) persists(key uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.index[key]
+	return ok && e.slot >= 0
+}
+
+func (s *FileStore) Get(key uint64) (interface{}, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.index[key]; ok {
+		return s.get(e)
+	}
+	return nil, false
+}
+
+func (s *FileStore) GetVersion(key uint64) (interface{}, uint64, uint32, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.index[key]
+	if !ok {
+		return nil, 0, 0, false
+	}
+	value, ok := s.get(e)
+	return value, e.version, e.flags, ok
+}
+
+func (s *FileStore) Set(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[key]
+	if !ok {
+		e = &fileEntry{slot: -1}
+		s.index[key] = e
+		s.put(key, e, value, version, flags)
+		return nil, false
+	}
+	return s.replace(key, e, value, version, flags), true
+}
+
+// replace stores value unless e is newer than version, and returns the value
+// that's no longer stored.
+func (s *FileStore) replace(key uint64, e *fileEntry, value interface{},
+	version uint64, flags uint32) interface{} {
+	if e.version > version {
+		return value
+	}
+	prev, _ := s.get(e)
+	s.put(key, e, value, version, flags)
+	return prev
+}
+
+func (s *FileStore) Update(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[key]
+	if !ok {
+		return nil, false
+	}
+	return s.replace(key, e, value, version, flags), true
+}
+
+func (s *FileStore) CompareAndSwap(key uint64, value interface{},
+	expected, version uint64, flags uint32) (interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[key]
+	if !ok || e.version != expected {
+		return nil, false
+	}
+	return s.replace(key, e, value, version, flags), true
+}
+
+func (s *FileStore) Del(key uint64, version uint64) (interface{}, uint32, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.index[key]
+	if !ok || e.version > version {
+		return nil, 0, false
+	}
+	value, _ := s.get(e)
+	s.drop(e)
+	delete(s.index, key)
+	s.compactIfDue()
+	return value, e.flags, true
+}
+
+func (s *FileStore) Range(f func(key uint64, value interface{}) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, e := range s.index {
+		if value, ok := s.get(e); ok && !f(key, value) {
+			return
+		}
+	}
+}
+
+func (s *FileStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.index)
+}
+
+// compactIfDue compacts the overflow log once most of it is garbage.
+func (s *FileStore) compactIfDue() {
+	if s.logDead < logCompactMin || s.logDead*2 < s.logSize {
+		return
+	}
+	// the log is compacted again once more garbage piles up
+	s.compact()
+}
+
+// compact copies the live values of the overflow log to a new log, which
+// replaces the old one before the slots are pointed at it. A crash in
+// between loses the overflowed values, whose records no longer match.
+func (s *FileStore) compact() error {
+	tmp, err := os.OpenFile(s.path+".log.tmp",
+		os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	moved := make(map[int32]int64)
+	var size int64
+	for key, e := range s.index {
+		if e.slot < 0 || !s.overflows(e.slot) {
+			continue
+		}
+		off := int64(binary.LittleEndian.Uint64(s.slot(e.slot)[24:]))
+		record := make([]byte, logHeaderSize+int64(s.length(e.slot)))
+		if _, err = s.log.ReadAt(record, off); err == nil {
+			_, err = tmp.WriteAt(record, size)
+		}
+		if err == nil && binary.LittleEndian.Uint64(record) != key {
+			err = fmt.Errorf("ristretto: corrupt overflow log %s", s.log.Name())
+		}
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+		moved[e.slot] = size
+		size += int64(len(record))
+	}
+	if err = tmp.Sync(); err == nil {
+		err = os.Rename(tmp.Name(), s.path+".log")
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.log.Close()
+	s.log, s.logSize, s.logDead = tmp, size, 0
+	for i, off := range moved {
+		slot := s.slot(i)
+		state := binary.LittleEndian.Uint32(slot)
+		binary.LittleEndian.PutUint32(slot, 0)
+		binary.LittleEndian.PutUint64(slot[24:], uint64(off))
+		binary.LittleEndian.PutUint32(slot, state)
+	}
+	return nil
+}
+
+// Sync writes every change back to the file and the overflow log, and waits
+// until they're on disk.
+func (s *FileStore) Sync() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := z.Msync(s.data); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+// Close syncs the FileStore and closes its files. The cache using it must
+// not be used afterwards.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.data != nil {
+		err = z.Msync(s.data)
+		if unmapErr := z.Munmap(s.data); err == nil {
+			err = unmapErr
+		}
+		s.data = nil
+	}
+	if s.log != nil {
+		if closeErr := s.log.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// loadStore adds the values a FileStore kept from a previous run to the policy,
+// costing their length in bytes, and deletes the ones that don't fit.
+func (c *Cache) loadStore() {
+	var keys []uint64
+	var costs []int64
+	c.store.Range(func(key uint64, val interface{}) bool {
+		b, _, _ := persisted(val)
+		keys, costs = append(keys, key), append(costs, int64(len(b)))
+		return true
+	})
+	for i, key := range keys {
+		if costs[i] <= c.shardPolicy(key).Cap() {
+			if _, added := c.policy.Add(key, costs[i]); added {
+				c.born(key)
+				continue
+			}
+		}
+		c.store.Del(key, math.MaxUint64)
+	}
+}
@@ -0,0 +1,272 @@
+//go:build linux || darwin || freebsd || openbsd || dragonfly
+// +build linux darwin freebsd openbsd dragonfly
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestFileStore(t *testing.T, dir string, slots int) *FileStore {
+	s, err := OpenFileStore(filepath.Join(dir, "cache"), slots, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestFileStore(t, dir, 2)
+	long := bytes.Repeat([]byte("x"), 100)
+	s.Set(1, []byte("short"), 1, 7)
+	s.Set(2, long, 1, 0)
+	s.Set(3, "not bytes", 1, 0)
+	// every slot is taken, so the value is kept in memory
+	s.Set(4, []byte("no slot"), 1, 0)
+	if prev, ok := s.Set(1, []byte("older"), 0, 0); !ok ||
+		string(prev.([]byte)) != "older" {
+		t.Fatal("older writes should be rejected")
+	}
+	if val, version, flags, ok := s.GetVersion(1); !ok ||
+		string(val.([]byte)) != "short" || version != 1 || flags != 7 {
+		t.Fatalf("unexpected value %v of version %d\n", val, version)
+	}
+	if val, ok := s.Get(2); !ok || !bytes.Equal(val.([]byte), long) {
+		t.Fatal("overflowed values should be read from the log")
+	}
+	if prev, ok := s.Update(1, []byte("updated"), 2, 0); !ok ||
+		string(prev.([]byte)) != "short" {
+		t.Fatal("Update should return the previous value")
+	}
+	if s.Len() != 4 {
+		t.Fatalf("expected 4 keys but got %d\n", s.Len())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// only []byte values that found a slot survive
+	s = openTestFileStore(t, dir, 2)
+	defer s.Close()
+	if s.Len() != 2 {
+		t.Fatalf("expected 2 persisted keys but got %d\n", s.Len())
+	}
+	if val, ok := s.Get(1); !ok || string(val.([]byte)) != "updated" {
+		t.Fatal("the last value should be persisted")
+	}
+	if val, ok := s.Get(2); !ok || !bytes.Equal(val.([]byte), long) {
+		t.Fatal("overflowed values should be persisted")
+	}
+	if _, _, ok := s.Del(2, 1); !ok {
+		t.Fatal("persisted keys should be deleted")
+	}
+	// freed slots are reused
+	s.Set(3, []byte("slot"), 1, 0)
+	if e := s.index[3]; e.slot < 0 {
+		t.Fatal("freed slots should be reused")
+	}
+	if _, err := OpenFileStore(filepath.Join(dir, "cache"), 3, 64); err == nil {
+		t.Fatal("FileStores should be opened with the slots they were created with")
+	}
+}
+
+func TestFileStoreCorruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestFileStore(t, dir, 3)
+	s.Set(1, []byte("kept"), 1, 0)
+	s.Set(2, []byte("torn"), 1, 0)
+	s.Set(3, bytes.Repeat([]byte("overflowed"), 10), 1, 0)
+	// a crash tore the value of key 2, and the length of key 3, which
+	// mustn't be allocated before it's checked against the log
+	s.slot(s.index[2].slot)[slotHeaderSize] ^= 0xff
+	copy(s.slot(s.index[3].slot)[16:], []byte{0xff, 0xff, 0xff, 0xff})
+	s.Close()
+	s = openTestFileStore(t, dir, 3)
+	defer s.Close()
+	if _, ok := s.Get(2); ok {
+		t.Fatal("corrupt values should be dropped")
+	}
+	if _, ok := s.Get(3); ok {
+		t.Fatal("values with a corrupt length should be dropped")
+	}
+	if _, ok := s.Get(1); !ok || len(s.free) != 2 {
+		t.Fatal("only the corrupt values should be dropped")
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := openTestFileStore(t, dir, 2)
+	defer s.Close()
+	val := bytes.Repeat([]byte("x"), 64<<10)
+	for version := uint64(1); version <= 64; version++ {
+		s.Set(1, val, version, 0)
+		s.Set(2, val, version, 0)
+	}
+	if s.logSize > 2*logCompactMin {
+		t.Fatalf("the overflow log should be compacted, it has %d bytes\n",
+			s.logSize)
+	}
+	for key := uint64(1); key <= 2; key++ {
+		if got, ok := s.Get(key); !ok || !bytes.Equal(got.([]byte), val) {
+			t.Fatalf("key %d was lost by compaction\n", key)
+		}
+	}
+}
+
+func TestFileStoreEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	newAEAD := func(key string) cipher.AEAD {
+		block, err := aes.NewCipher([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return aead
+	}
+	path := filepath.Join(dir, "cache")
+	key := "0123456789abcdef"
+	s, err := OpenEncryptedFileStore(path, 2, 128, newAEAD(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := bytes.Repeat([]byte("secret"), 50)
+	s.Set(1, []byte("plaintext"), 1, 0)
+	s.Set(2, long, 1, 0)
+	if val, ok := s.Get(1); !ok || string(val.([]byte)) != "plaintext" {
+		t.Fatal("encrypted values should be decrypted")
+	}
+	s.Close()
+	for _, name := range []string{path, path + ".log"} {
+		raw, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, []byte("plaintext")) ||
+			bytes.Contains(raw, []byte("secret")) {
+			t.Fatalf("%s holds plaintext\n", name)
+		}
+	}
+
+	if _, err := OpenEncryptedFileStore(path, 2, 128,
+		newAEAD("fedcba9876543210")); err == nil {
+		t.Fatal("FileStores shouldn't be opened with the wrong key")
+	}
+	if _, err := OpenFileStore(path, 2, 128); err == nil {
+		t.Fatal("encrypted FileStores shouldn't be opened without a key")
+	}
+	// the key is checked again every time
+	for i := 0; i < 2; i++ {
+		if s, err = OpenEncryptedFileStore(path, 2, 128, newAEAD(key)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			s.Close()
+		}
+	}
+	defer s.Close()
+	if val, ok := s.Get(1); !ok || string(val.([]byte)) != "plaintext" {
+		t.Fatal("encrypted values should be persisted")
+	}
+	if val, ok := s.Get(2); !ok || !bytes.Equal(val.([]byte), long) {
+		t.Fatal("encrypted overflowed values should be persisted")
+	}
+
+	path = filepath.Join(dir, "plain")
+	plain, err := OpenFileStore(path, 2, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain.Close()
+	if _, err := OpenEncryptedFileStore(path, 2, 128, newAEAD(key)); err == nil {
+		t.Fatal("plain FileStores shouldn't be opened with a key")
+	}
+}
+
+func TestCacheFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exits := 0
+	newCache := func(s *FileStore) *Cache {
+		cache, err := NewCache(&Config{
+			NumCounters:       100,
+			MaxCost:           20,
+			BufferItems:       64,
+			DeterministicMode: true,
+			Store:             s,
+			OnExit:            func(interface{}) { exits++ },
+		})
+		if err != nil {
+			panic(err)
+		}
+		return cache
+	}
+	s := openTestFileStore(t, dir, 10)
+	cache := newCache(s)
+	for key := 0; key < 4; key++ {
+		cache.Set(key, []byte("value"), 5)
+	}
+	cache.Close()
+	s.Close()
+	if exits != 0 {
+		t.Fatal("values kept in the file shouldn't exit on Close")
+	}
+
+	s = openTestFileStore(t, dir, 10)
+	defer s.Close()
+	cache = newCache(s)
+	defer cache.Close()
+	for key := 0; key < 4; key++ {
+		if val, ok := cache.Get(key); !ok || string(val.([]byte)) != "value" {
+			t.Fatalf("key %d wasn't kept across restarts\n", key)
+		}
+	}
+	if _, used := cache.policy.Costs(); used != 20 {
+		t.Fatalf("kept values should cost their length, got %d\n", used)
+	}
+}
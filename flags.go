@@ -0,0 +1,30 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// SetWithFlags works like Set, but stores flags along with the value. Flags
+// are returned by GetWithInfo and passed to Config.OnEvictWithFlags, so
+// applications can tag values, for example with where they came from, without
+// wrapping every value in a struct. Writes that don't take flags, like Set and
+// Replace, clear them.
+func (c *Cache) SetWithFlags(key, val interface{}, cost int64,
+	flags uint32) bool {
+	if c == nil {
+		return false
+	}
+	return c.set(c.keyToHash(key), val, cost, setOptions{flags: flags}, nil)
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+)
+
+func TestCacheSetWithFlags(t *testing.T) {
+	evicted := make(map[uint64]uint32)
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		OnEvictWithFlags: func(key uint64, _ interface{}, _ int64,
+			flags uint32) {
+			evicted[key] = flags
+		},
+
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.SetWithFlags(1, 1, 1, 5)
+	if _, info, ok := cache.GetWithInfo(1); !ok || info.Flags != 5 {
+		t.Fatalf("expected flags 5 but got %d\n", info.Flags)
+	}
+	// updates in place replace the flags
+	cache.SetWithFlags(1, 2, 1, 6)
+	if _, info, _ := cache.GetWithInfo(1); info.Flags != 6 {
+		t.Fatalf("expected flags 6 but got %d\n", info.Flags)
+	}
+	cache.Set(1, 3, 1)
+	if _, info, _ := cache.GetWithInfo(1); info.Flags != 0 {
+		t.Fatal("Set should clear the flags")
+	}
+	cache.SetWithFlags(1, 4, 1, 7)
+	// make key 2 hot enough to evict key 1
+	for i := 0; i < 10; i++ {
+		cache.Get(2)
+	}
+	cache.SetWithFlags(2, 1, 1, 8)
+	if _, ok := cache.Get(2); !ok {
+		t.Fatal("key 2 should have been admitted")
+	}
+	if flags, ok := evicted[1]; !ok || flags != 7 {
+		t.Fatalf("expected key 1 evicted with flags 7, got %v\n", evicted)
+	}
+}
+
+func TestCacheSetWithFlagsNil(t *testing.T) {
+	var cache *Cache
+	if cache.SetWithFlags(1, 1, 1, 1) {
+		t.Fatal("nil cache shouldn't accept Sets")
+	}
+}
@@ -5,5 +5,6 @@ go 1.12
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/dgryski/go-farm v0.0.0-20190423205320-6a90982ecee2
+	github.com/golang/snappy v0.0.4
 	github.com/stretchr/testify v1.3.0
 )
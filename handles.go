@@ -0,0 +1,131 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Handle is a reference to a cached value, returned by Acquire. Until it's
+// released, the value stays safe to use even if it's evicted, deleted or
+// overwritten in the meantime: Config.OnExit isn't called with it before
+// every handle to it is released. This makes it safe to cache pointers to
+// buffers that are reused once they leave the cache.
+type Handle struct {
+	cache    *Cache
+	ref      *valueRef
+	val      interface{}
+	released int32
+}
+
+// Value returns the value the handle refers to. It must not be used after
+// Release.
+func (h *Handle) Value() interface{} {
+	return h.val
+}
+
+// Release releases the handle, calling Config.OnExit if the value left the
+// cache and this was its last handle. Calls after the first do nothing.
+func (h *Handle) Release() {
+	if h == nil || !atomic.CompareAndSwapInt32(&h.released, 0, 1) {
+		return
+	}
+	for exits := h.cache.refs.release(h.ref); exits > 0; exits-- {
+		h.cache.callback("OnExit", func() { h.cache.onExit(h.ref.val) })
+	}
+}
+
+// Acquire is like Get, but returns a handle to the value, which must be
+// released once the caller is done with the value.
+func (c *Cache) Acquire(key interface{}) (*Handle, bool) {
+	if c == nil {
+		return nil, false
+	}
+	hash := c.keyToHash(key)
+	c.getBuf.Push(hash)
+	var h *Handle
+	_, ok := c.lookup(hash, nil, &h)
+	return h, ok
+}
+
+// valueID identifies a value by the words of its interface, so values that
+// aren't comparable, like slices, can be told apart too.
+type valueID struct {
+	typ, data unsafe.Pointer
+}
+
+func idOf(val interface{}) valueID {
+	return *(*valueID)(unsafe.Pointer(&val))
+}
+
+// valueRef counts the handles to a value, and the exits deferred until
+// they're released.
+type valueRef struct {
+	id      valueID
+	val     interface{}
+	handles int
+	exits   int
+}
+
+// refTracker keeps track of the values referenced by handles.
+type refTracker struct {
+	sync.Mutex
+	refs map[valueID]*valueRef
+}
+
+func newRefTracker() *refTracker {
+	return &refTracker{refs: make(map[valueID]*valueRef)}
+}
+
+// acquire counts a new handle to val. t must be locked.
+func (t *refTracker) acquire(val interface{}) *valueRef {
+	id := idOf(val)
+	ref := t.refs[id]
+	if ref == nil {
+		ref = &valueRef{id: id, val: val}
+		t.refs[id] = ref
+	}
+	ref.handles++
+	return ref
+}
+
+// release counts a released handle to ref, and returns the number of exits
+// that were deferred until it, if it was the last one.
+func (t *refTracker) release(ref *valueRef) int {
+	t.Lock()
+	defer t.Unlock()
+	if ref.handles--; ref.handles > 0 {
+		return 0
+	}
+	delete(t.refs, ref.id)
+	return ref.exits
+}
+
+// deferExit returns true if val is referenced by handles, in which case its
+// exit is deferred until they're released.
+func (t *refTracker) deferExit(val interface{}) bool {
+	t.Lock()
+	defer t.Unlock()
+	ref := t.refs[idOf(val)]
+	if ref == nil {
+		return false
+	}
+	ref.exits++
+	return true
+}
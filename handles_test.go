@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+)
+
+func TestCacheAcquire(t *testing.T) {
+	var exits []interface{}
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		DeterministicMode: true,
+		Metrics:           true,
+		OnExit: func(val interface{}) {
+			exits = append(exits, val)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	a, b := new(int), new(int)
+	cache.Set(1, a, 1)
+	h, ok := cache.Acquire(1)
+	if !ok || h.Value() != a {
+		t.Fatal("Acquire should return a handle to the cached value")
+	}
+	// the overwritten value is still in use
+	cache.Set(1, b, 1)
+	if len(exits) != 0 {
+		t.Fatal("values with handles shouldn't exit")
+	}
+	h.Release()
+	if len(exits) != 1 || exits[0] != a {
+		t.Fatalf("expected the released value to exit but got %v\n", exits)
+	}
+	h.Release()
+	if len(exits) != 1 {
+		t.Fatal("releasing a handle twice should do nothing")
+	}
+	// values exit once their last handle is released
+	h1, _ := cache.Acquire(1)
+	h2, _ := cache.Acquire(1)
+	cache.Del(1)
+	h1.Release()
+	if len(exits) != 1 {
+		t.Fatal("values with handles shouldn't exit")
+	}
+	h2.Release()
+	if len(exits) != 2 || exits[1] != b {
+		t.Fatalf("expected the deleted value to exit but got %v\n", exits)
+	}
+	// releasing values that are still cached doesn't make them exit, and
+	// values that aren't comparable are told apart too
+	cache.Set(2, []byte("c"), 1)
+	h, _ = cache.Acquire(2)
+	h.Release()
+	if len(exits) != 2 || len(cache.refs.refs) != 0 {
+		t.Fatal("released handles should be forgotten")
+	}
+	if _, ok := cache.Acquire(3); ok {
+		t.Fatal("Acquire shouldn't find missing keys")
+	}
+	if m := cache.Metrics(); m.Get(hit) != 4 || m.Get(miss) != 1 {
+		t.Fatalf("expected 4 hits and 1 miss but got %d and %d\n",
+			m.Get(hit), m.Get(miss))
+	}
+	var nilCache *Cache
+	if _, ok := nilCache.Acquire(1); ok {
+		t.Fatal("nil caches have no keys")
+	}
+}
+
+func TestHandleReleasePanic(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		DeterministicMode: true,
+		Metrics:           true,
+		OnExit:            func(interface{}) { panic("exit") },
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	h, _ := cache.Acquire(1)
+	cache.Del(1)
+	h.Release()
+	if cache.Metrics().Get(callbackPanics) != 1 {
+		t.Fatal("panics in OnExit should be recovered on Release too")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// Hasher hashes keys, with a fast path for each common key type so keys don't
+// need to be converted to a single type first. Unlike the default hashing,
+// which is seeded differently in every process, the Hashers shipped with
+// Ristretto hash keys the same way everywhere, for example for Invalidation.
+//
+// Like Config.KeyToHash, its methods must not keep the key, or the string or
+// slice behind it, once they return: Get and Set pass keys without copying
+// them to the heap, so they may be reused or gone by then.
+type Hasher interface {
+	HashString(key string) uint64
+	HashBytes(key []byte) uint64
+	HashUint64(key uint64) uint64
+}
+
+// XXH3Hasher is a Hasher using the 64-bit XXH3 hash.
+var XXH3Hasher Hasher = xxh3Hasher{}
+
+// WyHasher is a Hasher using wyhash.
+var WyHasher Hasher = wyHasher{}
+
+type xxh3Hasher struct{}
+
+func (xxh3Hasher) HashString(key string) uint64 { return z.XXH3String(key) }
+func (xxh3Hasher) HashBytes(key []byte) uint64  { return z.XXH3(key) }
+func (xxh3Hasher) HashUint64(key uint64) uint64 { return z.XXH3Uint64(key) }
+
+type wyHasher struct{}
+
+func (wyHasher) HashString(key string) uint64 { return z.WyHashString(key) }
+func (wyHasher) HashBytes(key []byte) uint64  { return z.WyHash(key) }
+func (wyHasher) HashUint64(key uint64) uint64 { return z.WyHashUint64(key) }
+
+// hashKeys returns a KeyToHash function for h. It accepts the same key types
+// as z.KeyToHash, hashing all integers with HashUint64.
+func hashKeys(h Hasher) func(interface{}) uint64 {
+	return func(key interface{}) uint64 {
+		switch k := key.(type) {
+		case uint64:
+			return h.HashUint64(k)
+		case string:
+			return h.HashString(k)
+		case []byte:
+			return h.HashBytes(k)
+		case byte:
+			return h.HashUint64(uint64(k))
+		case int:
+			return h.HashUint64(uint64(k))
+		case int32:
+			return h.HashUint64(uint64(k))
+		case uint32:
+			return h.HashUint64(uint64(k))
+		case int64:
+			return h.HashUint64(uint64(k))
+		default:
+			panic("Key type not supported")
+		}
+	}
+}
+
+// randomSeed returns a random seed for Config.HashSeed, which is never 0.
+func randomSeed() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return uint64(time.Now().UnixNano()) | 1
+	}
+	return binary.LittleEndian.Uint64(b[:]) | 1
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHashers(t *testing.T) {
+	for _, h := range []Hasher{XXH3Hasher, WyHasher} {
+		if h.HashString("key") != h.HashBytes([]byte("key")) {
+			t.Fatal("strings and bytes should hash the same")
+		}
+		if h.HashUint64(1) == h.HashUint64(2) {
+			t.Fatal("uint64 keys should be hashed")
+		}
+		hash := hashKeys(h)
+		if hash(1) != h.HashUint64(1) || hash(int32(-1)) != h.HashUint64(1<<64-1) {
+			t.Fatal("integer keys should use HashUint64")
+		}
+		if hash("key") != h.HashString("key") {
+			t.Fatal("string keys should use HashString")
+		}
+	}
+}
+
+func TestCacheHasher(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+		Hasher:      WyHasher,
+
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set("key", 1, 1)
+	if val, ok := cache.Get("key"); !ok || val.(int) != 1 {
+		t.Fatal("set/get error")
+	}
+	if cache.keyToHash("key") != WyHasher.HashString("key") {
+		t.Fatal("keys should be hashed with the Hasher")
+	}
+}
+
+func BenchmarkHashers(b *testing.B) {
+	key := "ristretto-key-42"
+	for _, h := range []struct {
+		name string
+		hash func(interface{}) uint64
+	}{
+		{"memhash", nil},
+		{"xxh3", hashKeys(XXH3Hasher)},
+		{"wyhash", hashKeys(WyHasher)},
+	} {
+		cache, _ := NewCache(&Config{
+			NumCounters: 100,
+			MaxCost:     10,
+			BufferItems: 64,
+			KeyToHash:   h.hash,
+		})
+		cache.Set(key, nil, 1)
+		b.Run(fmt.Sprintf("Get/%s", h.name), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				cache.Get(key)
+			}
+		})
+	}
+}
+
+func TestCacheHashSeed(t *testing.T) {
+	seedOf := func(config *Config) uint64 {
+		config.NumCounters, config.MaxCost, config.BufferItems = 100, 10, 64
+		cache, err := NewCache(config)
+		if err != nil {
+			panic(err)
+		}
+		defer cache.Close()
+		return cache.policy.(*defaultPolicy).admit.seed
+	}
+	if seedOf(&Config{HashSeed: 42}) != 42 {
+		t.Fatal("explicit seeds should be used as they are")
+	}
+	if a, b := seedOf(&Config{}), seedOf(&Config{}); a == 0 || a == b {
+		t.Fatalf("expected random seeds per cache but got %d and %d\n", a, b)
+	}
+	if seedOf(&Config{DeterministicMode: true}) != 0 {
+		t.Fatal("DeterministicMode shouldn't seed keys")
+	}
+}
+
+func TestCacheHashSeedStoreHashes(t *testing.T) {
+	// the seed only applies to the admission counters: keys are stored
+	// under the same hash whatever the seed
+	for _, seed := range []uint64{1, 2} {
+		cache, err := NewCache(&Config{
+			NumCounters:       100,
+			MaxCost:           10,
+			BufferItems:       64,
+			DeterministicMode: true,
+			HashSeed:          seed,
+		})
+		if err != nil {
+			panic(err)
+		}
+		cache.Set(42, 42, 1)
+		if _, ok := cache.store.Get(42); !ok {
+			t.Fatalf("seed %d changed the hash the key is stored under\n", seed)
+		}
+		cache.Close()
+	}
+}
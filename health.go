@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// stuckAfter is how long a goroutine of the cache can take to apply a single
+// Set, or to get back to its periodic maintenance, before Health reports it
+// as stuck.
+const stuckAfter = 10 * expirationInterval
+
+// HealthReport describes whether the goroutines of a cache are keeping up,
+// see Cache.Health.
+type HealthReport struct {
+	// Healthy is false if any goroutine of the cache is stuck, which leaves
+	// Sets stuck in the Set buffer, and eventually every Set dropped.
+	Healthy bool `json:"healthy"`
+	// Workers is the number of goroutines applying Sets, and StuckWorkers
+	// the number of them that spent more than 10 seconds on a single Set,
+	// typically in a callback that blocks.
+	Workers      int `json:"workers"`
+	StuckWorkers int `json:"stuckWorkers"`
+	// MaintenanceStuck is whether the goroutine removing expired keys,
+	// which is due every second, didn't get to it for 10 seconds.
+	MaintenanceStuck bool `json:"maintenanceStuck"`
+	// LastDrain is when a Set was last taken out of the Set buffer, and
+	// LastMaintenance when expired keys were last removed.
+	LastDrain       time.Time `json:"lastDrain"`
+	LastMaintenance time.Time `json:"lastMaintenance"`
+	// SetBufferUsed is the share of the Set buffer holding Sets waiting to
+	// be applied.
+	SetBufferUsed float64 `json:"setBufferUsed"`
+	// SetsDropped and GetsDropped are the number of Sets and Get accesses
+	// dropped over the last Interval of maintenance. Gets are only counted
+	// if Config.Metrics is set.
+	SetsDropped uint64        `json:"setsDropped"`
+	GetsDropped uint64        `json:"getsDropped"`
+	Interval    time.Duration `json:"interval"`
+}
+
+// healthState is what the goroutines of a cache record for Health. Times are
+// stamps, see stamp.
+type healthState struct {
+	// lastDrain and maintained are when the last Set was taken out of
+	// setBuf and when the last maintenance was done
+	lastDrain, maintained int64
+	// setsDropped and getsDropped are the drops over the last maintenance
+	// interval, and getsDroppedTotal the dropGets metric at its end
+	setsDropped, getsDropped uint64
+	getsDroppedTotal         uint64
+	// handling is, for every worker, when it started applying its current
+	// Set, or 0 if it's idle
+	handling []int64
+	// start is when the cache was created
+	start time.Time
+}
+
+func newHealthState(workers int, now time.Time) *healthState {
+	h := &healthState{handling: make([]int64, workers), start: now}
+	h.maintained = h.stamp(now)
+	return h
+}
+
+// stamp returns t as an atomically stored stamp, which is never 0, so 0 can
+// mean no time at all.
+func (h *healthState) stamp(t time.Time) int64 {
+	return int64(t.Sub(h.start)) + 1
+}
+
+// time returns the time of a stamp.
+func (h *healthState) time(stamp int64) time.Time {
+	return h.start.Add(time.Duration(stamp - 1))
+}
+
+// Health reports whether the goroutines of the cache are alive and keeping
+// up, and how full its Set buffer is, without taking any lock, so it can
+// back readiness probes even if the cache is wedged. Caches in
+// DeterministicMode have no goroutines, and are always healthy.
+func (c *Cache) Health() HealthReport {
+	if c == nil {
+		return HealthReport{}
+	}
+	if c.deterministic {
+		return HealthReport{Healthy: true}
+	}
+	h := c.health
+	now := c.clock.Now()
+	r := HealthReport{
+		Workers:       len(h.handling),
+		SetBufferUsed: float64(c.setBuf.Len()) / float64(c.setBuf.Cap()),
+		SetsDropped:   atomic.LoadUint64(&h.setsDropped),
+		GetsDropped:   atomic.LoadUint64(&h.getsDropped),
+		Interval:      expirationInterval,
+	}
+	for i := range h.handling {
+		at := atomic.LoadInt64(&h.handling[i])
+		if at != 0 && now.Sub(h.time(at)) > stuckAfter {
+			r.StuckWorkers++
+		}
+	}
+	if at := atomic.LoadInt64(&h.lastDrain); at != 0 {
+		r.LastDrain = h.time(at)
+	}
+	r.LastMaintenance = h.time(atomic.LoadInt64(&h.maintained))
+	r.MaintenanceStuck = now.Sub(r.LastMaintenance) > stuckAfter
+	r.Healthy = r.StuckWorkers == 0 && !r.MaintenanceStuck
+	return r
+}
+
+// applying records that worker started applying a Set at now.
+func (h *healthState) applying(worker int, now time.Time) {
+	at := h.stamp(now)
+	atomic.StoreInt64(&h.handling[worker], at)
+	atomic.StoreInt64(&h.lastDrain, at)
+}
+
+// applied records that worker is done applying its Set.
+func (h *healthState) applied(worker int) {
+	atomic.StoreInt64(&h.handling[worker], 0)
+}
+
+// maintained records the end of a maintenance interval, along with the drops
+// over it. Only the maintaining goroutine calls it.
+func (c *Cache) maintained(setsDropped uint64) {
+	h := c.health
+	atomic.StoreUint64(&h.setsDropped, setsDropped)
+	gets := c.stats.Get(dropGets)
+	if gets < h.getsDroppedTotal {
+		// the metrics were reset
+		h.getsDroppedTotal = 0
+	}
+	atomic.StoreUint64(&h.getsDropped, gets-h.getsDroppedTotal)
+	h.getsDroppedTotal = gets
+	atomic.StoreInt64(&h.maintained, h.stamp(c.clock.Now()))
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheHealth(t *testing.T) {
+	deterministic, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if !deterministic.Health().Healthy {
+		t.Fatal("caches in DeterministicMode should always be healthy")
+	}
+
+	clock := NewManualClock(time.Unix(0, 0))
+	evicting, unblock := make(chan struct{}), make(chan struct{})
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		OnEvict: func(key uint64, value interface{}, cost int64) {
+			close(evicting)
+			<-unblock
+		},
+		Clock: clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if h := cache.Health(); !h.Healthy || h.Workers != 1 ||
+		!h.LastMaintenance.Equal(clock.Now()) {
+		t.Fatalf("expected a healthy cache but got %+v\n", h)
+	}
+	cache.Set(1, 1, 1)
+	cache.Get(2)
+	cache.Get(2)
+	cache.Set(2, 2, 1)
+	<-evicting
+	if h := cache.Health(); !h.Healthy || !h.LastDrain.Equal(clock.Now()) {
+		t.Fatalf("a busy worker isn't stuck yet, got %+v\n", h)
+	}
+	// the worker is blocked by OnEvict, and maintenance by the worker
+	clock.Advance(stuckAfter + time.Second)
+	time.Sleep(10 * time.Millisecond)
+	h := cache.Health()
+	if h.Healthy || h.StuckWorkers != 1 || !h.MaintenanceStuck {
+		t.Fatalf("expected a stuck cache but got %+v\n", h)
+	}
+	close(unblock)
+	// maintenance may have been waiting for a later tick than the one
+	// passed, if it started after the clock was advanced
+	for start := time.Now(); time.Since(start) < time.Second; {
+		if h = cache.Health(); h.Healthy {
+			break
+		}
+		clock.Advance(expirationInterval)
+		time.Sleep(time.Millisecond)
+	}
+	if !h.Healthy || h.StuckWorkers != 0 {
+		t.Fatalf("expected the cache to recover but got %+v\n", h)
+	}
+}
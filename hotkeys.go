@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// spaceSaving tracks the most frequently accessed keys with the Space-Saving
+// algorithm [1]. It keeps a fixed number of counters, and a key that isn't
+// tracked takes over the counter of the least accessed key, inheriting its
+// count. Counts are therefore overestimated by at most the smallest count,
+// but every key accessed more often than that is guaranteed to be tracked.
+//
+// spaceSaving is NOT thread safe.
+//
+// [1]: https://doi.org/10.1007/978-3-540-30570-5_27
+type spaceSaving struct {
+	// counters is a min-heap on count
+	counters []*hotCounter
+	index    map[uint64]*hotCounter
+}
+
+type hotCounter struct {
+	key   uint64
+	count uint64
+	// pos is the counter's position in the heap
+	pos int
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		counters: make([]*hotCounter, 0, capacity),
+		index:    make(map[uint64]*hotCounter, capacity),
+	}
+}
+
+func (s *spaceSaving) Len() int           { return len(s.counters) }
+func (s *spaceSaving) Less(i, j int) bool { return s.counters[i].count < s.counters[j].count }
+func (s *spaceSaving) Swap(i, j int) {
+	s.counters[i], s.counters[j] = s.counters[j], s.counters[i]
+	s.counters[i].pos, s.counters[j].pos = i, j
+}
+func (s *spaceSaving) Push(x interface{}) {
+	c := x.(*hotCounter)
+	c.pos = len(s.counters)
+	s.counters = append(s.counters, c)
+}
+func (s *spaceSaving) Pop() interface{} {
+	c := s.counters[len(s.counters)-1]
+	s.counters = s.counters[:len(s.counters)-1]
+	return c
+}
+
+// increment counts an access to the key.
+func (s *spaceSaving) increment(key uint64) {
+	if c, ok := s.index[key]; ok {
+		c.count++
+		heap.Fix(s, c.pos)
+		return
+	}
+	if len(s.counters) < cap(s.counters) {
+		c := &hotCounter{key: key, count: 1}
+		s.index[key] = c
+		heap.Push(s, c)
+		return
+	}
+	// replace the least accessed key
+	c := s.counters[0]
+	delete(s.index, c.key)
+	c.key = key
+	c.count++
+	s.index[key] = c
+	heap.Fix(s, 0)
+}
+
+// halve halves every count, so keys that cooled down can be replaced. The
+// order of the counters doesn't change.
+func (s *spaceSaving) halve() {
+	for _, c := range s.counters {
+		c.count >>= 1
+	}
+}
+
+// top returns up to n tracked keys, most accessed first.
+func (s *spaceSaving) top(n int) []KeyCount {
+	keys := make([]KeyCount, len(s.counters))
+	for i, c := range s.counters {
+		keys[i] = KeyCount{Key: c.key, Count: c.count}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
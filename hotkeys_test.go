@@ -0,0 +1,79 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/ristretto/sim"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(4)
+	// key i is accessed i times, so the hottest keys are 9, 8, 7 ...
+	for round := 0; round < 10; round++ {
+		for key := uint64(round); key < 10; key++ {
+			s.increment(key)
+		}
+	}
+	// new keys take over the least accessed counters, not the hottest ones
+	for i := 0; i < 3; i++ {
+		s.increment(100 + uint64(i))
+	}
+	top := s.top(2)
+	if len(top) != 2 || top[0].Key != 9 || top[1].Key != 8 {
+		t.Fatalf("expected keys 9 and 8 but got %v\n", top)
+	}
+	if top[0].Count < 10 {
+		t.Fatal("counts should never be underestimated")
+	}
+	s.halve()
+	if s.top(1)[0].Count != top[0].Count/2 {
+		t.Fatal("halve error")
+	}
+	if len(s.top(10)) != 4 {
+		t.Fatal("expected every counter")
+	}
+}
+
+func TestCacheHotKeys(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		HotKeys:           16,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	keys := sim.NewZipfian(1.5, 1, 1000)
+	for i := 0; i < 10000; i++ {
+		key, err := keys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.Get(key)
+	}
+	hot := cache.HotKeys(3)
+	if len(hot) != 3 || hot[0].Key != cache.keyToHash(uint64(0)) {
+		t.Fatalf("expected the most frequent Zipfian key first but got %v\n", hot)
+	}
+	if newCache(false).HotKeys(3) != nil {
+		t.Fatal("hot keys shouldn't be tracked by default")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package httpdebug serves the state of ristretto caches over HTTP, for
+// operators looking into cache health. Like net/http/pprof, importing it
+// registers a handler on http.DefaultServeMux, under /debug/ristretto, which
+// reports on every cache created by ristretto.NewNamedCache:
+//
+//	import _ "github.com/dgraph-io/ristretto/httpdebug"
+//
+// Pages are rendered as HTML, or as JSON if the format query parameter is
+// "json" or the request accepts application/json. The n query parameter sets
+// the number of hot keys listed for each cache, 10 by default.
+package httpdebug
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// defaultHotKeys is the number of hot keys listed when n isn't set.
+const defaultHotKeys = 10
+
+func init() {
+	http.Handle("/debug/ristretto", Handler())
+}
+
+// Handler returns a handler reporting on caches. If no caches are given, it
+// reports on ristretto.NamedCaches at the time of every request.
+func Handler(caches ...*ristretto.Cache) http.Handler {
+	return &handler{caches: caches}
+}
+
+type handler struct {
+	caches []*ristretto.Cache
+}
+
+// report is what's served for a single cache.
+type report struct {
+	Name string `json:"name"`
+	// State is the document written by Cache.Dump
+	State           json.RawMessage      `json:"state"`
+	HotKeys         []ristretto.KeyCount `json:"hotKeys"`
+	RecentEvictions []ristretto.Eviction `json:"recentEvictions"`
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n := defaultHotKeys
+	if s := r.FormValue("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n < 0 {
+			http.Error(w, "n must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+	caches := h.caches
+	if len(caches) == 0 {
+		caches = ristretto.NamedCaches()
+	}
+	reports := make([]report, 0, len(caches))
+	for _, cache := range caches {
+		var state bytes.Buffer
+		if err := cache.Dump(&state); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		reports = append(reports, report{
+			Name:            cache.Name(),
+			State:           state.Bytes(),
+			HotKeys:         hotKeys(cache, n),
+			RecentEvictions: cache.RecentEvictions(),
+		})
+	}
+	if r.FormValue("format") == "json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(reports)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.Execute(w, reports)
+}
+
+// hotKeys returns the cache's hot keys if they're tracked, or the cached keys
+// with the highest estimated frequency otherwise.
+func hotKeys(cache *ristretto.Cache, n int) []ristretto.KeyCount {
+	if keys := cache.HotKeys(n); keys != nil {
+		return keys
+	}
+	return cache.TopKeys(n)
+}
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><title>ristretto</title></head>
+<body>
+{{range .}}
+<h2>{{if .Name}}{{.Name}}{{else}}(unnamed){{end}}</h2>
+<pre>{{printf "%s" .State}}</pre>
+<h3>Hot keys</h3>
+<table>
+<tr><th>Key</th><th>Frequency</th></tr>
+{{range .HotKeys}}<tr><td>{{.Key}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<h3>Recent evictions</h3>
+<table>
+<tr><th>Key</th><th>Cost</th><th>Time</th></tr>
+{{range .RecentEvictions}}<tr><td>{{.Key}}</td><td>{{.Cost}}</td><td>{{.Time}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No named caches.</p>
+{{end}}
+</body>
+</html>
+`))
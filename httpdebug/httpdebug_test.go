@@ -0,0 +1,86 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpdebug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+func newCache(t *testing.T) *ristretto.Cache {
+	cache, err := ristretto.NewNamedCache("test", &ristretto.Config{
+		NumCounters:       100,
+		MaxCost:           2,
+		BufferItems:       64,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		cache.Set(i, i, 1)
+	}
+	return cache
+}
+
+func get(url string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	http.DefaultServeMux.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	return w
+}
+
+func TestHandlerJSON(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+	w := get("/debug/ristretto?format=json&n=1")
+	var reports []report
+	if err := json.Unmarshal(w.Body.Bytes(), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Name != "test" {
+		t.Fatal("expected the named cache")
+	}
+	if len(reports[0].HotKeys) != 1 {
+		t.Fatal("n wasn't applied")
+	}
+	if len(reports[0].RecentEvictions) != 1 {
+		t.Fatal("expected an eviction")
+	}
+	if !strings.Contains(string(reports[0].State), `"maxCost": 2`) {
+		t.Fatal("expected the cache's config")
+	}
+}
+
+func TestHandlerHTML(t *testing.T) {
+	cache := newCache(t)
+	defer cache.Close()
+	w := get("/debug/ristretto")
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("expected HTML but got %s\n", ct)
+	}
+	if !strings.Contains(w.Body.String(), "<h2>test</h2>") {
+		t.Fatal("expected the named cache")
+	}
+	if w := get("/debug/ristretto?n=-1"); w.Code != http.StatusBadRequest {
+		t.Fatal("negative n should be rejected")
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package httppeer fetches values from the caches of other processes over
+// HTTP, for distributed read-through caches built with
+// ristretto.Cache.GetOrLoad. Every process serves its cache with Handler, and
+// passes a Pool of the URLs of every process (itself included) as
+// Config.Peers:
+//
+//	pool := httppeer.NewPool("http://10.0.0.1:8080/_ristretto",
+//		"http://10.0.0.1:8080/_ristretto", "http://10.0.0.2:8080/_ristretto")
+//	cache, err := ristretto.NewCache(&ristretto.Config{..., Peers: pool})
+//	http.Handle("/_ristretto", httppeer.Handler(cache, load))
+//
+// Keys are split between the processes by consistent hashing, so changing
+// the set of processes only moves a fraction of the keys.
+package httppeer
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	farm "github.com/dgryski/go-farm"
+)
+
+// replicas is the number of points every peer has on the hash ring. More
+// points split keys more evenly.
+const replicas = 64
+
+// DefaultTimeout bounds requests to peers when Pool.Client is nil.
+const DefaultTimeout = 10 * time.Second
+
+// defaultClient sends requests to peers when Pool.Client is nil.
+var defaultClient = &http.Client{Timeout: DefaultTimeout}
+
+// Handler serves the values of cache to the other processes, loading the
+// keys it owns from the origin with load. The key is passed in the key query
+// parameter. Missing keys are always loaded from the origin, never forwarded
+// to another peer, so processes that briefly disagree on who owns a key
+// don't forward it back and forth.
+func Handler(cache *ristretto.Cache,
+	load func(ctx context.Context, key string) ([]byte, error)) http.Handler {
+	return &handler{cache: cache, load: load}
+}
+
+type handler struct {
+	cache *ristretto.Cache
+	load  func(ctx context.Context, key string) ([]byte, error)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	val, err := h.cache.GetOrCompute(key, func() (interface{}, int64, error) {
+		data, err := h.load(r.Context(), key)
+		return data, int64(len(data)), err
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(val.([]byte))
+}
+
+// Pool picks peers by consistent hashing over their URLs. It implements
+// ristretto.PeerPicker.
+type Pool struct {
+	// Client sends requests to peers. If it's nil, a client timing out
+	// after DefaultTimeout is used.
+	Client *http.Client
+
+	self string
+	mu   sync.RWMutex
+	// ring is sorted by hash
+	ring []point
+}
+
+type point struct {
+	hash uint64
+	peer *peer
+}
+
+// NewPool returns a pool of peers, where self is the URL of this process'
+// Handler as the other peers know it.
+func NewPool(self string, peers ...string) *Pool {
+	p := &Pool{self: self}
+	p.Set(peers...)
+	return p
+}
+
+// Set replaces the URLs of the peers, for example when processes join or
+// leave.
+func (p *Pool) Set(peers ...string) {
+	ring := make([]point, 0, len(peers)*replicas)
+	for _, u := range peers {
+		pr := &peer{pool: p, url: u}
+		for i := 0; i < replicas; i++ {
+			ring = append(ring, point{
+				hash: farm.Fingerprint64([]byte(strconv.Itoa(i) + u)),
+				peer: pr,
+			})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	p.mu.Lock()
+	p.ring = ring
+	p.mu.Unlock()
+}
+
+// PickPeer returns the peer owning the key, or false if it's owned by this
+// process or there are no peers.
+func (p *Pool) PickPeer(key string) (ristretto.PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.ring) == 0 {
+		return nil, false
+	}
+	hash := farm.Fingerprint64([]byte(key))
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= hash })
+	if i == len(p.ring) {
+		i = 0
+	}
+	owner := p.ring[i].peer
+	if owner.url == p.self {
+		return nil, false
+	}
+	return owner, true
+}
+
+// peer gets values from the Handler at url.
+type peer struct {
+	pool *Pool
+	url  string
+}
+
+func (p *peer) Get(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequest("GET", p.url+"?key="+url.QueryEscape(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := p.pool.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %s returned %s", p.url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
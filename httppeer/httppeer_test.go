@@ -0,0 +1,168 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httppeer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/ristretto"
+)
+
+// origin counts the loads of every key.
+type origin struct {
+	sync.Mutex
+	loads map[string]int
+}
+
+func (o *origin) load(ctx context.Context, key string) ([]byte, error) {
+	o.Lock()
+	o.loads[key]++
+	o.Unlock()
+	return []byte("value " + key), nil
+}
+
+type node struct {
+	cache  *ristretto.Cache
+	pool   *Pool
+	server *httptest.Server
+}
+
+func newNodes(t *testing.T, o *origin, n int) []*node {
+	nodes := make([]*node, n)
+	urls := make([]string, n)
+	mux := make([]*http.ServeMux, n)
+	for i := range nodes {
+		mux[i] = http.NewServeMux()
+		nodes[i] = &node{server: httptest.NewServer(mux[i])}
+		urls[i] = nodes[i].server.URL + "/_ristretto"
+	}
+	for i, nd := range nodes {
+		nd.pool = NewPool(urls[i], urls...)
+		cache, err := ristretto.NewCache(&ristretto.Config{
+			NumCounters:       1000,
+			MaxCost:           1 << 20,
+			BufferItems:       64,
+			DeterministicMode: true,
+			Peers:             nd.pool,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nd.cache = cache
+		mux[i].Handle("/_ristretto", Handler(cache, o.load))
+	}
+	return nodes
+}
+
+func TestGetOrLoad(t *testing.T) {
+	o := &origin{loads: make(map[string]int)}
+	nodes := newNodes(t, o, 3)
+	for _, nd := range nodes {
+		defer nd.server.Close()
+	}
+	for _, nd := range nodes {
+		for i := 0; i < 100; i++ {
+			key := strconv.Itoa(i)
+			data, err := nd.cache.GetOrLoad(context.Background(), key, o.load)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "value "+key {
+				t.Fatalf("unexpected value %q for key %s\n", data, key)
+			}
+		}
+	}
+	// every key is loaded by its owner only
+	for key, loads := range o.loads {
+		if loads != 1 {
+			t.Fatalf("key %s was loaded %d times\n", key, loads)
+		}
+	}
+}
+
+func TestGetOrLoadPeerDown(t *testing.T) {
+	o := &origin{loads: make(map[string]int)}
+	nodes := newNodes(t, o, 2)
+	defer nodes[0].server.Close()
+	nodes[1].server.Close()
+	for i := 0; i < 100; i++ {
+		if _, err := nodes[0].cache.GetOrLoad(context.Background(),
+			strconv.Itoa(i), o.load); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(o.loads) != 100 {
+		t.Fatalf("keys owned by a failed peer should be loaded locally, got %d\n",
+			len(o.loads))
+	}
+}
+
+func TestHandlerDoesNotForward(t *testing.T) {
+	o := &origin{loads: make(map[string]int)}
+	nodes := newNodes(t, o, 2)
+	for _, nd := range nodes {
+		defer nd.server.Close()
+	}
+	// both nodes think the other one owns every key
+	a, b := nodes[0].server.URL+"/_ristretto", nodes[1].server.URL+"/_ristretto"
+	nodes[0].pool.Set(b)
+	nodes[1].pool.Set(a)
+	data, err := nodes[0].cache.GetOrLoad(context.Background(), "key", o.load)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "value key" || o.loads["key"] != 1 {
+		t.Fatalf("the peer should have loaded the key once, got %q and %d "+
+			"loads\n", data, o.loads["key"])
+	}
+}
+
+func TestPoolPickPeer(t *testing.T) {
+	pool := NewPool("a", "a", "b", "c")
+	owners := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		owner := "a"
+		if p, ok := pool.PickPeer(strconv.Itoa(i)); ok {
+			owner = p.(*peer).url
+		}
+		owners[owner]++
+	}
+	for _, u := range []string{"a", "b", "c"} {
+		if owners[u] < 500 {
+			t.Fatalf("keys are split unevenly: %v\n", owners)
+		}
+	}
+	// removing a peer only moves its own keys
+	before := NewPool("a", "a", "b", "c")
+	pool.Set("a", "b")
+	for i := 0; i < 3000; i++ {
+		key := strconv.Itoa(i)
+		prev, moved := before.PickPeer(key)
+		if moved && prev.(*peer).url == "c" {
+			continue
+		}
+		p, ok := pool.PickPeer(key)
+		if ok != moved || (ok && p.(*peer).url != prev.(*peer).url) {
+			t.Fatalf("key %s moved between remaining peers\n", key)
+		}
+	}
+}
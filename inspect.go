@@ -0,0 +1,150 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyCount is a hashed key along with how often it was accessed.
+type KeyCount struct {
+	Key   uint64 `json:"key"`
+	Count uint64 `json:"count"`
+}
+
+// TopKeys returns up to n cached keys with the highest estimated access
+// frequency, hottest first. Every call walks the whole cache, so it's meant
+// for debugging.
+func (c *Cache) TopKeys(n int) []KeyCount {
+	if c == nil || n <= 0 {
+		return nil
+	}
+	costs, _ := c.policy.Costs()
+	keys := make([]KeyCount, 0, len(costs))
+	for key := range costs {
+		keys = append(keys, KeyCount{Key: key, Count: uint64(c.policy.Estimate(key))})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+// HotKeys returns up to n of the most accessed keys, hottest first, along
+// with their approximate access counts. Counts are halved periodically, like
+// the counters used for admission. It returns nil unless Config.HotKeys is
+// set.
+func (c *Cache) HotKeys(n int) []KeyCount {
+	if c == nil || n <= 0 {
+		return nil
+	}
+	return c.policy.HotKeys(n)
+}
+
+// SketchStats describes how accurately the access counters used for
+// admission count accesses, see Cache.SketchStats.
+type SketchStats struct {
+	// Counters is the number of counters in each row of the count-min
+	// sketch, which is NumCounters rounded up to a power of two.
+	Counters int64 `json:"counters"`
+	// Used and Maxed are the shares of counters that are non-zero, and that
+	// reached their maximum of 15.
+	Used  float64 `json:"used"`
+	Maxed float64 `json:"maxed"`
+	// OverflowRate is the share of counter increments since the counters
+	// were last halved that were lost, as the counter was maxed out.
+	OverflowRate float64 `json:"overflowRate"`
+	// Overestimate is by how many accesses a key that was never accessed is
+	// estimated to have been on average, as it shares its counters with
+	// other keys, and Collisions the share of such keys estimated to have
+	// been accessed at all. Every key's estimate is inflated alike, so
+	// admission can't tell keys accessed less often than that apart.
+	Overestimate float64 `json:"overestimate"`
+	Collisions   float64 `json:"collisions"`
+}
+
+// SketchStats reports how accurately the access counters count, so users can
+// tell whether NumCounters is too small for their keyspace: many counters
+// maxed out or overflowing, or an Overestimate around the accesses of the
+// keys worth admitting, mean the sketch can't tell those keys apart. It
+// walks every counter, so it's meant for debugging. Policies without access
+// counters report zeros.
+func (c *Cache) SketchStats() SketchStats {
+	if c == nil {
+		return SketchStats{}
+	}
+	return c.policy.SketchStats()
+}
+
+// recentEvictions is the number of evictions remembered by a cache.
+const recentEvictions = 64
+
+// Eviction describes a key that was evicted to make room for other keys.
+type Eviction struct {
+	Key  uint64    `json:"key"`
+	Cost int64     `json:"cost"`
+	Time time.Time `json:"time"`
+	// Added and Updated are when the key was added and last updated. They're
+	// only known if Config.Metrics or Config.OnEvictWithTimes is set.
+	Added   time.Time `json:"added"`
+	Updated time.Time `json:"updated"`
+}
+
+// evictionLog is a ring of the most recent evictions.
+type evictionLog struct {
+	sync.Mutex
+	entries [recentEvictions]Eviction
+	next    int
+	full    bool
+}
+
+func (l *evictionLog) add(e Eviction) {
+	l.Lock()
+	defer l.Unlock()
+	l.entries[l.next] = e
+	l.next = (l.next + 1) % recentEvictions
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// RecentEvictions returns the most recent evictions, newest first.
+func (c *Cache) RecentEvictions() []Eviction {
+	if c == nil {
+		return nil
+	}
+	l := &c.evictions
+	l.Lock()
+	defer l.Unlock()
+	n := l.next
+	if l.full {
+		n = recentEvictions
+	}
+	evictions := make([]Eviction, n)
+	for i := range evictions {
+		evictions[i] = l.entries[(l.next-1-i+recentEvictions)%recentEvictions]
+	}
+	return evictions
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func newInspectCache(clock Clock) *Cache {
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           10,
+		BufferItems:       64,
+		Clock:             clock,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestCacheTopKeys(t *testing.T) {
+	cache := newInspectCache(nil)
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i, 1)
+		for j := 0; j < i; j++ {
+			cache.Get(i)
+		}
+	}
+	top := cache.TopKeys(2)
+	if len(top) != 2 || top[0].Key != 4 || top[1].Key != 3 {
+		t.Fatalf("expected keys 4 and 3 but got %v\n", top)
+	}
+	if top[0].Count < top[1].Count {
+		t.Fatal("keys should be sorted by frequency")
+	}
+	if len(cache.TopKeys(100)) != 5 {
+		t.Fatal("expected every cached key")
+	}
+}
+
+func TestCacheRecentEvictions(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache := newInspectCache(clock)
+	if len(cache.RecentEvictions()) != 0 {
+		t.Fatal("expected no evictions")
+	}
+	for i := 0; i < 10+recentEvictions+1; i++ {
+		clock.Advance(time.Second)
+		cache.Set(i, i, 1)
+	}
+	evictions := cache.RecentEvictions()
+	if len(evictions) != recentEvictions {
+		t.Fatalf("expected %d evictions but got %d\n",
+			recentEvictions, len(evictions))
+	}
+	for i := 1; i < len(evictions); i++ {
+		if evictions[i].Time.After(evictions[i-1].Time) {
+			t.Fatal("evictions should be sorted newest first")
+		}
+	}
+	if !evictions[0].Time.Equal(clock.Now()) || evictions[0].Cost != 1 {
+		t.Fatalf("unexpected eviction %v\n", evictions[0])
+	}
+}
+
+func TestCacheSketchStats(t *testing.T) {
+	var stats []SketchStats
+	for _, numCounters := range []int64{64, 1 << 16} {
+		cache, err := NewCache(&Config{
+			NumCounters:       numCounters,
+			MaxCost:           10,
+			BufferItems:       64,
+			DeterministicMode: true,
+		})
+		if err != nil {
+			panic(err)
+		}
+		// a key hot enough to max out its counters
+		for i := 0; i < 20; i++ {
+			cache.Get(-1)
+		}
+		// and a keyspace far larger than 64 counters can tell apart
+		for key := 0; key < 1000; key++ {
+			for i := 0; i < 3; i++ {
+				cache.Get(key)
+			}
+		}
+		s := cache.SketchStats()
+		if s.Counters != numCounters {
+			t.Fatalf("expected %d counters but got %d\n", numCounters, s.Counters)
+		}
+		stats = append(stats, s)
+	}
+	if small := stats[0]; small.Overestimate < 0.02 || small.Collisions < 0.02 {
+		t.Fatalf("expected keys to share counters but got %+v\n", small)
+	}
+	if large := stats[1]; large.Overestimate > 0.01 || large.OverflowRate == 0 {
+		t.Fatalf("expected accurate counters but got %+v\n", large)
+	}
+	var nilCache *Cache
+	if nilCache.SketchStats() != (SketchStats{}) {
+		t.Fatal("nil caches should report zeros")
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// Invalidator broadcasts deleted keys between caches in different processes,
+// usually over a pub/sub system like Redis or NATS. Keys are hashed, so the
+// caches sharing an Invalidator must hash keys the same way.
+type Invalidator interface {
+	// Publish broadcasts a key deleted from the local cache. It's called by
+	// every Del, so it shouldn't block; it's up to the implementation to
+	// buffer or drop messages when the transport is slow.
+	Publish(key uint64)
+	// Subscribe is called once by NewCache with a function deleting a key
+	// from the cache, which is to be called for every key broadcast by other
+	// processes. Keys deleted that way aren't published again.
+	Subscribe(invalidate func(key uint64))
+}
+
+// publish broadcasts a deleted key, if the cache has an Invalidator.
+func (c *Cache) publish(hash uint64) {
+	if c.invalidator != nil {
+		c.invalidator.Publish(hash)
+	}
+}
+
+// invalidate deletes a key broadcast by another process.
+func (c *Cache) invalidate(hash uint64) {
+	c.del(hash, c.nextVersion())
+}
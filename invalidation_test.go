@@ -0,0 +1,86 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+// testBus delivers published keys to every other subscriber synchronously.
+type testBus struct {
+	subscribers []func(uint64)
+	published   int
+}
+
+type testBusClient struct {
+	bus *testBus
+	id  int
+}
+
+func (b *testBus) client() *testBusClient {
+	return &testBusClient{bus: b, id: len(b.subscribers)}
+}
+
+func (c *testBusClient) Publish(key uint64) {
+	c.bus.published++
+	for id, invalidate := range c.bus.subscribers {
+		if id != c.id {
+			invalidate(key)
+		}
+	}
+}
+
+func (c *testBusClient) Subscribe(invalidate func(uint64)) {
+	c.bus.subscribers = append(c.bus.subscribers, invalidate)
+}
+
+func newInvalidatedCache(bus *testBus) *Cache {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		DeterministicMode: true,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+		Invalidation:      bus.client(),
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	bus := &testBus{}
+	a := newInvalidatedCache(bus)
+	b := newInvalidatedCache(bus)
+	a.Set(1, 1, 1)
+	b.Set(1, 1, 1)
+	b.Set(2, 2, 1)
+	a.Del(1)
+	if _, ok := b.Get(1); ok {
+		t.Fatal("Del should be broadcast to other caches")
+	}
+	// invalidated keys aren't broadcast again
+	if bus.published != 1 {
+		t.Fatalf("expected 1 broadcast but got %d\n", bus.published)
+	}
+	a.GetAndDelete(2)
+	if _, ok := b.Get(2); ok {
+		t.Fatal("GetAndDelete should be broadcast to other caches")
+	}
+}
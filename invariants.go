@@ -0,0 +1,97 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxReportedKeys bounds the number of mismatched keys listed when an
+// invariant is violated.
+const maxReportedKeys = 10
+
+// checkInvariants panics if the policy's cost accounting has diverged from
+// the store's contents. Every key in the store must be known to the policy
+// and the other way around, and the policy's total cost must be the sum of
+// its keys' costs. The caller must hold every lock in processMu, so no item
+// is being applied in the meantime.
+func (c *Cache) checkInvariants() {
+	costs, used := c.policy.Costs()
+	var problems []string
+	var sum int64
+	for _, cost := range costs {
+		sum += cost
+	}
+	if sum != used {
+		problems = append(problems, fmt.Sprintf(
+			"policy accounts for a cost of %d, but its keys add up to %d",
+			used, sum))
+	}
+	stored := make(map[uint64]struct{})
+	var unknown []uint64
+	c.store.Range(func(key uint64, _ interface{}) bool {
+		stored[key] = struct{}{}
+		if _, ok := costs[key]; !ok {
+			unknown = append(unknown, key)
+		}
+		return true
+	})
+	if len(unknown) > 0 {
+		problems = append(problems, fmt.Sprintf(
+			"%d stored keys are unknown to the policy: %s",
+			len(unknown), formatKeys(unknown)))
+	}
+	var missing []uint64
+	for key := range costs {
+		if _, ok := stored[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		problems = append(problems, fmt.Sprintf(
+			"%d policy keys are missing from the store: %s",
+			len(missing), formatKeys(missing)))
+	}
+	if len(problems) == 0 {
+		return
+	}
+	panic(fmt.Sprintf("ristretto: cache invariants violated\n  %s\n"+
+		"policy: %d keys, cost %d, capacity left %d\n"+
+		"store: %d keys\n"+
+		"setBuf: %d of %d items",
+		strings.Join(problems, "\n  "),
+		len(costs), used, c.policy.Cap(),
+		len(stored),
+		c.setBuf.Len(), c.setBuf.Cap()))
+}
+
+// formatKeys lists the first maxReportedKeys keys.
+func formatKeys(keys []uint64) string {
+	var b strings.Builder
+	for i, key := range keys {
+		if i == maxReportedKeys {
+			fmt.Fprintf(&b, " and %d more", len(keys)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%d", key)
+	}
+	return b.String()
+}
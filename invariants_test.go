@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newInvariantCache(deterministic bool) *Cache {
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		DebugInvariants:   true,
+		DeterministicMode: deterministic,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestCacheInvariantsChurn(t *testing.T) {
+	for _, deterministic := range []bool{false, true} {
+		t.Run(fmt.Sprintf("deterministic=%v", deterministic), func(t *testing.T) {
+			cache := newInvariantCache(deterministic)
+			wg := &sync.WaitGroup{}
+			for g := 0; g < 4; g++ {
+				wg.Add(1)
+				go func(seed int64) {
+					defer wg.Done()
+					r := rand.New(rand.NewSource(seed))
+					for i := 0; i < 10000; i++ {
+						key := r.Intn(200)
+						switch r.Intn(3) {
+						case 0, 1:
+							cache.Set(key, key, int64(1+r.Intn(5)))
+						case 2:
+							cache.Del(key)
+						}
+					}
+				}(int64(g))
+			}
+			wg.Wait()
+			// GetOrCompute waits for the buffered items, so the last one is checked
+			cache.GetOrCompute(-1, func() (interface{}, int64, error) {
+				return nil, 1, nil
+			})
+		})
+	}
+}
+
+func TestCacheInvariantsViolated(t *testing.T) {
+	cache := newInvariantCache(true)
+	cache.Set(1, 1, 1)
+	// sneak a key past the policy
+	cache.store.Set(2, 2, 0, 0)
+	defer func() {
+		msg, _ := recover().(string)
+		if !strings.Contains(msg, "1 stored keys are unknown to the policy: 2") {
+			t.Fatalf("expected an invariant violation but got %q\n", msg)
+		}
+	}()
+	cache.Set(3, 3, 1)
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// keyedShards is the number of independently locked lock maps in a
+// KeyedMutex.
+const keyedShards = 64
+
+// KeyedMutex is a set of mutexes addressed by key, so callers can serialize
+// work on a single key (for example, loading it from a backing store) without
+// blocking other keys. Keys are hashed with the same function as the Cache
+// they belong to, and locks only exist while they're held or waited on.
+type KeyedMutex struct {
+	keyToHash func(interface{}) uint64
+	shards    [keyedShards]keyedShard
+}
+
+type keyedShard struct {
+	sync.Mutex
+	locks map[uint64]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// refs is the number of goroutines holding or waiting for the lock
+	refs int
+}
+
+// NewKeyedMutex returns a KeyedMutex hashing keys with keyToHash, or with
+// z.KeyToHash if keyToHash is nil.
+func NewKeyedMutex(keyToHash func(interface{}) uint64) *KeyedMutex {
+	if keyToHash == nil {
+		keyToHash = z.KeyToHash
+	}
+	m := &KeyedMutex{keyToHash: keyToHash}
+	for i := range m.shards {
+		m.shards[i].locks = make(map[uint64]*keyedLock)
+	}
+	return m
+}
+
+// Lock locks key. If the key is already locked, Lock blocks until it's
+// available.
+func (m *KeyedMutex) Lock(key interface{}) {
+	m.LockHash(m.keyToHash(key))
+}
+
+// Unlock unlocks key. It's a run-time error if key isn't locked.
+func (m *KeyedMutex) Unlock(key interface{}) {
+	m.UnlockHash(m.keyToHash(key))
+}
+
+// LockHash is Lock for an already hashed key.
+func (m *KeyedMutex) LockHash(hash uint64) {
+	shard := &m.shards[hash%keyedShards]
+	shard.Lock()
+	l, ok := shard.locks[hash]
+	if !ok {
+		l = &keyedLock{}
+		shard.locks[hash] = l
+	}
+	l.refs++
+	shard.Unlock()
+	l.Lock()
+}
+
+// UnlockHash is Unlock for an already hashed key.
+func (m *KeyedMutex) UnlockHash(hash uint64) {
+	shard := &m.shards[hash%keyedShards]
+	shard.Lock()
+	l, ok := shard.locks[hash]
+	if !ok {
+		shard.Unlock()
+		panic("ristretto: unlock of unlocked key")
+	}
+	if l.refs--; l.refs == 0 {
+		delete(shard.locks, hash)
+	}
+	shard.Unlock()
+	l.Unlock()
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := NewKeyedMutex(nil)
+	counter := 0
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.Lock("key")
+				counter++
+				m.Unlock("key")
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 8000 {
+		t.Fatal("keyed mutex isn't exclusive")
+	}
+	for i := range m.shards {
+		if len(m.shards[i].locks) != 0 {
+			t.Fatal("released locks should be removed")
+		}
+	}
+}
+
+func TestKeyedMutexIndependent(t *testing.T) {
+	m := NewKeyedMutex(nil)
+	m.Lock(1)
+	// locking another key must not block
+	m.Lock(2)
+	m.Unlock(2)
+	m.Unlock(1)
+}
+
+func TestKeyedMutexUnlockPanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("unlocking an unlocked key should panic")
+		}
+	}()
+	NewKeyedMutex(nil).Unlock(1)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// InflightLoad is a GetOrCompute or GetOrLoad call computing a missing key.
+type InflightLoad struct {
+	Key interface{}
+	// Started is when the key started loading.
+	Started time.Time
+	// Waiters is the number of callers blocked on the load, waiting for its
+	// value.
+	Waiters int
+}
+
+// loadTracker keeps track of the keys being computed by GetOrCompute, of the
+// callers waiting for them, and of the loads that failed.
+type loadTracker struct {
+	sync.Mutex
+	loads map[uint64]*InflightLoad
+	// errs holds the errors of failed loads, for Config.LoadErrorTTL
+	errs map[uint64]failedLoad
+}
+
+type failedLoad struct {
+	err     error
+	expires time.Time
+}
+
+func newLoadTracker() *loadTracker {
+	return &loadTracker{
+		loads: make(map[uint64]*InflightLoad),
+		errs:  make(map[uint64]failedLoad),
+	}
+}
+
+// wait counts a caller about to wait for the key, and returns its load, or
+// nil if the key isn't being loaded.
+func (t *loadTracker) wait(hash uint64) *InflightLoad {
+	t.Lock()
+	defer t.Unlock()
+	l := t.loads[hash]
+	if l != nil {
+		l.Waiters++
+	}
+	return l
+}
+
+// waited counts a caller of wait done waiting for l.
+func (t *loadTracker) waited(l *InflightLoad) {
+	if l == nil {
+		return
+	}
+	t.Lock()
+	l.Waiters--
+	t.Unlock()
+}
+
+// start records that the key started loading at now.
+func (t *loadTracker) start(hash uint64, key interface{}, now time.Time) {
+	t.Lock()
+	t.loads[hash] = &InflightLoad{Key: key, Started: now}
+	t.Unlock()
+}
+
+// finish records that the key is done loading, and returns how long it took.
+func (t *loadTracker) finish(hash uint64, now time.Time) time.Duration {
+	t.Lock()
+	defer t.Unlock()
+	l := t.loads[hash]
+	delete(t.loads, hash)
+	return now.Sub(l.Started)
+}
+
+// fail records that loading the key failed with err, which is served until
+// expires.
+func (t *loadTracker) fail(hash uint64, err error, expires time.Time) {
+	t.Lock()
+	t.errs[hash] = failedLoad{err: err, expires: expires}
+	t.Unlock()
+}
+
+// failed returns the error loading the key failed with, and false if it
+// didn't fail or the error expired.
+func (t *loadTracker) failed(hash uint64, now time.Time) (error, bool) {
+	t.Lock()
+	defer t.Unlock()
+	e, ok := t.errs[hash]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(e.expires) {
+		delete(t.errs, hash)
+		return nil, false
+	}
+	return e.err, true
+}
+
+// expireErrors forgets the errors that expired by now, so keys failing once
+// don't pile up.
+func (t *loadTracker) expireErrors(now time.Time) {
+	t.Lock()
+	for hash, e := range t.errs {
+		if !now.Before(e.expires) {
+			delete(t.errs, hash)
+		}
+	}
+	t.Unlock()
+}
+
+// snapshot returns a copy of the loads, the oldest first.
+func (t *loadTracker) snapshot() []InflightLoad {
+	t.Lock()
+	loads := make([]InflightLoad, 0, len(t.loads))
+	for _, l := range t.loads {
+		loads = append(loads, *l)
+	}
+	t.Unlock()
+	sort.Slice(loads, func(i, j int) bool {
+		return loads[i].Started.Before(loads[j].Started)
+	})
+	return loads
+}
+
+// InflightLoads returns the keys being computed by GetOrCompute or loaded by
+// GetOrLoad, the oldest first, to find loads that are stuck.
+func (c *Cache) InflightLoads() []InflightLoad {
+	if c == nil {
+		return nil
+	}
+	return c.loads.snapshot()
+}
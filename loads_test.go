@@ -0,0 +1,151 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheInflightLoads(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+		Clock:             clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	release := make(chan struct{})
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cache.GetOrCompute(1, func() (interface{}, int64, error) {
+				<-release
+				return 1, 1, nil
+			})
+		}()
+	}
+	// wait for one caller to load the key, and the others to wait for it
+	var loads []InflightLoad
+	for start := time.Now(); time.Since(start) < time.Second; {
+		if loads = cache.InflightLoads(); len(loads) == 1 && loads[0].Waiters == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if len(loads) != 1 || loads[0].Key != 1 || loads[0].Waiters != 2 {
+		t.Fatalf("unexpected inflight loads %v\n", loads)
+	}
+	m := cache.Metrics()
+	if m.LoadsInflight() != 1 {
+		t.Fatalf("expected 1 inflight load but got %d\n", m.LoadsInflight())
+	}
+	clock.Advance(time.Second)
+	close(release)
+	wg.Wait()
+	if len(cache.InflightLoads()) != 0 || m.LoadsInflight() != 0 {
+		t.Fatal("finished loads should be forgotten")
+	}
+	if m.LoadsCoalesced() != 2 {
+		t.Fatalf("expected 2 coalesced callers but got %d\n", m.LoadsCoalesced())
+	}
+	if m.LoadLatency() != time.Second {
+		t.Fatalf("expected loads to take 1s but got %v\n", m.LoadLatency())
+	}
+	cache.GetOrCompute(2, func() (interface{}, int64, error) {
+		return nil, 0, errors.New("failed")
+	})
+	if m.LoadsFailed() != 1 || m.LoadLatency() != time.Second/2 {
+		t.Fatal("failed loads should be counted")
+	}
+}
+
+func TestCacheInflightLoadsPanic(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	func() {
+		defer func() { recover() }()
+		cache.GetOrCompute(1, func() (interface{}, int64, error) {
+			panic("failed")
+		})
+	}()
+	if len(cache.InflightLoads()) != 0 || cache.Metrics().LoadsInflight() != 0 {
+		t.Fatal("loads that panicked should be forgotten")
+	}
+}
+
+func TestCacheLoadErrorTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+		Clock:             clock,
+		LoadErrorTTL:      time.Second,
+	})
+	if err != nil {
+		panic(err)
+	}
+	loads := 0
+	failure := errors.New("failed")
+	load := func() (interface{}, int64, error) {
+		loads++
+		if loads == 1 {
+			return nil, 0, failure
+		}
+		return 1, 1, nil
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := cache.GetOrCompute(1, load); err != failure {
+			t.Fatalf("expected the load's error but got %v\n", err)
+		}
+	}
+	m := cache.Metrics()
+	if loads != 1 || m.LoadsFailed() != 1 || m.LoadErrorsServed() != 2 {
+		t.Fatal("the error should be served instead of loading again")
+	}
+	// other keys aren't affected
+	if val, err := cache.GetOrCompute(2, load); err != nil || val != 1 {
+		t.Fatal("only the failed key should serve its error")
+	}
+	clock.Advance(time.Second)
+	if val, err := cache.GetOrCompute(1, load); err != nil || val != 1 {
+		t.Fatal("expired errors should be loaded again")
+	}
+	if loads != 3 || m.LoadErrorsServed() != 2 {
+		t.Fatalf("expected 3 loads but got %d\n", loads)
+	}
+}
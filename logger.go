@@ -0,0 +1,64 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "sync/atomic"
+
+// Logger reports what the cache would otherwise handle silently, see
+// Config.Logger. *log.Logger implements it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// logf logs through Config.Logger, if set.
+func (c *Cache) logf(format string, v ...interface{}) {
+	if c.config.Logger != nil {
+		c.config.Logger.Printf("ristretto: "+format, v...)
+	}
+}
+
+// callback calls f, which calls the user callback named name. A panic in it
+// is recovered, counted by the callback-panics-recovered metric and logged,
+// as it would otherwise take down the goroutine applying Sets or removing
+// expired keys, or leave the cache holding locks.
+func (c *Cache) callback(name string, f func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.stats.Add(callbackPanics, 0, 1)
+			c.logf("recovered panic in %s: %v", name, r)
+		}
+	}()
+	f()
+}
+
+// dropSet counts a Set dropped because the Set buffer was full.
+func (c *Cache) dropSet(hash uint64) {
+	c.stats.Add(dropSets, hash, 1)
+	atomic.AddUint64(&c.droppedSets, 1)
+}
+
+// logDrops logs the Sets dropped since it was last called, so bursts of
+// drops are reported once rather than for every Set, and returns their
+// number.
+func (c *Cache) logDrops() uint64 {
+	n := atomic.SwapUint64(&c.droppedSets, 0)
+	if n > 0 {
+		c.logf("dropped %d Sets as the Set buffer was full; it may be too "+
+			"small for the write load", n)
+	}
+	return n
+}
@@ -0,0 +1,162 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+type testLogger struct {
+	sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) logged(substr string) bool {
+	l.Lock()
+	defer l.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCacheLogger(t *testing.T) {
+	logger := &testLogger{}
+	var exits int
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		OnEvict: func(key uint64, value interface{}, cost int64) {
+			panic("evicted")
+		},
+		OnExit: func(value interface{}) {
+			exits++
+		},
+		Logger:            logger,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	cache.Get(2)
+	cache.Get(2)
+	cache.Set(2, 2, 1)
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("expected key 1 to be evicted")
+	}
+	// the panic doesn't keep the value from exiting the cache
+	if exits != 1 {
+		t.Fatalf("expected 1 exit but got %d\n", exits)
+	}
+	if n := cache.Metrics().CallbackPanics(); n != 1 {
+		t.Fatalf("expected 1 recovered panic but got %d\n", n)
+	}
+	if !logger.logged("recovered panic in OnEvict: evicted") {
+		t.Fatalf("expected the panic to be logged, got %q\n", logger.lines)
+	}
+
+	// corrections made by Reconcile are logged
+	cache.store.Set(3, 3, 0, 0)
+	if r := cache.Reconcile(); r.Dropped != 1 {
+		t.Fatalf("expected a dropped key but got %+v\n", r)
+	}
+	if !logger.logged("dropped 1 keys") {
+		t.Fatalf("expected the reconciliation to be logged, got %q\n",
+			logger.lines)
+	}
+
+	// drops are logged at once
+	for i := 0; i < 3; i++ {
+		cache.dropSet(4)
+	}
+	cache.logDrops()
+	cache.logDrops()
+	if !logger.logged("dropped 3 Sets") {
+		t.Fatalf("expected the drops to be logged, got %q\n", logger.lines)
+	}
+	if n := len(logger.lines); n != 3 {
+		t.Fatalf("expected 3 lines but got %d\n", n)
+	}
+	cache.Close()
+}
+
+// panickyStore panics when setting key.
+type panickyStore struct {
+	store
+	key uint64
+}
+
+func (s *panickyStore) Set(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	if key == s.key {
+		panic("faulty store")
+	}
+	return s.store.Set(key, value, version, flags)
+}
+
+func TestCachePanicIsolation(t *testing.T) {
+	logger := &testLogger{}
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		OnEvict: func(key uint64, value interface{}, cost int64) {
+			panic("evicted")
+		},
+		Logger:  logger,
+		Metrics: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.store = &panickyStore{store: cache.store, key: z.KeyToHash(1)}
+	cache.Set(1, 1, 1)
+	time.Sleep(10 * time.Millisecond)
+	if !logger.logged("recovered panic applying a Set of key") {
+		t.Fatalf("expected the panic to be logged, got %q\n", logger.lines)
+	}
+	// the goroutine applying Sets survived, and so does it evicting keys
+	// with a panicking OnEvict
+	for key := 2; key < 10; key++ {
+		cache.Set(key, key, 1)
+		time.Sleep(10 * time.Millisecond)
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("expected key %d to be set\n", key)
+		}
+	}
+	if n := cache.Metrics().CallbackPanics(); n < 2 {
+		t.Fatalf("expected recovered panics but got %d\n", n)
+	}
+	cache.Close()
+}
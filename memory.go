@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// defaultPressureEvict is the fraction of the cache's cost evicted under
+// memory pressure when Config.MemoryPressureEvict isn't set.
+const defaultPressureEvict = 0.1
+
+// memoryUsage returns the memory used by the Go runtime, as counted against
+// its memory limit, and the memory limit, which is zero if there's none. It's
+// a variable so tests can simulate memory pressure.
+var memoryUsage = readMemoryUsage
+
+// systemMemory returns the total memory of the system, or zero if it isn't
+// known.
+var systemMemory = readSystemMemory
+
+// percentMaxCost returns percent of the memory limit, or of the system memory
+// if there's no limit, or zero if neither is known.
+func percentMaxCost(percent float64) int64 {
+	_, limit := memoryUsage()
+	if limit == 0 {
+		limit = systemMemory()
+	}
+	return int64(float64(limit) * percent / 100)
+}
+
+// resize recomputes MaxCost from Config.MaxCostPercent, in case the memory
+// limit changed. The caller must hold every lock in processMu.
+func (c *Cache) resize() {
+	if c.config.MaxCostPercent == 0 {
+		return
+	}
+	maxCost := percentMaxCost(c.config.MaxCostPercent)
+	if maxCost > 0 && maxCost != c.policy.MaxCost() {
+		c.evict(c.policy.SetMaxCost(maxCost))
+	}
+}
+
+// relievePressure evicts a fraction of the cache's cost if memory usage is
+// above Config.MemoryPressureThreshold. The caller must hold every lock in
+// processMu.
+func (c *Cache) relievePressure() {
+	if c.pressureThreshold == 0 {
+		return
+	}
+	used, limit := memoryUsage()
+	if limit == 0 || float64(used) < c.pressureThreshold*float64(limit) {
+		return
+	}
+	cost := c.policy.MaxCost() - c.policy.Cap()
+	c.evict(c.policy.Evict(int64(float64(cost) * c.pressureEvict)))
+}
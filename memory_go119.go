@@ -0,0 +1,42 @@
+//go:build go1.19
+// +build go1.19
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"math"
+	"runtime/debug"
+	rtmetrics "runtime/metrics"
+)
+
+func readMemoryUsage() (used, limit uint64) {
+	// the memory limit applies to all memory mapped by the runtime, except
+	// for what was returned to the OS
+	samples := []rtmetrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	rtmetrics.Read(samples)
+	used = samples[0].Value.Uint64() - samples[1].Value.Uint64()
+	// a negative limit only reads the current one
+	if l := debug.SetMemoryLimit(-1); l != math.MaxInt64 {
+		limit = uint64(l)
+	}
+	return used, limit
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "testing"
+
+func TestReadMemoryUsage(t *testing.T) {
+	if used, _ := readMemoryUsage(); used == 0 {
+		t.Fatal("expected some memory to be used")
+	}
+}
+
+func TestCacheMemoryPressure(t *testing.T) {
+	defer func(f func() (uint64, uint64)) { memoryUsage = f }(memoryUsage)
+	var used uint64
+	memoryUsage = func() (uint64, uint64) { return used, 100 }
+	var evicted int
+	cache, err := NewCache(&Config{
+		NumCounters:             1000,
+		MaxCost:                 100,
+		BufferItems:             64,
+		DeterministicMode:       true,
+		MemoryPressureThreshold: 0.9,
+		MemoryPressureEvict:     0.2,
+		OnEvict: func(uint64, interface{}, int64) {
+			evicted++
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 50; i++ {
+		cache.Set(i, i, 1)
+	}
+	cache.lockAll()
+	defer cache.unlockAll()
+	used = 89
+	cache.relievePressure()
+	if evicted != 0 {
+		t.Fatal("nothing should be evicted under the threshold")
+	}
+	used = 90
+	cache.relievePressure()
+	if evicted != 10 {
+		t.Fatalf("expected 20%% of the cost to be evicted but got %d keys\n",
+			evicted)
+	}
+	if n := cache.store.Len(); n != 40 {
+		t.Fatalf("expected 40 keys left but got %d\n", n)
+	}
+}
+
+func TestPolicyEvict(t *testing.T) {
+	policies := []func(int64, int64, policyOptions) policy{
+		newSyncPolicy, newLRUPolicy, newGDSFPolicy, newLFUDAPolicy,
+	}
+	for _, create := range policies {
+		p := create(100, 10, policyOptions{})
+		for key := uint64(0); key < 5; key++ {
+			p.Add(key, 2)
+		}
+		if victims := p.Evict(3); len(victims) != 2 {
+			t.Fatalf("%T: expected 2 victims but got %d\n", p, len(victims))
+		}
+		if victims := p.Evict(100); len(victims) != 3 {
+			t.Fatalf("%T: expected the remaining 3 victims but got %d\n",
+				p, len(victims))
+		}
+		if _, used := p.Costs(); used != 0 {
+			t.Fatalf("%T: expected an empty policy\n", p)
+		}
+	}
+}
+
+func TestCacheMaxCostPercent(t *testing.T) {
+	defer func(f func() (uint64, uint64)) { memoryUsage = f }(memoryUsage)
+	defer func(f func() uint64) { systemMemory = f }(systemMemory)
+	var limit uint64
+	memoryUsage = func() (uint64, uint64) { return 0, limit }
+	systemMemory = func() uint64 { return 1000 }
+	config := &Config{
+		NumCounters:       1000,
+		BufferItems:       64,
+		DeterministicMode: true,
+		MaxCostPercent:    10,
+	}
+	cache, err := NewCache(config)
+	if err != nil {
+		panic(err)
+	}
+	if n := cache.policy.MaxCost(); n != 100 {
+		t.Fatalf("expected 10%% of the system memory but got %d\n", n)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Set(i, i, 1)
+	}
+	// a memory limit takes precedence
+	limit = 500
+	cache.lockAll()
+	cache.resize()
+	cache.unlockAll()
+	if n := cache.policy.MaxCost(); n != 50 {
+		t.Fatalf("expected 10%% of the memory limit but got %d\n", n)
+	}
+	if n := cache.store.Len(); n != 50 {
+		t.Fatalf("expected the cache to shrink to 50 keys but got %d\n", n)
+	}
+	systemMemory = func() uint64 { return 0 }
+	limit = 0
+	if _, err := NewCache(config); err == nil {
+		t.Fatal("expected an error without any memory to size the cache by")
+	}
+	config.MaxCost = 10
+	if cache, _ := NewCache(config); cache.policy.MaxCost() != 10 {
+		t.Fatal("expected MaxCost to be used as a fallback")
+	}
+}
+
+func TestPolicySetMaxCost(t *testing.T) {
+	policies := []func(int64, int64, policyOptions) policy{
+		newSyncPolicy, newLRUPolicy, newGDSFPolicy, newLFUDAPolicy,
+	}
+	for _, create := range policies {
+		p := create(100, 10, policyOptions{})
+		for key := uint64(0); key < 5; key++ {
+			p.Add(key, 2)
+		}
+		if victims := p.SetMaxCost(20); len(victims) != 0 {
+			t.Fatalf("%T: growing shouldn't evict\n", p)
+		}
+		if victims := p.SetMaxCost(5); len(victims) != 3 {
+			t.Fatalf("%T: expected 3 victims but got %d\n", p, len(victims))
+		}
+		if p.MaxCost() != 5 || p.Cap() != 1 {
+			t.Fatalf("%T: unexpected capacity\n", p)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "time"
+
+// SetOption changes how Set stores a single key. Options are applied in
+// order, so later ones win.
+type SetOption func(*setOptions)
+
+// setOptions describes how set stores a key.
+type setOptions struct {
+	// flags are stored along with the value
+	flags uint32
+	// tags, if set, replace the tags of the key
+	tags []string
+	// exp describes when the key expires, if expires is set
+	exp     expiry
+	expires bool
+	// pin keeps the key from being evicted
+	pin bool
+	// noAdmit skips admission for new keys
+	noAdmit bool
+	// priority is the key's eviction priority, if prioritize is set
+	priority   int
+	prioritize bool
+	// penalty is the key's miss penalty, if it's not zero
+	penalty time.Duration
+}
+
+// newSetOptions applies opts to empty setOptions.
+func newSetOptions(opts []SetOption) setOptions {
+	var o setOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithTTL makes the key expire after ttl, like SetWithTTL. A ttl of zero
+// never expires, and a negative ttl drops the Set.
+func WithTTL(ttl time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.exp, o.expires = expiry{ttl: ttl}, true
+	}
+}
+
+// WithIdleTTL makes the key expire once it hasn't been accessed for idle,
+// like SetWithIdleTTL.
+func WithIdleTTL(idle time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.exp, o.expires = expiry{ttl: idle, idle: true}, true
+	}
+}
+
+// WithTags tags the key, like SetWithTags.
+func WithTags(tags ...string) SetOption {
+	// the index keeps the tags, so they're copied
+	tags = append(make([]string, 0, len(tags)), tags...)
+	return func(o *setOptions) {
+		o.tags = tags
+	}
+}
+
+// WithFlags stores flags along with the value, like SetWithFlags.
+func WithFlags(flags uint32) SetOption {
+	return func(o *setOptions) {
+		o.flags = flags
+	}
+}
+
+// WithPin keeps the key from being evicted until it's deleted or expires,
+// for values that must stay cached, like configuration. Pinned keys still
+// count against MaxCost, and once they fill the cache, new keys are
+// rejected. Only the default EvictSampledLFU policy pins keys; other
+// policies ignore it.
+func WithPin() SetOption {
+	return func(o *setOptions) {
+		o.pin = true
+	}
+}
+
+// WithNoAdmission adds a new key without going through admission, evicting
+// other keys to make room if needed, for values known to be hot before they
+// were ever accessed, like content that was just published. The key is
+// counted as accessed once, like keys loaded by Warm, so it isn't the first
+// to go when the next key needs room. The Set can still be dropped by a full
+// Set buffer, and keys costing more than MaxCost still don't fit.
+func WithNoAdmission() SetOption {
+	return func(o *setOptions) {
+		o.noAdmit = true
+	}
+}
+
+// WithPriority sets the key's eviction priority, 0 by default, so keys that
+// are much more expensive to recompute than others of similar popularity can
+// be given a higher one. Eviction picks the lowest priority among the keys it
+// samples, so keys of a higher priority only go when a sample holds nothing
+// else; among keys of the same priority, the policy picks victims as usual.
+// Priorities only matter for eviction, so new keys still go through
+// admission, and Sets of the key without WithPriority keep its priority.
+// Only the default EvictSampledLFU policy has priorities; other policies
+// ignore them.
+func WithPriority(priority int) SetOption {
+	return func(o *setOptions) {
+		o.priority, o.prioritize = priority, true
+	}
+}
+
+// WithMissPenalty records how long loading the key took, for callers that
+// load keys themselves rather than through GetOrCompute or GetOrLoad. It's
+// ignored unless Config.TrackMissPenalty or Config.PenaltyAwareAdmission is
+// set, and Sets of the key without it keep its penalty.
+func WithMissPenalty(penalty time.Duration) SetOption {
+	return func(o *setOptions) {
+		o.penalty = penalty
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheSetOptions(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	if !cache.Set(1, 1, 1, WithTTL(time.Second), WithTags("a"), WithFlags(7)) {
+		t.Fatal("Set was dropped")
+	}
+	_, info, ok := cache.GetWithInfo(1)
+	if !ok || info.Flags != 7 || !info.Expiration.Equal(time.Unix(1, 0)) {
+		t.Fatalf("options weren't applied: %+v\n", info)
+	}
+	if cache.Set(2, 2, 1, WithTTL(-time.Second)) {
+		t.Fatal("negative TTLs should drop the Set")
+	}
+	// later options win
+	cache.Set(3, 3, 1, WithTTL(time.Second), WithIdleTTL(time.Minute))
+	if ttl, ok := cache.TTL(3); !ok || ttl != time.Minute {
+		t.Fatalf("expected an idle TTL of a minute but got %v\n", ttl)
+	}
+	if n := cache.InvalidateTag("a"); n != 1 {
+		t.Fatalf("expected 1 tagged key but got %d\n", n)
+	}
+}
+
+func TestCacheSetWithPin(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	cache.Set(1, 1, 1, WithPin())
+	for key := 2; key < 10; key++ {
+		cache.Set(key, key, 1)
+	}
+	// keys already in the cache can be pinned too
+	cache.Set(2, 2, 1, WithPin())
+	for key := 100; key < 120; key++ {
+		cache.Set(key, key, 1, WithNoAdmission())
+	}
+	for _, key := range evicted {
+		if key == cache.keyToHash(1) || key == cache.keyToHash(2) {
+			t.Fatalf("pinned key %d was evicted\n", key)
+		}
+	}
+	// the first new key still fits
+	if len(evicted) != 19 {
+		t.Fatalf("expected 19 evictions but got %d\n", len(evicted))
+	}
+	// once pinned keys fill the cache, new keys are rejected
+	for key := 3; key < 11; key++ {
+		cache.Set(key, key, 1, WithPin(), WithNoAdmission())
+	}
+	cache.Set(200, 200, 1, WithNoAdmission())
+	if _, ok := cache.Get(200); ok {
+		t.Fatal("pinned keys shouldn't make room for new ones")
+	}
+	for key := 1; key < 11; key++ {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("pinned key %d is missing\n", key)
+		}
+	}
+	// deleted keys are unpinned
+	cache.Del(1)
+	if !cache.Set(200, 200, 1) {
+		t.Fatal("Set was dropped")
+	}
+	if _, ok := cache.Get(200); !ok {
+		t.Fatal("deleted pinned keys should give their room back")
+	}
+}
+
+func TestCacheSetWithNoAdmission(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	// hot keys filling the cache
+	for key := 0; key < 10; key++ {
+		cache.Set(key, key, 1)
+		cache.Get(key)
+		cache.Get(key)
+	}
+	cache.Set(100, 100, 1)
+	if _, ok := cache.Get(100); ok {
+		t.Fatal("cold keys should be rejected by admission")
+	}
+	cache.Set(101, 101, 1, WithNoAdmission())
+	if cache.EstimateFrequency(101) == 0 {
+		t.Fatal("forced keys should be counted as accessed")
+	}
+	if _, ok := cache.Get(101); !ok {
+		t.Fatal("keys set WithNoAdmission should skip admission")
+	}
+	if len(evicted) != 1 {
+		t.Fatalf("expected a single eviction but got %d\n", len(evicted))
+	}
+	// keys costing more than the whole cache still don't fit
+	cache.Set(102, 102, 11, WithNoAdmission())
+	if _, ok := cache.Get(102); ok || len(evicted) != 1 {
+		t.Fatal("oversized keys shouldn't make room")
+	}
+}
+
+func TestCacheSetWithPriority(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	defer cache.Close()
+	cache.Set(1, 1, 1, WithPriority(1))
+	for key := 2; key < 10; key++ {
+		cache.Set(key, key, 1)
+		cache.Get(key)
+		cache.Get(key)
+	}
+	// keys already in the cache can be prioritized too
+	cache.Set(2, 2, 1, WithPriority(1))
+	for key := 100; key < 105; key++ {
+		cache.Set(key, key, 1, WithNoAdmission())
+	}
+	// the first new key still fits
+	if len(evicted) != 4 {
+		t.Fatalf("expected 4 evictions but got %d\n", len(evicted))
+	}
+	for key := 1; key < 3; key++ {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("key %d was evicted before lower priorities\n", key)
+		}
+	}
+}
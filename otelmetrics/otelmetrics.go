@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package otelmetrics exports the metrics of a ristretto Cache as
+// OpenTelemetry instruments. It's a separate module so that only services
+// using OpenTelemetry depend on it.
+package otelmetrics
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/dgraph-io/ristretto"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Prefix is prepended to the name of every instrument.
+const Prefix = "ristretto."
+
+// Register creates an asynchronous counter on meter for every metric of
+// cache, named after the metric with dashes replaced by underscores (for
+// example "ristretto.keys_added"), along with a "ristretto.hit_ratio" gauge,
+// and a "ristretto.sizing_hit_ratio" gauge observed for every multiple of
+// Config.SizingCurve, with the multiple as the "multiple" attribute.
+// Every observation carries attrs, which should tell caches sharing a meter
+// apart, such as attribute.String("cache", name).
+//
+// The cache must have been created with Config.Metrics set. Unregister the
+// returned Registration once the cache is no longer used.
+func Register(meter metric.Meter, cache *ristretto.Cache,
+	attrs ...attribute.KeyValue) (metric.Registration, error) {
+	stats := cache.Metrics()
+	if stats == nil {
+		return nil, errors.New("otelmetrics: the cache doesn't collect metrics")
+	}
+	var names []string
+	for name := range stats.Snapshot() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	counters := make(map[string]metric.Int64ObservableCounter, len(names))
+	observables := make([]metric.Observable, 0, len(names)+1)
+	for _, name := range names {
+		counter, err := meter.Int64ObservableCounter(
+			Prefix + strings.Replace(name, "-", "_", -1))
+		if err != nil {
+			return nil, err
+		}
+		counters[name] = counter
+		observables = append(observables, counter)
+	}
+	ratio, err := meter.Float64ObservableGauge(Prefix+"hit_ratio",
+		metric.WithDescription("Ratio of Gets that found their key."))
+	if err != nil {
+		return nil, err
+	}
+	observables = append(observables, ratio)
+	var sizing metric.Float64ObservableGauge
+	if len(stats.SizingCurve()) > 0 {
+		sizing, err = meter.Float64ObservableGauge(Prefix+"sizing_hit_ratio",
+			metric.WithDescription("Estimated ratio of Gets that would find "+
+				"their key at a multiple of MaxCost."))
+		if err != nil {
+			return nil, err
+		}
+		observables = append(observables, sizing)
+	}
+	opt := metric.WithAttributes(attrs...)
+	return meter.RegisterCallback(
+		func(_ context.Context, o metric.Observer) error {
+			for name, value := range stats.Snapshot() {
+				if counter, ok := counters[name]; ok {
+					o.ObserveInt64(counter, int64(value), opt)
+				}
+			}
+			// without Gets there's no hit ratio, rather than one of 0
+			if value, valid := stats.HitRatio(); valid {
+				o.ObserveFloat64(ratio, value, opt)
+			}
+			for _, point := range stats.SizingCurve() {
+				if point.Valid {
+					o.ObserveFloat64(sizing, point.HitRatio, metric.WithAttributes(
+						append(attrs[:len(attrs):len(attrs)],
+							attribute.Float64("multiple", point.Multiple))...))
+				}
+			}
+			return nil
+		}, observables...)
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package otelmetrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/dgraph-io/ristretto"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func newCache(metrics bool) *ristretto.Cache {
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           metrics,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestRegister(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	cache := newCache(true)
+	reg, err := Register(meter, cache, attribute.String("cache", "test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Unregister()
+	cache.Set(1, 1, 1)
+	cache.Get(1)
+	cache.Get(2)
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[string]bool)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				if m.Name == "ristretto.keys_added" && data.DataPoints[0].Value != 1 {
+					t.Fatalf("expected 1 key added but got %d\n",
+						data.DataPoints[0].Value)
+				}
+				if v, _ := data.DataPoints[0].Attributes.Value("cache"); v.AsString() != "test" {
+					t.Fatal("attributes weren't applied")
+				}
+			case metricdata.Gauge[float64]:
+				if data.DataPoints[0].Value != 0.5 {
+					t.Fatalf("expected a hit ratio of 0.5 but got %.2f\n",
+						data.DataPoints[0].Value)
+				}
+			}
+			found[m.Name] = true
+		}
+	}
+	if !found["ristretto.keys_added"] || !found["ristretto.hit_ratio"] {
+		t.Fatal("instruments weren't registered")
+	}
+}
+
+func TestRegisterWithoutMetrics(t *testing.T) {
+	meter := sdkmetric.NewMeterProvider().Meter("test")
+	if _, err := Register(meter, newCache(false)); err == nil {
+		t.Fatal("Register should fail when metrics are disabled")
+	}
+}
+
+func TestRegisterSizingCurve(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+	cache, err := ristretto.NewCache(&ristretto.Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		SizingCurve:       []float64{1, 2},
+		SizingSampleRate:  1,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	reg, err := Register(meter, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reg.Unregister()
+	cache.Get(1)
+	rm := metricdata.ResourceMetrics{}
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "ristretto.sizing_hit_ratio" {
+				continue
+			}
+			if points := m.Data.(metricdata.Gauge[float64]).DataPoints; len(points) != 2 {
+				t.Fatalf("expected a point per multiple but got %d\n", len(points))
+			}
+			return
+		}
+	}
+	t.Fatal("the sizing curve wasn't registered")
+}
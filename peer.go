@@ -0,0 +1,68 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "context"
+
+// PeerPicker splits keys between the processes of a distributed cache, like
+// in groupcache. Every key is owned by a single process, which is the only
+// one loading it from the origin, so the origin sees one load per key instead
+// of one per process. See the httppeer package for an implementation over
+// HTTP.
+type PeerPicker interface {
+	// PickPeer returns the peer owning the key, or false if the key is owned
+	// by this process.
+	PickPeer(key string) (PeerGetter, bool)
+}
+
+// PeerGetter gets values from the cache of another process.
+type PeerGetter interface {
+	// Get returns the value of the key from the peer's cache, which loads it
+	// from the origin if it's missing.
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetOrLoad is like GetOrCompute for a distributed cache. If the key is
+// missing, it asks the peer owning it for its value, as picked by
+// Config.Peers, and only calls load if this process owns the key, there are
+// no peers, or the peer fails. Either way, the value is added to the cache
+// with its length as cost, so hot keys are served locally afterwards.
+func (c *Cache) GetOrLoad(ctx context.Context, key string,
+	load func(ctx context.Context, key string) ([]byte, error)) ([]byte, error) {
+	val, err := c.GetOrCompute(key, func() (interface{}, int64, error) {
+		data, err := c.load(ctx, key, load)
+		return data, int64(len(data)), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.([]byte), nil
+}
+
+// load gets a missing key from the peer owning it, falling back to the
+// origin.
+func (c *Cache) load(ctx context.Context, key string,
+	load func(ctx context.Context, key string) ([]byte, error)) ([]byte, error) {
+	if c != nil && c.peers != nil {
+		if peer, ok := c.peers.PickPeer(key); ok {
+			if data, err := peer.Get(ctx, key); err == nil {
+				return data, nil
+			}
+		}
+	}
+	return load(ctx, key)
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testPeers owns the keys in owned, and fails for the others.
+type testPeers struct {
+	owned map[string][]byte
+}
+
+func (p *testPeers) PickPeer(key string) (PeerGetter, bool) {
+	return p, key != "local"
+}
+
+func (p *testPeers) Get(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := p.owned[key]; ok {
+		return data, nil
+	}
+	return nil, errors.New("peer failed")
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           100,
+		BufferItems:       64,
+		DeterministicMode: true,
+		Clock:             NewManualClock(time.Unix(0, 0)),
+		Peers:             &testPeers{owned: map[string][]byte{"remote": []byte("peer")}},
+	})
+	if err != nil {
+		panic(err)
+	}
+	var loads []string
+	load := func(ctx context.Context, key string) ([]byte, error) {
+		loads = append(loads, key)
+		return []byte("origin"), nil
+	}
+	for _, key := range []string{"remote", "local", "failed", "remote"} {
+		data, err := cache.GetOrLoad(context.Background(), key, load)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == "remote" && string(data) != "peer" {
+			t.Fatalf("expected the value from the peer but got %s\n", data)
+		}
+	}
+	if len(loads) != 2 || loads[0] != "local" || loads[1] != "failed" {
+		t.Fatalf("unexpected loads from the origin %v\n", loads)
+	}
+	if _, ok := cache.Get("remote"); !ok {
+		t.Fatal("values from peers should be cached")
+	}
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "time"
+
+// missPenalties holds the miss penalty of the keys of a sampledLFU, which is
+// how long loading them took, for Config.TrackMissPenalty.
+type missPenalties struct {
+	// keys holds the penalty of the keys in the policy that have one, in
+	// nanoseconds, which add up to sum
+	keys map[uint64]int64
+	sum  int64
+	// next is the penalty of nextKey, which was given before the key was
+	// added, since penalties are recorded right before keys go through
+	// admission
+	nextKey uint64
+	next    int64
+	hasNext bool
+	// weighs makes weigh multiply hits by penalties
+	weighs bool
+}
+
+func newMissPenalties(weigh bool) *missPenalties {
+	return &missPenalties{keys: make(map[uint64]int64), weighs: weigh}
+}
+
+// set sets the penalty of the key, which is in the policy if cached, or is
+// about to be added otherwise.
+func (m *missPenalties) set(key uint64, penalty time.Duration, cached bool) {
+	if m == nil {
+		return
+	}
+	if !cached {
+		m.nextKey, m.next, m.hasNext = key, int64(penalty), true
+		return
+	}
+	m.sum += int64(penalty) - m.keys[key]
+	m.keys[key] = int64(penalty)
+}
+
+// add records that the key was added to the policy, with the penalty given
+// to set beforehand, if any.
+func (m *missPenalties) add(key uint64) {
+	if m == nil || !m.hasNext || m.nextKey != key {
+		return
+	}
+	m.keys[key] = m.next
+	m.sum += m.next
+	m.hasNext = false
+}
+
+// del forgets the penalty of the key.
+func (m *missPenalties) del(key uint64) {
+	if m == nil {
+		return
+	}
+	if penalty, ok := m.keys[key]; ok {
+		m.sum -= penalty
+		delete(m.keys, key)
+	}
+}
+
+// saved returns the penalties of the keys that were accessed, in
+// nanoseconds, which were saved by finding them cached.
+func (m *missPenalties) saved(keys []uint64) uint64 {
+	if m == nil {
+		return 0
+	}
+	var saved int64
+	for _, key := range keys {
+		saved += m.keys[key]
+	}
+	return uint64(saved)
+}
+
+// weigh returns the hits of the key times its penalty, if penalties are
+// weighed, or hits as they are otherwise. Keys without a penalty weigh the
+// mean penalty, so they neither win nor lose against the others by default.
+func (m *missPenalties) weigh(key uint64, hits int64) int64 {
+	if m == nil || !m.weighs {
+		return hits
+	}
+	penalty, ok := m.keys[key]
+	if !ok && m.hasNext && m.nextKey == key {
+		penalty, ok = m.next, true
+	}
+	if !ok && len(m.keys) > 0 {
+		penalty = m.sum / int64(len(m.keys))
+	}
+	if penalty < 1 {
+		penalty = 1
+	}
+	return hits * penalty
+}
+
+// MissPenaltySaved returns the load time saved by Gets that found their key:
+// how long loading the key took, as recorded by Config.TrackMissPenalty, for
+// every such Get. LoadLatency tells the time spent on misses.
+func (p *metrics) MissPenaltySaved() time.Duration {
+	return time.Duration(p.Get(missPenaltySaved))
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheMissPenalty(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		TrackMissPenalty:  true,
+		DeterministicMode: true,
+		Clock:             clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	load := func(took time.Duration) func() (interface{}, int64, error) {
+		return func() (interface{}, int64, error) {
+			clock.Advance(took)
+			return 1, 1, nil
+		}
+	}
+	if _, err := cache.GetOrCompute(1, load(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		cache.Get(1)
+	}
+	if saved := cache.Metrics().MissPenaltySaved(); saved != 30*time.Millisecond {
+		t.Fatalf("expected 3 hits to save 30ms but got %v\n", saved)
+	}
+	cache.Set(2, 2, 1, WithMissPenalty(5*time.Millisecond))
+	cache.Get(2)
+	if saved := cache.Metrics().MissPenaltySaved(); saved != 35*time.Millisecond {
+		t.Fatalf("expected WithMissPenalty to count, got %v saved\n", saved)
+	}
+	cache.Del(1)
+	cache.Get(1)
+	cache.Set(3, 3, 1)
+	cache.Get(3)
+	if saved := cache.Metrics().MissPenaltySaved(); saved != 35*time.Millisecond {
+		t.Fatalf("keys without a penalty shouldn't count, got %v saved\n", saved)
+	}
+}
+
+func TestCachePenaltyAwareAdmission(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:           100,
+		MaxCost:               2,
+		BufferItems:           64,
+		PenaltyAwareAdmission: true,
+		DeterministicMode:     true,
+		Clock:                 clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	load := func(took time.Duration) func() (interface{}, int64, error) {
+		return func() (interface{}, int64, error) {
+			clock.Advance(took)
+			return 1, 1, nil
+		}
+	}
+	cache.GetOrCompute(1, load(100*time.Millisecond))
+	cache.GetOrCompute(2, load(time.Millisecond))
+	cache.Get(1)
+	cache.Get(2)
+	// key 3 is accessed more than the others, but only outweighs the one
+	// that's quick to load
+	for i := 0; i < 3; i++ {
+		cache.Get(3)
+	}
+	cache.GetOrCompute(3, load(10*time.Millisecond))
+	if _, ok := cache.Get(1); !ok {
+		t.Fatal("the key slowest to load should have been kept")
+	}
+	if _, ok := cache.Get(2); ok {
+		t.Fatal("the key quickest to load should have been evicted")
+	}
+	if _, ok := cache.Get(3); !ok {
+		t.Fatal("the new key should have been admitted")
+	}
+}
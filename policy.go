@@ -17,17 +17,21 @@
 package ristretto
 
 import (
+	"container/heap"
 	"container/list"
 	"math"
+	"math/rand"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/dgraph-io/ristretto/z"
 )
 
 const (
-	// lfuSample is the number of items to sample when looking at eviction
-	// candidates. 5 seems to be the most optimal number [citation needed].
-	lfuSample = 5
+	// lfuSampleGrowth is how many times larger than the configured size the
+	// sample may grow if it adapts to the sampled keys.
+	lfuSampleGrowth = 4
 )
 
 // policy is the interface encapsulating eviction/admission behavior.
@@ -37,35 +41,199 @@ type policy interface {
 	// of evicted keys and a bool denoting whether or not the key-cost pair
 	// was added. If it returns true, the key should be stored in cache.
 	Add(uint64, int64) ([]*item, bool)
+	// Update updates the cost of a key already in the Policy, without going
+	// through admission. Keys that aren't in the Policy are ignored.
+	Update(uint64, int64)
 	// Has returns true if the key exists in the Policy.
 	Has(uint64) bool
+	// Cost returns the cost of a key, and false if it isn't in the Policy.
+	Cost(uint64) (int64, bool)
+	// Estimate returns the estimated access frequency of the key.
+	Estimate(uint64) int64
 	// Del deletes the key from the Policy.
 	Del(uint64)
+	// Pin keeps a key in the Policy from being evicted until it's deleted.
+	// Policies that can't pin keys ignore it.
+	Pin(uint64)
+	// Prioritize sets the priority of a key in the Policy. Keys of lower
+	// priorities are evicted first. Policies without priorities ignore it.
+	Prioritize(key uint64, priority int)
+	// Evict evicts the least valuable keys until at least cost was freed or
+	// the Policy is empty, and returns them.
+	Evict(cost int64) []*item
 	// Cap returns the available capacity.
 	Cap() int64
+	// MaxCost returns the total capacity.
+	MaxCost() int64
+	// SetMaxCost changes the total capacity, and evicts keys until they fit
+	// in it. It returns the evicted keys.
+	SetMaxCost(int64) []*item
+	// Costs returns a copy of the cost of every key in the Policy, and the
+	// total cost the Policy accounts for. It's meant for debugging.
+	Costs() (map[uint64]int64, int64)
+	// Recount recomputes the total cost the Policy accounts for from the
+	// cost of every key, and returns by how much it was off.
+	Recount() int64
+	// Saturation returns the fractions of access counters that are non-zero
+	// and that are maxed out. It's meant for debugging.
+	Saturation() (float64, float64)
+	// SketchStats describes how accurately the access counters count. It's
+	// meant for debugging.
+	SketchStats() SketchStats
 	// Optionally, set stats object to track how policy is performing.
 	CollectMetrics(stats *metrics)
+	// Penalize sets the miss penalty of a key in the Policy, or of the key
+	// if it's added next. Policies not tracking penalties ignore it.
+	Penalize(key uint64, penalty time.Duration)
+	// Optionally, resize the access counters to numCounters, which resets
+	// them.
+	ResizeCounters(numCounters int64)
+	// HotKeys returns up to n of the most accessed keys, or nil if hot keys
+	// aren't tracked.
+	HotKeys(n int) []KeyCount
 }
 
-func newPolicy(numCounters, maxCost int64) policy {
+// policyOptions holds the optional features of a policy, which are set when
+// it's created. Policies ignore the features they can't provide.
+type policyOptions struct {
+	// hotKeys is the number of most accessed keys tracked for HotKeys
+	hotKeys int
+	// byCost compares keys by hits per unit of cost instead of hits when
+	// admitting and evicting them
+	byCost bool
+	// admitAfter admits keys anyway once they were rejected that many times
+	// since the access counters were last halved
+	admitAfter int
+	// fill admits every key as long as the cache isn't full
+	fill bool
+	// grace keeps keys from being evicted until that many more keys were
+	// admitted
+	grace int
+	// sampleSize is the number of keys sampled when looking for a victim,
+	// and the sample grows while their hits vary a lot if adaptiveSample
+	// is set
+	sampleSize     int
+	adaptiveSample bool
+	// sampleSeed, if set, seeds the source the sampled keys are drawn from,
+	// so they're the same on every run
+	sampleSeed int64
+	// scanWindow, if set, rejects new keys while at least scanThreshold of
+	// the last scanWindow keys going through admission were new
+	scanWindow    int
+	scanThreshold float64
+	// idleDecay, if set, halves the hits of keys for every idleDecay they
+	// weren't accessed for, as read from clock, when looking for a victim
+	idleDecay time.Duration
+	clock     Clock
+	// penalties remembers the miss penalty of keys given to Penalize, and
+	// counts it as saved for every access of a key in the Policy. If
+	// weighPenalties is set, hits are multiplied by it when admitting and
+	// evicting keys.
+	penalties      bool
+	weighPenalties bool
+	// hashSeed, if set, seeds the hashing of keys to the access counters,
+	// so keys can't be picked to share counters
+	hashSeed uint64
+	// stop, if set, stops the goroutine counting pushed keys once it's
+	// closed, and stopped is done when it returned
+	stop    <-chan struct{}
+	stopped *sync.WaitGroup
+}
+
+// tunablePolicy is implemented by policies whose eviction can be tuned while
+// they're in use, by Config.AutoTune.
+type tunablePolicy interface {
+	// SampleEvictions samples n keys when looking for a victim, and grows
+	// the sample while the hits of sampled keys vary a lot if adaptive is
+	// set.
+	SampleEvictions(n int, adaptive bool)
+	// ScaleResets halves the access counters every scale times as many
+	// accesses as there are counters, instead of every as many.
+	ScaleResets(scale float64)
+	// ProtectNewKeys keeps keys from being evicted until n more keys were
+	// admitted.
+	ProtectNewKeys(n int)
+}
+
+// newAdmission returns the access counters admitting keys, with the options
+// of opts that apply to them.
+func newAdmission(numCounters int64, opts policyOptions) *tinyLFU {
+	admit := newTinyLFU(numCounters)
+	if opts.hashSeed != 0 {
+		admit.seedHashes(opts.hashSeed)
+	}
+	if opts.hotKeys > 0 {
+		admit.hot = newSpaceSaving(opts.hotKeys)
+	}
+	admit.byCost = opts.byCost
+	if opts.admitAfter > 0 {
+		admit.retryAfter(opts.admitAfter)
+	}
+	if opts.scanWindow > 0 {
+		admit.scan = newScanDetector(opts.scanWindow, opts.scanThreshold)
+	}
+	return admit
+}
+
+// newEviction returns the sampled LFU evicting keys, with the options of opts
+// that apply to it.
+func newEviction(maxCost int64, opts policyOptions) *sampledLFU {
+	evict := newSampledLFU(maxCost)
+	if opts.grace > 0 {
+		evict.protect(opts.grace)
+	}
+	if opts.sampleSize > 0 {
+		evict.resizeSample(opts.sampleSize, opts.adaptiveSample)
+	}
+	if opts.sampleSeed != 0 {
+		evict.seedSamples(opts.sampleSeed)
+	}
+	if opts.idleDecay > 0 {
+		evict.decayIdle(opts.idleDecay, opts.clock)
+	}
+	if opts.penalties {
+		evict.penalties = newMissPenalties(opts.weighPenalties)
+	}
+	return evict
+}
+
+func newPolicy(numCounters, maxCost int64, opts policyOptions) policy {
 	p := &defaultPolicy{
-		admit:   newTinyLFU(numCounters),
-		evict:   newSampledLFU(maxCost),
-		itemsCh: make(chan []uint64, 3),
+		admit:   newAdmission(numCounters, opts),
+		evict:   newEviction(maxCost, opts),
+		itemsCh: make(chan *[]uint64, 3),
+		fill:    opts.fill,
 	}
-	// TODO: Add a way to stop the goroutine.
-	go p.processItems()
+	if opts.stopped != nil {
+		opts.stopped.Add(1)
+	}
+	go p.processItems(opts.stop, opts.stopped)
 	return p
 }
 
+// newSyncPolicy is like newPolicy, but keys pushed to the returned policy are
+// counted before Push returns, instead of by a separate goroutine.
+func newSyncPolicy(numCounters, maxCost int64, opts policyOptions) policy {
+	return &defaultPolicy{
+		admit: newAdmission(numCounters, opts),
+		evict: newEviction(maxCost, opts),
+		fill:  opts.fill,
+	}
+}
+
 // defaultPolicy is the default defaultPolicy, which is currently TinyLFU
 // admission with sampledLFU eviction.
 type defaultPolicy struct {
 	sync.Mutex
-	admit   *tinyLFU
-	evict   *sampledLFU
-	itemsCh chan []uint64
+	admit *tinyLFU
+	evict *sampledLFU
+	// itemsCh is nil if keys are counted synchronously
+	itemsCh chan *[]uint64
+	// batches recycles the copies of pushed keys sent over itemsCh
+	batches sync.Pool
 	stats   *metrics
+	// fill admits every key until the cache is full
+	fill bool
 }
 
 func (p *defaultPolicy) CollectMetrics(stats *metrics) {
@@ -78,11 +246,36 @@ type policyPair struct {
 	cost int64
 }
 
-func (p *defaultPolicy) processItems() {
-	for items := range p.itemsCh {
+// newVictim returns an item from itemPool for a key evicted by a policy.
+func newVictim(key uint64, cost int64) *item {
+	i := getItem()
+	i.key, i.cost = key, cost
+	return i
+}
+
+// processItems counts the keys sent over itemsCh until stop is closed, then
+// marks stopped done, if it's set.
+func (p *defaultPolicy) processItems(stop <-chan struct{},
+	stopped *sync.WaitGroup) {
+	if stopped != nil {
+		defer stopped.Done()
+	}
+	for {
+		var items *[]uint64
+		select {
+		case items = <-p.itemsCh:
+		case <-stop:
+			return
+		}
 		p.Lock()
-		p.admit.Push(items)
+		p.admit.Push(*items)
+		p.evict.touch(*items)
+		saved := p.evict.penalties.saved(*items)
 		p.Unlock()
+		if saved > 0 {
+			p.stats.Add(missPenaltySaved, (*items)[0], saved)
+		}
+		p.batches.Put(items)
 	}
 }
 
@@ -90,11 +283,30 @@ func (p *defaultPolicy) Push(keys []uint64) bool {
 	if len(keys) == 0 {
 		return true
 	}
+	if p.itemsCh == nil {
+		p.Lock()
+		p.admit.Push(keys)
+		p.evict.touch(keys)
+		saved := p.evict.penalties.saved(keys)
+		p.Unlock()
+		p.stats.Add(keepGets, keys[0], uint64(len(keys)))
+		if saved > 0 {
+			p.stats.Add(missPenaltySaved, keys[0], saved)
+		}
+		return true
+	}
+	// keys are reused by the ring buffer once Push returns, so send a copy
+	batch, _ := p.batches.Get().(*[]uint64)
+	if batch == nil {
+		batch = new([]uint64)
+	}
+	*batch = append((*batch)[:0], keys...)
 	select {
-	case p.itemsCh <- keys:
+	case p.itemsCh <- batch:
 		p.stats.Add(keepGets, keys[0], uint64(len(keys)))
 		return true
 	default:
+		p.batches.Put(batch)
 		p.stats.Add(dropGets, keys[0], uint64(len(keys)))
 		return false
 	}
@@ -105,6 +317,7 @@ func (p *defaultPolicy) Add(key uint64, cost int64) ([]*item, bool) {
 	defer p.Unlock()
 	// can't add an item bigger than entire cache
 	if cost > p.evict.maxCost {
+		p.stats.Add(oversizeSets, key, 1)
 		return nil, false
 	}
 	// we don't need to go any further if the item is already in the cache
@@ -123,12 +336,24 @@ func (p *defaultPolicy) Add(key uint64, cost int64) ([]*item, bool) {
 	}
 	// incHits is the hit count for the incoming item
 	incHits := p.admit.Estimate(key)
+	rejected, started := p.admit.scan.rejects(incHits)
+	incHits = p.evict.penalties.weigh(key, incHits)
+	if started {
+		p.stats.Add(scanActivations, key, 1)
+	}
+	if rejected {
+		p.stats.Add(rejectSets, key, 1)
+		return nil, false
+	}
+	// forced is set if the cache is still filling up, or once the item was
+	// rejected too often to be rejected again
+	forced := p.fill && p.evict.used < p.evict.maxCost
 	// sample is the eviction candidate pool to be filled via random sampling
 	//
 	// TODO: perhaps we should use a min heap here. Right now our time
 	// complexity is N for finding the min. Min heap should bring it down to
 	// O(lg N).
-	sample := make([]*policyPair, 0, lfuSample)
+	sample := p.evict.samples[:0]
 	// as items are evicted they will be appended to victims
 	victims := make([]*item, 0)
 	// Delete victims until there's enough space or a minKey is found that has
@@ -136,36 +361,93 @@ func (p *defaultPolicy) Add(key uint64, cost int64) ([]*item, bool) {
 	for ; room < 0; room = p.evict.roomLeft(cost) {
 		// fill up empty slots in sample
 		sample = p.evict.fillSample(sample)
-		// find minimally used item in sample
-		minKey, minHits, minId, minCost := uint64(0), int64(math.MaxInt64), 0, int64(0)
-		for i, pair := range sample {
-			// look up hit count for sample key
-			if hits := p.admit.Estimate(pair.key); hits < minHits {
-				minKey, minHits, minId, minCost = pair.key, hits, i, pair.cost
-			}
-		}
-		// If the incoming item isn't worth keeping in the policy, reject.
-		if incHits < minHits {
+		if len(sample) == 0 {
+			// the rest of the cache is pinned
 			p.stats.Add(rejectSets, key, 1)
 			return victims, false
 		}
+		// find minimally used item in sample
+		minId, minHits := p.victim(sample)
+		minKey, minCost := sample[minId].key, sample[minId].cost
+		// If the incoming item isn't worth keeping in the policy, reject.
+		if !forced && p.admit.less(incHits, cost, minHits, minCost) {
+			if forced = p.admit.reject(key); !forced {
+				p.stats.Add(rejectSets, key, 1)
+				return victims, false
+			}
+		}
 		// delete the victim from metadata
 		p.evict.del(minKey)
 		// delete the victim from sample
 		sample[minId] = sample[len(sample)-1]
 		sample = sample[:len(sample)-1]
 		// store victim in evicted victims slice
-		victims = append(victims, &item{
-			key:  minKey,
-			val:  nil,
-			cost: minCost,
-			del:  false,
-		})
+		victims = append(victims, newVictim(minKey, minCost))
 	}
 	p.evict.add(key, cost)
 	return victims, true
 }
 
+// victim returns the index of the least valuable key in a sample that isn't
+// empty, along with its hits, weighed by its miss penalty if penalties are.
+// Keys of the lowest priority in the sample go first, whatever their hits.
+func (p *defaultPolicy) victim(sample []policyPair) (int, int64) {
+	minId, minHits, minCost := -1, int64(math.MaxInt64), int64(0)
+	minPriority := 0
+	now := p.evict.now()
+	var sum, sumSquares int64
+	for i, pair := range sample {
+		// look up hit count for sample key
+		hits := p.evict.decay(pair.key, p.admit.Estimate(pair.key), now)
+		weighed := p.evict.penalties.weigh(pair.key, hits)
+		priority := p.evict.priorities[pair.key]
+		if minId < 0 || priority < minPriority || priority == minPriority &&
+			p.admit.less(weighed, pair.cost, minHits, minCost) {
+			minId, minHits, minCost = i, weighed, pair.cost
+			minPriority = priority
+		}
+		sum, sumSquares = sum+hits, sumSquares+hits*hits
+	}
+	p.evict.adapt(int64(len(sample)), sum, sumSquares)
+	return minId, minHits
+}
+
+func (p *defaultPolicy) Evict(cost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	return p.evictN(cost)
+}
+
+// evictN evicts the least valuable keys until at least cost was freed. The
+// caller must hold the lock.
+func (p *defaultPolicy) evictN(cost int64) []*item {
+	var victims []*item
+	sample := p.evict.samples[:0]
+	for freed := int64(0); freed < cost && len(p.evict.keyCosts) > 0; {
+		if sample = p.evict.fillSample(sample); len(sample) == 0 {
+			break
+		}
+		minId, _ := p.victim(sample)
+		victim := sample[minId]
+		sample[minId] = sample[len(sample)-1]
+		sample = sample[:len(sample)-1]
+		// samples may hold the same key twice
+		if _, ok := p.evict.keyCosts[victim.key]; !ok {
+			continue
+		}
+		p.evict.del(victim.key)
+		victims = append(victims, newVictim(victim.key, victim.cost))
+		freed += victim.cost
+	}
+	return victims
+}
+
+func (p *defaultPolicy) Update(key uint64, cost int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.evict.updateIfHas(key, cost)
+}
+
 func (p *defaultPolicy) Has(key uint64) bool {
 	p.Lock()
 	defer p.Unlock()
@@ -173,72 +455,440 @@ func (p *defaultPolicy) Has(key uint64) bool {
 	return exists
 }
 
+func (p *defaultPolicy) Cost(key uint64) (int64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	cost, ok := p.evict.keyCosts[key]
+	return cost, ok
+}
+
+func (p *defaultPolicy) Estimate(key uint64) int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.Estimate(key)
+}
+
 func (p *defaultPolicy) Del(key uint64) {
 	p.Lock()
 	defer p.Unlock()
 	p.evict.del(key)
 }
 
+func (p *defaultPolicy) Pin(key uint64) {
+	p.Lock()
+	defer p.Unlock()
+	p.evict.pin(key)
+}
+
+func (p *defaultPolicy) Prioritize(key uint64, priority int) {
+	p.Lock()
+	defer p.Unlock()
+	p.evict.prioritize(key, priority)
+}
+
+func (p *defaultPolicy) Costs() (map[uint64]int64, int64) {
+	p.Lock()
+	defer p.Unlock()
+	costs := make(map[uint64]int64, len(p.evict.keyCosts))
+	for key, cost := range p.evict.keyCosts {
+		costs[key] = cost
+	}
+	return costs, p.evict.used
+}
+
+func (p *defaultPolicy) Recount() int64 {
+	p.Lock()
+	defer p.Unlock()
+	var sum int64
+	for _, cost := range p.evict.keyCosts {
+		sum += cost
+	}
+	drift := p.evict.used - sum
+	p.evict.used = sum
+	return drift
+}
+
+func (p *defaultPolicy) Saturation() (float64, float64) {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.freq.saturation()
+}
+
+func (p *defaultPolicy) SketchStats() SketchStats {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.sketchStats()
+}
+
+func (p *defaultPolicy) HotKeys(n int) []KeyCount {
+	p.Lock()
+	defer p.Unlock()
+	if p.admit.hot == nil {
+		return nil
+	}
+	return p.admit.hot.top(n)
+}
+
+func (p *defaultPolicy) ProtectNewKeys(n int) {
+	p.Lock()
+	defer p.Unlock()
+	p.evict.protect(n)
+}
+
+func (p *defaultPolicy) SampleEvictions(n int, adaptive bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.evict.resizeSample(n, adaptive)
+}
+
+func (p *defaultPolicy) Penalize(key uint64, penalty time.Duration) {
+	p.Lock()
+	defer p.Unlock()
+	_, cached := p.evict.keyCosts[key]
+	p.evict.penalties.set(key, penalty, cached)
+}
+
+func (p *defaultPolicy) ResizeCounters(numCounters int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.admit.resize(numCounters)
+}
+
+func (p *defaultPolicy) ScaleResets(scale float64) {
+	p.Lock()
+	defer p.Unlock()
+	p.admit.scaleResets(scale)
+}
+
 func (p *defaultPolicy) Cap() int64 {
 	p.Lock()
 	defer p.Unlock()
 	return int64(p.evict.maxCost - p.evict.used)
 }
 
+func (p *defaultPolicy) MaxCost() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.evict.maxCost
+}
+
+func (p *defaultPolicy) SetMaxCost(maxCost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	p.evict.maxCost = maxCost
+	return p.evictN(p.evict.used - maxCost)
+}
+
 // sampledLFU is an eviction helper storing key-cost pairs.
 type sampledLFU struct {
 	keyCosts map[uint64]int64
 	maxCost  int64
 	used     int64
 	stats    *metrics
+	// admitted holds the number of keys that were admitted before every key,
+	// if keys are protected from eviction until grace more keys were admitted
+	admitted map[uint64]int64
+	admits   int64
+	grace    int64
+	// pinned holds the keys that are never evicted, if any were pinned
+	pinned map[uint64]struct{}
+	// priorities holds the priority of keys that don't have the default
+	// priority of 0
+	priorities map[uint64]int
+	// accessed holds when every key was last added or accessed, in
+	// nanoseconds, if the hits of idle keys decay every idle period
+	accessed map[uint64]int64
+	idle     time.Duration
+	clock    Clock
+	// sample is the number of keys to sample, between minSample and
+	// maxSample, which only differ if the sample adapts to the sampled keys
+	sample    int
+	minSample int
+	maxSample int
+	// samples is reused for every sample, since the policy is locked
+	samples []policyPair
+	// rng, if set, draws samples from keys, which holds every key at its
+	// position in index, instead of them being taken in the iteration
+	// order of keyCosts, which changes from run to run
+	rng   *rand.Rand
+	keys  []uint64
+	index map[uint64]int
+	// penalties, if set, holds the miss penalty of keys
+	penalties *missPenalties
 }
 
 func newSampledLFU(maxCost int64) *sampledLFU {
-	return &sampledLFU{
+	p := &sampledLFU{
 		keyCosts: make(map[uint64]int64),
 		maxCost:  maxCost,
 	}
+	p.resizeSample(DefaultEvictionSampleSize, false)
+	return p
+}
+
+func (p *sampledLFU) resizeSample(n int, adaptive bool) {
+	p.sample, p.minSample, p.maxSample = n, n, n
+	if adaptive {
+		p.maxSample = n * lfuSampleGrowth
+	}
+	p.samples = make([]policyPair, 0, p.maxSample)
+}
+
+// adapt doubles the sample size if the hits of the n sampled keys vary more
+// than their mean, since the coldest key is then likely outside of the
+// sample, and shrinks it back otherwise.
+func (p *sampledLFU) adapt(n, sum, sumSquares int64) {
+	if p.minSample == p.maxSample || n < 2 {
+		return
+	}
+	// the variance exceeds the square of the mean
+	if n*sumSquares-sum*sum > sum*sum {
+		if p.sample *= 2; p.sample > p.maxSample {
+			p.sample = p.maxSample
+		}
+	} else if p.sample > p.minSample {
+		p.sample--
+	}
 }
 
 func (p *sampledLFU) roomLeft(cost int64) int64 {
 	return p.maxCost - (p.used + cost)
 }
 
-func (p *sampledLFU) fillSample(in []*policyPair) []*policyPair {
-	if len(in) >= lfuSample {
+func (p *sampledLFU) protect(n int) {
+	p.grace = int64(n)
+	if p.admitted == nil {
+		p.admitted = make(map[uint64]int64)
+	}
+}
+
+// protected returns true if the key was admitted too recently to be evicted.
+func (p *sampledLFU) protected(key uint64) bool {
+	if p.admitted == nil {
+		return false
+	}
+	at, ok := p.admitted[key]
+	return ok && p.admits-at <= p.grace
+}
+
+// pin keeps the key from being evicted until it's deleted.
+func (p *sampledLFU) pin(key uint64) {
+	if _, ok := p.keyCosts[key]; !ok {
+		return
+	}
+	if p.pinned == nil {
+		p.pinned = make(map[uint64]struct{})
+	}
+	p.pinned[key] = struct{}{}
+}
+
+// decayIdle makes the hits of keys decay once they weren't accessed for idle.
+func (p *sampledLFU) decayIdle(idle time.Duration, clock Clock) {
+	p.idle, p.clock = idle, clock
+	p.accessed = make(map[uint64]int64, len(p.keyCosts))
+	now := p.now()
+	for key := range p.keyCosts {
+		p.accessed[key] = now
+	}
+}
+
+// now returns the current time in nanoseconds, or 0 if hits don't decay.
+func (p *sampledLFU) now() int64 {
+	if p.accessed == nil {
+		return 0
+	}
+	return p.clock.Now().UnixNano()
+}
+
+// touch records that keys were accessed, if hits decay.
+func (p *sampledLFU) touch(keys []uint64) {
+	if p.accessed == nil {
+		return
+	}
+	now := p.now()
+	for _, key := range keys {
+		if _, ok := p.accessed[key]; ok {
+			p.accessed[key] = now
+		}
+	}
+}
+
+// decay halves the hits of the key for every idle period since it was last
+// accessed.
+func (p *sampledLFU) decay(key uint64, hits int64, now int64) int64 {
+	if p.accessed == nil {
+		return hits
+	}
+	periods := (now - p.accessed[key]) / int64(p.idle)
+	if periods >= 63 {
+		return 0
+	}
+	return hits >> uint(periods)
+}
+
+// prioritize sets the priority of the key.
+func (p *sampledLFU) prioritize(key uint64, priority int) {
+	if _, ok := p.keyCosts[key]; !ok {
+		return
+	}
+	if priority == 0 {
+		delete(p.priorities, key)
+		return
+	}
+	if p.priorities == nil {
+		p.priorities = make(map[uint64]int)
+	}
+	p.priorities[key] = priority
+}
+
+// fillSample adds keys that may be evicted to in until it holds the sample
+// size. It returns in as is if every key is pinned.
+func (p *sampledLFU) fillSample(in []policyPair) []policyPair {
+	if len(in) >= p.sample {
 		return in
 	}
+	if p.rng != nil {
+		return p.drawSample(in)
+	}
+	// protected keys are only skipped so many times, so the sample isn't
+	// filled by going over every key when most of them are protected
+	skips := 4 * p.sample
 	for key, cost := range p.keyCosts {
-		in = append(in, &policyPair{key, cost})
-		if len(in) >= lfuSample {
+		if _, ok := p.pinned[key]; ok {
+			continue
+		}
+		if skips > 0 && p.protected(key) {
+			skips--
+			continue
+		}
+		in = append(in, policyPair{key, cost})
+		if len(in) >= p.sample {
+			return in
+		}
+	}
+	if len(in) > 0 || p.admitted == nil {
+		return in
+	}
+	// every key is protected, so they have to do
+	for key, cost := range p.keyCosts {
+		if _, ok := p.pinned[key]; ok {
+			continue
+		}
+		in = append(in, policyPair{key, cost})
+		if len(in) >= p.sample {
 			return in
 		}
 	}
 	return in
 }
 
+// seedSamples makes fillSample draw keys from a source seeded with seed.
+func (p *sampledLFU) seedSamples(seed int64) {
+	p.rng = rand.New(rand.NewSource(seed))
+	p.keys = make([]uint64, 0, len(p.keyCosts))
+	for key := range p.keyCosts {
+		p.keys = append(p.keys, key)
+	}
+	// keys already added are in no particular order
+	sort.Slice(p.keys, func(i, j int) bool { return p.keys[i] < p.keys[j] })
+	p.index = make(map[uint64]int, len(p.keys))
+	for i, key := range p.keys {
+		p.index[key] = i
+	}
+}
+
+// drawSample is fillSample for seeded samples: keys are drawn at random, or
+// all taken if the sample can hold them all.
+func (p *sampledLFU) drawSample(in []policyPair) []policyPair {
+	eligible := func(key uint64) bool {
+		_, pinned := p.pinned[key]
+		return !pinned && !p.protected(key) && !sampled(in, key)
+	}
+	if len(p.keys) <= p.sample {
+		for _, key := range p.keys {
+			if eligible(key) {
+				in = append(in, policyPair{key, p.keyCosts[key]})
+			}
+		}
+	} else {
+		// give up eventually if most keys are pinned or protected
+		for draws := 0; len(in) < p.sample && draws < 4*p.sample; draws++ {
+			if key := p.keys[p.rng.Intn(len(p.keys))]; eligible(key) {
+				in = append(in, policyPair{key, p.keyCosts[key]})
+			}
+		}
+	}
+	if len(in) > 0 || p.admitted == nil {
+		return in
+	}
+	// every key is protected, so they have to do
+	for _, key := range p.keys {
+		if _, ok := p.pinned[key]; ok {
+			continue
+		}
+		in = append(in, policyPair{key, p.keyCosts[key]})
+		if len(in) >= p.sample {
+			return in
+		}
+	}
+	return in
+}
+
+// sampled returns whether key is in sample.
+func sampled(sample []policyPair, key uint64) bool {
+	for _, pair := range sample {
+		if pair.key == key {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *sampledLFU) del(key uint64) {
 	cost, ok := p.keyCosts[key]
 	if !ok {
 		return
 	}
+	if p.index != nil {
+		// move the last key into the deleted key's position
+		i, last := p.index[key], p.keys[len(p.keys)-1]
+		p.keys[i], p.index[last] = last, i
+		p.keys = p.keys[:len(p.keys)-1]
+		delete(p.index, key)
+	}
 
 	p.stats.Add(keyEvict, key, 1)
 	p.stats.Add(costEvict, key, uint64(cost))
 
 	p.used -= cost
 	delete(p.keyCosts, key)
+	delete(p.admitted, key)
+	delete(p.pinned, key)
+	delete(p.priorities, key)
+	delete(p.accessed, key)
+	p.penalties.del(key)
 }
 
 func (p *sampledLFU) add(key uint64, cost int64) {
 	p.stats.Add(keyAdd, key, 1)
 	p.stats.Add(costAdd, key, uint64(cost))
 
+	if _, ok := p.index[key]; !ok && p.index != nil {
+		p.index[key] = len(p.keys)
+		p.keys = append(p.keys, key)
+	}
 	p.keyCosts[key] = cost
 	p.used += cost
+	if p.accessed != nil {
+		p.accessed[key] = p.now()
+	}
+	if p.admitted != nil {
+		p.admitted[key] = p.admits
+		p.admits++
+	}
+	p.penalties.add(key)
 }
 
-// TODO: Move this to the store itself. So, it can be used by public Set.
 func (p *sampledLFU) updateIfHas(key uint64, cost int64) (updated bool) {
 	if prev, exists := p.keyCosts[key]; exists {
 		// Update the cost of the existing key. For simplicity, don't worry about evicting anything
@@ -259,13 +909,31 @@ type tinyLFU struct {
 	door    *z.Bloom
 	incrs   int64
 	resetAt int64
+	// counters is the number of counters, which resetAt is scale times of
+	counters int64
+	scale    float64
+	// seed, if set, seeds the hashing of keys to counters, which uses the
+	// keys as they are otherwise
+	seed uint64
+	// hot, if set, tracks the most accessed keys
+	hot *spaceSaving
+	// byCost makes less compare hits per unit of cost
+	byCost bool
+	// rejections counts how many times keys were rejected since the last
+	// reset, if they're admitted after maxRejections
+	rejections    map[uint64]int
+	maxRejections int
+	// scan, if set, rejects new keys during scans
+	scan *scanDetector
 }
 
 func newTinyLFU(numCounters int64) *tinyLFU {
 	return &tinyLFU{
-		freq:    newCmSketch(numCounters),
-		door:    z.NewBloomFilter(float64(numCounters), 0.01),
-		resetAt: numCounters,
+		freq:     newCmSketch(numCounters),
+		door:     z.NewBloomFilter(float64(numCounters), 0.01),
+		resetAt:  numCounters,
+		counters: numCounters,
+		scale:    1,
 	}
 }
 
@@ -275,19 +943,76 @@ func (p *tinyLFU) Push(keys []uint64) {
 	}
 }
 
+// Estimate returns the count-min sketch estimate for the key, plus one if the
+// key is in the doorkeeper.
 func (p *tinyLFU) Estimate(key uint64) int64 {
-	hits := p.freq.Estimate(key)
-	if p.door.Has(key) {
+	hashed := p.hash(key)
+	hits := p.freq.Estimate(hashed)
+	if p.door.Has(hashed) {
 		hits += 1
 	}
 	return hits
 }
 
+// hash returns what counts the accesses of key in freq and door.
+func (p *tinyLFU) hash(key uint64) uint64 {
+	if p.seed == 0 {
+		return key
+	}
+	return z.WyHashUint64Seed(key, p.seed)
+}
+
+// less returns true if a key with hits and cost is less worth keeping than
+// a key with otherHits and otherCost.
+func (p *tinyLFU) less(hits, cost, otherHits, otherCost int64) bool {
+	if !p.byCost {
+		return hits < otherHits
+	}
+	return density(hits, cost) < density(otherHits, otherCost)
+}
+
+// density returns hits per unit of cost. Costs under 1 count as 1.
+func density(hits, cost int64) float64 {
+	if cost < 1 {
+		cost = 1
+	}
+	return float64(hits) / float64(cost)
+}
+
+// retryAfter makes reject admit keys once they were rejected n times.
+func (p *tinyLFU) retryAfter(n int) {
+	p.rejections = make(map[uint64]int)
+	p.maxRejections = n
+}
+
+// reject counts a rejection of the key, and returns true if it was rejected
+// too often to be rejected again.
+func (p *tinyLFU) reject(key uint64) bool {
+	if p.rejections == nil {
+		return false
+	}
+	n := p.rejections[key]
+	if n >= p.maxRejections {
+		delete(p.rejections, key)
+		return true
+	}
+	if int64(len(p.rejections)) >= p.resetAt {
+		// don't let keys that are only ever set once pile up
+		p.rejections = make(map[uint64]int)
+	}
+	p.rejections[key] = n + 1
+	return false
+}
+
 func (p *tinyLFU) Increment(key uint64) {
 	// flip doorkeeper bit if not already
-	if added := p.door.AddIfNotHas(key); !added {
+	hashed := p.hash(key)
+	if added := p.door.AddIfNotHas(hashed); !added {
 		// increment count-min counter if doorkeeper bit is already set.
-		p.freq.Increment(key)
+		p.freq.Increment(hashed)
+	}
+	if p.hot != nil {
+		p.hot.increment(key)
 	}
 	p.incrs++
 	if p.incrs >= p.resetAt {
@@ -295,6 +1020,65 @@ func (p *tinyLFU) Increment(key uint64) {
 	}
 }
 
+// resize replaces the counters with numCounters new ones, forgetting every
+// access so far.
+func (p *tinyLFU) resize(numCounters int64) {
+	p.freq = newCmSketch(numCounters)
+	p.door = z.NewBloomFilter(float64(numCounters), 0.01)
+	p.incrs, p.counters = 0, numCounters
+	p.scaleResets(p.scale)
+	if p.rejections != nil {
+		p.rejections = make(map[uint64]int)
+	}
+}
+
+// sketchSamples is the number of keys that were never accessed sketchStats
+// looks up, to tell by how much other keys inflate their estimates.
+const sketchSamples = 1024
+
+// sketchStats describes how accurately freq and door count.
+func (p *tinyLFU) sketchStats() SketchStats {
+	s := SketchStats{Counters: int64(p.freq.mask) + 1}
+	s.Used, s.Maxed = p.freq.saturation()
+	if p.freq.increments > 0 {
+		s.OverflowRate = float64(p.freq.overflows) / float64(p.freq.increments)
+	}
+	var hits int64
+	var collided int
+	for i := uint64(0); i < sketchSamples; i++ {
+		// hashes spread like those of seeded keys, which can't have been
+		// accessed unless one happened to hash the same
+		hashed := z.WyHashUint64(i)
+		estimate := p.freq.Estimate(hashed)
+		if p.door.Has(hashed) {
+			estimate++
+		}
+		if estimate > 0 {
+			collided++
+		}
+		hits += estimate
+	}
+	s.Overestimate = float64(hits) / sketchSamples
+	s.Collisions = float64(collided) / sketchSamples
+	return s
+}
+
+// seedHashes seeds the hashing of keys to counters, resetting them as they
+// were counted under the old seed.
+func (p *tinyLFU) seedHashes(seed uint64) {
+	p.seed = seed
+	p.resize(p.counters)
+}
+
+// scaleResets halves the counters every scale times as many increments as
+// there are counters.
+func (p *tinyLFU) scaleResets(scale float64) {
+	p.scale = scale
+	if p.resetAt = int64(float64(p.counters) * scale); p.resetAt < 1 {
+		p.resetAt = 1
+	}
+}
+
 func (p *tinyLFU) reset() {
 	// Zero out incrs.
 	p.incrs = 0
@@ -302,6 +1086,12 @@ func (p *tinyLFU) reset() {
 	p.door.Clear()
 	// halves count-min counters
 	p.freq.Reset()
+	if p.hot != nil {
+		p.hot.halve()
+	}
+	if p.rejections != nil {
+		p.rejections = make(map[uint64]int)
+	}
 }
 
 // lruPolicy is different than the default policy in that it uses exact LRU
@@ -309,7 +1099,7 @@ func (p *tinyLFU) reset() {
 // workloads (ARC-OLTP for example; LRU heavy workloads).
 //
 // TODO: - cost based eviction (multiple evictions for one new item, etc.)
-//       - sampled LRU
+//   - sampled LRU
 type lruPolicy struct {
 	sync.Mutex
 	admit   *tinyLFU
@@ -317,6 +1107,7 @@ type lruPolicy struct {
 	vals    *list.List
 	maxCost int64
 	room    int64
+	stats   *metrics
 }
 
 type lruItem struct {
@@ -325,9 +1116,9 @@ type lruItem struct {
 	cost int64
 }
 
-func newLRUPolicy(numCounters, maxCost int64) policy {
+func newLRUPolicy(numCounters, maxCost int64, opts policyOptions) policy {
 	return &lruPolicy{
-		admit:   newTinyLFU(numCounters),
+		admit:   newAdmission(numCounters, opts),
 		ptrs:    make(map[uint64]*lruItem, maxCost),
 		vals:    list.New(),
 		room:    maxCost,
@@ -357,6 +1148,7 @@ func (p *lruPolicy) Add(key uint64, cost int64) ([]*item, bool) {
 	p.Lock()
 	defer p.Unlock()
 	if cost > p.maxCost {
+		p.stats.Add(oversizeSets, key, 1)
 		return nil, false
 	}
 	if val, has := p.ptrs[key]; has {
@@ -365,21 +1157,31 @@ func (p *lruPolicy) Add(key uint64, cost int64) ([]*item, bool) {
 	}
 	victims := make([]*item, 0)
 	incHits := p.admit.Estimate(key)
+	if p.room < 0 {
+		rejected, started := p.admit.scan.rejects(incHits)
+		if started {
+			p.stats.Add(scanActivations, key, 1)
+		}
+		if rejected {
+			p.stats.Add(rejectSets, key, 1)
+			return victims, false
+		}
+	}
+	forced := false
 	for p.room < 0 {
 		lru := p.vals.Back()
 		victim := lru.Value.(*lruItem)
-		if incHits < p.admit.Estimate(victim.key) {
-			return victims, false
+		if !forced && p.admit.less(incHits, cost, p.admit.Estimate(victim.key),
+			victim.cost) {
+			if forced = p.admit.reject(key); !forced {
+				p.stats.Add(rejectSets, key, 1)
+				return victims, false
+			}
 		}
 		// delete victim from metadata
 		p.vals.Remove(victim.ptr)
 		delete(p.ptrs, victim.key)
-		victims = append(victims, &item{
-			key:  victim.key,
-			val:  nil,
-			cost: victim.cost,
-			del:  false,
-		})
+		victims = append(victims, newVictim(victim.key, victim.cost))
 		// adjust room
 		p.room += victim.cost
 	}
@@ -390,6 +1192,15 @@ func (p *lruPolicy) Add(key uint64, cost int64) ([]*item, bool) {
 	return victims, true
 }
 
+func (p *lruPolicy) Update(key uint64, cost int64) {
+	p.Lock()
+	defer p.Unlock()
+	if val, ok := p.ptrs[key]; ok {
+		p.room -= cost - val.cost
+		val.cost = cost
+	}
+}
+
 func (p *lruPolicy) Has(key uint64) bool {
 	p.Lock()
 	defer p.Unlock()
@@ -397,6 +1208,21 @@ func (p *lruPolicy) Has(key uint64) bool {
 	return has
 }
 
+func (p *lruPolicy) Cost(key uint64) (int64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if val, ok := p.ptrs[key]; ok {
+		return val.cost, true
+	}
+	return 0, false
+}
+
+func (p *lruPolicy) Estimate(key uint64) int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.Estimate(key)
+}
+
 func (p *lruPolicy) Del(key uint64) {
 	p.Lock()
 	defer p.Unlock()
@@ -406,12 +1232,394 @@ func (p *lruPolicy) Del(key uint64) {
 	}
 }
 
+// Pin does nothing, only the default policy pins keys.
+func (p *lruPolicy) Pin(key uint64) {}
+
+// Prioritize does nothing, only the default policy has priorities.
+func (p *lruPolicy) Prioritize(key uint64, priority int) {}
+
+func (p *lruPolicy) Evict(cost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	return p.evictN(cost)
+}
+
+// evictN evicts the least recently used keys until at least cost was freed.
+// The caller must hold the lock.
+func (p *lruPolicy) evictN(cost int64) []*item {
+	var victims []*item
+	for freed := int64(0); freed < cost && p.vals.Len() > 0; {
+		victim := p.vals.Back().Value.(*lruItem)
+		p.vals.Remove(victim.ptr)
+		delete(p.ptrs, victim.key)
+		p.room += victim.cost
+		victims = append(victims, newVictim(victim.key, victim.cost))
+		freed += victim.cost
+	}
+	return victims
+}
+
+func (p *lruPolicy) MaxCost() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.maxCost
+}
+
+func (p *lruPolicy) SetMaxCost(maxCost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	p.room += maxCost - p.maxCost
+	p.maxCost = maxCost
+	return p.evictN(-p.room)
+}
+
+func (p *lruPolicy) Costs() (map[uint64]int64, int64) {
+	p.Lock()
+	defer p.Unlock()
+	costs := make(map[uint64]int64, len(p.ptrs))
+	for key, val := range p.ptrs {
+		costs[key] = val.cost
+	}
+	return costs, p.maxCost - p.room
+}
+
+func (p *lruPolicy) Recount() int64 {
+	p.Lock()
+	defer p.Unlock()
+	var sum int64
+	for _, val := range p.ptrs {
+		sum += val.cost
+	}
+	drift := p.maxCost - p.room - sum
+	p.room = p.maxCost - sum
+	return drift
+}
+
+func (p *lruPolicy) Saturation() (float64, float64) {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.freq.saturation()
+}
+
+func (p *lruPolicy) SketchStats() SketchStats {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.sketchStats()
+}
+
+func (p *lruPolicy) HotKeys(n int) []KeyCount {
+	p.Lock()
+	defer p.Unlock()
+	if p.admit.hot == nil {
+		return nil
+	}
+	return p.admit.hot.top(n)
+}
+
+// Penalize does nothing, since lruPolicy doesn't track penalties.
+func (p *lruPolicy) Penalize(key uint64, penalty time.Duration) {}
+
+func (p *lruPolicy) ResizeCounters(numCounters int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.admit.resize(numCounters)
+}
+
 func (p *lruPolicy) Cap() int64 {
 	p.Lock()
 	defer p.Unlock()
-	return int64(p.vals.Len())
+	return p.room
 }
 
-// TODO
+// CollectMetrics only tracks Sets turned away by the policy, as keys are added
+// and evicted without going through the metrics.
 func (p *lruPolicy) CollectMetrics(stats *metrics) {
+	p.stats = stats
+}
+
+// gdPolicy is a GreedyDual policy [1], evicting the key with the lowest
+// priority. A key's priority is its number of hits, divided by its cost for
+// GDSF (GreedyDual-Size-Frequency), plus the cache's age. The age is the
+// priority of the last evicted key, so keys that were hot a long time ago
+// eventually make room for newer ones, like in LFUDA (LFU with Dynamic
+// Aging), which is the same policy without the division by cost.
+//
+// GreedyDual policies admit every key that fits in the cache, and are meant
+// for workloads where costs vary by orders of magnitude, like web objects.
+//
+// [1]: https://www.hpl.hp.com/techreports/98/HPL-98-173.pdf
+type gdPolicy struct {
+	sync.Mutex
+	// admit is only used to estimate the hits of keys before they're added
+	admit *tinyLFU
+	// bySize divides hits by cost, for GDSF
+	bySize bool
+	// entries is a min-heap on priority
+	entries gdHeap
+	keys    map[uint64]*gdEntry
+	age     float64
+	maxCost int64
+	used    int64
+	stats   *metrics
+}
+
+type gdEntry struct {
+	key      uint64
+	cost     int64
+	hits     int64
+	priority float64
+	// pos is the entry's position in the heap
+	pos int
+}
+
+type gdHeap []*gdEntry
+
+func (h gdHeap) Len() int           { return len(h) }
+func (h gdHeap) Less(i, j int) bool { return h[i].priority < h[j].priority }
+func (h gdHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].pos, h[j].pos = i, j
+}
+func (h *gdHeap) Push(x interface{}) {
+	e := x.(*gdEntry)
+	e.pos = len(*h)
+	*h = append(*h, e)
+}
+func (h *gdHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+func newGDSFPolicy(numCounters, maxCost int64, opts policyOptions) policy {
+	return newGDPolicy(numCounters, maxCost, true, opts)
+}
+
+func newLFUDAPolicy(numCounters, maxCost int64, opts policyOptions) policy {
+	return newGDPolicy(numCounters, maxCost, false, opts)
+}
+
+func newGDPolicy(numCounters, maxCost int64, bySize bool,
+	opts policyOptions) *gdPolicy {
+	return &gdPolicy{
+		admit:   newAdmission(numCounters, opts),
+		bySize:  bySize,
+		keys:    make(map[uint64]*gdEntry),
+		maxCost: maxCost,
+	}
+}
+
+// prioritize updates the priority of the entry after its hits or cost
+// changed.
+func (p *gdPolicy) prioritize(e *gdEntry) {
+	value := float64(e.hits)
+	if p.bySize {
+		value /= float64(e.cost)
+		if e.cost < 1 {
+			value = float64(e.hits)
+		}
+	}
+	e.priority = p.age + value
+}
+
+func (p *gdPolicy) CollectMetrics(stats *metrics) {
+	p.stats = stats
+}
+
+func (p *gdPolicy) Push(keys []uint64) bool {
+	if len(keys) == 0 {
+		return true
+	}
+	p.Lock()
+	defer p.Unlock()
+	for _, key := range keys {
+		p.admit.Increment(key)
+		if e, ok := p.keys[key]; ok {
+			e.hits++
+			p.prioritize(e)
+			heap.Fix(&p.entries, e.pos)
+		}
+	}
+	p.stats.Add(keepGets, keys[0], uint64(len(keys)))
+	return true
+}
+
+func (p *gdPolicy) Add(key uint64, cost int64) ([]*item, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if cost > p.maxCost {
+		p.stats.Add(oversizeSets, key, 1)
+		return nil, false
+	}
+	if e, ok := p.keys[key]; ok {
+		p.update(e, cost)
+		return nil, true
+	}
+	victims := p.evictN(p.used + cost - p.maxCost)
+	e := &gdEntry{key: key, cost: cost, hits: p.admit.Estimate(key)}
+	if e.hits < 1 {
+		e.hits = 1
+	}
+	p.prioritize(e)
+	heap.Push(&p.entries, e)
+	p.keys[key] = e
+	p.used += cost
+	p.stats.Add(keyAdd, key, 1)
+	p.stats.Add(costAdd, key, uint64(cost))
+	return victims, true
+}
+
+// evictN evicts the entries with the lowest priority until at least cost was
+// freed. The caller must hold the lock.
+func (p *gdPolicy) evictN(cost int64) []*item {
+	var victims []*item
+	for freed := int64(0); freed < cost && len(p.entries) > 0; {
+		victim := heap.Pop(&p.entries).(*gdEntry)
+		// age the cache, so new keys can catch up with old hot ones
+		p.age = victim.priority
+		p.remove(victim)
+		victims = append(victims, newVictim(victim.key, victim.cost))
+		freed += victim.cost
+	}
+	return victims
+}
+
+func (p *gdPolicy) Evict(cost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	return p.evictN(cost)
+}
+
+// update changes the cost of an entry.
+func (p *gdPolicy) update(e *gdEntry, cost int64) {
+	p.stats.Add(keyUpdate, e.key, 1)
+	p.used += cost - e.cost
+	e.cost = cost
+	p.prioritize(e)
+	heap.Fix(&p.entries, e.pos)
+}
+
+// remove forgets an entry that's no longer in the heap.
+func (p *gdPolicy) remove(e *gdEntry) {
+	p.stats.Add(keyEvict, e.key, 1)
+	p.stats.Add(costEvict, e.key, uint64(e.cost))
+	p.used -= e.cost
+	delete(p.keys, e.key)
+}
+
+func (p *gdPolicy) Update(key uint64, cost int64) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		p.update(e, cost)
+	}
+}
+
+func (p *gdPolicy) Has(key uint64) bool {
+	p.Lock()
+	defer p.Unlock()
+	_, has := p.keys[key]
+	return has
+}
+
+func (p *gdPolicy) Cost(key uint64) (int64, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		return e.cost, true
+	}
+	return 0, false
+}
+
+func (p *gdPolicy) Estimate(key uint64) int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.Estimate(key)
+}
+
+func (p *gdPolicy) Del(key uint64) {
+	p.Lock()
+	defer p.Unlock()
+	if e, ok := p.keys[key]; ok {
+		heap.Remove(&p.entries, e.pos)
+		p.remove(e)
+	}
+}
+
+// Pin does nothing, only the default policy pins keys.
+func (p *gdPolicy) Pin(key uint64) {}
+
+// Prioritize does nothing, only the default policy has priorities.
+func (p *gdPolicy) Prioritize(key uint64, priority int) {}
+
+func (p *gdPolicy) Costs() (map[uint64]int64, int64) {
+	p.Lock()
+	defer p.Unlock()
+	costs := make(map[uint64]int64, len(p.keys))
+	for key, e := range p.keys {
+		costs[key] = e.cost
+	}
+	return costs, p.used
+}
+
+func (p *gdPolicy) Recount() int64 {
+	p.Lock()
+	defer p.Unlock()
+	var sum int64
+	for _, e := range p.keys {
+		sum += e.cost
+	}
+	drift := p.used - sum
+	p.used = sum
+	return drift
+}
+
+func (p *gdPolicy) Saturation() (float64, float64) {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.freq.saturation()
+}
+
+func (p *gdPolicy) SketchStats() SketchStats {
+	p.Lock()
+	defer p.Unlock()
+	return p.admit.sketchStats()
+}
+
+func (p *gdPolicy) HotKeys(n int) []KeyCount {
+	p.Lock()
+	defer p.Unlock()
+	if p.admit.hot == nil {
+		return nil
+	}
+	return p.admit.hot.top(n)
+}
+
+// Penalize does nothing, since GreedyDual policies don't track penalties.
+func (p *gdPolicy) Penalize(key uint64, penalty time.Duration) {}
+
+func (p *gdPolicy) ResizeCounters(numCounters int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.admit.resize(numCounters)
+}
+
+func (p *gdPolicy) Cap() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.maxCost - p.used
+}
+
+func (p *gdPolicy) MaxCost() int64 {
+	p.Lock()
+	defer p.Unlock()
+	return p.maxCost
+}
+
+func (p *gdPolicy) SetMaxCost(maxCost int64) []*item {
+	p.Lock()
+	defer p.Unlock()
+	p.maxCost = maxCost
+	return p.evictN(p.used - maxCost)
 }
@@ -18,12 +18,13 @@ package ristretto
 
 import (
 	"testing"
+	"time"
 )
 
-func GeneratePolicyTest(p func(int64, int64) policy) func(*testing.T) {
+func GeneratePolicyTest(p func(int64, int64, policyOptions) policy) func(*testing.T) {
 	return func(t *testing.T) {
 		t.Run("uniform-push", func(t *testing.T) {
-			policy := p(1024, 1024)
+			policy := p(1024, 1024, policyOptions{})
 			values := make([]uint64, 1024)
 			for i := range values {
 				values[i] = uint64(i)
@@ -35,7 +36,7 @@ func GeneratePolicyTest(p func(int64, int64) policy) func(*testing.T) {
 			}
 		})
 		t.Run("uniform-add", func(t *testing.T) {
-			policy := p(1024, 1024)
+			policy := p(1024, 1024, policyOptions{})
 			for i := int64(0); i < 1024; i++ {
 				policy.Add(uint64(i), 1)
 			}
@@ -44,7 +45,7 @@ func GeneratePolicyTest(p func(int64, int64) policy) func(*testing.T) {
 			}
 		})
 		t.Run("variable-push", func(t *testing.T) {
-			policy := p(1024, 1024*4)
+			policy := p(1024, 1024*4, policyOptions{})
 			values := make([]uint64, 1024)
 			for i := range values {
 				values[i] = uint64(i)
@@ -56,7 +57,7 @@ func GeneratePolicyTest(p func(int64, int64) policy) func(*testing.T) {
 			}
 		})
 		t.Run("variable-add", func(t *testing.T) {
-			policy := p(1024, 1024*4)
+			policy := p(1024, 1024*4, policyOptions{})
 			for i := int64(0); i < 1024; i++ {
 				policy.Add(uint64(i), 4)
 			}
@@ -74,3 +75,323 @@ func TestLFUPolicy(t *testing.T) {
 func TestLRUPolicy(t *testing.T) {
 	GeneratePolicyTest(newLRUPolicy)(t)
 }
+
+func TestGDSFPolicy(t *testing.T) {
+	GeneratePolicyTest(newGDSFPolicy)(t)
+}
+
+func TestLFUDAPolicy(t *testing.T) {
+	GeneratePolicyTest(newLFUDAPolicy)(t)
+}
+
+func TestGDPolicyEviction(t *testing.T) {
+	for _, bySize := range []bool{false, true} {
+		p := newGDPolicy(100, 10, bySize, policyOptions{})
+		// a cheap and an expensive key, the expensive one accessed more
+		p.Add(1, 1)
+		p.Add(2, 5)
+		p.Push([]uint64{1, 2, 2})
+		// making room for the new key evicts one of them
+		victims, added := p.Add(3, 5)
+		if !added || len(victims) != 1 {
+			t.Fatalf("bySize=%v: expected a single victim\n", bySize)
+		}
+		// GDSF values the cheap key's hits per unit of cost, LFUDA the
+		// expensive key's hits
+		want := uint64(1)
+		if bySize {
+			want = 2
+		}
+		if victims[0].key != want {
+			t.Fatalf("bySize=%v: expected key %d to be evicted, not %d\n",
+				bySize, want, victims[0].key)
+		}
+		if p.age == 0 {
+			t.Fatal("evictions should age the cache")
+		}
+		if _, added := p.Add(4, 11); added {
+			t.Fatal("keys costing more than MaxCost shouldn't be added")
+		}
+	}
+}
+
+func TestPolicyCostAwareAdmission(t *testing.T) {
+	for _, create := range []func(int64, int64, policyOptions) policy{
+		newSyncPolicy, newLRUPolicy,
+	} {
+		for _, byCost := range []bool{false, true} {
+			p := create(100, 10, policyOptions{byCost: byCost})
+			// cheap keys filling the cache, accessed twice each (lruPolicy
+			// only evicts once it overflows)
+			for key := uint64(0); key < 11; key++ {
+				p.Add(key, 1)
+				p.Push([]uint64{key, key})
+			}
+			// an expensive key accessed a little more often
+			p.Push([]uint64{100, 100, 100})
+			victims, added := p.Add(100, 10)
+			if added == byCost {
+				t.Fatalf("%T byCost=%v: unexpected admission decision\n", p, byCost)
+			}
+			if byCost && len(victims) != 0 {
+				t.Fatal("rejected keys shouldn't evict anything")
+			}
+		}
+	}
+}
+
+func TestPolicyAdmitAfterRejections(t *testing.T) {
+	for _, create := range []func(int64, int64, policyOptions) policy{
+		newSyncPolicy, newLRUPolicy,
+	} {
+		p := create(100, 10, policyOptions{admitAfter: 3})
+		stats := newMetrics()
+		p.CollectMetrics(stats)
+		// hot keys filling the cache (lruPolicy only evicts once it
+		// overflows)
+		for key := uint64(0); key < 11; key++ {
+			p.Add(key, 1)
+			p.Push([]uint64{key, key, key})
+		}
+		filled := stats.SetsRejected()
+		for i := 0; i < 3; i++ {
+			if _, added := p.Add(100, 1); added {
+				t.Fatalf("%T: key was admitted after %d rejections\n", p, i)
+			}
+		}
+		if _, added := p.Add(100, 1); !added {
+			t.Fatalf("%T: key should be admitted after 3 rejections\n", p)
+		}
+		if rejected := stats.SetsRejected() - filled; rejected != 3 {
+			t.Fatalf("%T: expected 3 rejected Sets but got %d\n", p, rejected)
+		}
+		if !p.Has(100) {
+			t.Fatalf("%T: admitted key is missing\n", p)
+		}
+	}
+}
+
+func TestPolicyProtectNewKeys(t *testing.T) {
+	p := newSyncPolicy(100, 10, policyOptions{grace: 3})
+	for key := uint64(0); key < 10; key++ {
+		p.Add(key, 1)
+	}
+	victims := p.Evict(7)
+	if len(victims) != 7 {
+		t.Fatalf("expected 7 victims but got %d\n", len(victims))
+	}
+	for _, victim := range victims {
+		if victim.key >= 7 {
+			t.Fatalf("key %d was evicted during its grace period\n", victim.key)
+		}
+	}
+	// only protected keys are left, so they have to go
+	if victims := p.Evict(1); len(victims) != 1 {
+		t.Fatal("protected keys should be evicted if nothing else is left")
+	}
+	// deleted keys are forgotten
+	for key := uint64(7); key < 10; key++ {
+		p.Del(key)
+	}
+	if len(p.(*defaultPolicy).evict.admitted) != 0 {
+		t.Fatal("evicted and deleted keys should be forgotten")
+	}
+}
+
+func TestPolicyProtectMostKeys(t *testing.T) {
+	p := newSyncPolicy(100000, 20000, policyOptions{grace: 9900})
+	for key := uint64(0); key < 10000; key++ {
+		p.Add(key, 1)
+	}
+	// only the first 100 keys are past their grace period, but sampling
+	// doesn't go over every key to find them
+	protected := 0
+	for i := 0; i < 50; i++ {
+		for _, victim := range p.Evict(1) {
+			if victim.key >= 200 {
+				protected++
+			}
+		}
+	}
+	if protected == 0 {
+		t.Fatal("protected keys should be sampled if most keys are protected")
+	}
+}
+
+func TestPolicySampleEvictions(t *testing.T) {
+	p := newSyncPolicy(1000, 100, policyOptions{sampleSize: 100})
+	for key := uint64(0); key < 100; key++ {
+		p.Add(key, 1)
+		if key != 42 {
+			p.Push([]uint64{key, key})
+		}
+	}
+	// sampling every key always finds the coldest one
+	if victims := p.Evict(1); len(victims) != 1 || victims[0].key != 42 {
+		t.Fatal("expected the only cold key to be evicted")
+	}
+
+	evict := newSampledLFU(10)
+	evict.resizeSample(2, true)
+	// a cold key among hot ones doubles the sample, up to 4 times its size
+	for _, want := range []int{4, 8, 8} {
+		if evict.adapt(4, 12, 144); evict.sample != want {
+			t.Fatalf("expected a sample of %d but got %d\n", want, evict.sample)
+		}
+	}
+	// keys that are alike shrink it back
+	for _, want := range []int{7, 6, 5, 4, 3, 2, 2} {
+		if evict.adapt(4, 8, 16); evict.sample != want {
+			t.Fatalf("expected a sample of %d but got %d\n", want, evict.sample)
+		}
+	}
+	// fixed samples don't adapt
+	evict.resizeSample(2, false)
+	if evict.adapt(4, 12, 144); evict.sample != 2 {
+		t.Fatal("fixed samples shouldn't grow")
+	}
+}
+
+func TestPolicyAdmitWhileFilling(t *testing.T) {
+	for _, fill := range []bool{false, true} {
+		p := newSyncPolicy(100, 10, policyOptions{fill: fill})
+		for key := uint64(0); key < 9; key++ {
+			p.Add(key, 1)
+			p.Push([]uint64{key, key})
+		}
+		// the cache isn't full, but the key doesn't fit
+		if _, added := p.Add(100, 2); added != fill {
+			t.Fatalf("fill=%v: unexpected admission decision\n", fill)
+		}
+		if !fill {
+			continue
+		}
+		// a full cache consults admission again (the admitted key mustn't
+		// be the coldest)
+		p.Push([]uint64{100, 100, 100})
+		if _, added := p.Add(101, 1); added {
+			t.Fatal("keys shouldn't be admitted once the cache is full")
+		}
+	}
+}
+
+func TestPolicyPriorities(t *testing.T) {
+	p := newSyncPolicy(100, 10, policyOptions{sampleSize: 10})
+	// cold keys of a high priority, and hot ones of the default priority
+	for key := uint64(0); key < 10; key++ {
+		p.Add(key, 1)
+		if key < 5 {
+			p.Prioritize(key, 2)
+		} else {
+			p.Push([]uint64{key, key, key})
+		}
+	}
+	p.Prioritize(0, 1)
+	victims := p.Evict(6)
+	if len(victims) != 6 {
+		t.Fatalf("expected 6 victims but got %d\n", len(victims))
+	}
+	for i, victim := range victims {
+		if i < 5 && victim.key < 5 {
+			t.Fatalf("key %d was evicted before lower priorities\n", victim.key)
+		}
+	}
+	// the lowest of the remaining priorities goes next
+	if victims[5].key != 0 {
+		t.Fatalf("expected key 0 to be evicted but got %d\n", victims[5].key)
+	}
+	// deleted keys are forgotten
+	for key := uint64(1); key < 5; key++ {
+		p.Del(key)
+	}
+	if len(p.(*defaultPolicy).evict.priorities) != 0 {
+		t.Fatal("evicted and deleted keys should be forgotten")
+	}
+}
+
+func TestPolicyIdleDecay(t *testing.T) {
+	for _, decay := range []bool{false, true} {
+		clock := NewManualClock(time.Unix(0, 0))
+		opts := policyOptions{sampleSize: 10}
+		if decay {
+			opts.idleDecay, opts.clock = time.Second, clock
+		}
+		p := newSyncPolicy(100, 10, opts)
+		for key := uint64(0); key < 10; key++ {
+			p.Add(key, 1)
+			p.Push([]uint64{key, key})
+		}
+		// key 0 was hot, but went idle
+		for i := 0; i < 8; i++ {
+			p.Push([]uint64{0})
+		}
+		clock.Advance(3 * time.Second)
+		for key := uint64(1); key < 10; key++ {
+			p.Push([]uint64{key})
+		}
+		victims := p.Evict(1)
+		if len(victims) != 1 || (victims[0].key == 0) != decay {
+			t.Fatalf("decay=%v: unexpected victims %v\n", decay, victims)
+		}
+	}
+}
+
+func TestTinyLFUSeedHashes(t *testing.T) {
+	// keys differing only in bits that cmSketch and the doorkeeper
+	// multiply away, so they all share counters unless seeded
+	adversarial := func(i int) uint64 { return uint64(i) << 42 }
+	for _, seed := range []uint64{0, 42} {
+		p := newTinyLFU(1024)
+		p.seedHashes(seed)
+		for i := 1; i <= 100; i++ {
+			p.Increment(adversarial(i))
+			p.Increment(adversarial(i))
+		}
+		// a key that was never accessed
+		hits := p.Estimate(adversarial(1000))
+		if seed == 0 && hits < 15 {
+			t.Fatalf("expected colliding keys to saturate the counters, got %d\n", hits)
+		}
+		if seed != 0 && hits > 1 {
+			t.Fatalf("seeded keys shouldn't collide, got %d hits\n", hits)
+		}
+	}
+}
+
+func TestPolicySeedSamples(t *testing.T) {
+	p := newSyncPolicy(1000, 100,
+		policyOptions{sampleSeed: 1, grace: 10}).(*defaultPolicy)
+	for key := uint64(0); key < 100; key++ {
+		p.Add(key, 1)
+	}
+	p.Pin(0)
+	// deleted keys leave the keys sampled from
+	for key := uint64(1); key < 50; key++ {
+		p.Del(key)
+	}
+	if len(p.evict.keys) != 51 || len(p.evict.index) != 51 {
+		t.Fatalf("expected 51 keys to sample but got %d\n", len(p.evict.keys))
+	}
+	for i, key := range p.evict.keys {
+		if p.evict.index[key] != i {
+			t.Fatalf("key %d isn't at its index\n", key)
+		}
+	}
+	sample := p.evict.fillSample(nil)
+	if len(sample) != DefaultEvictionSampleSize {
+		t.Fatalf("expected a full sample but got %v\n", sample)
+	}
+	for i, pair := range sample {
+		if pair.key == 0 || pair.key >= 90 {
+			t.Fatalf("pinned and protected keys shouldn't be sampled: %v\n", sample)
+		}
+		if sampled(sample[:i], pair.key) {
+			t.Fatalf("keys shouldn't be sampled twice: %v\n", sample)
+		}
+	}
+	// once only protected keys are left, they're sampled
+	p.Evict(40)
+	if victims := p.Evict(1); len(victims) != 1 || victims[0].key < 90 {
+		t.Fatalf("expected a protected key to be evicted, got %v\n", victims)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "sync/atomic"
+
+// PopularityDecile describes the Gets of a tenth of all Gets, ranked by the
+// estimated access frequency of their keys.
+type PopularityDecile struct {
+	// MinHits and MaxHits bound the access frequency of the keys, as
+	// estimated by the policy when they were read.
+	MinHits int64 `json:"minHits"`
+	MaxHits int64 `json:"maxHits"`
+	// Gets and Hits are the number of Gets and of those that found the key.
+	// Gets of keys as popular as the keys of the next decile are split
+	// between them.
+	Gets uint64 `json:"gets"`
+	Hits uint64 `json:"hits"`
+	// HitRatio is Hits divided by Gets.
+	HitRatio float64 `json:"hitRatio"`
+}
+
+// popularityStats counts Gets and hits by the estimated access frequency of
+// their key, for Config.PopularityDeciles. Like victimStats, the counters
+// aren't padded, since tracking them is meant for analysis.
+type popularityStats struct {
+	gets [maxHits + 1]uint64
+	hits [maxHits + 1]uint64
+}
+
+func (s *popularityStats) add(estimate int64, hit bool) {
+	if estimate > maxHits {
+		estimate = maxHits
+	}
+	atomic.AddUint64(&s.gets[estimate], 1)
+	if hit {
+		atomic.AddUint64(&s.hits[estimate], 1)
+	}
+}
+
+// reset zeroes the counters.
+func (s *popularityStats) reset() {
+	for i := range s.gets {
+		atomic.StoreUint64(&s.gets[i], 0)
+		atomic.StoreUint64(&s.hits[i], 0)
+	}
+}
+
+// swap zeroes the counters, and returns their values until then.
+func (s *popularityStats) swap() *popularityStats {
+	old := &popularityStats{}
+	for i := range s.gets {
+		old.gets[i] = atomic.SwapUint64(&s.gets[i], 0)
+		old.hits[i] = atomic.SwapUint64(&s.hits[i], 0)
+	}
+	return old
+}
+
+// trackPopularity makes p count Gets by the popularity of their key.
+func (p *metrics) trackPopularity() {
+	p.popularity = &popularityStats{}
+}
+
+// PopularityDeciles returns the hit ratio of Gets by the popularity of their
+// key, the most popular tenth of Gets first, or nil if Config.PopularityDeciles
+// isn't set. Misses are expected to pile up in the last deciles, the long tail
+// of keys read too rarely to be worth caching; misses in the first deciles
+// mean popular keys don't stay cached, because the cache is too small or the
+// policy evicts the wrong keys.
+func (p *metrics) PopularityDeciles() []PopularityDecile {
+	if p == nil || p.popularity == nil {
+		return nil
+	}
+	var gets, hits [maxHits + 1]float64
+	var total float64
+	for i := range gets {
+		gets[i] = float64(atomic.LoadUint64(&p.popularity.gets[i]))
+		hits[i] = float64(atomic.LoadUint64(&p.popularity.hits[i]))
+		total += gets[i]
+	}
+	deciles := make([]PopularityDecile, 10)
+	if total == 0 {
+		return deciles
+	}
+	// walk the frequencies down, filling every decile with a tenth of the
+	// Gets, and splitting the Gets of a frequency across deciles if needed
+	size := total / 10
+	var decileGets, decileHits float64
+	d := 0
+	closeDecile := func() {
+		deciles[d].Gets = uint64(decileGets + 0.5)
+		deciles[d].Hits = uint64(decileHits + 0.5)
+		deciles[d].HitRatio = decileHits / decileGets
+		decileGets, decileHits = 0, 0
+		d++
+	}
+	for i := maxHits; i >= 0; i-- {
+		for gets[i] > 0 {
+			if decileGets == 0 {
+				deciles[d].MaxHits = int64(i)
+			}
+			deciles[d].MinHits = int64(i)
+			take := gets[i]
+			if last := d == len(deciles)-1; !last && take > size-decileGets {
+				take = size - decileGets
+			}
+			taken := hits[i] * take / gets[i]
+			decileGets, decileHits = decileGets+take, decileHits+taken
+			gets[i], hits[i] = gets[i]-take, hits[i]-taken
+			// allow for rounding errors
+			if d < len(deciles)-1 && decileGets >= size*(1-1e-9) {
+				closeDecile()
+			}
+		}
+	}
+	if decileGets > 0 {
+		closeDecile()
+	}
+	return deciles
+}
+
+// countPopularity counts a Get of the key by its popularity, if
+// Config.PopularityDeciles is set.
+func (c *Cache) countPopularity(hash uint64, hit bool) {
+	if c.stats == nil || c.stats.popularity == nil {
+		return
+	}
+	c.stats.popularity.add(c.policy.Estimate(hash), hit)
+}
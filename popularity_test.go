@@ -0,0 +1,94 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMetricsPopularityDeciles(t *testing.T) {
+	m := newMetrics()
+	if m.PopularityDeciles() != nil {
+		t.Fatal("popularity shouldn't be tracked by default")
+	}
+	m.trackPopularity()
+	// 15 Gets of popular keys that hit, and 85 Gets of rare ones that miss
+	for i := 0; i < 100; i++ {
+		if i < 15 {
+			m.popularity.add(5, true)
+		} else {
+			m.popularity.add(2, false)
+		}
+	}
+	deciles := m.PopularityDeciles()
+	if len(deciles) != 10 {
+		t.Fatalf("expected 10 deciles but got %d\n", len(deciles))
+	}
+	want := []PopularityDecile{
+		{MinHits: 5, MaxHits: 5, Gets: 10, Hits: 10, HitRatio: 1},
+		{MinHits: 2, MaxHits: 5, Gets: 10, Hits: 5, HitRatio: 0.5},
+		{MinHits: 2, MaxHits: 2, Gets: 10},
+	}
+	for i, w := range want {
+		if deciles[i] != w {
+			t.Fatalf("expected decile %d to be %+v but got %+v\n", i, w, deciles[i])
+		}
+	}
+	if last := deciles[9]; last.Gets != 10 || last.Hits != 0 {
+		t.Fatalf("unexpected last decile %+v\n", last)
+	}
+	old := m.Swap()
+	if old.PopularityDeciles()[0].Gets != 10 || m.PopularityDeciles()[0].Gets != 0 {
+		t.Fatal("Swap should zero the popularity counters")
+	}
+}
+
+func TestCachePopularityDeciles(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+		PopularityDeciles: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	cache.Get(1)
+	cache.Get(1)
+	cache.Metrics().Swap()
+	// the cached key is read often, and missing ones once each
+	for i := 0; i < 10; i++ {
+		cache.Get(1)
+		cache.Get(100 + i)
+	}
+	deciles := cache.Metrics().PopularityDeciles()
+	if deciles[0].HitRatio != 1 || deciles[9].HitRatio != 0 {
+		t.Fatalf("misses should be in the tail, got %+v\n", deciles)
+	}
+	var buf bytes.Buffer
+	if err := cache.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"popularityDeciles": [`) {
+		t.Fatal("Dump should report the deciles")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// PrefixedCache is a view of a Cache with its own keyspace. Keys are hashed
+// together with the prefix, so the same key used through different prefixes,
+// or through the Cache itself, refers to different values. All views share
+// the memory, policy and eviction of the underlying Cache.
+type PrefixedCache struct {
+	cache *Cache
+	seed  uint64
+	stats *metrics
+}
+
+// WithPrefix returns a view of the cache whose keys are namespaced by prefix.
+// If Config.Metrics is set, the view counts its own hits and misses, on top
+// of those counted by the cache, so every part of an application sharing a
+// cache can tell how well the cache serves it.
+func (c *Cache) WithPrefix(prefix string) *PrefixedCache {
+	if c == nil {
+		return nil
+	}
+	p := &PrefixedCache{cache: c, seed: z.XXH3String(prefix)}
+	if c.stats != nil {
+		p.stats = newMetrics()
+	}
+	return p
+}
+
+// hash mixes the prefix into the key hash.
+func (p *PrefixedCache) hash(key interface{}) uint64 {
+	return z.XXH3Uint64(p.cache.keyToHash(key) ^ p.seed)
+}
+
+// Get works like Cache.Get for a key in the view's keyspace.
+func (p *PrefixedCache) Get(key interface{}) (interface{}, bool) {
+	if p == nil {
+		return nil, false
+	}
+	hash := p.hash(noescape(key))
+	val, ok := p.cache.get(hash)
+	if ok {
+		p.stats.Add(hit, hash, 1)
+	} else {
+		p.stats.Add(miss, hash, 1)
+	}
+	return val, ok
+}
+
+// Set works like Cache.Set for a key in the view's keyspace.
+func (p *PrefixedCache) Set(key, val interface{}, cost int64) bool {
+	if p == nil {
+		return false
+	}
+	return p.cache.set(p.hash(noescape(key)), val, cost, setOptions{}, nil)
+}
+
+// SetWithTTL works like Cache.SetWithTTL for a key in the view's keyspace.
+func (p *PrefixedCache) SetWithTTL(key, val interface{}, cost int64,
+	ttl time.Duration) bool {
+	if p == nil || ttl < 0 {
+		return false
+	}
+	return p.cache.set(p.hash(key), val, cost,
+		setOptions{exp: expiry{ttl: ttl}, expires: true}, nil)
+}
+
+// Del works like Cache.Del for a key in the view's keyspace.
+func (p *PrefixedCache) Del(key interface{}) {
+	if p == nil {
+		return
+	}
+	hash := p.hash(key)
+	p.cache.del(hash, p.cache.nextVersion())
+	p.cache.publish(hash)
+}
+
+// Metrics returns the hits and misses of Gets through the view, or nil if
+// Config.Metrics isn't set. Other statistics are only kept by the Cache.
+func (p *PrefixedCache) Metrics() *metrics {
+	if p == nil {
+		return nil
+	}
+	return p.stats
+}
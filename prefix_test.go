@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+)
+
+func TestCacheWithPrefix(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	users, posts := cache.WithPrefix("users"), cache.WithPrefix("posts")
+	cache.Set(1, "raw", 1)
+	users.Set(1, "user", 1)
+	posts.Set(1, "post", 1)
+	for view, want := range map[interface {
+		Get(interface{}) (interface{}, bool)
+	}]string{cache: "raw", users: "user", posts: "post"} {
+		if val, ok := view.Get(1); !ok || val != want {
+			t.Fatalf("expected %s but got %v\n", want, val)
+		}
+	}
+	if _, ok := users.Get(2); ok {
+		t.Fatal("key 2 was never set")
+	}
+	if users.Metrics().Get(hit) != 1 || users.Metrics().Get(miss) != 1 {
+		t.Fatalf("unexpected view metrics: %s\n", users.Metrics())
+	}
+	if posts.Metrics().Ratio() != 1 {
+		t.Fatal("posts should only have hits")
+	}
+	if cache.Metrics().Get(hit) != 3 || cache.Metrics().Get(miss) != 1 {
+		t.Fatal("the cache should count the gets of all views")
+	}
+	users.Del(1)
+	if _, ok := users.Get(1); ok {
+		t.Fatal("key 1 should have been deleted")
+	}
+	if _, ok := posts.Get(1); !ok {
+		t.Fatal("deletes shouldn't leak into other views")
+	}
+	// views of the same prefix share their keys
+	if val, _ := cache.WithPrefix("posts").Get(1); val != "post" {
+		t.Fatal("views of the same prefix should share keys")
+	}
+}
+
+func TestCacheWithPrefixNoMetrics(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+	})
+	if err != nil {
+		panic(err)
+	}
+	view := cache.WithPrefix("a")
+	if view.Metrics() != nil {
+		t.Fatal("views shouldn't keep metrics unless the cache does")
+	}
+	view.Get(1)
+	var nilCache *Cache
+	if nilCache.WithPrefix("a").Set(1, 1, 1) {
+		t.Fatal("a nil view shouldn't accept sets")
+	}
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "math"
+
+// Reconciliation describes what Reconcile corrected.
+type Reconciliation struct {
+	// Drift is by how much the total cost the policy accounted for was off
+	// from the sum of its keys' costs. It's positive if the cache thought it
+	// held more than it did.
+	Drift int64 `json:"drift"`
+	// Forgotten is the number of keys the policy still accounted for though
+	// they were gone from the cache, and ForgottenCost their cost.
+	Forgotten     int   `json:"forgotten"`
+	ForgottenCost int64 `json:"forgottenCost"`
+	// Dropped is the number of cached keys the policy didn't know about,
+	// which were deleted since their cost is unknown.
+	Dropped int `json:"dropped"`
+}
+
+// Reconcile walks the cache and corrects the policy's cost accounting where
+// it diverged from the cache's contents: the total cost is recomputed, keys
+// the policy holds on to though they left the cache are forgotten, and
+// cached keys the policy doesn't know about are deleted. Corrections are
+// counted by the cost-reconciled and keys-reconciled metrics. Sets and Dels
+// wait while it runs, which takes time proportional to the number of keys.
+// See Config.ReconcileInterval to run it periodically.
+func (c *Cache) Reconcile() Reconciliation {
+	if c == nil {
+		return Reconciliation{}
+	}
+	c.lockAll()
+	defer c.unlockAll()
+	return c.reconcile()
+}
+
+// reconcile is Reconcile. The caller must hold every lock in processMu.
+func (c *Cache) reconcile() Reconciliation {
+	c.reconciledAt = c.clock.Now()
+	var r Reconciliation
+	costs, _ := c.policy.Costs()
+	var unknown []uint64
+	c.store.Range(func(key uint64, _ interface{}) bool {
+		if _, ok := costs[key]; ok {
+			delete(costs, key)
+		} else {
+			unknown = append(unknown, key)
+		}
+		return true
+	})
+	// costs is left with the keys missing from the store
+	for key, cost := range costs {
+		c.policy.Del(key)
+		c.died(key)
+		c.tags.untag(key)
+		r.Forgotten++
+		r.ForgottenCost += cost
+	}
+	for _, key := range unknown {
+		if val, _, ok := c.store.Del(key, math.MaxUint64); ok {
+			c.exit(val)
+		}
+		c.died(key)
+		c.tags.untag(key)
+		r.Dropped++
+	}
+	r.Drift = c.policy.Recount()
+	drift := r.Drift
+	if drift < 0 {
+		drift = -drift
+	}
+	c.stats.Add(reconciledCost, 0, uint64(drift+r.ForgottenCost))
+	c.stats.Add(reconciledKeys, 0, uint64(r.Forgotten+r.Dropped))
+	if r != (Reconciliation{}) {
+		c.logf("reconciled cost accounting: drift %d, forgot %d keys "+
+			"costing %d, dropped %d keys", r.Drift, r.Forgotten,
+			r.ForgottenCost, r.Dropped)
+	}
+	return r
+}
+
+// reconcileIfDue runs reconcile if Config.ReconcileInterval passed since it
+// last ran. The caller must hold every lock in processMu.
+func (c *Cache) reconcileIfDue() {
+	interval := c.config.ReconcileInterval
+	if interval > 0 && c.clock.Now().Sub(c.reconciledAt) >= interval {
+		c.reconcile()
+	}
+}
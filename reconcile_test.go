@@ -0,0 +1,93 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCacheReconcile(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           100,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	for key := uint64(1); key <= 5; key++ {
+		cache.Set(key, key, 2)
+	}
+	// corrupt the accounting: a drifted total, a key the policy holds on to
+	// and a key it doesn't know about
+	p := cache.policy.(*defaultPolicy)
+	p.evict.used += 7
+	cache.store.Del(1, math.MaxUint64)
+	p.Del(2)
+	if r := cache.Reconcile(); r != (Reconciliation{
+		Drift: 7, Forgotten: 1, ForgottenCost: 2, Dropped: 1}) {
+		t.Fatalf("unexpected reconciliation %+v\n", r)
+	}
+	cache.lockAll()
+	cache.checkInvariants()
+	cache.unlockAll()
+	if _, ok := cache.Get(2); ok {
+		t.Fatal("keys unknown to the policy should be deleted")
+	}
+	if used := cache.policy.MaxCost() - cache.policy.Cap(); used != 6 {
+		t.Fatalf("expected a cost of 6 but got %d\n", used)
+	}
+	stats := cache.Metrics()
+	if stats.Get(reconciledCost) != 9 || stats.Get(reconciledKeys) != 2 {
+		t.Fatal("reconciliations should be counted")
+	}
+	if r := cache.Reconcile(); r != (Reconciliation{}) {
+		t.Fatalf("nothing should be left to reconcile, got %+v\n", r)
+	}
+}
+
+func TestCacheReconcileInterval(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           100,
+		BufferItems:       64,
+		Clock:             clock,
+		ReconcileInterval: time.Minute,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	cache.lockAll()
+	cache.policy.(*defaultPolicy).evict.used += 7
+	cache.reconcileIfDue()
+	if cache.policy.Cap() != 93 {
+		t.Fatal("reconciliation shouldn't run before it's due")
+	}
+	clock.Advance(time.Minute)
+	cache.reconcileIfDue()
+	cache.unlockAll()
+	if cache.policy.Cap() != 100 {
+		t.Fatal("reconciliation should run once it's due")
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// registry holds every named cache that hasn't been closed yet.
+var registry = struct {
+	sync.Mutex
+	caches map[string]*Cache
+}{caches: make(map[string]*Cache)}
+
+// NewNamedCache is like NewCache, but also registers the cache under name so
+// it's listed by NamedCaches until it's closed. Names must be unique among
+// the caches that are still open.
+func NewNamedCache(name string, config *Config) (*Cache, error) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.caches[name]; ok {
+		return nil, fmt.Errorf("a cache named %q already exists", name)
+	}
+	cache, err := NewCache(config)
+	if err != nil {
+		return nil, err
+	}
+	cache.name = name
+	registry.caches[name] = cache
+	return cache, nil
+}
+
+// NamedCaches returns every cache created by NewNamedCache that hasn't been
+// closed, sorted by name, so diagnostics can report on all the caches in the
+// process.
+func NamedCaches() []*Cache {
+	registry.Lock()
+	defer registry.Unlock()
+	caches := make([]*Cache, 0, len(registry.caches))
+	for _, cache := range registry.caches {
+		caches = append(caches, cache)
+	}
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].name < caches[j].name
+	})
+	return caches
+}
+
+// unregister removes the cache from the registry, if it's there.
+func (c *Cache) unregister() {
+	if c.name == "" {
+		return
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.caches[c.name] == c {
+		delete(registry.caches, c.name)
+	}
+}
+
+// Name returns the name the cache was created with by NewNamedCache, or an
+// empty string.
+func (c *Cache) Name() string {
+	if c == nil {
+		return ""
+	}
+	return c.name
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+)
+
+func newNamedCache(name string) (*Cache, error) {
+	return NewNamedCache(name, &Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+	})
+}
+
+func TestNamedCaches(t *testing.T) {
+	b, err := newNamedCache("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	a, err := newNamedCache("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Name() != "a" {
+		t.Fatal("name wasn't set")
+	}
+	if _, err := newNamedCache("a"); err == nil {
+		t.Fatal("duplicate names should be rejected")
+	}
+	if caches := NamedCaches(); len(caches) != 2 || caches[0] != a || caches[1] != b {
+		t.Fatal("expected caches a and b, sorted by name")
+	}
+	a.Close()
+	if caches := NamedCaches(); len(caches) != 1 || caches[0] != b {
+		t.Fatal("closed caches should be unregistered")
+	}
+	// the name can be reused once it's free
+	if a, err = newNamedCache("a"); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+}
@@ -20,15 +20,23 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/dgraph-io/ristretto/z"
 )
 
 const (
 	ringLossy byte = iota
 	ringLossless
+	// ringStriped has a fixed number of stripes like ringLossless, and pushes
+	// to the stripe of the P (logical processor) the goroutine runs on, so
+	// goroutines running in parallel don't contend on stripes. Items are
+	// dropped instead of spinning when the stripe is busy draining.
+	ringStriped
 )
 
 // ringConsumer is the user-defined object responsible for receiving and
-// processing items in batches when buffers are drained.
+// processing items in batches when buffers are drained. The batch is reused
+// once Push returns, so consumers must copy any items they keep.
 type ringConsumer interface {
 	Push([]uint64) bool
 }
@@ -55,12 +63,28 @@ func (s *ringStripe) Push(item uint64) {
 	s.data = append(s.data, item)
 	// if we should drain
 	if len(s.data) >= s.capacity {
-		// Send elements to consumer. Create a new one.
-		if s.consumer.Push(s.data) {
-			s.data = make([]uint64, 0, s.capacity)
-		} else {
-			s.data = s.data[:0]
-		}
+		// Send elements to consumer and reuse the batch, so draining doesn't
+		// allocate.
+		s.consumer.Push(s.data)
+		s.data = s.data[:0]
+	}
+}
+
+// PushMany appends all items in the ring buffer and drains at most once, so
+// a batch larger than the capacity is sent to Consumer in a single Push.
+func (s *ringStripe) PushMany(items []uint64) {
+	s.data = append(s.data, items...)
+	if len(s.data) >= s.capacity {
+		s.consumer.Push(s.data)
+		s.data = s.data[:0]
+	}
+}
+
+// drain sends the buffered items to Consumer, if there are any.
+func (s *ringStripe) drain() {
+	if len(s.data) > 0 {
+		s.consumer.Push(s.data)
+		s.data = s.data[:0]
 	}
 }
 
@@ -69,6 +93,8 @@ type ringConfig struct {
 	Consumer ringConsumer
 	Stripes  int64
 	Capacity int64
+	// Stats, if set, counts items dropped by ringStriped buffers.
+	Stats *metrics
 }
 
 // ringBuffer stores multiple buffers (stripes) and distributes Pushed items
@@ -80,8 +106,10 @@ type ringBuffer struct {
 	stripes []*ringStripe
 	pool    *sync.Pool
 	push    func(*ringBuffer, uint64)
+	many    func(*ringBuffer, []uint64)
 	rand    int
 	mask    int
+	stats   *metrics
 }
 
 // newRingBuffer returns a striped ring buffer. The Type can be either LOSSY or
@@ -101,6 +129,7 @@ func newRingBuffer(ringType byte, config *ringConfig) *ringBuffer {
 				New: func() interface{} { return newRingStripe(config) },
 			},
 			push: pushLossy,
+			many: pushManyLossy,
 		}
 	}
 	// begin LOSSLESS buffer handling
@@ -110,11 +139,17 @@ func newRingBuffer(ringType byte, config *ringConfig) *ringBuffer {
 	for i := range stripes {
 		stripes[i] = newRingStripe(config)
 	}
+	push, many := pushLossless, pushManyLossless
+	if ringType == ringStriped {
+		push, many = pushStriped, pushManyStriped
+	}
 	return &ringBuffer{
 		stripes: stripes,
 		mask:    int(config.Stripes - 1),
 		rand:    int(time.Now().UnixNano()), // random seed for picking stripes
-		push:    pushLossless,
+		push:    push,
+		many:    many,
+		stats:   config.Stats,
 	}
 }
 
@@ -124,6 +159,26 @@ func (b *ringBuffer) Push(item uint64) {
 	b.push(b, item)
 }
 
+// PushMany adds all items to a single stripe, which is claimed once for the
+// whole batch and drained at most once, instead of once per item.
+func (b *ringBuffer) PushMany(items []uint64) {
+	if len(items) > 0 {
+		b.many(b, items)
+	}
+}
+
+// Drain sends the items of every stripe that isn't in use to Consumer, however
+// few they are. LOSSY buffers pool their stripes, which can't be reached, so
+// it only drains buffers with a fixed number of stripes.
+func (b *ringBuffer) Drain() {
+	for _, stripe := range b.stripes {
+		if atomic.CompareAndSwapInt32(&stripe.busy, 0, 1) {
+			stripe.drain()
+			atomic.StoreInt32(&stripe.busy, 0)
+		}
+	}
+}
+
 func pushLossy(b *ringBuffer, item uint64) {
 	// reuse or create a new stripe
 	stripe := b.pool.Get().(*ringStripe)
@@ -143,3 +198,66 @@ func pushLossless(b *ringBuffer, item uint64) {
 		}
 	}
 }
+
+func pushStriped(b *ringBuffer, item uint64) {
+	stripe := b.claim()
+	if stripe == nil {
+		// the stripe is draining, drop the item rather than waiting
+		b.stats.Add(dropGets, item, 1)
+		return
+	}
+	stripe.data = append(stripe.data, item)
+	b.release(stripe)
+}
+
+// claim claims the stripe of the P the goroutine runs on and pins the
+// goroutine to the P, so no other goroutine can contend on the stripe until
+// release. It returns nil if the stripe is busy draining.
+func (b *ringBuffer) claim() *ringStripe {
+	stripe := b.stripes[z.ProcPin()&b.mask]
+	if !atomic.CompareAndSwapInt32(&stripe.busy, 0, 1) {
+		z.ProcUnpin()
+		return nil
+	}
+	return stripe
+}
+
+// release unpins the goroutine and releases a stripe returned by claim,
+// draining it first if it's full. The consumer may block, so it can't be
+// called while pinned.
+func (b *ringBuffer) release(stripe *ringStripe) {
+	z.ProcUnpin()
+	if len(stripe.data) >= stripe.capacity {
+		stripe.drain()
+	}
+	atomic.StoreInt32(&stripe.busy, 0)
+}
+
+func pushManyLossy(b *ringBuffer, items []uint64) {
+	stripe := b.pool.Get().(*ringStripe)
+	stripe.PushMany(items)
+	b.pool.Put(stripe)
+}
+
+func pushManyLossless(b *ringBuffer, items []uint64) {
+	for i := 0; ; i = (i + 1) & b.mask {
+		if atomic.CompareAndSwapInt32(&b.stripes[i].busy, 0, 1) {
+			b.stripes[i].PushMany(items)
+			atomic.StoreInt32(&b.stripes[i].busy, 0)
+			return
+		}
+	}
+}
+
+func pushManyStriped(b *ringBuffer, items []uint64) {
+	stripe := b.claim()
+	if stripe == nil {
+		// the whole batch is dropped, like a single item would be
+		for _, item := range items {
+			b.stats.Add(dropGets, item, 1)
+		}
+		return
+	}
+	stripe.data = append(stripe.data, items...)
+	b.release(stripe)
+}
@@ -17,8 +17,12 @@
 package ristretto
 
 import (
+	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/dgraph-io/ristretto/z"
 )
 
 const (
@@ -89,6 +93,97 @@ func TestRingLossless(t *testing.T) {
 	}
 }
 
+func TestRingStriped(t *testing.T) {
+	found := make(map[uint64]struct{})
+	stats := newMetrics()
+	buffer := newRingBuffer(ringStriped, &ringConfig{
+		Consumer: &TestConsumer{
+			push: func(items []uint64) {
+				for _, item := range items {
+					found[item] = struct{}{}
+				}
+			},
+		},
+		Capacity: 1,
+		Stripes:  2,
+		Stats:    stats,
+	})
+	buffer.Push(1)
+	buffer.Push(2)
+	if len(found) != 2 {
+		t.Fatal("drain error")
+	}
+	// with every stripe busy, pushes are dropped and counted
+	for _, stripe := range buffer.stripes {
+		stripe.busy = 1
+	}
+	buffer.Push(3)
+	if _, ok := found[3]; ok || stats.GetsDropped() != 1 {
+		t.Fatal("busy stripes should drop items")
+	}
+}
+
+func TestRingDrain(t *testing.T) {
+	var found []uint64
+	buffer := newRingBuffer(ringStriped, &ringConfig{
+		Consumer: &TestConsumer{
+			push: func(items []uint64) {
+				found = append(found, items...)
+			},
+		},
+		Capacity: 4,
+		Stripes:  2,
+		Stats:    newMetrics(),
+	})
+	buffer.Push(1)
+	buffer.Push(2)
+	if len(found) != 0 {
+		t.Fatal("stripes shouldn't drain before they're full")
+	}
+	buffer.Drain()
+	if len(found) != 2 {
+		t.Fatalf("expected 2 drained items but got %v\n", found)
+	}
+	// busy stripes are left alone
+	buffer.Push(3)
+	for _, stripe := range buffer.stripes {
+		stripe.busy = 1
+	}
+	if buffer.Drain(); len(found) != 2 {
+		t.Fatal("busy stripes shouldn't be drained")
+	}
+}
+
+func TestRingPushMany(t *testing.T) {
+	for _, ringType := range []byte{ringLossy, ringLossless, ringStriped} {
+		var drains [][]uint64
+		buffer := newRingBuffer(ringType, &ringConfig{
+			Consumer: &TestConsumer{
+				push: func(items []uint64) {
+					drains = append(drains, append([]uint64(nil), items...))
+				},
+			},
+			Capacity: 4,
+			Stripes:  1,
+			Stats:    newMetrics(),
+		})
+		buffer.PushMany([]uint64{1, 2})
+		if len(drains) != 0 {
+			t.Fatalf("type %d: batches smaller than a stripe shouldn't drain\n",
+				ringType)
+		}
+		// a batch overflowing the stripe drains once, with every item
+		buffer.PushMany([]uint64{3, 4, 5, 6, 7})
+		if ringType == ringLossy && len(drains) == 0 {
+			// the pooled stripe may have been collected in between
+			continue
+		}
+		if len(drains) != 1 || len(drains[0]) < 5 {
+			t.Fatalf("type %d: unexpected drains %v\n", ringType, drains)
+		}
+	}
+}
+
 func BenchmarkRingLossy(b *testing.B) {
 	buffer := newRingBuffer(ringLossy, &ringConfig{
 		Consumer: &BaseConsumer{},
@@ -111,6 +206,42 @@ func BenchmarkRingLossy(b *testing.B) {
 	})
 }
 
+// pushRandomStripe is how ringStriped used to pick stripes, kept to compare
+// against picking the stripe of the P.
+func pushRandomStripe(b *ringBuffer, item uint64) {
+	stripe := b.stripes[int(z.FastRand())&b.mask]
+	if !atomic.CompareAndSwapInt32(&stripe.busy, 0, 1) {
+		return
+	}
+	stripe.Push(item)
+	atomic.StoreInt32(&stripe.busy, 0)
+}
+
+// BenchmarkRingStriped pushes from every CPU to a stripe per CPU, picked at
+// random or by P. Run it with -cpu 32,64 to see how they scale.
+func BenchmarkRingStriped(b *testing.B) {
+	for _, pick := range []struct {
+		name string
+		push func(*ringBuffer, uint64)
+	}{{"random", pushRandomStripe}, {"per-p", pushStriped}} {
+		buffer := newRingBuffer(ringStriped, &ringConfig{
+			Consumer: &BaseConsumer{},
+			Stripes:  next2Power(int64(runtime.GOMAXPROCS(0))),
+			Capacity: RING_CAPACITY,
+			Stats:    newMetrics(),
+		})
+		buffer.push = pick.push
+		b.Run(pick.name, func(b *testing.B) {
+			b.SetBytes(1)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					buffer.Push(1)
+				}
+			})
+		})
+	}
+}
+
 func BenchmarkRingLossless(b *testing.B) {
 	buffer := newRingBuffer(ringLossless, &ringConfig{
 		Consumer: &BaseConsumer{},
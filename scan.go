@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// defaultScanThreshold is the share of new keys that makes a window a scan
+// when Config.ScanThreshold isn't set.
+const defaultScanThreshold = 0.9
+
+// maxScanWindows is the most windows a scan lasts. Workloads setting keys
+// before they're ever read, like write-through caches, look like a scan that
+// never ends, as every key going through admission is new.
+const maxScanWindows = 16
+
+// scanDetector tells scans apart from regular traffic by the share of new
+// keys among the keys going through admission. Keys are new if they were
+// accessed at most once, by the Get that missed them. Once a window of keys
+// was mostly new ones, the policy is scanning until a window isn't, and
+// rejects every new key in the meantime, so a table scan can't evict the
+// working set one key at a time. Scans end after maxScanWindows windows
+// regardless, and another one can only start once a window wasn't mostly new
+// keys, so workloads where new keys are the norm aren't rejected for good.
+type scanDetector struct {
+	window    int
+	threshold float64
+	// admissions and fresh count the keys and new keys of the current window
+	admissions int
+	fresh      int
+	scanning   bool
+	// windows is the number of windows the current scan lasted, and
+	// disarmed is set once a scan timed out, until a window isn't a scan
+	windows  int
+	disarmed bool
+}
+
+func newScanDetector(window int, threshold float64) *scanDetector {
+	return &scanDetector{window: window, threshold: threshold}
+}
+
+// observe counts a key with hits going through admission, and returns
+// whether the policy is scanning, and whether it just started to.
+func (d *scanDetector) observe(hits int64) (scanning, started bool) {
+	d.admissions++
+	if hits <= 1 {
+		d.fresh++
+	}
+	if d.admissions == d.window {
+		wasScanning := d.scanning
+		scan := float64(d.fresh) >= d.threshold*float64(d.window)
+		if !scan {
+			d.disarmed = false
+		}
+		d.scanning = scan && !d.disarmed
+		if d.scanning {
+			d.windows++
+		} else {
+			d.windows = 0
+		}
+		if d.windows > maxScanWindows {
+			d.scanning, d.disarmed, d.windows = false, true, 0
+		}
+		started = d.scanning && !wasScanning
+		d.admissions, d.fresh = 0, 0
+	}
+	return d.scanning, started
+}
+
+// rejects returns whether a key with hits must be rejected because the
+// policy is scanning, and whether scanning just started.
+func (d *scanDetector) rejects(hits int64) (rejected, started bool) {
+	if d == nil {
+		return false, false
+	}
+	scanning, started := d.observe(hits)
+	return scanning && hits <= 1, started
+}
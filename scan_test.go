@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "testing"
+
+func TestPolicyDetectScans(t *testing.T) {
+	p := newSyncPolicy(1000, 10,
+		policyOptions{scanWindow: 4, scanThreshold: 0.75})
+	stats := newMetrics()
+	p.CollectMetrics(stats)
+	add := func(key uint64, hits int) bool {
+		for i := 0; i < hits; i++ {
+			p.Push([]uint64{key})
+		}
+		_, added := p.Add(key, 1)
+		return added
+	}
+	// cold keys filling the cache
+	for key := uint64(0); key < 10; key++ {
+		add(key, 1)
+	}
+	// new keys are as good as the cached ones until a window of them is a
+	// scan
+	for key := uint64(100); key < 103; key++ {
+		if !add(key, 1) {
+			t.Fatalf("key %d should be admitted before the scan\n", key)
+		}
+	}
+	if add(103, 1) {
+		t.Fatal("new keys shouldn't be admitted during scans")
+	}
+	if n := stats.Get(scanActivations); n != 1 {
+		t.Fatalf("expected a scan to be detected but got %d\n", n)
+	}
+	// keys accessed more than once are admitted, and end the scan
+	for key := uint64(300); key < 304; key++ {
+		if !add(key, 3) {
+			t.Fatalf("key %d should be admitted during the scan\n", key)
+		}
+	}
+	if !add(400, 1) {
+		t.Fatal("new keys should be admitted once the scan ended")
+	}
+}
+
+func TestPolicyDetectScansWriteThrough(t *testing.T) {
+	p := newSyncPolicy(1000, 10,
+		policyOptions{scanWindow: 4, scanThreshold: 0.75})
+	// every key is set before it's read, so none of them was accessed yet
+	rejected := 0
+	for key := uint64(0); key < 1000; key++ {
+		if _, added := p.Add(key, 1); !added {
+			rejected++
+		}
+	}
+	if rejected > 4*(maxScanWindows+1) {
+		t.Fatalf("scans should time out, but %d new keys were rejected\n",
+			rejected)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"runtime"
+)
+
+// minSetStripe is the smallest number of items the default number of Set
+// buffer stripes leaves to every stripe.
+const minSetStripe = 256
+
+// setBuffer holds Sets and Dels until the workers apply them. It's split into
+// stripes picked by key, so concurrent writers of different keys rarely
+// contend on the same channel. Items for the same key always share a stripe,
+// so they're applied in the order they were pushed.
+type setBuffer struct {
+	stripes []chan *item
+	mask    uint64
+	// ready is signaled for a worker after items are pushed to one of its
+	// stripes, so it can wait for all of them at once
+	ready []chan struct{}
+}
+
+// newSetBuffer splits size items into stripes for workers, which must both
+// be powers of two. If stripes is zero, there's one per P, or per worker if
+// there are more workers, as long as every stripe holds at least
+// minSetStripe items.
+func newSetBuffer(size, stripes, workers int64) *setBuffer {
+	if stripes == 0 {
+		stripes = workers
+		for procs := int64(runtime.GOMAXPROCS(0)); stripes < procs &&
+			size/(stripes*2) >= minSetStripe; {
+			stripes *= 2
+		}
+	}
+	b := &setBuffer{
+		stripes: make([]chan *item, stripes),
+		mask:    uint64(stripes - 1),
+		ready:   make([]chan struct{}, workers),
+	}
+	for i := range b.stripes {
+		b.stripes[i] = make(chan *item, (size+stripes-1)/stripes)
+	}
+	for i := range b.ready {
+		b.ready[i] = make(chan struct{}, 1)
+	}
+	return b
+}
+
+// stripe returns the stripe holding items for key.
+func (b *setBuffer) stripe(key uint64) chan *item {
+	return b.stripes[key&b.mask]
+}
+
+// signal wakes up the worker of key after an item was pushed.
+func (b *setBuffer) signal(key uint64) {
+	select {
+	case b.ready[key&uint64(len(b.ready)-1)] <- struct{}{}:
+	default:
+	}
+}
+
+// workerStripes returns the stripes drained by a worker, out of n. Workers
+// apply the items of the keys whose lowest bits match theirs, so every
+// stripe belongs to a single worker.
+func (b *setBuffer) workerStripes(worker, n int) []chan *item {
+	var stripes []chan *item
+	for i := worker; i < len(b.stripes); i += n {
+		stripes = append(stripes, b.stripes[i])
+	}
+	return stripes
+}
+
+// Len returns the number of buffered items.
+func (b *setBuffer) Len() int {
+	n := 0
+	for _, stripe := range b.stripes {
+		n += len(stripe)
+	}
+	return n
+}
+
+// Cap returns the number of items the buffer holds.
+func (b *setBuffer) Cap() int {
+	n := 0
+	for _, stripe := range b.stripes {
+		n += cap(stripe)
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSetBufferStripes(t *testing.T) {
+	b := newSetBuffer(64, 4, 1)
+	if len(b.stripes) != 4 || b.Cap() != 64 {
+		t.Fatalf("expected 4 stripes of 16 items but got %d holding %d\n",
+			len(b.stripes), b.Cap())
+	}
+	if b.stripe(1) != b.stripe(5) || b.stripe(1) == b.stripe(2) {
+		t.Fatal("stripes should be picked by key")
+	}
+	b.stripe(1) <- &item{}
+	b.stripe(2) <- &item{}
+	if b.Len() != 2 {
+		t.Fatal("Len should count the items of every stripe")
+	}
+	// every default stripe holds at least minSetStripe items
+	if n := len(newSetBuffer(minSetStripe, 0, 1).stripes); n != 1 {
+		t.Fatalf("expected a single stripe but got %d\n", n)
+	}
+	procs := runtime.GOMAXPROCS(0)
+	if n := len(newSetBuffer(32*1024, 0, 1).stripes); n < procs && n < 128 {
+		t.Fatalf("expected a stripe per P but got %d for %d Ps\n", n, procs)
+	}
+}
+
+func TestCacheSetBufferStripes(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:      1000,
+		MaxCost:          100,
+		BufferItems:      64,
+		SetBufferStripes: 4,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	if len(cache.setBuf.stripes) != 4 {
+		t.Fatal("expected 4 stripes")
+	}
+	// items of a key are applied in order, whichever stripe they're in
+	for key := 0; key < 16; key++ {
+		cache.Set(key, 1, 1)
+		cache.Del(key)
+		cache.Set(key, 2, 1)
+	}
+	for cache.setBuf.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for key := 0; key < 16; key++ {
+		if val, ok := cache.Get(key); !ok || val != 2 {
+			t.Fatalf("expected the last value of key %d but got %v\n", key, val)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// Shadow is a cache holding keys only, fed the accesses of the Cache it was
+// created from by Cache.Shadow. It reports the hit ratio the Cache would have
+// with the Config of the Shadow, so a different MaxCost or policy can be
+// tried on live traffic without storing values twice.
+type Shadow struct {
+	// hits and misses are accessed atomically and kept first for 64-bit
+	// alignment.
+	hits   uint64
+	misses uint64
+	policy policy
+	getBuf *ringBuffer
+	cache  *Cache
+	// threshold is the largest hash, rehashed, of the keys the Shadow is fed,
+	// so it only sees a sample of the keys if it's less than math.MaxUint64
+	threshold uint64
+	// mu serializes Sets of a key with its Dels, so a Set of a key that's
+	// deleted concurrently doesn't re-add it.
+	mu sync.Mutex
+}
+
+// Shadow returns a Shadow fed every Get, Set and Del of the cache from now
+// on, until it's closed. Only the fields of config about the policy are
+// used: MaxCost, NumCounters, AverageCost, EvictionPolicy and the options
+// tuning admission and eviction. Keys set in the cache are added to the
+// Shadow with the same cost, whether or not the cache admits them.
+func (c *Cache) Shadow(config *Config) (*Shadow, error) {
+	if c == nil {
+		return nil, errors.New("Cache is nil.")
+	}
+	switch {
+	case config.MaxCost <= 0:
+		return nil, errors.New("MaxCost must be positive.")
+	case config.NumCounters < 0:
+		return nil, errors.New("NumCounters can't be negative.")
+	case config.AverageCost < 0:
+		return nil, errors.New("AverageCost can't be negative.")
+	case config.EvictionPolicy < EvictSampledLFU ||
+		config.EvictionPolicy > EvictAuto:
+		return nil, errors.New("EvictionPolicy is unknown.")
+	}
+	numCounters := config.NumCounters
+	if numCounters == 0 {
+		averageCost := config.AverageCost
+		if averageCost == 0 {
+			averageCost = 1
+		}
+		numCounters = countersFor(config.MaxCost / averageCost)
+	}
+	s := newShadow(c, config, config.MaxCost, numCounters, 1)
+	c.updateShadows(func(shadows []*Shadow) []*Shadow {
+		return append(shadows, s)
+	})
+	return s, nil
+}
+
+// newShadow returns a Shadow of maxCost and numCounters, with the policy of
+// config, fed the given share of the keys of the cache. It isn't fed anything
+// until it's added to the shadows of the cache.
+func newShadow(c *Cache, config *Config, maxCost, numCounters int64,
+	rate float64) *Shadow {
+	p := newEvictionPolicy(
+		resolveEvictionPolicy(config.EvictionPolicy, maxCost),
+		numCounters, maxCost, true, newPolicyOptions(config))
+	ring := &ringConfig{
+		Consumer: p,
+		Capacity: config.getBufferStripeSize(),
+	}
+	if c.deterministic {
+		ring.Capacity = 1
+	}
+	threshold := uint64(math.MaxUint64)
+	if t := rate * math.MaxUint64; t < math.MaxUint64 {
+		threshold = uint64(t)
+	}
+	return &Shadow{
+		policy:    p,
+		getBuf:    newRingBuffer(ringLossy, ring),
+		cache:     c,
+		threshold: threshold,
+	}
+}
+
+// updateShadows replaces the shadows of the cache with what update returns
+// for a copy of them.
+func (c *Cache) updateShadows(update func([]*Shadow) []*Shadow) {
+	c.shadowsMu.Lock()
+	defer c.shadowsMu.Unlock()
+	shadows := append([]*Shadow(nil), c.loadShadows()...)
+	c.shadows.Store(update(shadows))
+}
+
+// loadShadows returns the shadows of the cache, if any.
+func (c *Cache) loadShadows() []*Shadow {
+	shadows, _ := c.shadows.Load().([]*Shadow)
+	return shadows
+}
+
+// samples returns whether the Shadow is fed the key. Keys are rehashed, as
+// the hashes of integer keys are the integers themselves.
+func (s *Shadow) samples(hash uint64) bool {
+	return s.threshold == math.MaxUint64 || z.WyHashUint64(hash) <= s.threshold
+}
+
+// get records a Get of the key, and whether it would have hit.
+func (s *Shadow) get(hash uint64) {
+	if !s.samples(hash) {
+		return
+	}
+	if s.policy.Has(hash) {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+	s.getBuf.Push(hash)
+}
+
+// set adds the key with cost, or updates its cost if it's already there.
+func (s *Shadow) set(hash uint64, cost int64) {
+	if !s.samples(hash) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy.Has(hash) {
+		s.policy.Update(hash, cost)
+		return
+	}
+	victims, _ := s.policy.Add(hash, cost)
+	for _, victim := range victims {
+		putItem(victim)
+	}
+}
+
+// del deletes the key.
+func (s *Shadow) del(hash uint64) {
+	if !s.samples(hash) {
+		return
+	}
+	s.mu.Lock()
+	s.policy.Del(hash)
+	s.mu.Unlock()
+}
+
+// Hits returns the number of Gets that would have hit.
+func (s *Shadow) Hits() uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.hits)
+}
+
+// Misses returns the number of Gets that would have missed.
+func (s *Shadow) Misses() uint64 {
+	if s == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&s.misses)
+}
+
+// HitRatio returns the share of Gets that would have hit, and whether there
+// were any Gets to compute it from.
+func (s *Shadow) HitRatio() (ratio float64, valid bool) {
+	hits, misses := s.Hits(), s.Misses()
+	if hits+misses == 0 {
+		return 0, false
+	}
+	return float64(hits) / float64(hits+misses), true
+}
+
+// Close stops feeding the Shadow the accesses of its cache.
+func (s *Shadow) Close() {
+	if s == nil {
+		return
+	}
+	s.cache.updateShadows(func(shadows []*Shadow) []*Shadow {
+		for i, shadow := range shadows {
+			if shadow == s {
+				return append(shadows[:i], shadows[i+1:]...)
+			}
+		}
+		return shadows
+	})
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "testing"
+
+func TestCacheShadow(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if _, err := cache.Shadow(&Config{}); err == nil {
+		t.Fatal("shadows without a MaxCost should be rejected")
+	}
+	small, err := cache.Shadow(&Config{NumCounters: 1000, MaxCost: 10})
+	if err != nil {
+		panic(err)
+	}
+	large, err := cache.Shadow(&Config{NumCounters: 1000, MaxCost: 1000})
+	if err != nil {
+		panic(err)
+	}
+	if _, valid := large.HitRatio(); valid {
+		t.Fatal("the hit ratio shouldn't be valid without Gets")
+	}
+	for i := 0; i < 50; i++ {
+		cache.Set(i, i, 1)
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 50; i++ {
+			cache.Get(i)
+		}
+	}
+	if ratio, valid := large.HitRatio(); !valid || ratio != 1 ||
+		large.Hits() != 100 {
+		t.Fatalf("every key fits in the large shadow, got %d hits of %d\n",
+			large.Hits(), large.Hits()+large.Misses())
+	}
+	if ratio, _ := small.HitRatio(); ratio > 0.25 {
+		t.Fatalf("at most 10 keys fit in the small shadow, got a ratio of %v\n",
+			ratio)
+	}
+
+	cache.Del(1)
+	cache.Get(1)
+	if large.Misses() != 1 {
+		t.Fatalf("deleted keys should miss, got %d misses\n", large.Misses())
+	}
+	large.Close()
+	cache.Get(2)
+	if large.Hits()+large.Misses() != 101 {
+		t.Fatal("closed shadows shouldn't be fed Gets")
+	}
+	if small.Hits()+small.Misses() != 102 {
+		t.Fatal("open shadows should be fed Gets")
+	}
+	cache.GetWithInfo(3)
+	h, _ := cache.Acquire(4)
+	h.Release()
+	if small.Hits()+small.Misses() != 104 {
+		t.Fatal("shadows should be fed GetWithInfo and Acquire too")
+	}
+}
@@ -18,9 +18,11 @@ package sim
 
 import (
 	"bufio"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"strconv"
 	"strings"
@@ -65,6 +67,113 @@ func NewUniform(max uint64) Simulator {
 	}
 }
 
+// NewScan creates a Simulator returning the numbers [0, n) in order, over and
+// over. Loops bigger than the cache defeat LRU, since every key is evicted
+// right before it's accessed again. It returns an error if n is zero.
+func NewScan(n uint64) (Simulator, error) {
+	if n == 0 {
+		return nil, errors.New("scan of no numbers")
+	}
+	i := uint64(0)
+	return func() (uint64, error) {
+		key := i
+		i = (i + 1) % n
+		return key, nil
+	}, nil
+}
+
+// NewHotspot creates a Simulator returning numbers [0, n) following a normal
+// distribution with the given standard deviation, whose mean starts at 0 and
+// moves by drift after every number, wrapping around n. It simulates working
+// sets that shift over time, like recent items in a feed, which a policy
+// has to forget old keys to keep up with. It returns an error if n is zero
+// or stddev is negative.
+func NewHotspot(seed int64, n uint64, stddev, drift float64) (Simulator,
+	error) {
+	switch {
+	case n == 0:
+		return nil, errors.New("hotspot of no numbers")
+	case stddev < 0:
+		return nil, errors.New("negative hotspot standard deviation")
+	}
+	r := rand.New(rand.NewSource(seed))
+	mean := 0.0
+	return func() (uint64, error) {
+		key := math.Mod(math.Floor(mean+r.NormFloat64()*stddev), float64(n))
+		if key < 0 {
+			key += float64(n)
+		}
+		mean = math.Mod(mean+drift, float64(n))
+		return uint64(key), nil
+	}, nil
+}
+
+// flashCrowdKeys is the first number returned for flash crowds, so they
+// don't collide with the numbers of other Simulators.
+const flashCrowdKeys = 1 << 63
+
+// NewFlashCrowd creates a Simulator returning the numbers of simulator, except
+// that after every period numbers a flash crowd starts: for the next length
+// numbers, a fraction ratio of them are uniformly distributed among keys new
+// numbers, which are never returned again once the crowd is over. It
+// simulates sudden popularity spikes, like breaking news, which a policy
+// should admit quickly and evict once they're over. It returns an error if
+// period or keys is zero, or keys doesn't fit in an int64.
+func NewFlashCrowd(seed int64, simulator Simulator, period, length, keys uint64,
+	ratio float64) (Simulator, error) {
+	switch {
+	case period == 0:
+		return nil, errors.New("flash crowds every 0 numbers")
+	case keys == 0 || keys > math.MaxInt64:
+		return nil, errors.New("flash crowd keys out of range")
+	}
+	r := rand.New(rand.NewSource(seed))
+	i := uint64(0)
+	return func() (uint64, error) {
+		crowd, pos := i/period, i%period
+		i++
+		// the first period is left to warm the cache up
+		if crowd > 0 && pos < length && r.Float64() < ratio {
+			return flashCrowdKeys + crowd*keys + uint64(r.Int63n(int64(keys))), nil
+		}
+		return simulator()
+	}, nil
+}
+
+// NewMixture creates a Simulator returning the numbers of one of simulators
+// at random for every number, weighted by weights, which must have the same
+// length. Combined with NewScan, for example, it simulates a Zipfian
+// workload interrupted by scans, like a database with analytical queries. It
+// returns an error if there are no simulators, the lengths differ, or the
+// weights are negative or add up to zero.
+func NewMixture(seed int64, weights []float64, simulators ...Simulator) (
+	Simulator, error) {
+	if len(simulators) == 0 || len(weights) != len(simulators) {
+		return nil, errors.New("mixture needs a weight for every simulator")
+	}
+	total := 0.0
+	for _, w := range weights {
+		if w < 0 {
+			return nil, errors.New("negative mixture weight")
+		}
+		total += w
+	}
+	if total == 0 {
+		return nil, errors.New("mixture weights add up to zero")
+	}
+	r := rand.New(rand.NewSource(seed))
+	return func() (uint64, error) {
+		x := r.Float64() * total
+		for i, w := range weights {
+			if x < w {
+				return simulators[i]()
+			}
+			x -= w
+		}
+		return simulators[len(simulators)-1]()
+	}, nil
+}
+
 // Parser is used as a parameter to NewReader so we can create Simulators from
 // varying trace file formats easily.
 type Parser func(string, error) ([]uint64, error)
@@ -146,6 +255,131 @@ func ParseARC(line string, err error) ([]uint64, error) {
 	return nil, ErrDone
 }
 
+// SizedSimulator is like Simulator, but also returns the size of the item
+// each key refers to, for simulating workloads where item sizes vary.
+type SizedSimulator func() (key, size uint64, err error)
+
+// NewSized creates a SizedSimulator assigning every key returned by simulator
+// a size between min and max, which is log-uniformly distributed so sizes
+// vary by orders of magnitude like objects served over the web. A key always
+// has the same size.
+func NewSized(simulator Simulator, min, max uint64) SizedSimulator {
+	ratio := math.Log(float64(max) / float64(min))
+	return func() (uint64, uint64, error) {
+		key, err := simulator()
+		// derive the size from the key, so it's the same on every access
+		r := rand.New(rand.NewSource(int64(key)))
+		size := uint64(float64(min) * math.Exp(r.Float64()*ratio))
+		return key, size, err
+	}
+}
+
+// Unsized creates a Simulator returning the keys of simulator without their
+// sizes.
+func Unsized(simulator SizedSimulator) Simulator {
+	return func() (uint64, error) {
+		key, _, err := simulator()
+		return key, err
+	}
+}
+
+// SizedParser is like Parser for size-annotated trace files, with one access
+// per line.
+type SizedParser func(line string) (key, size uint64, err error)
+
+// NewSizedReader creates a SizedSimulator from a trace file with one access
+// per line, made of a key and a size separated by whitespace. Any columns
+// before them, like timestamps, are ignored. When every line in the file has
+// been read, ErrDone will be returned.
+func NewSizedReader(file io.Reader) SizedSimulator {
+	return NewSizedTrace(ParseSized, file)
+}
+
+// NewSizedTrace creates a SizedSimulator from a trace file in the format
+// understood by parser, such as ParseWebCacheSim. When every line in the file
+// has been read, ErrDone will be returned.
+func NewSizedTrace(parser SizedParser, file io.Reader) SizedSimulator {
+	b := bufio.NewReader(file)
+	return func() (uint64, uint64, error) {
+		line, _ := b.ReadString('\n')
+		return parser(line)
+	}
+}
+
+// ParseSized parses a single line of a size-annotated trace file, as read by
+// NewSizedReader.
+func ParseSized(line string) (key, size uint64, err error) {
+	cols := strings.Fields(line)
+	switch {
+	case len(cols) == 0:
+		return 0, 0, ErrDone
+	case len(cols) < 2:
+		return 0, 0, ErrBadLine
+	}
+	if key, err = strconv.ParseUint(cols[len(cols)-2], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if size, err = strconv.ParseUint(cols[len(cols)-1], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return key, size, nil
+}
+
+// ParseWebCacheSim parses a single line of a trace file in the format of
+// webcachesim [1], which most published CDN traces are converted to: a
+// timestamp, a key and a size separated by whitespace, optionally followed by
+// more columns.
+//
+// [1]: https://github.com/dasebe/webcachesim
+func ParseWebCacheSim(line string) (key, size uint64, err error) {
+	cols := strings.Fields(line)
+	switch {
+	case len(cols) == 0:
+		return 0, 0, ErrDone
+	case len(cols) < 3:
+		return 0, 0, ErrBadLine
+	}
+	if key, err = strconv.ParseUint(cols[1], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if size, err = strconv.ParseUint(cols[2], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return key, size, nil
+}
+
+// oracleGeneralRecord is a single access in the oracleGeneral format.
+type oracleGeneralRecord struct {
+	Time uint32
+	Key  uint64
+	Size uint32
+	// Next is the index of the next access to the key
+	Next int64
+}
+
+// NewOracleGeneralReader creates a SizedSimulator from a binary trace file in
+// the oracleGeneral format of libCacheSim [1], which many CDN and block
+// traces are distributed in. Compressed files have to be decompressed first.
+// When every access in the file has been read, ErrDone will be returned.
+//
+// [1]: https://github.com/1a1a11a/libCacheSim
+func NewOracleGeneralReader(file io.Reader) SizedSimulator {
+	b := bufio.NewReader(file)
+	return func() (uint64, uint64, error) {
+		var r oracleGeneralRecord
+		switch err := binary.Read(b, binary.LittleEndian, &r); err {
+		case nil:
+			return r.Key, uint64(r.Size), nil
+		case io.EOF:
+			return 0, 0, ErrDone
+		case io.ErrUnexpectedEOF:
+			return 0, 0, ErrBadLine
+		default:
+			return 0, 0, err
+		}
+	}
+}
+
 // Collection evaluates the Simulator size times and saves each item to the
 // returned slice.
 func Collection(simulator Simulator, size uint64) []uint64 {
@@ -19,8 +19,10 @@ package sim
 import (
 	"bytes"
 	"compress/gzip"
+	"encoding/binary"
 	"math"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -56,6 +58,120 @@ func TestUniform(t *testing.T) {
 	}
 }
 
+func TestScan(t *testing.T) {
+	s, err := NewScan(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if k, _ := s(); k != uint64(i%3) {
+			t.Fatalf("expected %d but got %d\n", i%3, k)
+		}
+	}
+}
+
+func TestHotspot(t *testing.T) {
+	s, err := NewHotspot(1, 1000, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2000; i++ {
+		k, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k >= 1000 {
+			t.Fatalf("%d is out of range\n", k)
+		}
+		// the mean wraps around after 1000 numbers
+		mean := uint64(i % 1000)
+		if dist := (k - mean + 1000) % 1000; dist > 100 && dist < 900 {
+			t.Fatalf("%d is too far from the mean %d\n", k, mean)
+		}
+	}
+	// the same seed gives the same numbers
+	a, _ := NewHotspot(2, 1000, 10, 0.5)
+	b, _ := NewHotspot(2, 1000, 10, 0.5)
+	for i := 0; i < 100; i++ {
+		x, _ := a()
+		y, _ := b()
+		if x != y {
+			t.Fatal("seeded Simulators should be deterministic")
+		}
+	}
+}
+
+func TestFlashCrowd(t *testing.T) {
+	scan, _ := NewScan(10)
+	s, err := NewFlashCrowd(1, scan, 100, 20, 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crowds := make(map[uint64]int)
+	for i := 0; i < 300; i++ {
+		k, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inCrowd := i >= 100 && i%100 < 20
+		if inCrowd != (k >= flashCrowdKeys) {
+			t.Fatalf("unexpected number %d at %d\n", k, i)
+		}
+		if inCrowd {
+			crowds[(k-flashCrowdKeys)/5]++
+		}
+	}
+	if len(crowds) != 2 || crowds[1] != 20 || crowds[2] != 20 {
+		t.Fatalf("every crowd should have its own keys, got %v\n", crowds)
+	}
+}
+
+func TestMixture(t *testing.T) {
+	scan, _ := NewScan(1)
+	s, err := NewMixture(1, []float64{3, 1}, scan, NewUniform(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zeros := 0
+	for i := 0; i < 10000; i++ {
+		if k, _ := s(); k == 0 {
+			zeros++
+		}
+	}
+	// 3/4 from the scan, plus 1/40 from the uniform distribution
+	if zeros < 7500 || zeros > 8100 {
+		t.Fatalf("unexpected mixture: %d zeros out of 10000\n", zeros)
+	}
+}
+
+func TestGeneratorArgs(t *testing.T) {
+	scan, _ := NewScan(1)
+	invalid := []struct {
+		name string
+		err  error
+	}{
+		{"scan", errOf(NewScan(0))},
+		{"hotspot", errOf(NewHotspot(1, 0, 10, 1))},
+		{"hotspot-stddev", errOf(NewHotspot(1, 10, -1, 1))},
+		{"flash-crowd-period", errOf(NewFlashCrowd(1, scan, 0, 1, 1, 1))},
+		{"flash-crowd-keys", errOf(NewFlashCrowd(1, scan, 1, 1, 0, 1))},
+		{"mixture", errOf(NewMixture(1, nil))},
+		{"mixture-lengths", errOf(NewMixture(1, []float64{1}, scan, scan))},
+		{"mixture-negative", errOf(NewMixture(1, []float64{-1, 2}, scan, scan))},
+		{"mixture-zero", errOf(NewMixture(1, []float64{0}, scan))},
+	}
+	for _, test := range invalid {
+		if test.err == nil {
+			t.Fatalf("%s: expected an error\n", test.name)
+		}
+	}
+}
+
+// errOf returns the error a generator was created with.
+func errOf(_ Simulator, err error) error {
+	return err
+}
+
 func TestParseLIRS(t *testing.T) {
 	s := NewReader(ParseLIRS, bytes.NewReader([]byte{
 		'0', '\n',
@@ -106,6 +222,100 @@ func TestParseARC(t *testing.T) {
 	}
 }
 
+func TestSized(t *testing.T) {
+	s := NewSized(NewUniform(100), 10, 10000)
+	sizes := make(map[uint64]uint64)
+	for i := 0; i < 1000; i++ {
+		key, size, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size < 10 || size > 10000 {
+			t.Fatalf("size %d out of range\n", size)
+		}
+		if prev, ok := sizes[key]; ok && prev != size {
+			t.Fatal("a key's size should never change")
+		}
+		sizes[key] = size
+	}
+}
+
+func TestSizedReader(t *testing.T) {
+	s := NewSizedReader(strings.NewReader("1 100\n1570000000 2 20\r\n\n"))
+	for _, want := range [][2]uint64{{1, 100}, {2, 20}} {
+		key, size, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != want[0] || size != want[1] {
+			t.Fatal("value mismatch")
+		}
+	}
+	if _, _, err := s(); err != ErrDone {
+		t.Fatal("expected ErrDone")
+	}
+	if _, _, err := ParseSized("1"); err != ErrBadLine {
+		t.Fatal("expected ErrBadLine")
+	}
+}
+
+func TestUnsized(t *testing.T) {
+	scan, _ := NewScan(2)
+	s := Unsized(NewSized(scan, 1, 10))
+	for i := 0; i < 4; i++ {
+		if k, _ := s(); k != uint64(i%2) {
+			t.Fatal("keys should be passed through")
+		}
+	}
+}
+
+func TestWebCacheSim(t *testing.T) {
+	s := NewSizedTrace(ParseWebCacheSim,
+		strings.NewReader("1 3 300 0 1\n2 4 40\n"))
+	for _, want := range [][2]uint64{{3, 300}, {4, 40}} {
+		key, size, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != want[0] || size != want[1] {
+			t.Fatal("value mismatch")
+		}
+	}
+	if _, _, err := s(); err != ErrDone {
+		t.Fatal("expected ErrDone")
+	}
+	if _, _, err := ParseWebCacheSim("1 2"); err != ErrBadLine {
+		t.Fatal("expected ErrBadLine")
+	}
+}
+
+func TestOracleGeneralReader(t *testing.T) {
+	var buf bytes.Buffer
+	for _, r := range []oracleGeneralRecord{
+		{Time: 1, Key: 5, Size: 500, Next: 1},
+		{Time: 2, Key: 5, Size: 500, Next: -1},
+	} {
+		binary.Write(&buf, binary.LittleEndian, &r)
+	}
+	buf.WriteByte(0)
+	s := NewOracleGeneralReader(&buf)
+	for i := 0; i < 2; i++ {
+		key, size, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != 5 || size != 500 {
+			t.Fatal("value mismatch")
+		}
+	}
+	if _, _, err := s(); err != ErrBadLine {
+		t.Fatal("expected ErrBadLine for a truncated record")
+	}
+	if _, _, err := NewOracleGeneralReader(&buf)(); err != ErrDone {
+		t.Fatal("expected ErrDone")
+	}
+}
+
 func TestCollection(t *testing.T) {
 	s := NewUniform(100)
 	c := Collection(s, 100)
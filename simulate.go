@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "github.com/dgraph-io/ristretto/sim"
+
+// SimConfig configures Simulate.
+type SimConfig struct {
+	// Config configures the policy like it would a cache's. Only NumCounters,
+	// MaxCost, EvictionPolicy and the fields tuning admission and eviction
+	// are used, and NumCounters and MaxCost must be set.
+	Config
+	// Requests is the number of keys read from the trace. If it's zero, the
+	// whole trace is read, so it must end.
+	Requests int
+}
+
+// Report is the outcome of Simulate.
+type Report struct {
+	// Requests is the number of keys read from the trace, of which Hits were
+	// cached and Misses weren't.
+	Requests uint64  `json:"requests"`
+	Hits     uint64  `json:"hits"`
+	Misses   uint64  `json:"misses"`
+	HitRatio float64 `json:"hitRatio"`
+	// Rejected is the number of missing keys the policy didn't admit.
+	Rejected uint64 `json:"rejected"`
+	// Evictions is the number of keys evicted to make room for others.
+	Evictions uint64 `json:"evictions"`
+	// SketchUsed and SketchMaxed are the fractions of access counters that
+	// are non-zero and that are maxed out once the trace ran. If most are
+	// maxed out, NumCounters is too low to tell keys apart.
+	SketchUsed  float64 `json:"sketchUsed"`
+	SketchMaxed float64 `json:"sketchMaxed"`
+	// Err is the error the trace stopped with, unless it's sim.ErrDone.
+	Err error `json:"-"`
+}
+
+// Simulate runs the admission and eviction policy a cache created with
+// config.Config would use over a trace, and reports how it did. Every key is
+// accessed like a Get, and added with a cost of 1 when it's missing, like
+// the Set that would follow. There's no store, no buffers and no concurrency
+// involved, so it's much faster than replaying the trace against a Cache,
+// and tuning NumCounters or the eviction policy only takes a few runs.
+func Simulate(trace sim.Simulator, config SimConfig) Report {
+	p := newEvictionPolicy(
+		resolveEvictionPolicy(config.EvictionPolicy, config.MaxCost),
+		config.NumCounters, config.MaxCost, true,
+		newPolicyOptions(&config.Config))
+	var report Report
+	access := make([]uint64, 1)
+	for config.Requests == 0 || report.Requests < uint64(config.Requests) {
+		key, err := trace()
+		if err != nil {
+			if err != sim.ErrDone {
+				report.Err = err
+			}
+			break
+		}
+		report.Requests++
+		access[0] = key
+		p.Push(access)
+		if p.Has(key) {
+			report.Hits++
+			continue
+		}
+		report.Misses++
+		victims, added := p.Add(key, 1)
+		if !added {
+			report.Rejected++
+		}
+		report.Evictions += uint64(len(victims))
+		for _, victim := range victims {
+			putItem(victim)
+		}
+	}
+	if report.Requests > 0 {
+		report.HitRatio = float64(report.Hits) / float64(report.Requests)
+	}
+	report.SketchUsed, report.SketchMaxed = p.Saturation()
+	return report
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/dgraph-io/ristretto/sim"
+)
+
+func TestSimulate(t *testing.T) {
+	var prev Report
+	for _, maxCost := range []int64{100, 1000} {
+		report := Simulate(sim.NewZipfian(1.0001, 1, 10000), SimConfig{
+			Config:   Config{NumCounters: 10000, MaxCost: maxCost},
+			Requests: 100000,
+		})
+		if report.Requests != 100000 ||
+			report.Hits+report.Misses != report.Requests {
+			t.Fatalf("unexpected request counts: %+v\n", report)
+		}
+		if report.HitRatio <= prev.HitRatio {
+			t.Fatal("a larger cache should hit more often")
+		}
+		if report.Evictions == 0 || report.Rejected == 0 ||
+			report.SketchUsed == 0 {
+			t.Fatalf("expected evictions, rejections and counters: %+v\n",
+				report)
+		}
+		prev = report
+	}
+}
+
+func TestSimulateTraceEnd(t *testing.T) {
+	keys := []uint64{1, 2, 1, 3, 1}
+	trace := func() (uint64, error) {
+		if len(keys) == 0 {
+			return 0, sim.ErrDone
+		}
+		key := keys[0]
+		keys = keys[1:]
+		return key, nil
+	}
+	report := Simulate(trace, SimConfig{
+		Config: Config{NumCounters: 100, MaxCost: 10,
+			EvictionPolicy: EvictExactLRU},
+	})
+	if report.Requests != 5 || report.Hits != 2 || report.Err != nil {
+		t.Fatalf("unexpected report: %+v\n", report)
+	}
+
+	failure := errors.New("bad trace")
+	report = Simulate(func() (uint64, error) { return 0, failure },
+		SimConfig{Config: Config{NumCounters: 100, MaxCost: 10}})
+	if report.Err != failure {
+		t.Fatal("trace errors should be reported")
+	}
+}
+
+func TestSimulateEvictionSampleSeed(t *testing.T) {
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.0001, 1, 10000)
+	keys := make([]uint64, 100000)
+	for i := range keys {
+		keys[i] = zipf.Uint64()
+	}
+	run := func(seed int64) Report {
+		i := 0
+		trace := func() (uint64, error) {
+			if i == len(keys) {
+				return 0, sim.ErrDone
+			}
+			i++
+			return keys[i-1], nil
+		}
+		return Simulate(trace, SimConfig{Config: Config{
+			NumCounters:        10000,
+			MaxCost:            100,
+			EvictionSampleSeed: seed,
+		}})
+	}
+	// the same seed evicts the same keys, down to the last hit
+	for _, seed := range []int64{1, 42} {
+		if a, b := run(seed), run(seed); a != b {
+			t.Fatalf("seed %d: runs differ: %+v and %+v\n", seed, a, b)
+		}
+	}
+}
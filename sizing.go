@@ -0,0 +1,114 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+// defaultSizingSampleRate is the share of keys fed to the shadows of
+// Config.SizingCurve if SizingSampleRate isn't set.
+const defaultSizingSampleRate = 0.01
+
+// SizingPoint is the hit ratio a cache is estimated to have at Multiple times
+// its MaxCost, as reported by Metrics().SizingCurve().
+type SizingPoint struct {
+	Multiple float64 `json:"multiple"`
+	MaxCost  int64   `json:"maxCost"`
+	// Gets is the number of sampled Gets the estimate is based on. Valid is
+	// false, and HitRatio 0, until there were any.
+	Gets     uint64  `json:"gets"`
+	HitRatio float64 `json:"hitRatio"`
+	Valid    bool    `json:"valid"`
+}
+
+// sizingShadow is the Shadow estimating the hit ratio at a multiple of
+// MaxCost.
+type sizingShadow struct {
+	multiple float64
+	maxCost  int64
+	shadow   *Shadow
+}
+
+// positive returns whether every multiple is positive.
+func positive(multiples []float64) bool {
+	for _, multiple := range multiples {
+		if !(multiple > 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// sizeShadows feeds a Shadow per multiple of maxCost in Config.SizingCurve a
+// sample of the keys. As in SHARDS, the capacity of a Shadow is scaled down
+// by the sample rate, so it holds as many sampled keys as the cache would
+// hold keys of the whole keyspace. The caller must have made the cache
+// collect metrics.
+func (c *Cache) sizeShadows(maxCost int64) {
+	rate := c.config.SizingSampleRate
+	if rate == 0 {
+		rate = defaultSizingSampleRate
+	}
+	averageCost := c.config.AverageCost
+	if averageCost == 0 {
+		averageCost = 1
+	}
+	shadows := make([]*Shadow, 0, len(c.config.SizingCurve))
+	for _, multiple := range c.config.SizingCurve {
+		cost := scaleCost(maxCost, multiple*rate)
+		numCounters := countersFor(cost / averageCost)
+		if c.config.NumCounters > 0 {
+			numCounters = scaleCost(c.config.NumCounters, multiple*rate)
+		}
+		shadow := newShadow(c, &c.config, cost, numCounters, rate)
+		c.stats.sizing = append(c.stats.sizing, sizingShadow{
+			multiple: multiple,
+			maxCost:  scaleCost(maxCost, multiple),
+			shadow:   shadow,
+		})
+		shadows = append(shadows, shadow)
+	}
+	c.updateShadows(func(current []*Shadow) []*Shadow {
+		return append(current, shadows...)
+	})
+}
+
+// scaleCost returns cost times scale, and at least 1.
+func scaleCost(cost int64, scale float64) int64 {
+	if scaled := int64(float64(cost) * scale); scaled > 1 {
+		return scaled
+	}
+	return 1
+}
+
+// SizingCurve returns the hit ratio the cache is estimated to have at every
+// multiple of MaxCost in Config.SizingCurve, in that order, since the cache
+// was created. It's nil without SizingCurve.
+func (p *metrics) SizingCurve() []SizingPoint {
+	if p == nil || len(p.sizing) == 0 {
+		return nil
+	}
+	points := make([]SizingPoint, len(p.sizing))
+	for i, s := range p.sizing {
+		ratio, valid := s.shadow.HitRatio()
+		points[i] = SizingPoint{
+			Multiple: s.multiple,
+			MaxCost:  s.maxCost,
+			Gets:     s.shadow.Hits() + s.shadow.Misses(),
+			HitRatio: ratio,
+			Valid:    valid,
+		}
+	}
+	return points
+}
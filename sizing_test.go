@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCacheSizingCurve(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		SizingCurve:       []float64{0.5, 1, 4},
+		SizingSampleRate:  1,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	if points := cache.Metrics().SizingCurve(); len(points) != 3 ||
+		points[2].MaxCost != 400 || points[0].Valid {
+		t.Fatalf("expected a point per multiple without Gets, got %+v\n", points)
+	}
+	for i := 0; i < 200; i++ {
+		cache.Set(i, i, 1)
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 200; i++ {
+			cache.Get(i)
+		}
+	}
+	points := cache.Metrics().SizingCurve()
+	for _, point := range points {
+		if !point.Valid || point.Gets != 600 {
+			t.Fatalf("every Get should be sampled, got %+v\n", point)
+		}
+	}
+	if points[2].HitRatio != 1 {
+		t.Fatalf("every key fits in 4x MaxCost, got a ratio of %v\n",
+			points[2].HitRatio)
+	}
+	if points[0].HitRatio > points[1].HitRatio ||
+		points[1].HitRatio >= points[2].HitRatio {
+		t.Fatalf("the hit ratio should grow with the cache, got %+v\n", points)
+	}
+	text, _ := cache.Metrics().MarshalText()
+	if !strings.Contains(string(text), "sizing-hit-ratio-4x 1\n") {
+		t.Fatalf("expected the sizing curve in the metrics, got %s", text)
+	}
+
+	sampled, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		SizingCurve:       []float64{1},
+		SizingSampleRate:  0.1,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	for i := 0; i < 10000; i++ {
+		sampled.Get(i)
+	}
+	point := sampled.Metrics().SizingCurve()[0]
+	if point.MaxCost != 100 || point.Gets < 800 || point.Gets > 1200 {
+		t.Fatalf("expected about a tenth of the Gets sampled, got %+v\n", point)
+	}
+}
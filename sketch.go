@@ -35,6 +35,9 @@ import (
 type cmSketch struct {
 	rows [cmDepth]cmRow
 	mask uint32
+	// increments counts the counters incremented since the last Reset, and
+	// overflows the ones that were already maxed out
+	increments, overflows uint64
 }
 
 const (
@@ -64,8 +67,11 @@ func (s *cmSketch) Increment(hashed uint64) {
 	l, r := uint32(hashed), uint32(hashed>>32)
 	for i := range s.rows {
 		// increment the counter on each row
-		s.rows[i].increment((l + uint32(i)*r) & s.mask)
+		if !s.rows[i].increment((l + uint32(i)*r) & s.mask) {
+			s.overflows++
+		}
 	}
+	s.increments += cmDepth
 }
 
 // Estimate returns the value of the specified key.
@@ -86,6 +92,29 @@ func (s *cmSketch) Reset() {
 	for _, r := range s.rows {
 		r.reset()
 	}
+	s.increments, s.overflows = 0, 0
+}
+
+// saturation returns the fraction of counters that are non-zero and the
+// fraction that reached their maximum value. A sketch with many maxed out
+// counters can't tell hot keys apart anymore, which means it needs more
+// counters.
+func (s *cmSketch) saturation() (used, maxed float64) {
+	var nonZero, full, total int
+	for i := range s.rows {
+		for _, b := range s.rows[i] {
+			for _, v := range [2]byte{b & 0x0f, b >> 4} {
+				if v > 0 {
+					nonZero++
+				}
+				if v == 15 {
+					full++
+				}
+			}
+			total += 2
+		}
+	}
+	return float64(nonZero) / float64(total), float64(full) / float64(total)
 }
 
 func (s *cmSketch) string() string {
@@ -109,7 +138,8 @@ func (r cmRow) get(n uint32) byte {
 	return byte(r[n/2]>>((n&1)*4)) & 0x0f
 }
 
-func (r cmRow) increment(n uint32) {
+// increment increments counter n, returning false if it was maxed out.
+func (r cmRow) increment(n uint32) bool {
 	// index of the counter
 	i := n / 2
 	// shift distance (even 0, odd 4)
@@ -119,7 +149,9 @@ func (r cmRow) increment(n uint32) {
 	// only increment if not max value (overflow wrap is bad for LFU)
 	if v < 15 {
 		r[i] += 1 << s
+		return true
 	}
+	return false
 }
 
 func (r cmRow) reset() {
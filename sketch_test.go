@@ -64,6 +64,29 @@ func TestCM(t *testing.T) {
 	GenerateSketchTest(func() TestSketch { return newCmSketch(16) })(t)
 }
 
+func TestCMSaturation(t *testing.T) {
+	s := newCmSketch(16)
+	if used, maxed := s.saturation(); used != 0 || maxed != 0 {
+		t.Fatal("empty sketch should have no saturation")
+	}
+	for i := 0; i < 20; i++ {
+		s.Increment(0)
+	}
+	// key 0 touches a single counter per row
+	if used, maxed := s.saturation(); used != 1.0/16 || maxed != 1.0/16 {
+		t.Fatalf("unexpected saturation %.3f/%.3f\n", used, maxed)
+	}
+	// the last 5 increments of every row overflowed
+	if s.increments != 20*cmDepth || s.overflows != 5*cmDepth {
+		t.Fatalf("expected %d overflows out of %d increments but got %d/%d\n",
+			5*cmDepth, 20*cmDepth, s.overflows, s.increments)
+	}
+	s.Reset()
+	if s.increments != 0 || s.overflows != 0 {
+		t.Fatal("resets should start counting overflows over")
+	}
+}
+
 func GenerateSketchBenchmark(create func() TestSketch) func(b *testing.B) {
 	return func(b *testing.B) {
 		s := create()
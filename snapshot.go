@@ -0,0 +1,106 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "time"
+
+// SnapshotEntry is a cached key as copied by a SnapshotIterator.
+type SnapshotEntry struct {
+	Key   uint64
+	Value interface{}
+	Cost  int64
+	// Expiration is the time the value expires, or zero if it doesn't.
+	Expiration time.Time
+}
+
+// SnapshotIterator walks a copy of the cache taken one store shard at a time,
+// for backups and exports. Every shard is copied as it was at a single point
+// in time, but shards are copied one after the other, so the copy as a whole
+// may mix keys from before and after concurrent writes.
+type SnapshotIterator struct {
+	cache   *Cache
+	shards  []store
+	next    int
+	entries []SnapshotEntry
+	entry   SnapshotEntry
+}
+
+// SnapshotIter returns an iterator over a copy of the cache. Shards are
+// copied once Next reaches them, and each is locked against writes only
+// while its keys and values are copied, so writes wait for at most the time
+// it takes to copy a single shard (see Config.NumShards), and not at all with
+// Config.LockFreeReads. Expired values, and keys that were deleted before
+// their cost was looked up, are skipped.
+func (c *Cache) SnapshotIter() *SnapshotIterator {
+	if c == nil {
+		return &SnapshotIterator{}
+	}
+	shards := []store{c.store}
+	if sm, ok := c.store.(*shardedMap); ok {
+		shards = sm.shards
+	}
+	return &SnapshotIterator{cache: c, shards: shards}
+}
+
+// Next advances to the next entry, and returns false once there are none
+// left.
+func (it *SnapshotIterator) Next() bool {
+	for len(it.entries) == 0 {
+		if it.next == len(it.shards) {
+			it.entry = SnapshotEntry{}
+			return false
+		}
+		it.entries = it.copy(it.shards[it.next])
+		it.next++
+	}
+	it.entry, it.entries = it.entries[0], it.entries[1:]
+	return true
+}
+
+// Entry returns the entry Next advanced to.
+func (it *SnapshotIterator) Entry() SnapshotEntry {
+	return it.entry
+}
+
+// copy returns the entries of a shard.
+func (it *SnapshotIterator) copy(shard store) []SnapshotEntry {
+	var entries []SnapshotEntry
+	shard.Range(func(key uint64, val interface{}) bool {
+		entries = append(entries, SnapshotEntry{Key: key, Value: val})
+		return true
+	})
+	// the shard is no longer locked, so writes don't wait for the policy
+	now := it.cache.clock.Now()
+	kept := entries[:0]
+	for _, e := range entries {
+		if v, ok := e.Value.(*expiringValue); ok {
+			if v.expired(now) {
+				continue
+			}
+			e.Value, e.Expiration = v.val, v.deadline()
+		}
+		var ok bool
+		if e.Value, ok = it.cache.decode(e.Value); !ok {
+			continue
+		}
+		if e.Cost, ok = it.cache.policy.Cost(e.Key); !ok {
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheSnapshotIter(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           100,
+		BufferItems:       64,
+		NumShards:         4,
+		DeterministicMode: true,
+		Clock:             clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	for key := 0; key < 10; key++ {
+		cache.Set(key, key, int64(key+1))
+	}
+	cache.SetWithTTL(10, 10, 1, time.Minute)
+	cache.SetWithTTL(11, 11, 1, time.Second)
+	clock.Advance(time.Second)
+	seen := make(map[uint64]SnapshotEntry)
+	for it := cache.SnapshotIter(); it.Next(); {
+		e := it.Entry()
+		if _, ok := seen[e.Key]; ok {
+			t.Fatalf("key %d was seen twice\n", e.Key)
+		}
+		seen[e.Key] = e
+	}
+	if len(seen) != 11 {
+		t.Fatalf("expected 11 entries but got %d\n", len(seen))
+	}
+	for key := 0; key < 10; key++ {
+		e := seen[cache.keyToHash(key)]
+		if e.Value.(int) != key || e.Cost != int64(key+1) ||
+			!e.Expiration.IsZero() {
+			t.Fatalf("unexpected entry for key %d: %+v\n", key, e)
+		}
+	}
+	if e := seen[cache.keyToHash(10)]; e.Value.(int) != 10 ||
+		!e.Expiration.Equal(time.Unix(60, 0)) {
+		t.Fatalf("values with a TTL should be unwrapped: %+v\n", e)
+	}
+	var nilCache *Cache
+	if nilCache.SnapshotIter().Next() {
+		t.Fatal("nil caches should have no entries")
+	}
+}
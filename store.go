@@ -17,7 +17,9 @@
 package ristretto
 
 import (
+	"runtime"
 	"sync"
+	"sync/atomic"
 )
 
 // store is the interface fulfilled by all hash map implementations in this
@@ -26,20 +28,55 @@ import (
 // in Ristretto.
 //
 // Every store is safe for concurrent usage.
+//
+// Every value is written with a version, and a write never replaces a value
+// with a newer version. Versions order writes that reach the store out of
+// order, such as a buffered Set that's overtaken by an update in place.
 type store interface {
 	// Get returns the value associated with the key parameter.
 	Get(uint64) (interface{}, bool)
+	// GetVersion is like Get, but also returns the version and flags of the
+	// value.
+	GetVersion(uint64) (interface{}, uint64, uint32, bool)
 	// Set adds the key-value pair to the Map or updates the value if it's
-	// already present.
-	Set(uint64, interface{})
-	// Del deletes the key-value pair from the Map.
-	Del(uint64)
+	// already present, unless the present value is newer. The flags are
+	// stored along with the value. The value that's no longer stored, either
+	// the replaced one or the rejected one, is returned along with true.
+	Set(key uint64, value interface{}, version uint64, flags uint32) (interface{}, bool)
+	// Update is like Set, but only updates keys that are already present. It
+	// returns false if the key isn't present.
+	Update(key uint64, value interface{}, version uint64, flags uint32) (interface{}, bool)
+	// CompareAndSwap is like Update, but only updates the key if its value
+	// has the expected version. It returns the replaced value and true if it
+	// did.
+	CompareAndSwap(key uint64, value interface{},
+		expected, version uint64, flags uint32) (interface{}, bool)
+	// Del deletes the key-value pair from the Map unless its version is
+	// newer than version, and returns the deleted value and its flags, if
+	// any.
+	Del(key uint64, version uint64) (interface{}, uint32, bool)
+	// Range calls f for every key-value pair in the Map until f returns
+	// false. Pairs changed concurrently may or may not be seen, and f must
+	// not modify the Map.
+	Range(f func(key uint64, value interface{}) bool)
+	// Len returns the number of key-value pairs in the Map.
+	Len() int
 }
 
-// newStore returns the default store implementation.
-func newStore() store {
+// newStore returns the default store implementation with numShards shards,
+// which must be a power of two. If numShards is zero, a default based on
+// GOMAXPROCS is used. If lockFree is true, shards are copy-on-write maps
+// which never lock on reads.
+func newStore(numShards uint64, lockFree bool) store {
+	if numShards == 0 {
+		numShards = defaultNumShards()
+	}
+	newShard := newLockedMap
+	if lockFree {
+		newShard = newCOWMap
+	}
 	// return newSyncMap()
-	return newShardedMap()
+	return newShardedMap(numShards, newShard)
 }
 
 type syncMap struct {
@@ -54,67 +91,486 @@ func (m *syncMap) Get(key uint64) (interface{}, bool) {
 	return m.Load(key)
 }
 
-func (m *syncMap) Set(key uint64, value interface{}) {
+func (m *syncMap) GetVersion(key uint64) (interface{}, uint64, uint32, bool) {
+	value, ok := m.Load(key)
+	return value, 0, 0, ok
+}
+
+// sync.Map has no atomic swap, so syncMap ignores versions and flags and is
+// only best-effort.
+func (m *syncMap) Set(key uint64, value interface{}, _ uint64, _ uint32) (interface{}, bool) {
+	prev, ok := m.Load(key)
 	m.Store(key, value)
+	return prev, ok
+}
+
+func (m *syncMap) Update(key uint64, value interface{}, _ uint64, _ uint32) (interface{}, bool) {
+	prev, ok := m.Load(key)
+	if ok {
+		m.Store(key, value)
+	}
+	return prev, ok
+}
+
+func (m *syncMap) CompareAndSwap(key uint64, value interface{},
+	_, version uint64, flags uint32) (interface{}, bool) {
+	return m.Update(key, value, version, flags)
 }
 
-func (m *syncMap) Del(key uint64) {
+func (m *syncMap) Del(key uint64, _ uint64) (interface{}, uint32, bool) {
+	prev, ok := m.Load(key)
 	m.Delete(key)
+	return prev, 0, ok
+}
+
+func (m *syncMap) Range(f func(key uint64, value interface{}) bool) {
+	m.Map.Range(func(key, value interface{}) bool {
+		return f(key.(uint64), value)
+	})
+}
+
+func (m *syncMap) Len() int {
+	n := 0
+	m.Map.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
 }
 
-const numShards uint64 = 256
+const (
+	// shardsPerProc is the number of store shards per GOMAXPROCS used when
+	// the number of shards isn't configured.
+	shardsPerProc = 16
+	// minShards and maxShards bound the default number of store shards.
+	minShards = 16
+	maxShards = 1024
+)
+
+// defaultNumShards returns the number of shards scaled to GOMAXPROCS, so
+// machines with many cores see less lock contention while small deployments
+// don't pay for hundreds of mostly empty maps.
+func defaultNumShards() uint64 {
+	n := next2Power(int64(runtime.GOMAXPROCS(0) * shardsPerProc))
+	switch {
+	case n < minShards:
+		n = minShards
+	case n > maxShards:
+		n = maxShards
+	}
+	return uint64(n)
+}
 
 type shardedMap struct {
-	shards []*lockedMap
+	shards []store
+	mask   uint64
 }
 
-func newShardedMap() *shardedMap {
-	sm := &shardedMap{shards: make([]*lockedMap, int(numShards))}
+func newShardedMap(numShards uint64, newShard func() store) *shardedMap {
+	sm := &shardedMap{
+		shards: make([]store, int(numShards)),
+		mask:   numShards - 1,
+	}
 	for i := range sm.shards {
-		sm.shards[i] = newLockedMap()
+		sm.shards[i] = newShard()
 	}
 	return sm
 }
 
 func (sm *shardedMap) Get(key uint64) (interface{}, bool) {
-	idx := key % numShards
-	return sm.shards[idx].Get(key)
+	return sm.shards[key&sm.mask].Get(key)
+}
+
+func (sm *shardedMap) GetVersion(key uint64) (interface{}, uint64, uint32, bool) {
+	return sm.shards[key&sm.mask].GetVersion(key)
+}
+
+func (sm *shardedMap) Set(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	return sm.shards[key&sm.mask].Set(key, value, version, flags)
 }
 
-func (sm *shardedMap) Set(key uint64, value interface{}) {
-	idx := key % numShards
-	sm.shards[idx].Set(key, value)
+func (sm *shardedMap) Update(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	return sm.shards[key&sm.mask].Update(key, value, version, flags)
 }
 
-func (sm *shardedMap) Del(key uint64) {
-	idx := key % numShards
-	sm.shards[idx].Del(key)
+func (sm *shardedMap) CompareAndSwap(key uint64, value interface{},
+	expected, version uint64, flags uint32) (interface{}, bool) {
+	return sm.shards[key&sm.mask].CompareAndSwap(key, value, expected, version,
+		flags)
+}
+
+func (sm *shardedMap) Del(key uint64, version uint64) (interface{}, uint32, bool) {
+	return sm.shards[key&sm.mask].Del(key, version)
+}
+
+func (sm *shardedMap) Range(f func(key uint64, value interface{}) bool) {
+	more := true
+	for _, shard := range sm.shards {
+		shard.Range(func(key uint64, value interface{}) bool {
+			more = f(key, value)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+func (sm *shardedMap) Len() int {
+	n := 0
+	for _, shard := range sm.shards {
+		n += shard.Len()
+	}
+	return n
 }
 
 type lockedMap struct {
 	sync.RWMutex
-	data map[uint64]interface{}
+	data *table
 }
 
-func newLockedMap() *lockedMap {
-	return &lockedMap{data: make(map[uint64]interface{})}
+func newLockedMap() store {
+	return &lockedMap{data: newTable()}
 }
 
 func (m *lockedMap) Get(key uint64) (interface{}, bool) {
 	m.RLock()
 	defer m.RUnlock()
-	val, found := m.data[key]
-	return val, found
+	return m.data.get(key)
+}
+
+func (m *lockedMap) GetVersion(key uint64) (interface{}, uint64, uint32, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	return m.data.getVersion(key)
 }
 
-func (m *lockedMap) Set(key uint64, value interface{}) {
+func (m *lockedMap) Set(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
 	m.Lock()
 	defer m.Unlock()
-	m.data[key] = value
+	return m.data.set(key, value, version, flags)
 }
 
-func (m *lockedMap) Del(key uint64) {
+func (m *lockedMap) Update(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
 	m.Lock()
 	defer m.Unlock()
-	delete(m.data, key)
+	return m.data.update(key, value, version, flags)
+}
+
+func (m *lockedMap) CompareAndSwap(key uint64, value interface{},
+	expected, version uint64, flags uint32) (interface{}, bool) {
+	m.Lock()
+	defer m.Unlock()
+	return m.data.compareAndSwap(key, value, expected, version, flags)
+}
+
+func (m *lockedMap) Del(key uint64, version uint64) (interface{}, uint32, bool) {
+	m.Lock()
+	defer m.Unlock()
+	return m.data.del(key, version)
+}
+
+func (m *lockedMap) Range(f func(key uint64, value interface{}) bool) {
+	m.RLock()
+	defer m.RUnlock()
+	m.data.forEach(f)
+}
+
+func (m *lockedMap) Len() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.data.count
+}
+
+const (
+	// minTableSize is the smallest number of slots in a table.
+	minTableSize = 8
+	// tableMultiplier spreads keys over the table (Fibonacci hashing). Keys
+	// in the same shard share their lower bits, so the table can't use them
+	// directly.
+	tableMultiplier = 0x9e3779b97f4a7c15
+)
+
+// tableEntry is a single slot of a table.
+type tableEntry struct {
+	key     uint64
+	value   interface{}
+	version uint64
+	flags   uint32
+}
+
+// table is an open-addressing hash table with linear probing. Entries are
+// stored inline in a single slice, so lookups touch one or two cache lines
+// instead of chasing the bucket pointers of a Go map, and deletions shift
+// entries back instead of leaving tombstones. It doesn't take less memory
+// than a map though: every slot takes 41 bytes and the table is between 3/8
+// and 3/4 full, so an entry costs 55 to 109 bytes, against about 64 in a map
+// (see BenchmarkStoreMemory).
+//
+// table is NOT thread safe.
+type table struct {
+	// used marks the occupied slots of entries. Every key and value is
+	// valid, so there's no sentinel for empty slots, and keeping the marks
+	// apart lets probes skim them instead of whole entries, which makes
+	// lookups in large tables several times faster (see BenchmarkStoreTable).
+	used    []bool
+	entries []tableEntry
+	shift   uint
+	count   int
+}
+
+func newTable() *table {
+	t := &table{}
+	t.resize(minTableSize)
+	return t
+}
+
+func (t *table) resize(size int) {
+	used, entries := t.used, t.entries
+	t.used = make([]bool, size)
+	t.entries = make([]tableEntry, size)
+	t.shift = 64
+	for s := size; s > 1; s >>= 1 {
+		t.shift--
+	}
+	t.count = 0
+	for i := range entries {
+		if used[i] {
+			t.set(entries[i].key, entries[i].value, entries[i].version,
+				entries[i].flags)
+		}
+	}
+}
+
+// home returns the slot the key would occupy without collisions.
+func (t *table) home(key uint64) int {
+	return int((key * tableMultiplier) >> t.shift)
+}
+
+// find returns the slot holding the key, or the empty slot where it would be
+// inserted.
+func (t *table) find(key uint64) (int, bool) {
+	mask := len(t.entries) - 1
+	for i := t.home(key); ; i = (i + 1) & mask {
+		if !t.used[i] {
+			return i, false
+		}
+		if t.entries[i].key == key {
+			return i, true
+		}
+	}
+}
+
+func (t *table) get(key uint64) (interface{}, bool) {
+	if i, ok := t.find(key); ok {
+		return t.entries[i].value, true
+	}
+	return nil, false
+}
+
+func (t *table) getVersion(key uint64) (interface{}, uint64, uint32, bool) {
+	if i, ok := t.find(key); ok {
+		e := &t.entries[i]
+		return e.value, e.version, e.flags, true
+	}
+	return nil, 0, 0, false
+}
+
+// forEach calls f for every entry until f returns false.
+func (t *table) forEach(f func(key uint64, value interface{}) bool) {
+	for i := range t.entries {
+		if t.used[i] && !f(t.entries[i].key, t.entries[i].value) {
+			return
+		}
+	}
+}
+
+// replace swaps the value in slot i if it's older than version, and returns
+// the value that's no longer stored.
+func (t *table) replace(i int, value interface{}, version uint64,
+	flags uint32) interface{} {
+	e := &t.entries[i]
+	if e.version > version {
+		return value
+	}
+	prev := e.value
+	e.value, e.version, e.flags = value, version, flags
+	return prev
+}
+
+func (t *table) update(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	if i, ok := t.find(key); ok {
+		return t.replace(i, value, version, flags), true
+	}
+	return nil, false
+}
+
+func (t *table) compareAndSwap(key uint64, value interface{},
+	expected, version uint64, flags uint32) (interface{}, bool) {
+	i, ok := t.find(key)
+	if !ok || t.entries[i].version != expected {
+		return nil, false
+	}
+	return t.replace(i, value, version, flags), true
+}
+
+func (t *table) set(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	i, ok := t.find(key)
+	if ok {
+		return t.replace(i, value, version, flags), true
+	}
+	// keep the load factor under 3/4
+	if (t.count+1)*4 > len(t.entries)*3 {
+		t.resize(len(t.entries) * 2)
+		i, _ = t.find(key)
+	}
+	t.used[i] = true
+	t.entries[i] = tableEntry{key: key, value: value, version: version,
+		flags: flags}
+	t.count++
+	return nil, false
+}
+
+func (t *table) del(key uint64, version uint64) (interface{}, uint32, bool) {
+	i, ok := t.find(key)
+	if !ok || t.entries[i].version > version {
+		return nil, 0, false
+	}
+	prev, flags := t.entries[i].value, t.entries[i].flags
+	// shift following entries back into the hole unless that would move them
+	// before their home slot
+	mask := len(t.entries) - 1
+	for j := (i + 1) & mask; t.used[j]; j = (j + 1) & mask {
+		k := t.home(t.entries[j].key)
+		if (i <= j && i < k && k <= j) || (i > j && (i < k || k <= j)) {
+			continue
+		}
+		t.entries[i] = t.entries[j]
+		i = j
+	}
+	t.used[i] = false
+	t.entries[i] = tableEntry{}
+	t.count--
+	// give memory back after heavy churn
+	if len(t.entries) > minTableSize && t.count*8 < len(t.entries) {
+		t.resize(len(t.entries) / 2)
+	}
+	return prev, flags, true
+}
+
+// cowMap is a copy-on-write map for read-mostly workloads. Readers load an
+// immutable snapshot of the map with a single atomic load and never lock,
+// so Gets scale with the number of cores. Writers are serialized and copy the
+// whole map on every change, which makes writes O(n) in the size of the shard.
+type cowMap struct {
+	sync.Mutex
+	data atomic.Value // map[uint64]cowEntry
+}
+
+type cowEntry struct {
+	value   interface{}
+	version uint64
+	flags   uint32
+}
+
+func newCOWMap() store {
+	m := &cowMap{}
+	m.data.Store(make(map[uint64]cowEntry))
+	return m
+}
+
+func (m *cowMap) load() map[uint64]cowEntry {
+	return m.data.Load().(map[uint64]cowEntry)
+}
+
+// clone returns a writable copy of the current map with room for extra
+// items. The map must be locked.
+func (m *cowMap) clone(extra int) map[uint64]cowEntry {
+	cur := m.load()
+	next := make(map[uint64]cowEntry, len(cur)+extra)
+	for k, v := range cur {
+		next[k] = v
+	}
+	return next
+}
+
+func (m *cowMap) Get(key uint64) (interface{}, bool) {
+	e, found := m.load()[key]
+	return e.value, found
+}
+
+func (m *cowMap) GetVersion(key uint64) (interface{}, uint64, uint32, bool) {
+	e, found := m.load()[key]
+	return e.value, e.version, e.flags, found
+}
+
+func (m *cowMap) Set(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	m.Lock()
+	defer m.Unlock()
+	return m.set(key, value, version, flags, false)
+}
+
+func (m *cowMap) Update(key uint64, value interface{}, version uint64,
+	flags uint32) (interface{}, bool) {
+	m.Lock()
+	defer m.Unlock()
+	return m.set(key, value, version, flags, true)
+}
+
+// set implements Set and Update. The map must be locked.
+func (m *cowMap) set(key uint64, value interface{}, version uint64,
+	flags uint32, mustExist bool) (interface{}, bool) {
+	prev, ok := m.load()[key]
+	switch {
+	case !ok && mustExist:
+		return nil, false
+	case ok && prev.version > version:
+		return value, true
+	}
+	next := m.clone(1)
+	next[key] = cowEntry{value: value, version: version, flags: flags}
+	m.data.Store(next)
+	return prev.value, ok
+}
+
+func (m *cowMap) CompareAndSwap(key uint64, value interface{},
+	expected, version uint64, flags uint32) (interface{}, bool) {
+	m.Lock()
+	defer m.Unlock()
+	if prev, ok := m.load()[key]; !ok || prev.version != expected {
+		return nil, false
+	}
+	return m.set(key, value, version, flags, true)
+}
+
+func (m *cowMap) Del(key uint64, version uint64) (interface{}, uint32, bool) {
+	m.Lock()
+	defer m.Unlock()
+	prev, ok := m.load()[key]
+	if !ok || prev.version > version {
+		return nil, 0, false
+	}
+	next := m.clone(0)
+	delete(next, key)
+	m.data.Store(next)
+	return prev.value, prev.flags, true
+}
+
+func (m *cowMap) Range(f func(key uint64, value interface{}) bool) {
+	for key, e := range m.load() {
+		if !f(key, e.value) {
+			return
+		}
+	}
+}
+
+func (m *cowMap) Len() int {
+	return len(m.load())
 }
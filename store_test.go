@@ -17,6 +17,9 @@
 package ristretto
 
 import (
+	"fmt"
+	"math/rand"
+	"runtime"
 	"testing"
 )
 
@@ -28,11 +31,112 @@ func BenchmarkStoreLockedMap(b *testing.B) {
 	GenerateBench(func() store { return newLockedMap() })(b)
 }
 
+func BenchmarkStoreCOWMap(b *testing.B) {
+	GenerateBench(func() store { return newCOWMap() })(b)
+}
+
+// BenchmarkStoreGet compares Get scaling of the locking and lock-free stores
+// with a populated store. Run it with -cpu 1,2,4,8,16,32,64 to see how each
+// scales with the number of cores.
+func BenchmarkStoreGet(b *testing.B) {
+	for _, lockFree := range []bool{false, true} {
+		m := newStore(0, lockFree)
+		for i := uint64(0); i < 1<<16; i++ {
+			m.Set(i, i, 0, 0)
+		}
+		b.Run(fmt.Sprintf("lockFree=%v", lockFree), func(b *testing.B) {
+			b.SetBytes(1)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := uint64(0); pb.Next(); i++ {
+					m.Get(i & (1<<16 - 1))
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkStoreTable measures hits and misses in the tables of a locking
+// store of growing size, where probes run longer.
+func BenchmarkStoreTable(b *testing.B) {
+	for _, n := range []uint64{1 << 10, 1 << 16, 1 << 20} {
+		m := newStore(0, false)
+		for i := uint64(0); i < n; i++ {
+			m.Set(i, i, 0, 0)
+		}
+		b.Run(fmt.Sprintf("hit-%d", n), func(b *testing.B) {
+			for i := uint64(0); i < uint64(b.N); i++ {
+				m.Get(i & (n - 1))
+			}
+		})
+		b.Run(fmt.Sprintf("miss-%d", n), func(b *testing.B) {
+			for i := uint64(0); i < uint64(b.N); i++ {
+				m.Get(n + i&(n-1))
+			}
+		})
+	}
+}
+
+// BenchmarkStoreMemory reports the heap bytes per entry of the table of a
+// locking store shard, and of a Go map holding the same entries, which the
+// table replaced. Sizes just before and after the table doubles show both
+// ends of its load factor.
+func BenchmarkStoreMemory(b *testing.B) {
+	// every entry holds the same value, so only the entries are counted
+	val := interface{}(new(int))
+	layouts := []struct {
+		name string
+		fill func(n int) interface{}
+	}{
+		{"table", func(n int) interface{} {
+			m := newLockedMap()
+			for i := 0; i < n; i++ {
+				m.Set(uint64(i), val, uint64(i), 0)
+			}
+			return m
+		}},
+		{"map", func(n int) interface{} {
+			m := make(map[uint64]cowEntry)
+			for i := 0; i < n; i++ {
+				m[uint64(i)] = cowEntry{value: val, version: uint64(i)}
+			}
+			return m
+		}},
+	}
+	for _, n := range []int{3 << 14, 3<<14 + 1, 3 << 18, 3<<18 + 1} {
+		for _, layout := range layouts {
+			b.Run(fmt.Sprintf("%s-%d", layout.name, n), func(b *testing.B) {
+				var bytes uint64
+				for i := 0; i < b.N; i++ {
+					bytes += heapGrowth(func() interface{} {
+						return layout.fill(n)
+					})
+				}
+				b.ReportMetric(float64(bytes)/float64(b.N)/float64(n),
+					"B/entry")
+			})
+		}
+	}
+}
+
+// heapGrowth returns by how many bytes the live heap grows while the value
+// returned by fill is kept.
+func heapGrowth(fill func() interface{}) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	kept := fill()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(kept)
+	return after.HeapAlloc - before.HeapAlloc
+}
+
 func GenerateBench(create func() store) func(*testing.B) {
 	return func(b *testing.B) {
 		b.Run("get  ", func(b *testing.B) {
 			m := create()
-			m.Set(1, 1)
+			m.Set(1, 1, 0, 0)
 			b.SetBytes(1)
 			b.ResetTimer()
 			b.RunParallel(func(pb *testing.PB) {
@@ -45,7 +149,16 @@ func GenerateBench(create func() store) func(*testing.B) {
 }
 
 func TestStore(t *testing.T) {
-	GenerateTest(func() store { return newStore() })(t)
+	GenerateTest(func() store { return newStore(0, false) })(t)
+	GenerateTest(func() store { return newStore(0, true) })(t)
+}
+
+func TestStoreShards(t *testing.T) {
+	GenerateTest(func() store { return newStore(1, false) })(t)
+	GenerateTest(func() store { return newStore(4, false) })(t)
+	if n := defaultNumShards(); n < minShards || n > maxShards || n&(n-1) != 0 {
+		t.Fatalf("bad default number of shards: %d\n", n)
+	}
 }
 
 func TestStoreSyncMap(t *testing.T) {
@@ -56,29 +169,167 @@ func TestStoreLockedMap(t *testing.T) {
 	GenerateTest(func() store { return newLockedMap() })(t)
 }
 
+func TestStoreCOWMap(t *testing.T) {
+	GenerateTest(func() store { return newCOWMap() })(t)
+}
+
+func TestStoreVersions(t *testing.T) {
+	for _, create := range []func() store{newLockedMap, newCOWMap} {
+		m := create()
+		m.Set(1, 2, 2, 0)
+		// older versions are rejected and handed back
+		if val, ok := m.Set(1, 1, 1, 0); !ok || val.(int) != 1 {
+			t.Fatal("set didn't reject an older version")
+		}
+		if val, ok := m.Update(1, 1, 1, 0); !ok || val.(int) != 1 {
+			t.Fatal("update didn't reject an older version")
+		}
+		if _, _, ok := m.Del(1, 1); ok {
+			t.Fatal("del deleted a newer version")
+		}
+		if val, _ := m.Get(1); val.(int) != 2 {
+			t.Fatal("newer version was replaced")
+		}
+		if prev, ok := m.Set(1, 3, 3, 0); !ok || prev.(int) != 2 {
+			t.Fatal("set didn't replace an older version")
+		}
+		if _, version, _, _ := m.GetVersion(1); version != 3 {
+			t.Fatalf("expected version 3 but got %d\n", version)
+		}
+		if _, ok := m.CompareAndSwap(1, 4, 2, 4, 0); ok {
+			t.Fatal("compare-and-swap replaced an unexpected version")
+		}
+		if prev, ok := m.CompareAndSwap(1, 4, 3, 4, 0); !ok || prev.(int) != 3 {
+			t.Fatal("compare-and-swap didn't replace the expected version")
+		}
+		if _, ok := m.CompareAndSwap(2, 1, 0, 5, 0); ok {
+			t.Fatal("compare-and-swap added a missing key")
+		}
+		if prev, _, ok := m.Del(1, 4); !ok || prev.(int) != 4 {
+			t.Fatal("del didn't delete the same version")
+		}
+	}
+}
+
+func TestStoreFlags(t *testing.T) {
+	for _, create := range []func() store{newLockedMap, newCOWMap} {
+		m := create()
+		m.Set(1, 1, 1, 7)
+		if _, _, flags, _ := m.GetVersion(1); flags != 7 {
+			t.Fatalf("expected flags 7 but got %d\n", flags)
+		}
+		// rejected writes don't change the flags
+		m.Update(1, 2, 0, 8)
+		if _, _, flags, _ := m.GetVersion(1); flags != 7 {
+			t.Fatal("an older version replaced the flags")
+		}
+		m.CompareAndSwap(1, 2, 1, 2, 9)
+		if _, flags, ok := m.Del(1, 2); !ok || flags != 9 {
+			t.Fatal("del didn't return the flags")
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	tbl := newTable()
+	ref := make(map[uint64]int)
+	r := rand.New(rand.NewSource(0))
+	for i := 0; i < 100000; i++ {
+		// a small key space with sequential keys forces lots of collisions,
+		// growing and shrinking
+		key := uint64(r.Intn(2048)) << 8
+		switch r.Intn(3) {
+		case 0, 1:
+			prev, ok := tbl.set(key, i, 0, 0)
+			if old, had := ref[key]; had != ok || (ok && prev.(int) != old) {
+				t.Fatal("set returned wrong previous value")
+			}
+			ref[key] = i
+		case 2:
+			prev, _, ok := tbl.del(key, 0)
+			if old, had := ref[key]; had != ok || (ok && prev.(int) != old) {
+				t.Fatal("del returned wrong previous value")
+			}
+			delete(ref, key)
+		}
+	}
+	if tbl.count != len(ref) {
+		t.Fatal("table count mismatch")
+	}
+	for key, val := range ref {
+		if got, ok := tbl.get(key); !ok || got.(int) != val {
+			t.Fatal("table lost an entry")
+		}
+	}
+}
+
 func GenerateTest(create func() store) func(*testing.T) {
 	return func(t *testing.T) {
 		t.Run("set/get", func(t *testing.T) {
 			m := create()
-			m.Set(1, 1)
+			m.Set(1, 1, 0, 0)
 			if val, _ := m.Get(1); val != nil && val.(int) != 1 {
 				t.Fatal("set-get error")
 			}
 		})
 		t.Run("set", func(t *testing.T) {
 			m := create()
-			m.Set(1, 1)
+			m.Set(1, 1, 0, 0)
 			// overwrite
-			m.Set(1, 2)
+			m.Set(1, 2, 0, 0)
 			if val, _ := m.Get(1); val != nil && val.(int) != 2 {
 				t.Fatal("set update error")
 			}
 		})
+		t.Run("update", func(t *testing.T) {
+			m := create()
+			if _, ok := m.Update(1, 1, 0, 0); ok {
+				t.Fatal("update added a missing key")
+			}
+			if _, found := m.Get(1); found {
+				t.Fatal("update added a missing key")
+			}
+			m.Set(1, 1, 0, 0)
+			if prev, ok := m.Update(1, 2, 0, 0); !ok || prev.(int) != 1 {
+				t.Fatal("update returned wrong previous value")
+			}
+			if val, _ := m.Get(1); val.(int) != 2 {
+				t.Fatal("update error")
+			}
+		})
+		t.Run("range", func(t *testing.T) {
+			m := create()
+			for i := uint64(0); i < 100; i++ {
+				m.Set(i, int(i), 0, 0)
+			}
+			seen := 0
+			m.Range(func(key uint64, value interface{}) bool {
+				if value.(int) != int(key) {
+					t.Fatal("range key-value mismatch")
+				}
+				seen++
+				return true
+			})
+			if seen != 100 {
+				t.Fatalf("expected 100 pairs but got %d\n", seen)
+			}
+			if m.Len() != 100 {
+				t.Fatalf("expected a length of 100 but got %d\n", m.Len())
+			}
+			seen = 0
+			m.Range(func(uint64, interface{}) bool {
+				seen++
+				return false
+			})
+			if seen != 1 {
+				t.Fatal("range didn't stop")
+			}
+		})
 		t.Run("del", func(t *testing.T) {
 			m := create()
-			m.Set(1, 1)
+			m.Set(1, 1, 0, 0)
 			// delete item
-			m.Del(1)
+			m.Del(1, 0)
 			if val, found := m.Get(1); val != nil || found {
 				t.Fatal("del error")
 			}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SetWithTags works like Set, but also tags the key, so it can be deleted
+// along with every other key carrying one of its tags by InvalidateTag. The
+// tags replace any the key already had, and stay with it until it leaves the
+// cache. Writes that don't take tags, like Set, keep them.
+func (c *Cache) SetWithTags(key, val interface{}, cost int64,
+	tags ...string) bool {
+	if c == nil {
+		return false
+	}
+	// the index keeps the tags, so they're copied
+	tags = append(make([]string, 0, len(tags)), tags...)
+	return c.set(c.keyToHash(key), val, cost, setOptions{tags: tags}, nil)
+}
+
+// InvalidateTag deletes every key tagged with tag, like Del, and returns how
+// many there were. Keys whose tagged Sets are still buffered aren't deleted.
+func (c *Cache) InvalidateTag(tag string) int {
+	if c == nil {
+		return 0
+	}
+	keys := c.tags.take(tag)
+	for _, hash := range keys {
+		c.del(hash, c.nextVersion())
+		c.publish(hash)
+	}
+	return len(keys)
+}
+
+// tagIndex maps tags to the keys carrying them, and keys to their tags.
+type tagIndex struct {
+	sync.Mutex
+	// used is set once a key was tagged, so caches that never tag keys
+	// don't lock the index when keys leave
+	used int32
+	keys map[string]map[uint64]struct{}
+	tags map[uint64][]string
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		keys: make(map[string]map[uint64]struct{}),
+		tags: make(map[uint64][]string),
+	}
+}
+
+// tag replaces the tags of key.
+func (t *tagIndex) tag(key uint64, tags []string) {
+	atomic.StoreInt32(&t.used, 1)
+	t.Lock()
+	defer t.Unlock()
+	t.remove(key)
+	if len(tags) == 0 {
+		return
+	}
+	for _, tag := range tags {
+		keys, ok := t.keys[tag]
+		if !ok {
+			keys = make(map[uint64]struct{})
+			t.keys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	t.tags[key] = tags
+}
+
+// untag forgets the tags of a key that left the cache.
+func (t *tagIndex) untag(key uint64) {
+	if atomic.LoadInt32(&t.used) == 0 {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.remove(key)
+}
+
+// remove forgets the tags of key. The caller must hold the lock.
+func (t *tagIndex) remove(key uint64) {
+	for _, tag := range t.tags[key] {
+		keys := t.keys[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.tags, key)
+}
+
+// take forgets the keys tagged with tag and returns them.
+func (t *tagIndex) take(tag string) []uint64 {
+	t.Lock()
+	defer t.Unlock()
+	keys := make([]uint64, 0, len(t.keys[tag]))
+	for key := range t.keys[tag] {
+		keys = append(keys, key)
+		t.remove(key)
+	}
+	return keys
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+)
+
+func TestCacheInvalidateTag(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.SetWithTags(1, 1, 1, "user:1")
+	cache.SetWithTags(2, 2, 1, "user:1", "user:2")
+	cache.SetWithTags(3, 3, 1, "user:2")
+	cache.Set(4, 4, 1)
+	if n := cache.InvalidateTag("user:1"); n != 2 {
+		t.Fatalf("expected 2 keys invalidated but got %d\n", n)
+	}
+	for key, want := range map[int]bool{1: false, 2: false, 3: true, 4: true} {
+		if _, ok := cache.Get(key); ok != want {
+			t.Fatalf("key %d: expected present %v\n", key, want)
+		}
+	}
+	// key 2 is gone, so it no longer carries user:2 either
+	if n := cache.InvalidateTag("user:2"); n != 1 {
+		t.Fatalf("expected 1 key invalidated but got %d\n", n)
+	}
+	if n := cache.InvalidateTag("user:1"); n != 0 {
+		t.Fatalf("expected no keys invalidated but got %d\n", n)
+	}
+}
+
+func TestCacheSetWithTagsReplace(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.SetWithTags(1, 1, 1, "a")
+	// plain Sets keep the tags
+	cache.Set(1, 2, 1)
+	cache.SetWithTags(1, 3, 1, "b")
+	if n := cache.InvalidateTag("a"); n != 0 {
+		t.Fatal("tags should be replaced by SetWithTags")
+	}
+	if n := cache.InvalidateTag("b"); n != 1 {
+		t.Fatal("tags should be kept by Set")
+	}
+	// keys leaving the cache lose their tags
+	cache.SetWithTags(1, 4, 1, "c")
+	cache.Del(1)
+	if len(cache.tags.tags) != 0 || len(cache.tags.keys) != 0 {
+		t.Fatal("deleted keys should be untagged")
+	}
+}
+
+func TestCacheSetWithTagsNil(t *testing.T) {
+	var cache *Cache
+	if cache.SetWithTags(1, 1, 1, "a") || cache.InvalidateTag("a") != 0 {
+		t.Fatal("nil cache shouldn't do anything")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// demotions tracks the keys handed down to Config.LowerTier, so that only
+// those are deleted from it when they're written, instead of every key
+// waiting on the lower tier's Set buffer.
+type demotions struct {
+	// keys holds the generation every key was demoted in
+	keys sync.Map
+	// n is about the number of keys, and gen the current generation, which
+	// are accessed atomically
+	n   int64
+	gen uint64
+}
+
+// add records that the key was demoted.
+func (d *demotions) add(hash uint64) {
+	if _, loaded := d.keys.LoadOrStore(hash, atomic.LoadUint64(&d.gen)); !loaded {
+		atomic.AddInt64(&d.n, 1)
+	}
+}
+
+// forget returns whether the key may be in the lower tier, and stops
+// tracking it.
+func (d *demotions) forget(hash uint64) bool {
+	if _, ok := d.keys.Load(hash); !ok {
+		return false
+	}
+	d.keys.Delete(hash)
+	atomic.AddInt64(&d.n, -1)
+	return true
+}
+
+// prune stops tracking the keys the lower tier evicted on its own, once
+// there are more than twice as many tracked keys as keys in the lower tier.
+// Keys demoted since the last prune are kept, as they may still be waiting
+// in the lower tier's Set buffer.
+func (d *demotions) prune(lower *Cache) {
+	if atomic.LoadInt64(&d.n) <= 2*int64(lower.store.Len())+1024 {
+		return
+	}
+	gen := atomic.AddUint64(&d.gen, 1) - 1
+	d.keys.Range(func(key, value interface{}) bool {
+		if value.(uint64) < gen && !lower.policy.Has(key.(uint64)) {
+			d.keys.Delete(key)
+			atomic.AddInt64(&d.n, -1)
+		}
+		return true
+	})
+}
+
+// newDemotions returns demotions tracking the keys already in lower, which
+// may have been demoted before the process restarted if it's persistent.
+func newDemotions(lower *Cache) *demotions {
+	d := &demotions{}
+	costs, _ := lower.policy.Costs()
+	for key := range costs {
+		d.add(key)
+	}
+	return d
+}
+
+// demote hands an evicted value down to Config.LowerTier. Only []byte values
+// are demoted, with the TTL they have left, if any.
+func (c *Cache) demote(hash uint64, val interface{}, cost int64, flags uint32) {
+	if c.lowerTier == nil {
+		return
+	}
+	val, opts, live := moving(val, c.clock.Now())
+	if !live {
+		return
+	}
+	val, ok := c.decode(val)
+	b, bytes := val.([]byte)
+	if !ok || !bytes {
+		return
+	}
+	// the lower tier takes whatever it's handed, as it was admitted here,
+	// and a copy of it, as the value is handed to OnExit once it's evicted
+	c.demoted.add(hash)
+	opts.flags = flags
+	if c.lowerTier.set(hash, append([]byte(nil), b...), cost, opts, nil) {
+		c.stats.Add(keyDemote, hash, 1)
+	}
+}
+
+// moving strips the expiration off a stored value moved between tiers, and
+// returns the setOptions keeping the TTL it has left at now, if it expires.
+// It returns false if the value expired. Idle TTLs are kept as the time left
+// until the value expires if it isn't accessed.
+func moving(stored interface{}, now time.Time) (interface{}, setOptions,
+	bool) {
+	opts := setOptions{noAdmit: true}
+	v, ok := stored.(*expiringValue)
+	if !ok {
+		return stored, opts, true
+	}
+	if v.expired(now) {
+		return nil, opts, false
+	}
+	opts.exp, opts.expires = expiry{ttl: v.deadline().Sub(now)}, true
+	return v.val, opts, true
+}
+
+// promote moves a key missing from the cache up from Config.LowerTier, and
+// returns its value. If acquire is true, it also returns a reference to the
+// value, taken before it's set so exit can't miss it.
+func (c *Cache) promote(hash uint64, acquire bool) (interface{}, *valueRef,
+	bool) {
+	b, ok := c.lowerTier.get(hash)
+	if !ok {
+		return nil, nil, false
+	}
+	// the value keeps the TTL it has left in the lower tier, if it's still
+	// there
+	opts := setOptions{noAdmit: true}
+	if stored, ok := c.lowerTier.store.Get(hash); ok {
+		if _, moved, live := moving(stored, c.lowerTier.clock.Now()); live {
+			opts = moved
+		}
+	}
+	// the lower tier hands the value to OnExit once it's deleted from it
+	val := append([]byte(nil), b.([]byte)...)
+	cost, ok := c.lowerTier.policy.Cost(hash)
+	if !ok {
+		cost = int64(len(val))
+	}
+	// encoded here rather than by set, so the value referenced is the one
+	// that's stored
+	stored, cost := c.encode(val, cost)
+	var ref *valueRef
+	if acquire {
+		c.refs.Lock()
+		ref = c.refs.acquire(stored)
+		c.refs.Unlock()
+	}
+	// a key found in the lower tier is worth keeping in memory, and setting
+	// it deletes it from the lower tier
+	c.set(hash, stored, cost, opts, nil)
+	c.stats.Add(keyPromote, hash, 1)
+	return val, ref, true
+}
+
+// forget deletes a key from Config.LowerTier, so it can't come back up with
+// an outdated value. Keys that were never demoted are left alone, so writes
+// don't wait on the lower tier's Set buffer.
+func (c *Cache) forget(hash uint64) {
+	if c.lowerTier != nil && c.demoted.forget(hash) {
+		c.lowerTier.del(hash, c.lowerTier.nextVersion())
+	}
+}
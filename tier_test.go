@@ -0,0 +1,248 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheLowerTier(t *testing.T) {
+	newCache := func(maxCost int64, lower *Cache) *Cache {
+		cache, err := NewCache(&Config{
+			NumCounters:       100,
+			MaxCost:           maxCost,
+			BufferItems:       64,
+			Metrics:           true,
+			DeterministicMode: true,
+			LowerTier:         lower,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return cache
+	}
+	lower := newCache(100, nil)
+	defer lower.Close()
+	cache := newCache(3, lower)
+	defer cache.Close()
+	for key := 0; key < 6; key++ {
+		cache.Set(key, []byte{byte(key)}, 1, WithNoAdmission())
+	}
+	cache.Set(6, "not bytes", 1, WithNoAdmission())
+	demoted := cache.Metrics().Get(keyDemote)
+	if demoted != 4 || lower.store.Len() != 4 {
+		t.Fatalf("expected 4 demoted keys but got %d\n", demoted)
+	}
+	var down uint64
+	for key := uint64(0); key < 6; key++ {
+		if _, ok := lower.store.Get(key); ok {
+			down = key
+			break
+		}
+	}
+	if !cache.Has(down) || cache.Metrics().Get(keyPromote) != 0 {
+		t.Fatal("Has should find demoted keys without promoting them")
+	}
+	val, ok := cache.Get(down)
+	if !ok || val.([]byte)[0] != byte(down) {
+		t.Fatal("demoted keys should be found in the lower tier")
+	}
+	if _, ok := lower.store.Get(down); ok {
+		t.Fatal("promoted keys should leave the lower tier")
+	}
+	if _, ok := cache.store.Get(down); !ok {
+		t.Fatal("promoted keys should be moved up")
+	}
+	if cache.Metrics().Get(keyPromote) != 1 {
+		t.Fatal("expected a single promotion")
+	}
+	// so do GetWithInfo and Acquire
+	for key := uint64(0); key < 6; key++ {
+		if _, ok := lower.store.Get(key); !ok || key == down {
+			continue
+		}
+		if _, info, ok := cache.GetWithInfo(key); !ok || info.Version == 0 {
+			t.Fatal("GetWithInfo should promote keys and describe them")
+		}
+		break
+	}
+	for key := uint64(0); key < 6; key++ {
+		if _, ok := lower.store.Get(key); !ok {
+			continue
+		}
+		h, ok := cache.Acquire(key)
+		if !ok || h.Value().([]byte)[0] != byte(key) {
+			t.Fatal("Acquire should promote keys")
+		}
+		h.Release()
+		break
+	}
+	if cache.Metrics().Get(keyPromote) != 3 {
+		t.Fatal("expected three promotions")
+	}
+	// writes don't leave outdated values behind in the lower tier
+	for key := uint64(0); key < 6; key++ {
+		if _, ok := lower.store.Get(key); !ok {
+			continue
+		}
+		cache.Del(key)
+		if _, ok := cache.Get(key); ok {
+			t.Fatalf("deleted key %d came back from the lower tier\n", key)
+		}
+	}
+}
+
+func TestCacheLowerTierTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	newCache := func(maxCost int64, lower *Cache) *Cache {
+		cache, err := NewCache(&Config{
+			NumCounters:       100,
+			MaxCost:           maxCost,
+			BufferItems:       64,
+			Metrics:           true,
+			DeterministicMode: true,
+			Clock:             clock,
+			DefaultTTL:        10 * time.Second,
+			LowerTier:         lower,
+		})
+		if err != nil {
+			panic(err)
+		}
+		return cache
+	}
+	lower := newCache(100, nil)
+	defer lower.Close()
+	cache := newCache(1, lower)
+	defer cache.Close()
+	cache.Set(1, []byte{1}, 1, WithNoAdmission())
+	clock.Advance(4 * time.Second)
+	// evicts key 1 with 6s left, and key 2 with 3s left later on
+	cache.Set(2, []byte{2}, 1, WithTTL(7*time.Second), WithNoAdmission())
+	clock.Advance(4 * time.Second)
+	cache.Set(3, []byte{3}, 1, WithNoAdmission())
+	if demoted := cache.Metrics().Get(keyDemote); demoted != 2 {
+		t.Fatalf("expected 2 demoted keys but got %d\n", demoted)
+	}
+	if ttl, ok := lower.TTL(uint64(2)); !ok || ttl != 3*time.Second {
+		t.Fatalf("expected 3s left in the lower tier, got %v\n", ttl)
+	}
+	// promoted keys keep the TTL they have left
+	if val, ok := cache.Get(1); !ok || val.([]byte)[0] != 1 {
+		t.Fatal("keys set with DefaultTTL should be demoted")
+	}
+	if ttl, ok := cache.TTL(1); !ok || ttl != 2*time.Second {
+		t.Fatalf("expected 2s left after the promotion, got %v\n", ttl)
+	}
+	clock.Advance(3 * time.Second)
+	if _, ok := cache.Get(2); ok {
+		t.Fatal("demoted keys should expire in the lower tier")
+	}
+	// expired keys aren't demoted
+	cache.Set(4, []byte{4}, 1, WithTTL(time.Second), WithNoAdmission())
+	clock.Advance(time.Second)
+	cache.Set(5, []byte{5}, 1, WithNoAdmission())
+	if _, ok := lower.store.Get(4); ok {
+		t.Fatal("expired keys shouldn't be demoted")
+	}
+}
+
+func TestCacheLowerTierHandoff(t *testing.T) {
+	lower, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           100,
+		BufferItems:       64,
+		DeterministicMode: true,
+		OnExit: func(val interface{}) {
+			val.([]byte)[0] = 0
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           1,
+		BufferItems:       64,
+		DeterministicMode: true,
+		LowerTier:         lower,
+		OnExit: func(val interface{}) {
+			val.([]byte)[0] = 0
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, []byte{1}, 1)
+	cache.Set(2, []byte{2}, 1, WithNoAdmission())
+	if val, ok := lower.store.Get(1); !ok || val.([]byte)[0] != 1 {
+		t.Fatal("demoted values shouldn't be handed to OnExit")
+	}
+	val, ok := cache.Get(1)
+	if !ok || val.([]byte)[0] != 1 {
+		t.Fatal("promoted values shouldn't be handed to OnExit")
+	}
+}
+
+func TestCacheLowerTierWritesDontWait(t *testing.T) {
+	evicting, unblock := make(chan struct{}), make(chan struct{})
+	lower, err := NewCache(&Config{
+		NumCounters:   100,
+		MaxCost:       1,
+		BufferItems:   64,
+		SetBufferSize: 16,
+		OnEvict: func(key uint64, value interface{}, cost int64) {
+			close(evicting)
+			<-unblock
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer close(unblock)
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           100,
+		BufferItems:       64,
+		DeterministicMode: true,
+		LowerTier:         lower,
+	})
+	if err != nil {
+		panic(err)
+	}
+	// block the lower tier's worker, and fill its Set buffer
+	for !lower.Set(1, []byte{1}, 1) {
+	}
+	lower.Get(2)
+	lower.Get(2)
+	for !lower.Set(2, []byte{2}, 1) {
+	}
+	<-evicting
+	for lower.Set(3, []byte{3}, 1) {
+	}
+	done := make(chan struct{})
+	go func() {
+		cache.Set(4, []byte{4}, 1)
+		cache.Del(4)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("writes of keys never demoted waited on the lower tier")
+	}
+}
@@ -0,0 +1,338 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expirationInterval is how often expired keys are removed from the cache.
+// Keys are grouped in buckets spanning expirationInterval by their
+// expiration, so a whole bucket is removed at once.
+const expirationInterval = time.Second
+
+// expiringValue wraps values set with a TTL, so the store keeps their
+// expiration along with them.
+type expiringValue struct {
+	// expiration is in Unix nanoseconds. It's accessed atomically, since
+	// Gets extend it for idle TTLs.
+	expiration int64
+	val        interface{}
+	cost       int64
+	version    uint64
+	// idle, if set, is how long the value lives after its last access
+	idle time.Duration
+}
+
+// deadline returns the time the value expires.
+func (v *expiringValue) deadline() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&v.expiration))
+}
+
+// expired reports whether the value expired at now.
+func (v *expiringValue) expired(now time.Time) bool {
+	return now.UnixNano() >= atomic.LoadInt64(&v.expiration)
+}
+
+// access extends the expiration of an idle TTL.
+func (v *expiringValue) access(now time.Time) {
+	if v.idle > 0 {
+		atomic.StoreInt64(&v.expiration, now.Add(v.idle).UnixNano())
+	}
+}
+
+// expiringKey is a version of a key waiting in an expiration bucket.
+type expiringKey struct {
+	key     uint64
+	version uint64
+}
+
+// expirationMap groups expiring keys in buckets by expiration. Keys aren't
+// removed from their bucket when they're updated or deleted, so a bucket may
+// hold stale versions, which have to be checked against the store.
+type expirationMap struct {
+	sync.Mutex
+	buckets map[int64][]expiringKey
+	// next is the first bucket that hasn't been removed yet
+	next int64
+}
+
+func newExpirationMap() *expirationMap {
+	return &expirationMap{buckets: make(map[int64][]expiringKey)}
+}
+
+// bucket returns the bucket of keys expiring at expiration, which is due once
+// every key in it expired.
+func bucket(expiration time.Time) int64 {
+	return expiration.UnixNano()/int64(expirationInterval) + 1
+}
+
+// add registers the version of a key expiring at expiration.
+func (m *expirationMap) add(key, version uint64, expiration time.Time) {
+	b := bucket(expiration)
+	m.Lock()
+	defer m.Unlock()
+	if b < m.next {
+		// the bucket was removed already, so use the next one due
+		b = m.next
+	}
+	m.buckets[b] = append(m.buckets[b], expiringKey{key: key, version: version})
+}
+
+// due removes and returns the keys of every bucket that's due at now.
+func (m *expirationMap) due(now time.Time) []expiringKey {
+	last := now.UnixNano() / int64(expirationInterval)
+	m.Lock()
+	defer m.Unlock()
+	if last < m.next {
+		return nil
+	}
+	var keys []expiringKey
+	if last-m.next < int64(len(m.buckets)) {
+		for b := m.next; b <= last; b++ {
+			keys = append(keys, m.buckets[b]...)
+			delete(m.buckets, b)
+		}
+	} else {
+		// the clock jumped ahead, so it's cheaper to walk the buckets
+		for b, expiring := range m.buckets {
+			if b <= last {
+				keys = append(keys, expiring...)
+				delete(m.buckets, b)
+			}
+		}
+	}
+	m.next = last + 1
+	return keys
+}
+
+// SetWithTTL works like Set, but the key expires after ttl. Expired keys are
+// no longer returned by Get, and are removed from the cache within a second
+// or so, which calls Config.OnExpire. A ttl of zero never expires, and a
+// negative ttl drops the Set.
+func (c *Cache) SetWithTTL(key, val interface{}, cost int64,
+	ttl time.Duration) bool {
+	if c == nil || ttl < 0 {
+		return false
+	}
+	return c.set(c.keyToHash(key), val, cost,
+		setOptions{exp: expiry{ttl: ttl}, expires: true}, nil)
+}
+
+// SetWithIdleTTL works like SetWithTTL, but the key expires once it hasn't
+// been accessed for idle, so every Get extends its TTL, like sessions timing
+// out. Only Get and GetOrCompute count as accesses.
+func (c *Cache) SetWithIdleTTL(key, val interface{}, cost int64,
+	idle time.Duration) bool {
+	if c == nil || idle < 0 {
+		return false
+	}
+	return c.set(c.keyToHash(key), val, cost,
+		setOptions{exp: expiry{ttl: idle, idle: true},
+			expires: true}, nil)
+}
+
+// TTL returns how long the key has left before it expires, or zero if it
+// never expires. It returns false if the key is missing or expired. It
+// doesn't count as an access.
+func (c *Cache) TTL(key interface{}) (time.Duration, bool) {
+	if c == nil {
+		return 0, false
+	}
+	stored, ok := c.store.Get(c.keyToHash(key))
+	if !ok {
+		return 0, false
+	}
+	v, ok := stored.(*expiringValue)
+	if !ok {
+		return 0, true
+	}
+	now := c.clock.Now()
+	if v.expired(now) {
+		return 0, false
+	}
+	return v.deadline().Sub(now), true
+}
+
+// Touch replaces the remaining TTL of a key with ttl, like setting it again
+// with the same value but without going through the policy, for example to
+// keep a session alive. Keys set with SetWithIdleTTL still expire once they
+// were idle for their original TTL after their next access. It returns false
+// if the key is missing, expired or was set without a TTL, or if ttl isn't
+// positive.
+func (c *Cache) Touch(key interface{}, ttl time.Duration) bool {
+	if c == nil || ttl <= 0 {
+		return false
+	}
+	hash := c.keyToHash(key)
+	stored, ok := c.store.Get(hash)
+	if !ok {
+		return false
+	}
+	v, ok := stored.(*expiringValue)
+	if !ok {
+		return false
+	}
+	now := c.clock.Now()
+	if v.expired(now) {
+		return false
+	}
+	expiration := now.Add(ttl)
+	prev := v.deadline()
+	atomic.StoreInt64(&v.expiration, expiration.UnixNano())
+	if expiration.Before(prev) {
+		// later expirations are picked up when the current bucket is due
+		c.expirations.add(hash, v.version, expiration)
+	}
+	return true
+}
+
+// expiry describes when a value expires.
+type expiry struct {
+	// ttl is zero for values that never expire
+	ttl time.Duration
+	// idle extends the ttl on every access
+	idle bool
+}
+
+// defaultExpiry describes when keys added without a TTL expire.
+func (c *Cache) defaultExpiry() expiry {
+	return expiry{ttl: c.config.DefaultTTL}
+}
+
+// expire wraps version of val so it expires after exp.ttl, give or take
+// Config.TTLJitter, unless the ttl is zero.
+func (c *Cache) expire(val interface{}, cost int64, exp expiry,
+	version uint64) interface{} {
+	ttl := exp.ttl
+	if ttl == 0 {
+		return val
+	}
+	if c.ttlJitter > 0 {
+		ttl = time.Duration(float64(ttl) * (1 + c.ttlJitter*(2*rand.Float64()-1)))
+	}
+	v := &expiringValue{
+		expiration: c.clock.Now().Add(ttl).UnixNano(),
+		val:        val,
+		cost:       cost,
+		version:    version,
+	}
+	if exp.idle {
+		v.idle = ttl
+	}
+	return v
+}
+
+// keepExpiry wraps version of val so it expires like stored, the value it
+// replaces, if stored expires. Writes swapping the value of a key in place
+// keep its TTL this way.
+func keepExpiry(stored, val interface{}, cost int64,
+	version uint64) interface{} {
+	v, ok := stored.(*expiringValue)
+	if !ok {
+		return val
+	}
+	return &expiringValue{
+		expiration: atomic.LoadInt64(&v.expiration),
+		val:        val,
+		cost:       cost,
+		version:    version,
+		idle:       v.idle,
+	}
+}
+
+// track registers a key that was just stored with val, if val expires.
+func (c *Cache) track(key uint64, val interface{}) {
+	if v, ok := val.(*expiringValue); ok {
+		c.expirations.add(key, v.version, v.deadline())
+	}
+}
+
+// value strips the expiration off a stored value, if any.
+func value(val interface{}) interface{} {
+	if v, ok := val.(*expiringValue); ok {
+		return v.val
+	}
+	return val
+}
+
+// peek is like unwrap, but doesn't count as an access.
+func (c *Cache) peek(val interface{}) (interface{}, bool) {
+	v, ok := val.(*expiringValue)
+	if !ok {
+		return val, true
+	}
+	if v.expired(c.clock.Now()) {
+		return nil, false
+	}
+	return v.val, true
+}
+
+// unwrap strips the expiration off a stored value that's being accessed,
+// returning false if it expired.
+func (c *Cache) unwrap(val interface{}) (interface{}, bool) {
+	v, ok := val.(*expiringValue)
+	if !ok {
+		return val, true
+	}
+	now := c.clock.Now()
+	if v.expired(now) {
+		return nil, false
+	}
+	v.access(now)
+	return v.val, true
+}
+
+// removeExpired removes the keys that expired from the cache. The caller
+// must hold every lock in processMu.
+func (c *Cache) removeExpired() {
+	now := c.clock.Now()
+	for _, e := range c.expirations.due(now) {
+		stored, ok := c.store.Get(e.key)
+		if !ok {
+			continue
+		}
+		// the key may have been updated since, and its new version tracked
+		v, ok := stored.(*expiringValue)
+		if !ok || v.version != e.version {
+			continue
+		}
+		if !v.expired(now) {
+			// accesses extended the idle TTL, so it's due in a later bucket
+			c.expirations.add(e.key, e.version, v.deadline())
+			continue
+		}
+		if _, _, ok := c.store.Del(e.key, e.version); !ok {
+			continue
+		}
+		c.policy.Del(e.key)
+		c.died(e.key)
+		c.tags.untag(e.key)
+		c.stats.Add(keyExpire, e.key, 1)
+		if c.onExpire != nil {
+			if val, ok := c.decode(v.val); ok {
+				c.callback("OnExpire", func() {
+					c.onExpire(e.key, val, v.cost)
+				})
+			}
+		}
+		c.exit(stored)
+	}
+}
@@ -0,0 +1,340 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExpirationMap(t *testing.T) {
+	m := newExpirationMap()
+	start := time.Unix(100, 0)
+	m.add(1, 1, start.Add(time.Second))
+	m.add(2, 2, start.Add(time.Minute))
+	if keys := m.due(start); len(keys) != 0 {
+		t.Fatalf("expected no keys due but got %v\n", keys)
+	}
+	keys := m.due(start.Add(2 * time.Second))
+	if len(keys) != 1 || keys[0] != (expiringKey{key: 1, version: 1}) {
+		t.Fatalf("expected key 1 to be due but got %v\n", keys)
+	}
+	// keys expiring in buckets that were already removed go in the next one
+	m.add(3, 3, start)
+	keys = m.due(start.Add(time.Hour))
+	if len(keys) != 2 {
+		t.Fatalf("expected keys 2 and 3 to be due but got %v\n", keys)
+	}
+	if len(m.buckets) != 0 {
+		t.Fatal("due buckets should be removed")
+	}
+}
+
+func newTTLCache(clock Clock, expired, evicted *[]uint64) *Cache {
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+		Clock:             clock,
+		OnExpire: func(key uint64, _ interface{}, _ int64) {
+			*expired = append(*expired, key)
+		},
+		OnEvict: func(key uint64, _ interface{}, _ int64) {
+			*evicted = append(*evicted, key)
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	return cache
+}
+
+func TestCacheSetWithTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	if !cache.SetWithTTL(1, 1, 1, time.Second) {
+		t.Fatal("Set was dropped")
+	}
+	if cache.SetWithTTL(2, 2, 1, -time.Second) {
+		t.Fatal("negative TTLs should drop the Set")
+	}
+	if _, ok := cache.Get(1); !ok {
+		t.Fatal("value shouldn't have expired yet")
+	}
+	clock.Advance(time.Second)
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("expired values shouldn't be returned")
+	}
+	// expired keys are removed once the next bucket is due
+	clock.Advance(time.Second)
+	cache.Set(3, 3, 1)
+	if len(expired) != 1 || expired[0] != cache.keyToHash(1) {
+		t.Fatalf("expected key 1 to expire but got %v\n", expired)
+	}
+	if len(evicted) != 0 {
+		t.Fatal("expired keys shouldn't be evicted")
+	}
+	if n := cache.Metrics().Get(keyExpire); n != 1 {
+		t.Fatalf("expected 1 expired key but got %d\n", n)
+	}
+	if _, used := cache.policy.Costs(); used != 1 {
+		t.Fatalf("expired keys should give their cost back, got %d\n", used)
+	}
+}
+
+func TestCacheSetWithTTLUpdate(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	cache.SetWithTTL(1, 1, 1, time.Second)
+	cache.SetWithTTL(1, 2, 1, time.Minute)
+	cache.SetWithTTL(2, 1, 1, time.Second)
+	cache.Set(2, 2, 1)
+	clock.Advance(time.Minute / 2)
+	cache.Set(3, 3, 1)
+	if len(expired) != 0 {
+		t.Fatalf("updated keys shouldn't expire early, got %v\n", expired)
+	}
+	if val, ok := cache.Get(2); !ok || val.(int) != 2 {
+		t.Fatal("keys set without a TTL shouldn't expire")
+	}
+	clock.Advance(time.Hour)
+	cache.Set(3, 3, 1)
+	if len(expired) != 1 || expired[0] != cache.keyToHash(1) {
+		t.Fatalf("expected key 1 to expire but got %v\n", expired)
+	}
+}
+
+func TestCacheExpireInBackground(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	expired := make(chan uint64, 1)
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+		Clock:       clock,
+		OnExpire: func(key uint64, _ interface{}, _ int64) {
+			expired <- key
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.SetWithTTL(1, 1, 1, time.Second)
+	for {
+		if _, ok := cache.Get(1); ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for {
+		clock.Advance(time.Second)
+		select {
+		case key := <-expired:
+			if key != cache.keyToHash(1) {
+				t.Fatalf("expected key 1 to expire but got %d\n", key)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestCacheTTLJitter(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		DeterministicMode: true,
+		Clock:             clock,
+		TTLJitter:         0.5,
+	})
+	if err != nil {
+		panic(err)
+	}
+	ttls := make(map[time.Duration]struct{})
+	for key := 0; key < 100; key++ {
+		cache.SetWithTTL(key, key, 1, 100*time.Second)
+		stored, _ := cache.store.Get(cache.keyToHash(key))
+		ttl := stored.(*expiringValue).deadline().Sub(clock.Now())
+		if ttl < 50*time.Second || ttl > 150*time.Second {
+			t.Fatalf("TTL %v is out of bounds\n", ttl)
+		}
+		ttls[ttl] = struct{}{}
+	}
+	if len(ttls) < 50 {
+		t.Fatalf("expected TTLs to vary but got %d distinct TTLs\n", len(ttls))
+	}
+}
+
+func TestCacheDefaultTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		DeterministicMode: true,
+		Clock:             clock,
+		DefaultTTL:        time.Second,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	cache.Set(2, 2, 1, WithTTL(0))
+	cache.Set(3, 3, 1, WithTTL(2*time.Second))
+	cache.SetWithTTL(4, 4, 1, 0)
+	cache.GetOrCompute(5, func() (interface{}, int64, error) {
+		return 5, 1, nil
+	})
+	cache.SetWithTags(6, 6, 1, "tag")
+	cache.SetWithFlags(7, 7, 1, 1)
+	cache.SetContext(context.Background(), 8, 8, 1)
+	cache.GetAndSet(9, 9, 1)
+	warmed := false
+	cache.Warm(context.Background(), func() (interface{}, interface{}, int64,
+		bool) {
+		if warmed {
+			return nil, nil, 0, false
+		}
+		warmed = true
+		return 10, 10, 1, true
+	})
+	// writes swapping the value of a cached key keep its TTL
+	for key := 11; key <= 13; key++ {
+		cache.Set(key, key, 1)
+	}
+	cache.Set(14, 14, 1, WithTTL(2*time.Second))
+	_, info, _ := cache.GetWithInfo(12)
+	if !cache.Replace(11, 11, 1) || !cache.SetIfVersion(12, 12, 1, info.Version) {
+		t.Fatal("cached keys should be replaced")
+	}
+	cache.GetAndSet(13, 13, 1)
+	cache.Replace(14, 14, 1)
+	if ttl, _ := cache.TTL(14); ttl != 2*time.Second {
+		t.Fatalf("Replace should keep the TTL of the key, not %v\n", ttl)
+	}
+	clock.Advance(time.Second)
+	for _, key := range []int{1, 5, 6, 7, 8, 9, 10, 11, 12, 13} {
+		if _, ok := cache.Get(key); ok {
+			t.Fatalf("key %d was set without a TTL and should get DefaultTTL\n",
+				key)
+		}
+	}
+	if _, ok := cache.Get(4); !ok {
+		t.Fatal("SetWithTTL with a ttl of zero should never expire")
+	}
+	if _, ok := cache.Get(2); !ok {
+		t.Fatal("WithTTL(0) should never expire")
+	}
+	if _, ok := cache.Get(3); !ok {
+		t.Fatal("WithTTL should override DefaultTTL")
+	}
+	if _, ok := cache.Get(14); !ok {
+		t.Fatal("Replace shouldn't shorten the TTL of the key")
+	}
+}
+
+func TestCacheSetWithIdleTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	if cache.SetWithIdleTTL(1, 1, 1, -time.Second) {
+		t.Fatal("negative TTLs should drop the Set")
+	}
+	cache.SetWithIdleTTL(1, 1, 1, 2*time.Second)
+	// every access keeps the key alive for another 2 seconds
+	for i := 0; i < 10; i++ {
+		clock.Advance(time.Second)
+		cache.Set(2, 2, 1)
+		if _, ok := cache.Get(1); !ok {
+			t.Fatalf("key expired after %d seconds despite accesses\n", i+1)
+		}
+	}
+	if len(expired) != 0 {
+		t.Fatalf("accessed keys shouldn't expire, got %v\n", expired)
+	}
+	clock.Advance(2 * time.Second)
+	if _, ok := cache.Get(1); ok {
+		t.Fatal("idle keys should expire")
+	}
+	clock.Advance(time.Second)
+	cache.Set(2, 2, 1)
+	if len(expired) != 1 || expired[0] != cache.keyToHash(1) {
+		t.Fatalf("expected key 1 to expire but got %v\n", expired)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	if _, ok := cache.TTL(1); ok {
+		t.Fatal("missing keys shouldn't have a TTL")
+	}
+	cache.Set(1, 1, 1)
+	if ttl, ok := cache.TTL(1); !ok || ttl != 0 {
+		t.Fatalf("keys without a TTL should report zero, got %v\n", ttl)
+	}
+	cache.SetWithTTL(2, 2, 1, time.Minute)
+	clock.Advance(time.Second)
+	if ttl, ok := cache.TTL(2); !ok || ttl != time.Minute-time.Second {
+		t.Fatalf("unexpected TTL %v\n", ttl)
+	}
+	clock.Advance(time.Minute)
+	if _, ok := cache.TTL(2); ok {
+		t.Fatal("expired keys shouldn't have a TTL")
+	}
+}
+
+func TestCacheTouch(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	var expired, evicted []uint64
+	cache := newTTLCache(clock, &expired, &evicted)
+	cache.Set(1, 1, 1)
+	if cache.Touch(1, time.Minute) {
+		t.Fatal("keys without a TTL shouldn't be touched")
+	}
+	cache.SetWithTTL(2, 2, 1, 2*time.Second)
+	if cache.Touch(2, 0) {
+		t.Fatal("non-positive TTLs shouldn't be accepted")
+	}
+	// extending the TTL
+	clock.Advance(time.Second)
+	if !cache.Touch(2, time.Minute) {
+		t.Fatal("key wasn't touched")
+	}
+	clock.Advance(10 * time.Second)
+	cache.Set(3, 3, 1)
+	if _, ok := cache.Get(2); !ok || len(expired) != 0 {
+		t.Fatal("touched keys shouldn't expire early")
+	}
+	// shortening the TTL
+	cache.Touch(2, time.Second)
+	clock.Advance(2 * time.Second)
+	cache.Set(3, 3, 1)
+	if len(expired) != 1 || expired[0] != cache.keyToHash(2) {
+		t.Fatalf("expected key 2 to expire but got %v\n", expired)
+	}
+	if cache.Touch(2, time.Minute) {
+		t.Fatal("expired keys shouldn't be touched")
+	}
+}
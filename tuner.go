@@ -0,0 +1,233 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sync"
+	"time"
+)
+
+// tuneMinGets is the fewest Gets a tuning period needs for its hit ratio to
+// be told apart from noise. Periods with fewer Gets are extended.
+const tuneMinGets = 1000
+
+// knob is a parameter tuned by Config.AutoTune, which takes one of values.
+type knob struct {
+	name   string
+	values []float64
+	at     int
+	apply  func(float64)
+}
+
+// newKnob returns a knob set to the first of values that's at least value.
+func newKnob(name string, values []float64, value float64,
+	apply func(float64)) *knob {
+	k := &knob{name: name, values: values, at: len(values) - 1, apply: apply}
+	for i, v := range values {
+		if v >= value {
+			k.at = i
+			break
+		}
+	}
+	return k
+}
+
+// tuner tunes knobs by hill climbing: it changes one knob a notch, keeps the
+// change if the hit ratio improved over the next period, and reverts it
+// otherwise, trying the other direction and then the next knob.
+type tuner struct {
+	knobs []*knob
+	// knob is the knob being tuned, in direction dir
+	knob, dir int
+	// tried counts the directions tried for knob since a change was kept
+	tried int
+	// prev is the notch knob was at before the change being evaluated, or
+	// -1 if there's none, in which case the next period measures baseline
+	prev     int
+	baseline float64
+	// hits and misses are the counts at the start of the period
+	hits, misses uint64
+	stats        *metrics
+}
+
+// tunables returns the parts of p that can be tuned, which are its shards if
+// it's sharded, or nil if it can't be tuned.
+func tunables(p policy) []tunablePolicy {
+	shards := []policy{p}
+	if sharded, ok := p.(*shardedPolicy); ok {
+		shards = sharded.shards
+	}
+	var tunables []tunablePolicy
+	for _, shard := range shards {
+		tunable, ok := shard.(tunablePolicy)
+		if !ok {
+			return nil
+		}
+		tunables = append(tunables, tunable)
+	}
+	return tunables
+}
+
+// newTuner returns a tuner of the knobs of policies, which are the tunables
+// of the policy of c.
+func newTuner(c *Cache, policies []tunablePolicy) *tuner {
+	sample := c.config.EvictionSampleSize
+	if sample == 0 {
+		sample = DefaultEvictionSampleSize
+	}
+	t := &tuner{
+		knobs: []*knob{
+			newKnob("eviction-sample-size",
+				[]float64{2, 3, 4, 5, 6, 8, 10, 12, 16, 24, 32},
+				float64(sample), func(v float64) {
+					for _, p := range policies {
+						p.SampleEvictions(int(v),
+							c.config.AdaptiveEvictionSample)
+					}
+				}),
+			newKnob("counter-reset-scale", []float64{0.25, 0.5, 1, 2, 4}, 1,
+				func(v float64) {
+					for _, p := range policies {
+						p.ScaleResets(v)
+					}
+				}),
+			newKnob("admission-grace", []float64{0, 16, 64, 256, 1024, 4096},
+				float64(c.config.AdmissionGrace), func(v float64) {
+					for _, p := range policies {
+						p.ProtectNewKeys(int(v))
+					}
+				}),
+		},
+		dir:   1,
+		prev:  -1,
+		stats: c.stats,
+	}
+	t.stats.tuning = &tuningStats{values: make(map[string]float64)}
+	for _, k := range t.knobs {
+		t.stats.tuning.set(k.name, k.values[k.at])
+	}
+	t.hits, t.misses = t.stats.Get(hit), t.stats.Get(miss)
+	return t
+}
+
+// autoTune runs t every period, for Config.AutoTune, until the cache is
+// stopped.
+func (c *Cache) autoTune(t *tuner, period time.Duration) {
+	defer c.background.Done()
+	for {
+		select {
+		case <-c.clock.After(period):
+		case <-c.stop:
+			return
+		}
+		t.tick()
+	}
+}
+
+// tick ends the period if it had enough Gets, and steps with its hit ratio.
+func (t *tuner) tick() {
+	hits, misses := t.stats.Get(hit), t.stats.Get(miss)
+	if hits < t.hits || misses < t.misses {
+		// the metrics were reset, so the period starts over
+		t.hits, t.misses = hits, misses
+		return
+	}
+	gets := hits - t.hits + misses - t.misses
+	if gets < tuneMinGets {
+		return
+	}
+	t.step(float64(hits-t.hits) / float64(gets))
+	t.hits, t.misses = hits, misses
+}
+
+// step evaluates the change made at the end of the last period, if any,
+// given the hit ratio of the period, and makes the next one.
+func (t *tuner) step(ratio float64) {
+	k := t.knobs[t.knob]
+	switch {
+	case t.prev < 0:
+		t.baseline = ratio
+	case ratio > t.baseline:
+		t.stats.Add(tuneKeep, 0, 1)
+		t.baseline, t.tried = ratio, 0
+	default:
+		t.stats.Add(tuneRevert, 0, 1)
+		t.set(k, t.prev)
+		t.prev = -1
+		// the baseline is measured again before the next change, as the
+		// workload may have changed too
+		t.turn()
+		return
+	}
+	// change the knob a notch, turning at the ends of its values
+	for i := 0; i < 2*len(t.knobs); i++ {
+		k = t.knobs[t.knob]
+		if at := k.at + t.dir; at >= 0 && at < len(k.values) {
+			t.prev = k.at
+			t.set(k, at)
+			return
+		}
+		t.turn()
+	}
+	t.prev = -1
+}
+
+// turn tries the other direction of the knob, or the next knob once both
+// directions were tried.
+func (t *tuner) turn() {
+	t.dir = -t.dir
+	if t.tried++; t.tried >= 2 {
+		t.knob = (t.knob + 1) % len(t.knobs)
+		t.tried = 0
+	}
+}
+
+// set changes k to the notch at.
+func (t *tuner) set(k *knob, at int) {
+	k.at = at
+	k.apply(k.values[at])
+	t.stats.tuning.set(k.name, k.values[at])
+}
+
+// tuningStats holds the values of the parameters tuned by Config.AutoTune.
+type tuningStats struct {
+	sync.Mutex
+	values map[string]float64
+}
+
+func (s *tuningStats) set(name string, value float64) {
+	s.Lock()
+	s.values[name] = value
+	s.Unlock()
+}
+
+// Tuning returns the values Config.AutoTune currently uses for the
+// parameters it tunes, by name, or nil if it's off. They're the eviction
+// sample size, the number of accesses after which the access counters are
+// halved, as a multiple of their number, and the AdmissionGrace.
+func (p *metrics) Tuning() map[string]float64 {
+	if p == nil || p.tuning == nil {
+		return nil
+	}
+	p.tuning.Lock()
+	defer p.tuning.Unlock()
+	values := make(map[string]float64, len(p.tuning.values))
+	for name, value := range p.tuning.values {
+		values[name] = value
+	}
+	return values
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import "testing"
+
+func TestTuner(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters:       1000,
+		MaxCost:           100,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	tuner := newTuner(cache, tunables(cache.policy))
+	check := func(name string, want float64) {
+		t.Helper()
+		if got := cache.Metrics().Tuning()[name]; got != want {
+			t.Fatalf("expected %s of %v but got %v\n", name, want, got)
+		}
+	}
+	check("eviction-sample-size", 5)
+	check("counter-reset-scale", 1)
+	check("admission-grace", 0)
+	// the first period measures the baseline, and the sample grows
+	tuner.step(0.5)
+	check("eviction-sample-size", 6)
+	// improvements are kept, and the sample grows further
+	tuner.step(0.6)
+	check("eviction-sample-size", 8)
+	// a worse ratio reverts the change, and the other way is tried next
+	tuner.step(0.4)
+	check("eviction-sample-size", 6)
+	tuner.step(0.4)
+	check("eviction-sample-size", 5)
+	// once both ways got worse, the next parameter is tuned
+	tuner.step(0.3)
+	check("eviction-sample-size", 6)
+	tuner.step(0.3)
+	check("counter-reset-scale", 2)
+	if tinyLFU := cache.policy.(*defaultPolicy).admit; tinyLFU.resetAt != 2000 {
+		t.Fatalf("expected resets every 2000 increments, not %d\n",
+			tinyLFU.resetAt)
+	}
+	m := cache.Metrics()
+	if m.TuneChangesKept() != 1 || m.TuneChangesReverted() != 2 {
+		t.Fatalf("expected 1 kept and 2 reverted changes but got %d and %d\n",
+			m.TuneChangesKept(), m.TuneChangesReverted())
+	}
+	// periods with too few Gets are extended
+	tuner.tick()
+	check("counter-reset-scale", 2)
+	cache.Close()
+}
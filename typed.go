@@ -0,0 +1,148 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"time"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+// CacheU64 is a view of a Cache for uint64 keys, like the page numbers of a
+// database page cache. Keys are hashed without going through the type switch
+// of KeyToHash, and aren't converted to interface{} on the way. Keys refer to
+// the same values through the view and through the Cache itself.
+type CacheU64 struct {
+	cache *Cache
+}
+
+// CacheString is like CacheU64, but for string keys.
+type CacheString struct {
+	cache *Cache
+}
+
+// Uint64Keys returns a view of the cache for uint64 keys.
+func (c *Cache) Uint64Keys() *CacheU64 {
+	if c == nil {
+		return nil
+	}
+	return &CacheU64{cache: c}
+}
+
+// StringKeys returns a view of the cache for string keys.
+func (c *Cache) StringKeys() *CacheString {
+	if c == nil {
+		return nil
+	}
+	return &CacheString{cache: c}
+}
+
+// hashUint64 hashes key like keyToHash would.
+func (c *Cache) hashUint64(key uint64) uint64 {
+	switch {
+	case c.config.Hasher != nil:
+		return c.config.Hasher.HashUint64(key)
+	case c.config.KeyToHash != nil:
+		return c.keyToHash(noescape(key))
+	default:
+		return key
+	}
+}
+
+// hashString hashes key like keyToHash would.
+func (c *Cache) hashString(key string) uint64 {
+	switch {
+	case c.config.Hasher != nil:
+		return c.config.Hasher.HashString(key)
+	case c.config.KeyToHash != nil:
+		return c.keyToHash(noescape(key))
+	default:
+		return z.MemHashString(key)
+	}
+}
+
+// Get works like Cache.Get.
+func (u *CacheU64) Get(key uint64) (interface{}, bool) {
+	if u == nil {
+		return nil, false
+	}
+	return u.cache.get(u.cache.hashUint64(key))
+}
+
+// Set works like Cache.Set.
+func (u *CacheU64) Set(key uint64, val interface{}, cost int64) bool {
+	if u == nil {
+		return false
+	}
+	return u.cache.set(u.cache.hashUint64(key), val, cost, setOptions{}, nil)
+}
+
+// SetWithTTL works like Cache.SetWithTTL.
+func (u *CacheU64) SetWithTTL(key uint64, val interface{}, cost int64,
+	ttl time.Duration) bool {
+	if u == nil || ttl < 0 {
+		return false
+	}
+	return u.cache.set(u.cache.hashUint64(key), val, cost,
+		setOptions{exp: expiry{ttl: ttl}, expires: true}, nil)
+}
+
+// Del works like Cache.Del.
+func (u *CacheU64) Del(key uint64) {
+	if u == nil {
+		return
+	}
+	hash := u.cache.hashUint64(key)
+	u.cache.del(hash, u.cache.nextVersion())
+	u.cache.publish(hash)
+}
+
+// Get works like Cache.Get.
+func (s *CacheString) Get(key string) (interface{}, bool) {
+	if s == nil {
+		return nil, false
+	}
+	return s.cache.get(s.cache.hashString(key))
+}
+
+// Set works like Cache.Set.
+func (s *CacheString) Set(key string, val interface{}, cost int64) bool {
+	if s == nil {
+		return false
+	}
+	return s.cache.set(s.cache.hashString(key), val, cost, setOptions{}, nil)
+}
+
+// SetWithTTL works like Cache.SetWithTTL.
+func (s *CacheString) SetWithTTL(key string, val interface{}, cost int64,
+	ttl time.Duration) bool {
+	if s == nil || ttl < 0 {
+		return false
+	}
+	return s.cache.set(s.cache.hashString(key), val, cost,
+		setOptions{exp: expiry{ttl: ttl}, expires: true}, nil)
+}
+
+// Del works like Cache.Del.
+func (s *CacheString) Del(key string) {
+	if s == nil {
+		return
+	}
+	hash := s.cache.hashString(key)
+	s.cache.del(hash, s.cache.nextVersion())
+	s.cache.publish(hash)
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/ristretto/z"
+)
+
+func TestCacheTypedKeys(t *testing.T) {
+	for _, config := range []Config{
+		{},
+		{Hasher: XXH3Hasher},
+		{KeyToHash: z.KeyToHash},
+	} {
+		config.NumCounters, config.MaxCost = 100, 10
+		config.BufferItems = 64
+		config.DeterministicMode = true
+		cache, err := NewCache(&config)
+		if err != nil {
+			panic(err)
+		}
+		u, s := cache.Uint64Keys(), cache.StringKeys()
+		// keys refer to the same values through the views and the cache
+		u.Set(1, 1, 1)
+		s.Set("a", 2, 1)
+		if val, ok := cache.Get(uint64(1)); !ok || val.(int) != 1 {
+			t.Fatal("uint64 keys should be hashed like KeyToHash does")
+		}
+		if val, ok := cache.Get("a"); !ok || val.(int) != 2 {
+			t.Fatal("string keys should be hashed like KeyToHash does")
+		}
+		cache.Set(uint64(3), 3, 1)
+		cache.Set("b", 4, 1)
+		if val, ok := u.Get(3); !ok || val.(int) != 3 {
+			t.Fatal("unexpected value for uint64 key")
+		}
+		if val, ok := s.Get("b"); !ok || val.(int) != 4 {
+			t.Fatal("unexpected value for string key")
+		}
+		u.Del(3)
+		s.Del("b")
+		if _, ok := cache.Get(uint64(3)); ok {
+			t.Fatal("uint64 key should be deleted")
+		}
+		if _, ok := cache.Get("b"); ok {
+			t.Fatal("string key should be deleted")
+		}
+		if !u.SetWithTTL(5, 5, 1, 0) || !s.SetWithTTL("c", 6, 1, 0) {
+			t.Fatal("Sets with a TTL should succeed")
+		}
+		cache.Close()
+	}
+
+	var nilCache *Cache
+	if _, ok := nilCache.Uint64Keys().Get(1); ok {
+		t.Fatal("nil caches should be empty")
+	}
+	if nilCache.StringKeys().Set("a", 1, 1) {
+		t.Fatal("nil caches should drop Sets")
+	}
+}
+
+func TestCacheTypedKeysAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items at random under the race detector")
+	}
+	cache, err := NewCache(&Config{
+		NumCounters:       capacity * 10,
+		MaxCost:           capacity,
+		BufferItems:       64,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	u, s := cache.Uint64Keys(), cache.StringKeys()
+	key := uint64(1000)
+	allocs := testing.AllocsPerRun(capacity*10, func() {
+		key++
+		u.Set(key, nil, 1)
+		u.Get(key)
+		s.Get("key")
+	})
+	if allocs != 0 {
+		t.Fatalf("typed keys allocated %v times\n", allocs)
+	}
+}
+
+// BenchmarkCacheU64Get Gets the same uint64 key through Uint64Keys.
+func BenchmarkCacheU64Get(b *testing.B) {
+	cache := newCache(false)
+	u := cache.Uint64Keys()
+	u.Set(1, nil, 1)
+	newBenchmark(func(i uint64) { u.Get(1) })(b)
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// maxHits is the highest access frequency estimated by the policy: the
+// largest 4-bit counter, plus one for the doorkeeper.
+const maxHits = 16
+
+// VictimAgeBounds are the bounds of the age histogram in VictimStats.
+var VictimAgeBounds = []time.Duration{
+	time.Second,
+	10 * time.Second,
+	time.Minute,
+	10 * time.Minute,
+	time.Hour,
+	24 * time.Hour,
+}
+
+// VictimStats describes the keys evicted from the cache. Evictions of keys
+// accessed often, or soon after they were added, mean the cache is too small
+// for its working set.
+type VictimStats struct {
+	// Count is the number of keys evicted.
+	Count uint64 `json:"count"`
+	// AvgHits is the average access frequency of evicted keys, as estimated
+	// by the policy when they were evicted.
+	AvgHits float64 `json:"avgHits"`
+	// AvgAge is the average time evicted keys spent in the cache.
+	AvgAge time.Duration `json:"avgAge"`
+	// MedianAge is AgeQuantile(0.5). A short median age is the clearest sign
+	// that the cache is too small, or that admission lets keys churn.
+	MedianAge time.Duration `json:"medianAge"`
+	// Hits[i] is the number of evicted keys with an estimated access
+	// frequency of i.
+	Hits []uint64 `json:"hits"`
+	// Ages[i] is the number of evicted keys younger than VictimAgeBounds[i],
+	// and not younger than the previous bound. The last count is for keys
+	// older than every bound.
+	Ages []uint64 `json:"ages"`
+}
+
+// victimStats accumulates VictimStats. Evictions are rare compared to the
+// accesses counted by the other metrics, so the counters aren't padded.
+type victimStats struct {
+	count uint64
+	hits  uint64
+	// age is in nanoseconds
+	age      uint64
+	hitsHist [maxHits + 1]uint64
+	ageHist  []uint64
+}
+
+func newVictimStats() *victimStats {
+	return &victimStats{ageHist: make([]uint64, len(VictimAgeBounds)+1)}
+}
+
+func (s *victimStats) add(hits int64, age time.Duration) {
+	if hits > maxHits {
+		hits = maxHits
+	}
+	bucket := 0
+	for bucket < len(VictimAgeBounds) && age >= VictimAgeBounds[bucket] {
+		bucket++
+	}
+	atomic.AddUint64(&s.count, 1)
+	atomic.AddUint64(&s.hits, uint64(hits))
+	atomic.AddUint64(&s.age, uint64(age))
+	atomic.AddUint64(&s.hitsHist[hits], 1)
+	atomic.AddUint64(&s.ageHist[bucket], 1)
+}
+
+// reset zeroes the counters.
+func (s *victimStats) reset() {
+	atomic.StoreUint64(&s.count, 0)
+	atomic.StoreUint64(&s.hits, 0)
+	atomic.StoreUint64(&s.age, 0)
+	for i := range s.hitsHist {
+		atomic.StoreUint64(&s.hitsHist[i], 0)
+	}
+	for i := range s.ageHist {
+		atomic.StoreUint64(&s.ageHist[i], 0)
+	}
+}
+
+// swap zeroes the counters, and returns their values until then.
+func (s *victimStats) swap() *victimStats {
+	old := newVictimStats()
+	old.count = atomic.SwapUint64(&s.count, 0)
+	old.hits = atomic.SwapUint64(&s.hits, 0)
+	old.age = atomic.SwapUint64(&s.age, 0)
+	for i := range s.hitsHist {
+		old.hitsHist[i] = atomic.SwapUint64(&s.hitsHist[i], 0)
+	}
+	for i := range s.ageHist {
+		old.ageHist[i] = atomic.SwapUint64(&s.ageHist[i], 0)
+	}
+	return old
+}
+
+// Victims describes the keys evicted from the cache so far.
+func (p *metrics) Victims() VictimStats {
+	if p == nil {
+		return VictimStats{}
+	}
+	s := p.victims
+	stats := VictimStats{
+		Count: atomic.LoadUint64(&s.count),
+		Hits:  make([]uint64, len(s.hitsHist)),
+		Ages:  make([]uint64, len(s.ageHist)),
+	}
+	if stats.Count > 0 {
+		stats.AvgHits = float64(atomic.LoadUint64(&s.hits)) / float64(stats.Count)
+		stats.AvgAge = time.Duration(atomic.LoadUint64(&s.age) / stats.Count)
+	}
+	for i := range s.hitsHist {
+		stats.Hits[i] = atomic.LoadUint64(&s.hitsHist[i])
+	}
+	for i := range s.ageHist {
+		stats.Ages[i] = atomic.LoadUint64(&s.ageHist[i])
+	}
+	stats.MedianAge = stats.AgeQuantile(0.5)
+	return stats
+}
+
+// AgeQuantile estimates the time that a fraction q of evicted keys spent in
+// the cache at most, from the histogram of Ages: it returns the upper bound
+// of the bucket holding the quantile, or the last bound if it's in the last
+// bucket. It returns zero if no key was evicted.
+func (s VictimStats) AgeQuantile(q float64) time.Duration {
+	var total uint64
+	for _, n := range s.Ages {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	var seen uint64
+	for i, n := range s.Ages {
+		seen += n
+		if float64(seen) >= q*float64(total) && i < len(VictimAgeBounds) {
+			return VictimAgeBounds[i]
+		}
+	}
+	return VictimAgeBounds[len(VictimAgeBounds)-1]
+}
+
+// ageName identifies bucket i of the age histogram in metric names, by its
+// bounds in seconds.
+func ageName(i int) string {
+	lower := int64(0)
+	if i > 0 {
+		lower = int64(VictimAgeBounds[i-1] / time.Second)
+	}
+	if i == len(VictimAgeBounds) {
+		return fmt.Sprintf("%d-inf", lower)
+	}
+	return fmt.Sprintf("%d-%d", lower, int64(VictimAgeBounds[i]/time.Second))
+}
+
+// keyTimes are when a key was added and last updated, in Unix nanoseconds.
+type keyTimes struct {
+	added, updated int64
+}
+
+// times converts the times to time.Time.
+func (t keyTimes) times() (added, updated time.Time) {
+	return time.Unix(0, t.added), time.Unix(0, t.updated)
+}
+
+// born records when a key was written, and when it was added if it wasn't
+// already.
+func (c *Cache) born(key uint64) {
+	if c.births == nil {
+		return
+	}
+	now := c.clock.Now().UnixNano()
+	c.birthsMu.Lock()
+	defer c.birthsMu.Unlock()
+	t, ok := c.births[key]
+	if !ok {
+		t.added = now
+	}
+	t.updated = now
+	c.births[key] = t
+}
+
+// rewritten records when a key was updated in place. Keys that aren't known
+// to be cached are ignored, so they can't linger after they left the cache.
+func (c *Cache) rewritten(key uint64) {
+	if c.births == nil {
+		return
+	}
+	now := c.clock.Now().UnixNano()
+	c.birthsMu.Lock()
+	defer c.birthsMu.Unlock()
+	if t, ok := c.births[key]; ok {
+		t.updated = now
+		c.births[key] = t
+	}
+}
+
+// died forgets when a key that left the cache was added.
+func (c *Cache) died(key uint64) {
+	if c.births != nil {
+		c.birthsMu.Lock()
+		delete(c.births, key)
+		c.birthsMu.Unlock()
+	}
+}
+
+// victim records the eviction of a key, and returns when it was added and
+// last updated, if that's known.
+func (c *Cache) victim(key uint64) (keyTimes, bool) {
+	if c.births == nil {
+		return keyTimes{}, false
+	}
+	c.birthsMu.Lock()
+	t, ok := c.births[key]
+	delete(c.births, key)
+	c.birthsMu.Unlock()
+	if c.stats != nil {
+		var age time.Duration
+		if ok {
+			age = time.Duration(c.clock.Now().UnixNano() - t.added)
+		}
+		c.stats.victims.add(c.policy.Estimate(key), age)
+	}
+	return t, ok
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCacheVictims(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           2,
+		BufferItems:       64,
+		Metrics:           true,
+		DeterministicMode: true,
+		Clock:             clock,
+	})
+	if err != nil {
+		panic(err)
+	}
+	cache.Set(1, 1, 1)
+	cache.Set(2, 2, 1)
+	cache.Get(1)
+	clock.Advance(time.Minute)
+	cache.Del(2)
+	cache.Set(3, 3, 1)
+	for i := 0; i < 5; i++ {
+		cache.Get(3)
+		cache.Get(4)
+	}
+	// key 1 is colder than key 3
+	cache.Set(4, 4, 1)
+	hits := cache.policy.Estimate(cache.keyToHash(1))
+	victims := cache.Metrics().Victims()
+	if victims.Count != 1 {
+		t.Fatalf("expected 1 victim but got %d\n", victims.Count)
+	}
+	if victims.AvgAge != time.Minute {
+		t.Fatalf("expected victims to be a minute old, got %v\n", victims.AvgAge)
+	}
+	if victims.Ages[3] != 1 {
+		t.Fatalf("expected the victim in the [1m,10m) bucket, got %v\n", victims.Ages)
+	}
+	if victims.MedianAge != 10*time.Minute {
+		t.Fatalf("expected a median age under 10m, got %v\n", victims.MedianAge)
+	}
+	if n := cache.Metrics().Snapshot()["keys-evicted-age-60-600"]; n != 1 {
+		t.Fatalf("expected the age bucket in the snapshot, got %d\n", n)
+	}
+	if victims.AvgHits != float64(hits) || victims.Hits[hits] != 1 {
+		t.Fatalf("unexpected victim hits %+v\n", victims)
+	}
+	if len(cache.births) != 2 {
+		t.Fatalf("births of keys that left the cache should be forgotten, got %v\n",
+			cache.births)
+	}
+}
+
+func TestMetricsVictimsEmpty(t *testing.T) {
+	victims := newMetrics().Victims()
+	if victims.Count != 0 || victims.AvgAge != 0 || len(victims.Ages) != len(VictimAgeBounds)+1 {
+		t.Fatalf("unexpected stats %+v\n", victims)
+	}
+}
+
+func TestVictimStatsAgeQuantile(t *testing.T) {
+	stats := VictimStats{Ages: []uint64{1, 2, 0, 0, 0, 0, 1}}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Second},
+		{0.25, time.Second},
+		{0.5, 10 * time.Second},
+		{0.75, 10 * time.Second},
+		{1, 24 * time.Hour},
+	} {
+		if got := stats.AgeQuantile(tc.q); got != tc.want {
+			t.Fatalf("quantile %v: expected %v but got %v\n", tc.q, tc.want, got)
+		}
+	}
+	if (VictimStats{}).AgeQuantile(0.5) != 0 {
+		t.Fatal("the quantiles of no victims should be zero")
+	}
+}
+
+func TestCacheOnEvictWithTimes(t *testing.T) {
+	start := time.Unix(1000, 0)
+	clock := NewManualClock(start)
+	var added, updated time.Time
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     1,
+		BufferItems: 64,
+		Clock:       clock,
+		OnEvictWithTimes: func(key uint64, value interface{}, cost int64,
+			a, u time.Time) {
+			added, updated = a, u
+		},
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	cache.Set(1, 1, 1)
+	clock.Advance(time.Minute)
+	cache.Set(1, 2, 1)
+	clock.Advance(time.Minute)
+	cache.Set(2, 3, 1)
+	if !added.Equal(start) || !updated.Equal(start.Add(time.Minute)) {
+		t.Fatalf("unexpected times %v and %v\n", added, updated)
+	}
+	evictions := cache.RecentEvictions()
+	if len(evictions) != 1 || !evictions[0].Added.Equal(added) ||
+		!evictions[0].Updated.Equal(updated) {
+		t.Fatalf("unexpected recent evictions %v\n", evictions)
+	}
+}
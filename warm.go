@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"context"
+	"time"
+)
+
+// readySaturation is the fraction of MaxCost the cache must stay over for
+// Config.ReadyAfter before it's ready.
+const readySaturation = 0.9
+
+// Warm loads the entries returned by next into the cache until next returns
+// false, for example from a snapshot of the cache taken before a restart. A
+// cold cache would reject most of them for never having been accessed, so
+// warmed entries skip admission, and are counted as accessed once so they
+// hold up against new keys. Entries that don't fit in the room left are
+// skipped, so warming never evicts anything.
+//
+// Unlike Set, Warm adds entries before it returns. They expire after
+// DefaultTTL, if it's set. It stops early and returns the context's error if
+// ctx is done.
+func (c *Cache) Warm(ctx context.Context,
+	next func() (key, val interface{}, cost int64, ok bool)) error {
+	if c == nil {
+		return nil
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key, val, cost, ok := next()
+		if !ok {
+			return nil
+		}
+		hash := c.keyToHash(key)
+		val, cost = c.encode(val, cost)
+		version := c.nextVersion()
+		c.process(&item{key: hash, cost: cost, version: version, warm: true,
+			val: c.expire(val, cost, c.defaultExpiry(), version)})
+		c.getBuf.Push(hash)
+	}
+}
+
+// ExportHotSet returns up to n cached keys with the highest estimated access
+// frequency, hottest first. A new replica can pass them to PrefetchHints, or
+// load them from the backing store, to start with the same hot set as a warm
+// peer. Every call walks the whole cache.
+func (c *Cache) ExportHotSet(n int) []uint64 {
+	top := c.TopKeys(n)
+	if top == nil {
+		return nil
+	}
+	keys := make([]uint64, len(top))
+	for i, key := range top {
+		keys[i] = key.Key
+	}
+	return keys
+}
+
+// ExportHotKeys is like ExportHotSet, but returns the original keys. The
+// cache only keeps hashes of its keys, so keyOf derives them from the cached
+// values instead. Keys whose values were removed in the meantime are skipped.
+func (c *Cache) ExportHotKeys(n int,
+	keyOf func(val interface{}) interface{}) []interface{} {
+	hashes := c.ExportHotSet(n)
+	if hashes == nil {
+		return nil
+	}
+	keys := make([]interface{}, 0, len(hashes))
+	for _, hash := range hashes {
+		stored, ok := c.store.Get(hash)
+		if !ok {
+			continue
+		}
+		val, ok := c.peek(stored)
+		if ok {
+			val, ok = c.decode(val)
+		}
+		if ok {
+			keys = append(keys, keyOf(val))
+		}
+	}
+	return keys
+}
+
+// PrefetchHints counts an access to each of the keys, as if they had been
+// read once, so that Sets of them aren't rejected by a cold cache for never
+// having been accessed. It's meant for keys exported by a warm peer, which
+// the caller then loads from the backing store. Hashed keys from ExportHotSet
+// can be passed as is, unless Config.KeyToHash is set. Like Gets, the hints
+// may be dropped under contention, in which case it returns false.
+func (c *Cache) PrefetchHints(keys []interface{}) bool {
+	if c == nil || len(keys) == 0 {
+		return false
+	}
+	hashes := make([]uint64, len(keys))
+	for i, key := range keys {
+		hashes[i] = c.keyToHash(key)
+	}
+	return c.policy.Push(hashes)
+}
+
+// Saturation returns the fraction of MaxCost used by the cached keys. A cold
+// cache, just started or flushed, has a low saturation.
+func (c *Cache) Saturation() float64 {
+	if c == nil {
+		return 0
+	}
+	maxCost := c.policy.MaxCost()
+	if maxCost == 0 {
+		return 0
+	}
+	return float64(maxCost-c.policy.Cap()) / float64(maxCost)
+}
+
+// Ready returns a channel that's closed once the cache stayed over 90% of
+// MaxCost for Config.ReadyAfter. It's never closed again once the cache
+// turned warm, even if its saturation drops afterwards.
+func (c *Cache) Ready() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	return c.ready
+}
+
+// checkReady closes the ready channel if the cache stayed saturated for long
+// enough. The caller must hold every lock in processMu.
+func (c *Cache) checkReady() {
+	if c.isReady {
+		return
+	}
+	if c.Saturation() < readySaturation {
+		c.saturatedSince = time.Time{}
+		return
+	}
+	now := c.clock.Now()
+	if c.saturatedSince.IsZero() {
+		c.saturatedSince = now
+	}
+	if now.Sub(c.saturatedSince) >= c.config.ReadyAfter {
+		c.isReady = true
+		close(c.ready)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func warmKeys(n int) func() (interface{}, interface{}, int64, bool) {
+	key := 0
+	return func() (interface{}, interface{}, int64, bool) {
+		if key == n {
+			return nil, nil, 0, false
+		}
+		key++
+		return key, key, 1, true
+	}
+}
+
+func TestCacheWarm(t *testing.T) {
+	cache, err := NewCache(&Config{
+		NumCounters: 100,
+		MaxCost:     10,
+		BufferItems: 64,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	if err := cache.Warm(context.Background(), warmKeys(20)); err != nil {
+		t.Fatal(err)
+	}
+	// the first 10 keys fill the cache and the rest are skipped
+	for key := 1; key <= 20; key++ {
+		val, ok := cache.Get(key)
+		if ok != (key <= 10) {
+			t.Fatalf("unexpected presence of key %d: %v\n", key, ok)
+		}
+		if ok && val.(int) != key {
+			t.Fatalf("unexpected value for key %d: %v\n", key, val)
+		}
+	}
+	if _, used := cache.policy.Costs(); used != 10 {
+		t.Fatalf("expected a cost of 10 but got %d\n", used)
+	}
+}
+
+func TestCacheWarmCanceled(t *testing.T) {
+	cache := newConditionalCache()
+	ctx, cancel := context.WithCancel(context.Background())
+	next := warmKeys(10)
+	err := cache.Warm(ctx, func() (interface{}, interface{}, int64, bool) {
+		key, val, cost, ok := next()
+		if key == 5 {
+			cancel()
+		}
+		return key, val, cost, ok
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected the context's error but got %v\n", err)
+	}
+	if _, ok := cache.Get(5); !ok {
+		t.Fatal("keys returned before canceling should be added")
+	}
+	if _, ok := cache.Get(6); ok {
+		t.Fatal("keys after canceling shouldn't be added")
+	}
+}
+
+func TestCacheExportHotSet(t *testing.T) {
+	cache := newConditionalCache()
+	for key := 1; key <= 3; key++ {
+		cache.Set(key, key, 1)
+		for i := 0; i < key; i++ {
+			cache.Get(key)
+		}
+	}
+	hot := cache.ExportHotSet(2)
+	if len(hot) != 2 || hot[0] != cache.keyToHash(3) || hot[1] != cache.keyToHash(2) {
+		t.Fatalf("expected keys 3 and 2 but got %v\n", hot)
+	}
+	keys := cache.ExportHotKeys(2, func(val interface{}) interface{} {
+		return val
+	})
+	if len(keys) != 2 || keys[0].(int) != 3 || keys[1].(int) != 2 {
+		t.Fatalf("expected keys 3 and 2 but got %v\n", keys)
+	}
+}
+
+func TestCachePrefetchHints(t *testing.T) {
+	cache := newConditionalCache()
+	if !cache.PrefetchHints([]interface{}{1, uint64(2)}) {
+		t.Fatal("hints were dropped")
+	}
+	if cache.EstimateFrequency(1) != 1 || cache.EstimateFrequency(2) != 1 {
+		t.Fatal("hints should count as an access")
+	}
+	// hints can come straight from another cache
+	peer := newConditionalCache()
+	peer.Set(3, 3, 1)
+	hints := make([]interface{}, 0)
+	for _, key := range peer.ExportHotSet(1) {
+		hints = append(hints, key)
+	}
+	cache.PrefetchHints(hints)
+	if cache.EstimateFrequency(3) != 1 {
+		t.Fatal("exported keys should be accepted as hints")
+	}
+}
+
+func TestCacheReady(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	cache, err := NewCache(&Config{
+		NumCounters:       100,
+		MaxCost:           10,
+		BufferItems:       64,
+		Clock:             clock,
+		ReadyAfter:        time.Minute,
+		DeterministicMode: true,
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	ready := func() bool {
+		select {
+		case <-cache.Ready():
+			return true
+		default:
+			return false
+		}
+	}
+	if err := cache.Warm(context.Background(), warmKeys(8)); err != nil {
+		t.Fatal(err)
+	}
+	if s := cache.Saturation(); s != 0.8 {
+		t.Fatalf("expected a saturation of 0.8 but got %v\n", s)
+	}
+	clock.Advance(time.Hour)
+	cache.Set(100, 100, 1)
+	if ready() {
+		t.Fatal("the cache should only be ready after a minute")
+	}
+	clock.Advance(time.Minute - time.Second)
+	cache.Set(101, 101, 1)
+	if ready() {
+		t.Fatal("the cache should only be ready after a minute")
+	}
+	clock.Advance(time.Second)
+	cache.Set(102, 102, 1)
+	if !ready() {
+		t.Fatal("the cache should be ready")
+	}
+	var nilCache *Cache
+	if nilCache.Saturation() != 0 || nilCache.Ready() != nil {
+		t.Fatal("nil caches should be cold")
+	}
+}
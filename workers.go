@@ -0,0 +1,261 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// shardedPolicy splits keys among independent policies, one per worker
+// applying Sets, so workers don't contend on the policy's lock. Every key
+// belongs to the shard picked by its lowest bits, like the Set buffer
+// stripes, so a worker only ever touches its own shard. Each shard holds
+// an equal part of MaxCost, and evicts its own keys to make room.
+type shardedPolicy struct {
+	shards []policy
+	mask   uint64
+	// batches recycles the keys pushed to every shard
+	batches sync.Pool
+}
+
+func newShardedPolicy(n int64, create func() policy) *shardedPolicy {
+	p := &shardedPolicy{
+		shards: make([]policy, n),
+		mask:   uint64(n - 1),
+	}
+	for i := range p.shards {
+		p.shards[i] = create()
+	}
+	return p
+}
+
+// shard returns the policy of key.
+func (p *shardedPolicy) shard(key uint64) policy {
+	return p.shards[key&p.mask]
+}
+
+func (p *shardedPolicy) Push(keys []uint64) bool {
+	batch, _ := p.batches.Get().(*[]uint64)
+	if batch == nil {
+		batch = new([]uint64)
+	}
+	kept := true
+	for i, shard := range p.shards {
+		*batch = (*batch)[:0]
+		for _, key := range keys {
+			if key&p.mask == uint64(i) {
+				*batch = append(*batch, key)
+			}
+		}
+		if len(*batch) > 0 && !shard.Push(*batch) {
+			kept = false
+		}
+	}
+	p.batches.Put(batch)
+	return kept
+}
+
+func (p *shardedPolicy) Add(key uint64, cost int64) ([]*item, bool) {
+	return p.shard(key).Add(key, cost)
+}
+
+func (p *shardedPolicy) Update(key uint64, cost int64) {
+	p.shard(key).Update(key, cost)
+}
+
+func (p *shardedPolicy) Has(key uint64) bool {
+	return p.shard(key).Has(key)
+}
+
+func (p *shardedPolicy) Cost(key uint64) (int64, bool) {
+	return p.shard(key).Cost(key)
+}
+
+func (p *shardedPolicy) Estimate(key uint64) int64 {
+	return p.shard(key).Estimate(key)
+}
+
+func (p *shardedPolicy) Del(key uint64) {
+	p.shard(key).Del(key)
+}
+
+func (p *shardedPolicy) Pin(key uint64) {
+	p.shard(key).Pin(key)
+}
+
+func (p *shardedPolicy) Prioritize(key uint64, priority int) {
+	p.shard(key).Prioritize(key, priority)
+}
+
+// Evict evicts an equal part of cost from every shard.
+func (p *shardedPolicy) Evict(cost int64) []*item {
+	var victims []*item
+	for _, shard := range p.shards {
+		victims = append(victims, shard.Evict(shardCost(cost, len(p.shards)))...)
+	}
+	return victims
+}
+
+func (p *shardedPolicy) Cap() int64 {
+	var room int64
+	for _, shard := range p.shards {
+		room += shard.Cap()
+	}
+	return room
+}
+
+func (p *shardedPolicy) MaxCost() int64 {
+	var maxCost int64
+	for _, shard := range p.shards {
+		maxCost += shard.MaxCost()
+	}
+	return maxCost
+}
+
+// SetMaxCost gives every shard an equal part of maxCost.
+func (p *shardedPolicy) SetMaxCost(maxCost int64) []*item {
+	var victims []*item
+	for _, shard := range p.shards {
+		victims = append(victims,
+			shard.SetMaxCost(shardCost(maxCost, len(p.shards)))...)
+	}
+	return victims
+}
+
+func (p *shardedPolicy) Costs() (map[uint64]int64, int64) {
+	costs, used := make(map[uint64]int64), int64(0)
+	for _, shard := range p.shards {
+		shardCosts, shardUsed := shard.Costs()
+		for key, cost := range shardCosts {
+			costs[key] = cost
+		}
+		used += shardUsed
+	}
+	return costs, used
+}
+
+func (p *shardedPolicy) Recount() int64 {
+	var drift int64
+	for _, shard := range p.shards {
+		drift += shard.Recount()
+	}
+	return drift
+}
+
+// Saturation returns the average saturation of the shards.
+func (p *shardedPolicy) Saturation() (float64, float64) {
+	var used, maxed float64
+	for _, shard := range p.shards {
+		shardUsed, shardMaxed := shard.Saturation()
+		used, maxed = used+shardUsed, maxed+shardMaxed
+	}
+	n := float64(len(p.shards))
+	return used / n, maxed / n
+}
+
+// SketchStats returns the total number of counters of the shards, and the
+// average of their other stats.
+func (p *shardedPolicy) SketchStats() SketchStats {
+	var s SketchStats
+	for _, shard := range p.shards {
+		stats := shard.SketchStats()
+		s.Counters += stats.Counters
+		s.Used += stats.Used
+		s.Maxed += stats.Maxed
+		s.OverflowRate += stats.OverflowRate
+		s.Overestimate += stats.Overestimate
+		s.Collisions += stats.Collisions
+	}
+	n := float64(len(p.shards))
+	s.Used, s.Maxed, s.OverflowRate = s.Used/n, s.Maxed/n, s.OverflowRate/n
+	s.Overestimate, s.Collisions = s.Overestimate/n, s.Collisions/n
+	return s
+}
+
+func (p *shardedPolicy) CollectMetrics(stats *metrics) {
+	for _, shard := range p.shards {
+		shard.CollectMetrics(stats)
+	}
+}
+
+// HotKeys merges the hottest keys of every shard.
+func (p *shardedPolicy) HotKeys(n int) []KeyCount {
+	var keys []KeyCount
+	for _, shard := range p.shards {
+		shardKeys := shard.HotKeys(n)
+		if shardKeys == nil {
+			return nil
+		}
+		keys = append(keys, shardKeys...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Count != keys[j].Count {
+			return keys[i].Count > keys[j].Count
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+func (p *shardedPolicy) Penalize(key uint64, penalty time.Duration) {
+	p.shard(key).Penalize(key, penalty)
+}
+
+func (p *shardedPolicy) ResizeCounters(numCounters int64) {
+	for _, shard := range p.shards {
+		shard.ResizeCounters(shardCost(numCounters, len(p.shards)))
+	}
+}
+
+// shardCost returns the part of cost that falls to each of n shards, rounded
+// up so the shards add up to at least cost.
+func shardCost(cost int64, n int) int64 {
+	return (cost + int64(n) - 1) / int64(n)
+}
+
+// shardPolicy returns the policy shard of key, or the policy if it isn't
+// sharded.
+func (c *Cache) shardPolicy(key uint64) policy {
+	if p, ok := c.policy.(*shardedPolicy); ok {
+		return p.shard(key)
+	}
+	return c.policy
+}
+
+// processLock returns the lock serializing the items of key.
+func (c *Cache) processLock(key uint64) *sync.Mutex {
+	return &c.processMu[key&uint64(len(c.processMu)-1)]
+}
+
+// lockAll takes every worker's lock, for operations touching keys of every
+// worker.
+func (c *Cache) lockAll() {
+	for i := range c.processMu {
+		c.processMu[i].Lock()
+	}
+}
+
+func (c *Cache) unlockAll() {
+	for i := range c.processMu {
+		c.processMu[i].Unlock()
+	}
+}
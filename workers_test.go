@@ -0,0 +1,98 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ristretto
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShardedPolicy(t *testing.T) {
+	GeneratePolicyTest(func(numCounters, maxCost int64,
+		opts policyOptions) policy {
+		return newShardedPolicy(4, func() policy {
+			return newSyncPolicy(numCounters/4, maxCost/4, opts)
+		})
+	})(t)
+	p := newShardedPolicy(4, func() policy {
+		return newSyncPolicy(100, 2, policyOptions{})
+	})
+	if p.MaxCost() != 8 {
+		t.Fatal("the shards should add up to MaxCost")
+	}
+	for key := uint64(0); key < 8; key++ {
+		p.Add(key, 1)
+	}
+	// key 8 belongs to the shard of keys 0 and 4, which is full
+	victims, added := p.Add(8, 1)
+	if !added || len(victims) != 1 || victims[0].key&3 != 0 {
+		t.Fatal("shards should only evict their own keys")
+	}
+	if victims := p.SetMaxCost(4); len(victims) != 4 || p.MaxCost() != 4 {
+		t.Fatal("every shard should shrink to its part of MaxCost")
+	}
+}
+
+func TestCacheNumWorkers(t *testing.T) {
+	evicting, release := make(chan struct{}, 1), make(chan struct{})
+	cache, err := NewCache(&Config{
+		NumCounters: 1000,
+		MaxCost:     8,
+		BufferItems: 64,
+		NumWorkers:  4,
+		OnEvict: func(key uint64, _ interface{}, _ int64) {
+			if key&3 == 0 {
+				evicting <- struct{}{}
+				<-release
+			}
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+	defer cache.Close()
+	if len(cache.processMu) != 4 || len(cache.setBuf.stripes) < 4 {
+		t.Fatal("expected 4 workers")
+	}
+	// the third key of a shard evicts one, blocking the shard's worker
+	for _, key := range []int{0, 4, 8} {
+		cache.Set(key, key, 1)
+	}
+	select {
+	case <-evicting:
+	case <-time.After(time.Second):
+		t.Fatal("expected a key to be evicted")
+	}
+	// the other workers carry on
+	cache.Set(1, 1, 1)
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		if _, ok := cache.Get(1); ok {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("a slow OnEvict shouldn't hold up other workers")
+		}
+	}
+	close(release)
+	for cache.setBuf.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	cache.lockAll()
+	cache.checkInvariants()
+	cache.unlockAll()
+}
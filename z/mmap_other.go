@@ -0,0 +1,31 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+// MmapAnon falls back to a regular heap allocation on platforms without
+// anonymous mmap support.
+func MmapAnon(size int) ([]byte, error) {
+	return make([]byte, size), nil
+}
+
+// Munmap is a no-op on platforms without anonymous mmap support.
+func Munmap(b []byte) error {
+	return nil
+}
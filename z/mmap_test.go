@@ -0,0 +1,32 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMmapAnon(t *testing.T) {
+	b, err := MmapAnon(1 << 20)
+	require.NoError(t, err)
+	require.Equal(t, 1<<20, len(b))
+	b[0], b[len(b)-1] = 1, 2
+	require.Equal(t, byte(1), b[0])
+	require.NoError(t, Munmap(b))
+}
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"syscall"
+)
+
+// MmapAnon returns size bytes of zeroed, anonymous memory mapped outside of
+// the Go heap. The garbage collector never scans or moves it, which makes it
+// a good fit for large pointer-free arenas. The memory must be released with
+// Munmap.
+func MmapAnon(size int) ([]byte, error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE,
+		syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+// Munmap releases memory obtained from MmapAnon.
+func Munmap(b []byte) error {
+	return syscall.Munmap(b)
+}
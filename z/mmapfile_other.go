@@ -0,0 +1,33 @@
+//go:build windows || plan9 || js
+// +build windows plan9 js
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"errors"
+	"os"
+)
+
+// errNoMmapFile is returned on platforms where files can't be mapped.
+var errNoMmapFile = errors.New("z: mapping files isn't supported on this platform")
+
+// MmapFile isn't supported on this platform.
+func MmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errNoMmapFile
+}
@@ -0,0 +1,50 @@
+//go:build !windows && !plan9 && !js
+// +build !windows,!plan9,!js
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMmapFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "mmap")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+	require.NoError(t, f.Truncate(1<<16))
+	b, err := MmapFile(f, 1<<16)
+	require.NoError(t, err)
+	b[0], b[len(b)-1] = 1, 2
+	switch runtime.GOOS {
+	case "linux", "darwin", "freebsd", "openbsd", "dragonfly":
+		require.NoError(t, Msync(b))
+	}
+	require.NoError(t, Munmap(b))
+	// writes end up in the file
+	data, err := ioutil.ReadFile(f.Name())
+	require.NoError(t, err)
+	require.Equal(t, byte(1), data[0])
+	require.Equal(t, byte(2), data[len(data)-1])
+}
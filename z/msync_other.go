@@ -0,0 +1,30 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!openbsd,!dragonfly
+
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import "errors"
+
+// errNoMsync is returned on platforms where mapped files can't be synced.
+var errNoMsync = errors.New("z: syncing mapped files isn't supported on this platform")
+
+// Msync isn't supported on this platform.
+func Msync(b []byte) error {
+	return errNoMsync
+}
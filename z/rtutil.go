@@ -62,3 +62,14 @@ func MemHashString(str string) uint64 {
 // FastRand is a fast thread local random function.
 //go:linkname FastRand runtime.fastrand
 func FastRand() uint32
+
+// ProcPin pins the goroutine to the P (logical processor) it runs on, so it
+// can't be preempted or moved, and returns the P's ID, which is less than
+// GOMAXPROCS. It must be followed by ProcUnpin, and nothing in between may
+// block.
+//go:linkname ProcPin runtime.procPin
+func ProcPin() int
+
+// ProcUnpin undoes ProcPin.
+//go:linkname ProcUnpin runtime.procUnpin
+func ProcUnpin()
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// wyp is the default wyhash secret.
+var wyp = [4]uint64{
+	0x2d358dccaa6c78a5, 0x8bb84b93962eacc9, 0x4b33a62ed433d4a3, 0x4d5a2da51de1aa47,
+}
+
+// wySeed is the wyhash seed 0 mixed with the secret.
+var wySeed = wymix(wyp[0], wyp[1])
+
+// WyHash returns the wyhash (final version 4.2) of data, with the default
+// secret and no seed. Unlike MemHash, it's the same in every process.
+func WyHash(data []byte) uint64 {
+	n := len(data)
+	seed := wySeed
+	var a, b uint64
+	switch {
+	case n > 16:
+		p := data
+		if len(p) >= 48 {
+			see1, see2 := seed, seed
+			for ; len(p) >= 48; p = p[48:] {
+				seed = wymix(wyr8(p)^wyp[1], wyr8(p[8:])^seed)
+				see1 = wymix(wyr8(p[16:])^wyp[2], wyr8(p[24:])^see1)
+				see2 = wymix(wyr8(p[32:])^wyp[3], wyr8(p[40:])^see2)
+			}
+			seed ^= see1 ^ see2
+		}
+		for ; len(p) > 16; p = p[16:] {
+			seed = wymix(wyr8(p)^wyp[1], wyr8(p[8:])^seed)
+		}
+		// the last 16 bytes may overlap the ones already mixed
+		a = wyr8(data[n-16:])
+		b = wyr8(data[n-8:])
+	case n >= 4:
+		off := (n >> 3) << 2
+		a = wyr4(data)<<32 | wyr4(data[off:])
+		b = wyr4(data[n-4:])<<32 | wyr4(data[n-4-off:])
+	case n > 0:
+		a = uint64(data[0])<<16 | uint64(data[n>>1])<<8 | uint64(data[n-1])
+	}
+	hi, lo := bits.Mul64(a^wyp[1], b^seed)
+	return wymix(lo^wyp[0]^uint64(n), hi^wyp[1])
+}
+
+// WyHashString is WyHash for a string, without copying it.
+func WyHashString(s string) uint64 {
+	return WyHash(stringBytes(s))
+}
+
+// WyHashUint64 is WyHash for the 8 little-endian bytes of k.
+func WyHashUint64(k uint64) uint64 {
+	return wyHashUint64(k, wySeed)
+}
+
+// WyHashUint64Seed is WyHashUint64 with a seed. Without knowing the seed,
+// which position k hashes to can't be predicted, so keys can't be picked to
+// collide.
+func WyHashUint64Seed(k, seed uint64) uint64 {
+	return wyHashUint64(k, seed^wymix(seed^wyp[0], wyp[1]))
+}
+
+// wyHashUint64 is WyHashUint64 with a seed mixed with the secret.
+func wyHashUint64(k, seed uint64) uint64 {
+	a := k<<32 | k>>32
+	hi, lo := bits.Mul64(a^wyp[1], k^seed)
+	return wymix(lo^wyp[0]^8, hi^wyp[1])
+}
+
+func wymix(a, b uint64) uint64 {
+	return mulFold(a, b)
+}
+
+func wyr8(p []byte) uint64 {
+	return binary.LittleEndian.Uint64(p)
+}
+
+func wyr4(p []byte) uint64 {
+	return uint64(binary.LittleEndian.Uint32(p))
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWyHash(t *testing.T) {
+	// generated with the reference implementation, covering every code path
+	for _, tc := range []struct {
+		n    int
+		hash uint64
+	}{
+		{0, 0x93228a4de0eec5a2},
+		{1, 0x09dc3b06afcfcdfa},
+		{3, 0x9d6f309864716719},
+		{4, 0xe8936f54388cdbf5},
+		{7, 0x0103a204bd36d7fe},
+		{8, 0xb8b8b0101a774b8b},
+		{9, 0xcb7b5ce0bd360798},
+		{16, 0x43271ea04489ebc4},
+		{17, 0xa55c3367b6b9a71c},
+		{32, 0x64624795ebae57a3},
+		{47, 0x698e53fa52bc5336},
+		{48, 0x222b83ab258c1121},
+		{49, 0x5e3a1f603be7896a},
+		{96, 0xd02b2331c221d4ff},
+		{100, 0x798994485b60baf4},
+		{1000, 0xc643f4a543452c3b},
+	} {
+		data := testData(tc.n)
+		require.Equal(t, tc.hash, WyHash(data), "length %d", tc.n)
+		require.Equal(t, tc.hash, WyHashString(string(data)), "length %d", tc.n)
+	}
+	var b [8]byte
+	for _, k := range []uint64{0, 1, 1 << 40, 0xdeadbeefcafe} {
+		binary.LittleEndian.PutUint64(b[:], k)
+		require.Equal(t, WyHash(b[:]), WyHashUint64(k))
+		require.Equal(t, WyHashUint64(k), WyHashUint64Seed(k, 0))
+		require.NotEqual(t, WyHashUint64(k), WyHashUint64Seed(k, 1))
+	}
+}
+
+func BenchmarkWyHashString(b *testing.B) {
+	s := string(testData(16))
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		WyHashString(s)
+	}
+}
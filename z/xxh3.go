@@ -0,0 +1,229 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"unsafe"
+)
+
+// xxh3Secret is the default XXH3 secret.
+var xxh3Secret = [192]byte{
+	0xb8, 0xfe, 0x6c, 0x39, 0x23, 0xa4, 0x4b, 0xbe, 0x7c, 0x01, 0x81, 0x2c, 0xf7, 0x21, 0xad, 0x1c,
+	0xde, 0xd4, 0x6d, 0xe9, 0x83, 0x90, 0x97, 0xdb, 0x72, 0x40, 0xa4, 0xa4, 0xb7, 0xb3, 0x67, 0x1f,
+	0xcb, 0x79, 0xe6, 0x4e, 0xcc, 0xc0, 0xe5, 0x78, 0x82, 0x5a, 0xd0, 0x7d, 0xcc, 0xff, 0x72, 0x21,
+	0xb8, 0x08, 0x46, 0x74, 0xf7, 0x43, 0x24, 0x8e, 0xe0, 0x35, 0x90, 0xe6, 0x81, 0x3a, 0x26, 0x4c,
+	0x3c, 0x28, 0x52, 0xbb, 0x91, 0xc3, 0x00, 0xcb, 0x88, 0xd0, 0x65, 0x8b, 0x1b, 0x53, 0x2e, 0xa3,
+	0x71, 0x64, 0x48, 0x97, 0xa2, 0x0d, 0xf9, 0x4e, 0x38, 0x19, 0xef, 0x46, 0xa9, 0xde, 0xac, 0xd8,
+	0xa8, 0xfa, 0x76, 0x3f, 0xe3, 0x9c, 0x34, 0x3f, 0xf9, 0xdc, 0xbb, 0xc7, 0xc7, 0x0b, 0x4f, 0x1d,
+	0x8a, 0x51, 0xe0, 0x4b, 0xcd, 0xb4, 0x59, 0x31, 0xc8, 0x9f, 0x7e, 0xc9, 0xd9, 0x78, 0x73, 0x64,
+	0xea, 0xc5, 0xac, 0x83, 0x34, 0xd3, 0xeb, 0xc3, 0xc5, 0x81, 0xa0, 0xff, 0xfa, 0x13, 0x63, 0xeb,
+	0x17, 0x0d, 0xdd, 0x51, 0xb7, 0xf0, 0xda, 0x49, 0xd3, 0x16, 0x55, 0x26, 0x29, 0xd4, 0x68, 0x9e,
+	0x2b, 0x16, 0xbe, 0x58, 0x7d, 0x47, 0xa1, 0xfc, 0x8f, 0xf8, 0xb8, 0xd1, 0x7a, 0xd0, 0x31, 0xce,
+	0x45, 0xcb, 0x3a, 0x8f, 0x95, 0x16, 0x04, 0x28, 0xaf, 0xd7, 0xfb, 0xca, 0xbb, 0x4b, 0x40, 0x7e,
+}
+
+const (
+	prime32_1 = 0x9E3779B1
+	prime32_2 = 0x85EBCA77
+	prime32_3 = 0xC2B2AE3D
+	prime64_1 = 0x9E3779B185EBCA87
+	prime64_2 = 0xC2B2AE3D27D4EB4F
+	prime64_3 = 0x165667B19E3779F9
+	prime64_4 = 0x85EBCA77C2B2AE63
+	prime64_5 = 0x27D4EB2F165667C5
+	primeMx1  = 0x165667919E3779F9
+	primeMx2  = 0x9FB21C651E98DF25
+
+	xxh3StripeLen = 64
+	xxh3Rounds    = (len(xxh3Secret) - xxh3StripeLen) / 8
+	xxh3BlockLen  = xxh3StripeLen * xxh3Rounds
+)
+
+// XXH3 returns the 64-bit XXH3 hash of data, with the default secret and no
+// seed. Unlike MemHash, it's the same in every process.
+func XXH3(data []byte) uint64 {
+	n := len(data)
+	switch {
+	case n <= 16:
+		return xxh3Short(data)
+	case n <= 128:
+		acc := uint64(n) * prime64_1
+		if n > 32 {
+			if n > 64 {
+				if n > 96 {
+					acc += xxh3Mix16(data[48:], xxh3Secret[96:])
+					acc += xxh3Mix16(data[n-64:], xxh3Secret[112:])
+				}
+				acc += xxh3Mix16(data[32:], xxh3Secret[64:])
+				acc += xxh3Mix16(data[n-48:], xxh3Secret[80:])
+			}
+			acc += xxh3Mix16(data[16:], xxh3Secret[32:])
+			acc += xxh3Mix16(data[n-32:], xxh3Secret[48:])
+		}
+		acc += xxh3Mix16(data, xxh3Secret[:])
+		acc += xxh3Mix16(data[n-16:], xxh3Secret[16:])
+		return xxh3Avalanche(acc)
+	case n <= 240:
+		acc := uint64(n) * prime64_1
+		for i := 0; i < 8; i++ {
+			acc += xxh3Mix16(data[16*i:], xxh3Secret[16*i:])
+		}
+		end := xxh3Mix16(data[n-16:], xxh3Secret[136-17:])
+		acc = xxh3Avalanche(acc)
+		for i := 8; i < n/16; i++ {
+			end += xxh3Mix16(data[16*i:], xxh3Secret[16*(i-8)+3:])
+		}
+		return xxh3Avalanche(acc + end)
+	default:
+		return xxh3Long(data)
+	}
+}
+
+// XXH3String is XXH3 for a string, without copying it.
+func XXH3String(s string) uint64 {
+	return XXH3(stringBytes(s))
+}
+
+// XXH3Uint64 is XXH3 for the 8 little-endian bytes of k.
+func XXH3Uint64(k uint64) uint64 {
+	bitflip := binary.LittleEndian.Uint64(xxh3Secret[8:]) ^
+		binary.LittleEndian.Uint64(xxh3Secret[16:])
+	return xxh3Rrmxmx((k<<32|k>>32)^bitflip, 8)
+}
+
+func xxh3Short(data []byte) uint64 {
+	n := len(data)
+	switch {
+	case n > 8:
+		lo := binary.LittleEndian.Uint64(data) ^
+			(binary.LittleEndian.Uint64(xxh3Secret[24:]) ^
+				binary.LittleEndian.Uint64(xxh3Secret[32:]))
+		hi := binary.LittleEndian.Uint64(data[n-8:]) ^
+			(binary.LittleEndian.Uint64(xxh3Secret[40:]) ^
+				binary.LittleEndian.Uint64(xxh3Secret[48:]))
+		return xxh3Avalanche(uint64(n) + bits.ReverseBytes64(lo) + hi + mulFold(lo, hi))
+	case n >= 4:
+		in1 := uint64(binary.LittleEndian.Uint32(data))
+		in2 := uint64(binary.LittleEndian.Uint32(data[n-4:]))
+		bitflip := binary.LittleEndian.Uint64(xxh3Secret[8:]) ^
+			binary.LittleEndian.Uint64(xxh3Secret[16:])
+		return xxh3Rrmxmx((in2+in1<<32)^bitflip, uint64(n))
+	case n > 0:
+		combined := uint64(data[0])<<16 | uint64(data[n>>1])<<24 |
+			uint64(data[n-1]) | uint64(n)<<8
+		bitflip := uint64(binary.LittleEndian.Uint32(xxh3Secret[:]) ^
+			binary.LittleEndian.Uint32(xxh3Secret[4:]))
+		return xxh64Avalanche(combined ^ bitflip)
+	default:
+		return xxh64Avalanche(binary.LittleEndian.Uint64(xxh3Secret[56:]) ^
+			binary.LittleEndian.Uint64(xxh3Secret[64:]))
+	}
+}
+
+func xxh3Long(data []byte) uint64 {
+	acc := [8]uint64{prime32_3, prime64_1, prime64_2, prime64_3,
+		prime64_4, prime32_2, prime64_5, prime32_1}
+	n := len(data)
+	blocks := (n - 1) / xxh3BlockLen
+	for b := 0; b < blocks; b++ {
+		block := data[b*xxh3BlockLen:]
+		for s := 0; s < xxh3Rounds; s++ {
+			xxh3Accumulate(&acc, block[s*xxh3StripeLen:], xxh3Secret[s*8:])
+		}
+		// scramble
+		secret := xxh3Secret[len(xxh3Secret)-xxh3StripeLen:]
+		for i := range acc {
+			a := acc[i]
+			a ^= a >> 47
+			a ^= binary.LittleEndian.Uint64(secret[i*8:])
+			acc[i] = a * prime32_1
+		}
+	}
+	// the last partial block, and the last stripe
+	stripes := ((n - 1) - blocks*xxh3BlockLen) / xxh3StripeLen
+	for s := 0; s < stripes; s++ {
+		xxh3Accumulate(&acc, data[blocks*xxh3BlockLen+s*xxh3StripeLen:], xxh3Secret[s*8:])
+	}
+	xxh3Accumulate(&acc, data[n-xxh3StripeLen:],
+		xxh3Secret[len(xxh3Secret)-xxh3StripeLen-7:])
+	// merge the accumulators
+	h := uint64(n) * prime64_1
+	for i := 0; i < 4; i++ {
+		secret := xxh3Secret[11+16*i:]
+		h += mulFold(acc[2*i]^binary.LittleEndian.Uint64(secret),
+			acc[2*i+1]^binary.LittleEndian.Uint64(secret[8:]))
+	}
+	return xxh3Avalanche(h)
+}
+
+func xxh3Accumulate(acc *[8]uint64, stripe, secret []byte) {
+	for i := range acc {
+		val := binary.LittleEndian.Uint64(stripe[i*8:])
+		key := val ^ binary.LittleEndian.Uint64(secret[i*8:])
+		acc[i^1] += val
+		acc[i] += (key & 0xffffffff) * (key >> 32)
+	}
+}
+
+func xxh3Mix16(data, secret []byte) uint64 {
+	return mulFold(binary.LittleEndian.Uint64(data)^binary.LittleEndian.Uint64(secret),
+		binary.LittleEndian.Uint64(data[8:])^binary.LittleEndian.Uint64(secret[8:]))
+}
+
+func xxh3Avalanche(h uint64) uint64 {
+	h ^= h >> 37
+	h *= primeMx1
+	return h ^ h>>32
+}
+
+func xxh3Rrmxmx(h, n uint64) uint64 {
+	h ^= bits.RotateLeft64(h, 49) ^ bits.RotateLeft64(h, 24)
+	h *= primeMx2
+	h ^= (h >> 35) + n
+	h *= primeMx2
+	return h ^ h>>28
+}
+
+func xxh64Avalanche(h uint64) uint64 {
+	h ^= h >> 33
+	h *= prime64_2
+	h ^= h >> 29
+	h *= prime64_3
+	return h ^ h>>32
+}
+
+// mulFold returns the xor of the high and low halves of the 128-bit product
+// of a and b.
+func mulFold(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return hi ^ lo
+}
+
+// stringBytes returns the bytes of s without copying them, so they must not be
+// modified.
+func stringBytes(s string) []byte {
+	ss := (*stringStruct)(unsafe.Pointer(&s))
+	return *(*[]byte)(unsafe.Pointer(&sliceStruct{ss.str, ss.len, ss.len}))
+}
+
+type sliceStruct struct {
+	array unsafe.Pointer
+	len   int
+	cap   int
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2019 Dgraph Labs, Inc. and Contributors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package z
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testData returns n bytes of input for the hash test vectors.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	return data
+}
+
+func TestXXH3(t *testing.T) {
+	// generated with the reference implementation, covering every code path
+	for _, tc := range []struct {
+		n    int
+		hash uint64
+	}{
+		{0, 0x2d06800538d394c2},
+		{1, 0x13e608bc156defed},
+		{3, 0xa9088dda485b481c},
+		{4, 0x6d9253b16c8b1ed3},
+		{8, 0x60539db630471163},
+		{9, 0xfeff668361d723a8},
+		{16, 0xb8c859b0f030b585},
+		{17, 0x714a04408e79b80f},
+		{32, 0x19ff4ee1d6ba1a55},
+		{33, 0x3e44983ad21679c8},
+		{64, 0x287eb1fa9e4be2c1},
+		{65, 0x829218de4d798646},
+		{96, 0xf084e7cfbc624743},
+		{97, 0x1daa83271a8e7b7c},
+		{128, 0x67425a03650261bf},
+		{129, 0xc664bf3311c6abc4},
+		{200, 0x746cd0025327bf5b},
+		{240, 0x64556dc6b462a6cf},
+		{241, 0x8beadd3a8874fe17},
+		{1024, 0x9b81661c641c72b1},
+		{1025, 0x806c2072ed713576},
+		{2048, 0xabe604813ba62ed1},
+		{4096, 0xd7428746842be37e},
+		{10000, 0xfcd0ecba1a48462d},
+	} {
+		data := testData(tc.n)
+		require.Equal(t, tc.hash, XXH3(data), "length %d", tc.n)
+		require.Equal(t, tc.hash, XXH3String(string(data)), "length %d", tc.n)
+	}
+	var b [8]byte
+	for _, k := range []uint64{0, 1, 1 << 40, 0xdeadbeefcafe} {
+		binary.LittleEndian.PutUint64(b[:], k)
+		require.Equal(t, XXH3(b[:]), XXH3Uint64(k))
+	}
+}
+
+func BenchmarkXXH3String(b *testing.B) {
+	s := string(testData(16))
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		XXH3String(s)
+	}
+}